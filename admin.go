@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                              ADMIN HTTP API                                 //
+////////////////////////////////////////////////////////////////////////////////
+
+// Admin API (JSON, chỉ nên mở trên địa chỉ nội bộ):
+//   - GET  /scrub : tiến độ + các object hỏng scrubber đã tìm thấy (ScrubStatus).
+//   - POST /scrub : chạy 1 lượt scrub ngay.
+//   - GET  /rebalance: tiến độ của rebalancer (RebalanceStatus).
+//   - POST /rebalance: chạy 1 lượt rebalance ngay.
+//   - GET  /decommission: tiến độ ngừng hoạt động (DecommissionStatus).
+//   - POST /decommission: bắt đầu ngừng hoạt động (chuyển hết object rồi dừng node).
+//   - GET  /usage : dung lượng theo namespace, tổng của node và dung lượng còn trống.
+//   - GET  /members: danh sách member của cụm và trạng thái (alive/suspect/dead/left) theo gossip.
+//   - GET  /catalog: trạng thái Raft của metadata catalog (vai trò, term, leader, commit/applied index).
+func (s *FileServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.usageReport())
+	})
+	mux.HandleFunc("/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.memberReport())
+	})
+	mux.HandleFunc("/catalog", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.CatalogStatus())
+	})
+	mux.HandleFunc("/scrub", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.scrubber.Status())
+		case http.MethodPost:
+			s.scrubber.Trigger()
+			writeJSON(w, http.StatusAccepted, s.scrubber.Status())
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/rebalance", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.rebalancer.Status())
+		case http.MethodPost:
+			s.rebalancer.Trigger()
+			writeJSON(w, http.StatusAccepted, s.rebalancer.Status())
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/decommission", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.DecommissionStatus())
+		case http.MethodPost:
+			if s.decommissioning() {
+				writeJSON(w, http.StatusConflict, s.DecommissionStatus())
+				return
+			}
+			go func() {
+				if err := s.Decommission(); err != nil {
+					log.Printf("decommission: %s", err)
+				}
+			}()
+			writeJSON(w, http.StatusAccepted, s.DecommissionStatus())
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// startAdmin mở admin API ở AdminAddr (nếu có) trong goroutine riêng.
+func (s *FileServer) startAdmin() error {
+	if len(s.AdminAddr) == 0 {
+		return nil
+	}
+	l, err := net.Listen("tcp", s.AdminAddr)
+	if err != nil {
+		return err
+	}
+	s.admin = &http.Server{Handler: s.adminHandler()}
+	go func() {
+		if err := s.admin.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin api: %s", err)
+		}
+	}()
+	log.Printf("admin api listening on %s", l.Addr())
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin api: encoding response: %s", err)
+	}
+}
+
+// UsageReport: nội dung trả về của GET /usage.
+type UsageReport struct {
+	Total      Usage
+	FreeBytes  int64 // -1 = không giới hạn / không biết
+	NodeQuota  Quota
+	Namespaces map[string]NamespaceUsage
+}
+
+// NamespaceUsage: dung lượng + quota của 1 namespace.
+type NamespaceUsage struct {
+	Usage
+	Quota Quota
+}
+
+func (s *FileServer) usageReport() UsageReport {
+	report := UsageReport{
+		Total:      s.store.TotalUsage(),
+		FreeBytes:  s.store.FreeBytes(),
+		NodeQuota:  s.store.NodeQuota,
+		Namespaces: make(map[string]NamespaceUsage),
+	}
+	for _, id := range s.store.Namespaces() {
+		report.Namespaces[id] = NamespaceUsage{Usage: s.store.Usage(id), Quota: s.store.namespaceQuota(id)}
+	}
+	return report
+}
+
+// MemberReport: 1 dòng trong kết quả GET /members.
+type MemberReport struct {
+	ID          string
+	Addr        string // địa chỉ TCP của transport
+	GossipAddr  string
+	Status      string
+	Incarnation uint64
+}
+
+func (s *FileServer) memberReport() []MemberReport {
+	report := []MemberReport{}
+	for _, m := range s.Members() {
+		report = append(report, MemberReport{
+			ID:          m.Name,
+			Addr:        m.Meta,
+			GossipAddr:  m.Addr,
+			Status:      m.Status.String(),
+			Incarnation: m.Incarnation,
+		})
+	}
+	return report
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                         STORAGE BACKEND (INTERFACE)                         //
+////////////////////////////////////////////////////////////////////////////////
+
+// StorageBackend: nơi thực sự chứa bytes của Store.
+// path luôn ở dạng "id/aaaaa/bbbbb/<hash>" (phân tách bằng '/', không có Root).
+// Object không tồn tại → lỗi bọc os.ErrNotExist.
+type StorageBackend interface {
+	// Put ghi nguyên tử toàn bộ nội dung r vào path: lỗi giữa chừng → path giữ nguyên như cũ.
+	Put(path string, r io.Reader) (int64, error)
+	// Get mở object để đọc dạng stream (người gọi phải Close).
+	Get(path string) (int64, io.ReadCloser, error)
+	Has(path string) bool
+	// Delete xóa object; object không tồn tại → nil.
+	Delete(path string) error
+	// List trả về (đã sắp xếp) mọi path bắt đầu bằng prefix.
+	List(prefix string) ([]string, error)
+	Stat(path string) (ObjectInfo, error)
+	// Clear xóa sạch dữ liệu của backend.
+	Clear() error
+}
+
+// RangeBackend: backend đọc được 1 đoạn object mà không phải đọc cả object (tùy chọn).
+// Store.ReadRange dùng nó khi có, nếu không thì đọc tuần tự rồi bỏ qua phần đầu.
+type RangeBackend interface {
+	GetRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// ObjectInfo: thông tin cơ bản của 1 object trong backend.
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// notExist: lỗi chuẩn khi path không có trong backend.
+func notExist(op, path string) error {
+	return fmt.Errorf("%s %s: %w", op, path, os.ErrNotExist)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                         FILESYSTEM BACKEND (MẶC ĐỊNH)                       //
+////////////////////////////////////////////////////////////////////////////////
+
+// FSBackend lưu mỗi object thành 1 file dưới Root (cách Store vẫn làm từ trước).
+// File/thư mục bắt đầu bằng "." ngay trong Root (.index, .identity, .migration, ...)
+// không phải object nên bị List bỏ qua.
+type FSBackend struct {
+	Root string
+}
+
+// NewFSBackend tạo backend trên thư mục root và dọn file tạm còn sót lại
+// từ lần chạy trước (crash giữa lúc ghi).
+func NewFSBackend(root string) *FSBackend {
+	b := &FSBackend{Root: root}
+	if n, err := b.sweepTempFiles(); err != nil {
+		log.Printf("sweeping temp files in %s: %s", root, err)
+	} else if n > 0 {
+		log.Printf("removed %d orphaned temp files from %s", n, root)
+	}
+	return b
+}
+
+func (b *FSBackend) fullPath(path string) string {
+	return filepath.Join(b.Root, filepath.FromSlash(path))
+}
+
+func (b *FSBackend) Put(path string, r io.Reader) (int64, error) {
+	return writeFileAtomic(b.fullPath(path), func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+}
+
+func (b *FSBackend) Get(path string) (int64, io.ReadCloser, error) {
+	file, err := os.Open(b.fullPath(path))
+	if err != nil {
+		return 0, nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, nil, err
+	}
+	return fi.Size(), file, nil
+}
+
+// GetRange đọc trực tiếp đoạn [offset, offset+length) của file.
+func (b *FSBackend) GetRange(path string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(b.fullPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(file, offset, length), file}, nil
+}
+
+func (b *FSBackend) Has(path string) bool {
+	return exists(b.fullPath(path))
+}
+
+// Delete xóa file rồi dọn các thư mục cha vừa trở nên rỗng (không xóa Root).
+func (b *FSBackend) Delete(path string) error {
+	fullPath := b.fullPath(path)
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return pruneEmptyDirs(filepath.Dir(fullPath), b.Root)
+}
+
+func (b *FSBackend) List(prefix string) ([]string, error) {
+	// Chỉ duyệt thư mục chứa prefix thay vì cả Root
+	start := b.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = b.fullPath(prefix[:i])
+	}
+
+	var paths []string
+	err := filepath.WalkDir(start, func(fullPath string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.Root, fullPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// Thư mục/file ẩn ở gốc (.index, .versions, ...) chỉ được liệt kê khi prefix chỉ thẳng vào đó
+		if rel != "." && strings.HasPrefix(rel, ".") && !strings.Contains(rel, "/") && !strings.HasPrefix(prefix, rel+"/") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), tempFilePrefix) && strings.HasPrefix(rel, prefix) {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+func (b *FSBackend) Stat(path string) (ObjectInfo, error) {
+	fi, err := os.Stat(b.fullPath(path))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: fi.Size(), ModTime: fi.ModTime().UTC()}, nil
+}
+
+// Clear xóa toàn bộ thư mục Root.
+func (b *FSBackend) Clear() error {
+	return os.RemoveAll(b.Root)
+}
+
+// writeFileAtomic ghi file theo kiểu "tất cả hoặc không có gì":
+//  1. Ghi vào file tạm (.tmp-*) trong CÙNG thư mục với file đích.
+//  2. fsync file tạm rồi đóng lại.
+//  3. rename file tạm → file đích (atomic trên cùng filesystem).
+//  4. fsync thư mục để chắc chắn thao tác rename đã xuống đĩa.
+//
+// Nếu write lỗi giữa chừng (hoặc process crash) thì file đích không bị đụng tới,
+// Has không bao giờ thấy một file bị cắt cụt. File tạm mồ côi sẽ được dọn khi NewFSBackend.
+func writeFileAtomic(path string, write func(io.Writer) (int64, error)) (int64, error) {
+	dir, name := filepath.Split(path)
+	// Tạo cây thư mục (nếu chưa có)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(dir, tempFilePrefix+name+"-*")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := write(f)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return n, err
+	}
+
+	return n, syncDir(dir)
+}
+
+// syncDir fsync một thư mục (đảm bảo entry mới/rename đã được ghi xuống đĩa).
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// sweepTempFiles xóa các file tạm mồ côi (do crash giữa lúc ghi) trong toàn bộ Root.
+// Trả về số file đã xóa.
+func (b *FSBackend) sweepTempFiles() (int, error) {
+	removed := 0
+	err := filepath.WalkDir(b.Root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasPrefix(d.Name(), tempFilePrefix) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// exists: file ở đường dẫn có tồn tại không.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	// Nếu file không tồn tại → trả về false
+	return !errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,277 @@
+package main
+
+import "sort"
+
+////////////////////////////////////////////////////////////////////////////////
+//                         B-TREE (TRONG BỘ NHỚ)                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// btreeDegree: bậc tối thiểu t của B-tree – mỗi node có từ t-1 đến 2t-1 key.
+const btreeDegree = 32
+
+// btree là B-tree key kiểu string, value kiểu V (thuật toán theo CLRS).
+// Không tự đồng bộ – người dùng tự giữ lock.
+type btree[V any] struct {
+	root   *bnode[V]
+	length int
+}
+
+// bnode: 1 node của cây. Node lá không có children.
+type bnode[V any] struct {
+	keys     []string
+	vals     []V
+	children []*bnode[V]
+}
+
+func (n *bnode[V]) leaf() bool {
+	return len(n.children) == 0
+}
+
+// search: vị trí đầu tiên có key >= k, và key ở đó có đúng bằng k không.
+func (n *bnode[V]) search(k string) (int, bool) {
+	i := sort.SearchStrings(n.keys, k)
+	return i, i < len(n.keys) && n.keys[i] == k
+}
+
+// Len: số key trong cây.
+func (t *btree[V]) Len() int {
+	return t.length
+}
+
+// Get tìm value theo key.
+func (t *btree[V]) Get(k string) (V, bool) {
+	for n := t.root; n != nil; {
+		i, found := n.search(k)
+		if found {
+			return n.vals[i], true
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	var zero V
+	return zero, false
+}
+
+// Set thêm hoặc ghi đè key.
+func (t *btree[V]) Set(k string, v V) {
+	if t.root == nil {
+		t.root = &bnode[V]{}
+	}
+	if len(t.root.keys) == 2*btreeDegree-1 {
+		old := t.root
+		t.root = &bnode[V]{children: []*bnode[V]{old}}
+		t.root.splitChild(0)
+	}
+	if t.root.insertNonFull(k, v) {
+		t.length++
+	}
+}
+
+// insertNonFull chèn vào cây con gốc n (n chắc chắn chưa đầy). Trả về true nếu là key mới.
+func (n *bnode[V]) insertNonFull(k string, v V) bool {
+	for {
+		i, found := n.search(k)
+		if found {
+			n.vals[i] = v
+			return false
+		}
+		if n.leaf() {
+			n.keys = insertAt(n.keys, i, k)
+			n.vals = insertAt(n.vals, i, v)
+			return true
+		}
+		if len(n.children[i].keys) == 2*btreeDegree-1 {
+			n.splitChild(i)
+			if k == n.keys[i] {
+				n.vals[i] = v
+				return false
+			}
+			if k > n.keys[i] {
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// splitChild tách children[i] (đang đầy) thành 2, đẩy key giữa lên n.
+func (n *bnode[V]) splitChild(i int) {
+	y := n.children[i]
+	mid := btreeDegree - 1
+
+	z := &bnode[V]{
+		keys: append([]string{}, y.keys[mid+1:]...),
+		vals: append([]V{}, y.vals[mid+1:]...),
+	}
+	if !y.leaf() {
+		z.children = append([]*bnode[V]{}, y.children[mid+1:]...)
+		y.children = y.children[:mid+1]
+	}
+
+	n.keys = insertAt(n.keys, i, y.keys[mid])
+	n.vals = insertAt(n.vals, i, y.vals[mid])
+	n.children = insertAt(n.children, i+1, z)
+
+	y.keys = y.keys[:mid]
+	y.vals = y.vals[:mid]
+}
+
+// Delete xóa key, trả về true nếu key có tồn tại.
+func (t *btree[V]) Delete(k string) bool {
+	if t.root == nil {
+		return false
+	}
+	deleted := t.root.delete(k)
+	if len(t.root.keys) == 0 && !t.root.leaf() {
+		t.root = t.root.children[0]
+	}
+	if deleted {
+		t.length--
+	}
+	return deleted
+}
+
+// delete xóa k khỏi cây con gốc n. Bất biến: khi đi xuống, node con luôn có >= t key
+// (trừ root) để việc xóa không bao giờ làm node thiếu key.
+func (n *bnode[V]) delete(k string) bool {
+	i, found := n.search(k)
+
+	if n.leaf() {
+		if !found {
+			return false
+		}
+		n.keys = removeAt(n.keys, i)
+		n.vals = removeAt(n.vals, i)
+		return true
+	}
+
+	if found {
+		switch {
+		case len(n.children[i].keys) >= btreeDegree:
+			// Thay bằng key lớn nhất của cây con trái rồi xóa key đó ở dưới
+			pred := n.children[i].max()
+			n.keys[i], n.vals[i] = pred.keys[len(pred.keys)-1], pred.vals[len(pred.vals)-1]
+			return n.children[i].delete(n.keys[i])
+		case len(n.children[i+1].keys) >= btreeDegree:
+			// Thay bằng key nhỏ nhất của cây con phải
+			succ := n.children[i+1].min()
+			n.keys[i], n.vals[i] = succ.keys[0], succ.vals[0]
+			return n.children[i+1].delete(n.keys[i])
+		default:
+			n.merge(i)
+			return n.children[i].delete(k)
+		}
+	}
+
+	// Key nằm ở cây con i: đảm bảo con i đủ key trước khi đi xuống
+	if len(n.children[i].keys) < btreeDegree {
+		switch {
+		case i > 0 && len(n.children[i-1].keys) >= btreeDegree:
+			n.rotateRight(i)
+		case i < len(n.children)-1 && len(n.children[i+1].keys) >= btreeDegree:
+			n.rotateLeft(i)
+		case i < len(n.children)-1:
+			n.merge(i)
+		default:
+			n.merge(i - 1)
+			i--
+		}
+	}
+	return n.children[i].delete(k)
+}
+
+// merge gộp children[i] + keys[i] + children[i+1] thành children[i].
+func (n *bnode[V]) merge(i int) {
+	left, right := n.children[i], n.children[i+1]
+	left.keys = append(append(left.keys, n.keys[i]), right.keys...)
+	left.vals = append(append(left.vals, n.vals[i]), right.vals...)
+	left.children = append(left.children, right.children...)
+
+	n.keys = removeAt(n.keys, i)
+	n.vals = removeAt(n.vals, i)
+	n.children = removeAt(n.children, i+1)
+}
+
+// rotateRight mượn 1 key từ anh em bên trái cho children[i].
+func (n *bnode[V]) rotateRight(i int) {
+	child, sibling := n.children[i], n.children[i-1]
+	last := len(sibling.keys) - 1
+
+	child.keys = insertAt(child.keys, 0, n.keys[i-1])
+	child.vals = insertAt(child.vals, 0, n.vals[i-1])
+	n.keys[i-1], n.vals[i-1] = sibling.keys[last], sibling.vals[last]
+	sibling.keys, sibling.vals = sibling.keys[:last], sibling.vals[:last]
+
+	if !sibling.leaf() {
+		lastChild := sibling.children[len(sibling.children)-1]
+		sibling.children = sibling.children[:len(sibling.children)-1]
+		child.children = insertAt(child.children, 0, lastChild)
+	}
+}
+
+// rotateLeft mượn 1 key từ anh em bên phải cho children[i].
+func (n *bnode[V]) rotateLeft(i int) {
+	child, sibling := n.children[i], n.children[i+1]
+
+	child.keys = append(child.keys, n.keys[i])
+	child.vals = append(child.vals, n.vals[i])
+	n.keys[i], n.vals[i] = sibling.keys[0], sibling.vals[0]
+	sibling.keys, sibling.vals = removeAt(sibling.keys, 0), removeAt(sibling.vals, 0)
+
+	if !sibling.leaf() {
+		child.children = append(child.children, sibling.children[0])
+		sibling.children = removeAt(sibling.children, 0)
+	}
+}
+
+func (n *bnode[V]) min() *bnode[V] {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n
+}
+
+func (n *bnode[V]) max() *bnode[V] {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n
+}
+
+// Ascend duyệt các key >= from theo thứ tự tăng dần, dừng khi fn trả về false.
+func (t *btree[V]) Ascend(from string, fn func(k string, v V) bool) {
+	if t.root != nil {
+		t.root.ascend(from, fn)
+	}
+}
+
+func (n *bnode[V]) ascend(from string, fn func(string, V) bool) bool {
+	i, _ := n.search(from)
+	for ; i <= len(n.keys); i++ {
+		if !n.leaf() && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if i < len(n.keys) && !fn(n.keys[i], n.vals[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// insertAt / removeAt: chèn/xóa phần tử tại vị trí i của slice.
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// TestBTree: so sánh B-tree với map qua nhiều lần Set/Delete ngẫu nhiên (đủ để tách/gộp node nhiều tầng).
+func TestBTree(t *testing.T) {
+	var (
+		tree btree[int]
+		want = make(map[string]int)
+		rnd  = rand.New(rand.NewSource(1))
+	)
+
+	for i := 0; i < 20000; i++ {
+		k := fmt.Sprintf("key_%05d", rnd.Intn(5000))
+		if rnd.Intn(3) == 0 {
+			_, had := want[k]
+			if deleted := tree.Delete(k); deleted != had {
+				t.Fatalf("delete %s: want %v have %v", k, had, deleted)
+			}
+			delete(want, k)
+		} else {
+			tree.Set(k, i)
+			want[k] = i
+		}
+	}
+
+	if tree.Len() != len(want) {
+		t.Fatalf("want len %d have %d", len(want), tree.Len())
+	}
+	for k, v := range want {
+		if have, ok := tree.Get(k); !ok || have != v {
+			t.Fatalf("get %s: want %d have %d (%v)", k, v, have, ok)
+		}
+	}
+
+	// Ascend phải trả về đúng thứ tự, bắt đầu từ key >= from
+	var keys []string
+	for k := range want {
+		if k >= "key_02500" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var have []string
+	tree.Ascend("key_02500", func(k string, _ int) bool {
+		have = append(have, k)
+		return true
+	})
+	if fmt.Sprint(have) != fmt.Sprint(keys) {
+		t.Errorf("unexpected ascend order")
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                    METADATA CATALOG (NHÂN BẢN BẰNG RAFT)                    //
+////////////////////////////////////////////////////////////////////////////////
+
+// Catalog là nguồn sự thật nhất quán mạnh cho metadata của cả cụm – thứ đĩa của từng node không cho được:
+//   - object (owner, key) → phiên bản hiện hành (VersionID, size, hash, mốc HLC) và các node đang giữ bản sao;
+//   - tombstone của key đã xóa;
+//   - quota theo namespace (áp cho cả cụm) và dung lượng đang dùng.
+//
+// Chỉ metadata đi qua Raft; bytes của object vẫn đi đường dữ liệu cũ (replicate / rebalance).
+// CatalogVoters (node ID) chạy Raft (raft.go) với Catalog làm state machine; node khác là client:
+// gửi lệnh / yêu cầu đọc tới 1 voter, voter chuyển lệnh tới leader. Đọc luôn nhất quán: voter ghi 1 no-op
+// qua leader rồi chờ tới khi chính nó áp dụng tới đó mới đọc.
+//
+// Khi bật catalog: Store kiểm tra quota của cụm trước khi ghi (kiểm tra trước nên có thể vượt tối đa 1 object)
+// và chỉ thành công khi object đã được ghi vào catalog; Delete ghi tombstone; rebalance cập nhật vị trí;
+// Get ưu tiên vị trí trong catalog thay vì DHT.
+
+// catalogTimeout: thời gian chờ tối đa 1 lệnh / 1 lần đọc catalog (kể cả chờ bầu leader).
+const catalogTimeout = 5 * time.Second
+
+// ErrCatalogDisabled: node không cấu hình CatalogVoters.
+var ErrCatalogDisabled = errors.New("metadata catalog is disabled")
+
+// CatalogEntry: metadata của 1 object trong catalog.
+type CatalogEntry struct {
+	Owner     string
+	Key       string
+	VersionID string
+	Size      int64
+	Hash      string
+	Timestamp Timestamp // mốc HLC của lần ghi / xóa – lệnh cũ hơn bản đang có bị bỏ qua (last-writer-wins)
+	Locations []string  `json:",omitempty"` // node ID đang giữ bản sao (kể cả chủ)
+	Deleted   bool      `json:",omitempty"` // tombstone
+	Index     uint64    // entry Raft của lần thay đổi gần nhất
+}
+
+// catalogCommand: 1 lệnh trong log Raft (JSON).
+//   - put   : ghi phiên bản mới của Entry.
+//   - delete: tombstone cho Entry (Owner, Key, VersionID, Timestamp).
+//   - locate: cập nhật Locations nếu phiên bản hiện hành vẫn là Entry.VersionID.
+//   - quota : đặt quota của namespace Owner (Quota rỗng → bỏ giới hạn).
+//   - noop  : không đổi gì (dùng để đọc nhất quán).
+type catalogCommand struct {
+	Op    string
+	Entry CatalogEntry
+	Owner string `json:",omitempty"`
+	Quota Quota
+}
+
+// catalogSnapshot: trạng thái Catalog trong snapshot Raft.
+type catalogSnapshot struct {
+	Entries []CatalogEntry
+	Quotas  map[string]Quota
+}
+
+// Catalog: state machine của metadata catalog. Apply/Snapshot/Restore chỉ do Raft gọi; đọc an toàn đồng thời.
+type Catalog struct {
+	mu      sync.RWMutex
+	entries map[string]CatalogEntry // objectIndexKey(owner, key) → entry
+	quotas  map[string]Quota
+	usage   map[string]Usage // tính lại từ entries (không nằm trong snapshot)
+}
+
+func newCatalog() *Catalog {
+	return &Catalog{
+		entries: make(map[string]CatalogEntry),
+		quotas:  make(map[string]Quota),
+		usage:   make(map[string]Usage),
+	}
+}
+
+// Apply áp dụng 1 lệnh (tất định: chỉ phụ thuộc lệnh và trạng thái hiện tại).
+func (c *Catalog) Apply(index uint64, command []byte) error {
+	var cmd catalogCommand
+	if err := json.Unmarshal(command, &cmd); err != nil {
+		return fmt.Errorf("decoding catalog command: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := cmd.Entry
+	k := objectIndexKey(e.Owner, e.Key)
+	old, exists := c.entries[k]
+	switch cmd.Op {
+	case "noop":
+	case "put", "delete":
+		if exists && old.Timestamp.Compare(e.Timestamp) > 0 {
+			return nil // lệnh tới muộn: đã có phiên bản mới hơn
+		}
+		if cmd.Op == "delete" {
+			e.Size, e.Hash, e.Locations, e.Deleted = 0, "", nil, true
+		} else {
+			e.Deleted = false
+		}
+		e.Index = index
+		c.account(old, exists, -1)
+		c.entries[k] = e
+		c.account(e, true, 1)
+	case "locate":
+		if !exists || old.Deleted || old.VersionID != e.VersionID {
+			return nil // phiên bản đã đổi: vị trí này không còn đúng
+		}
+		old.Locations, old.Index = e.Locations, index
+		c.entries[k] = old
+	case "quota":
+		if cmd.Quota == (Quota{}) {
+			delete(c.quotas, cmd.Owner)
+		} else {
+			c.quotas[cmd.Owner] = cmd.Quota
+		}
+	default:
+		return fmt.Errorf("unknown catalog command %q", cmd.Op)
+	}
+	return nil
+}
+
+// account cộng (sign = 1) / trừ (sign = -1) dung lượng của entry vào usage của owner (tombstone không tính).
+func (c *Catalog) account(e CatalogEntry, exists bool, sign int64) {
+	if !exists || e.Deleted {
+		return
+	}
+	u := c.usage[e.Owner]
+	u.Objects += sign
+	u.Bytes += sign * e.Size
+	if u == (Usage{}) {
+		delete(c.usage, e.Owner)
+		return
+	}
+	c.usage[e.Owner] = u
+}
+
+// Snapshot: toàn bộ entries (đã sắp xếp) + quotas.
+func (c *Catalog) Snapshot() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snap := catalogSnapshot{Entries: make([]CatalogEntry, 0, len(c.entries)), Quotas: c.quotas}
+	for _, e := range c.entries {
+		snap.Entries = append(snap.Entries, e)
+	}
+	sort.Slice(snap.Entries, func(i, j int) bool {
+		return objectIndexKey(snap.Entries[i].Owner, snap.Entries[i].Key) < objectIndexKey(snap.Entries[j].Owner, snap.Entries[j].Key)
+	})
+	return json.Marshal(snap)
+}
+
+// Restore thay toàn bộ trạng thái bằng snapshot.
+func (c *Catalog) Restore(data []byte) error {
+	var snap catalogSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decoding catalog snapshot: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]CatalogEntry, len(snap.Entries))
+	c.quotas = make(map[string]Quota, len(snap.Quotas))
+	c.usage = make(map[string]Usage)
+	for _, e := range snap.Entries {
+		c.entries[objectIndexKey(e.Owner, e.Key)] = e
+		c.account(e, true, 1)
+	}
+	for owner, q := range snap.Quotas {
+		c.quotas[owner] = q
+	}
+	return nil
+}
+
+// Lookup: entry của (owner, key) theo trạng thái cục bộ (có thể cũ – dùng FileServer.CatalogLookup để đọc nhất quán).
+func (c *Catalog) Lookup(owner string, key string) (CatalogEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[objectIndexKey(owner, key)]
+	e.Locations = append([]string(nil), e.Locations...)
+	return e, ok
+}
+
+// Usage: dung lượng và quota của namespace owner theo trạng thái cục bộ.
+func (c *Catalog) Usage(owner string) (Usage, Quota) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage[owner], c.quotas[owner]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                     CATALOG TRÊN FILESERVER (RPC & API)                     //
+////////////////////////////////////////////////////////////////////////////////
+
+// Thông điệp “đề xuất lệnh này lên catalog” (request). Voter nhận chuyển tiếp tới leader nếu cần.
+type MessageCatalogPropose struct {
+	Command []byte
+}
+
+// Trả lời MessageCatalogPropose: Index = entry Raft của lệnh.
+type MessageCatalogProposeResponse struct {
+	Index uint64
+	Error string
+}
+
+// Thông điệp “đọc nhất quán (Owner, Key) và dung lượng / quota của Owner” (request, chỉ gửi tới voter).
+type MessageCatalogRead struct {
+	Owner string
+	Key   string
+}
+
+// Trả lời MessageCatalogRead.
+type MessageCatalogReadResponse struct {
+	Entry CatalogEntry
+	Found bool
+	Usage Usage
+	Quota Quota
+	Error string
+}
+
+// newCatalogRaft tạo node Raft cho catalog nếu node này là voter (nil nếu không).
+// Trạng thái Raft nằm trong <StorageRoot>/.raft (chỉ trong RAM với MemoryBackend).
+func newCatalogRaft(s *FileServer) *raft {
+	voter := false
+	for _, id := range s.CatalogVoters {
+		voter = voter || id == s.ID
+	}
+	if !voter {
+		return nil
+	}
+
+	dir := filepath.Join(s.store.Root, raftDirName)
+	if _, ok := s.StorageBackend.(*MemoryBackend); ok {
+		dir = ""
+	}
+	r, err := newRaft(raftOpts{
+		ID:              s.ID,
+		Voters:          s.CatalogVoters,
+		Dir:             dir,
+		ElectionTimeout: s.CatalogElectionTimeout,
+		Send:            s.sendRaft,
+		StateMachine:    s.catalog,
+	})
+	if err != nil {
+		log.Printf("metadata catalog disabled on this node: %s", err)
+		return nil
+	}
+	return r
+}
+
+func (s *FileServer) catalogEnabled() bool {
+	return len(s.CatalogVoters) > 0
+}
+
+// CatalogStatus: trạng thái Raft của node (Role "client" nếu node không phải voter).
+func (s *FileServer) CatalogStatus() RaftStatus {
+	if s.raft == nil {
+		return RaftStatus{ID: s.ID, Role: "client", Voters: s.CatalogVoters}
+	}
+	return s.raft.Status()
+}
+
+// CatalogLookup: metadata hiện hành của (owner, key) – đọc nhất quán. Key đã xóa trả về tombstone (Deleted).
+func (s *FileServer) CatalogLookup(owner string, key string) (CatalogEntry, error) {
+	view, err := s.catalogRead(owner, key)
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+	if !view.Found {
+		return CatalogEntry{}, fmt.Errorf("catalog entry %s/%s: %w", owner, key, os.ErrNotExist)
+	}
+	return view.Entry, nil
+}
+
+// CatalogUsage: dung lượng namespace owner đang dùng trên cả cụm và quota của nó – đọc nhất quán.
+func (s *FileServer) CatalogUsage(owner string) (Usage, Quota, error) {
+	view, err := s.catalogRead(owner, "")
+	return view.Usage, view.Quota, err
+}
+
+// SetCatalogQuota đặt quota của namespace owner cho cả cụm (Quota rỗng → bỏ giới hạn).
+func (s *FileServer) SetCatalogQuota(owner string, q Quota) error {
+	_, err := s.proposeCatalog(catalogCommand{Op: "quota", Owner: owner, Quota: q})
+	return err
+}
+
+// checkCatalogQuota từ chối ghi key khi namespace của node đã dùng hết quota của cụm.
+func (s *FileServer) checkCatalogQuota(key string) error {
+	if !s.catalogEnabled() {
+		return nil
+	}
+	view, err := s.catalogRead(s.ID, key)
+	if err != nil {
+		return fmt.Errorf("checking catalog quota: %w", err)
+	}
+	used := view.Usage
+	if view.Found && !view.Entry.Deleted {
+		used.Objects, used.Bytes = used.Objects-1, used.Bytes-view.Entry.Size // ghi đè
+	}
+	limit := view.Quota
+	if (limit.MaxObjects > 0 && used.Objects+1 > limit.MaxObjects) || (limit.MaxBytes > 0 && used.Bytes >= limit.MaxBytes) {
+		return &QuotaError{Scope: "cluster", ID: s.ID, Limit: limit, Used: view.Usage, Need: -1}
+	}
+	return nil
+}
+
+// recordObject ghi phiên bản meta của key (node giữ bản sao: providers) vào catalog.
+func (s *FileServer) recordObject(key string, meta ObjectMeta, providers []PeerInfo) error {
+	if !s.catalogEnabled() {
+		return nil
+	}
+	_, err := s.proposeCatalog(catalogCommand{Op: "put", Entry: catalogEntryOf(s.ID, key, meta, providers)})
+	if err != nil {
+		return fmt.Errorf("recording %s in catalog: %w", key, err)
+	}
+	return nil
+}
+
+// recordLocations cập nhật các node giữ bản sao phiên bản meta của key (sau rebalance).
+func (s *FileServer) recordLocations(key string, meta ObjectMeta, providers []PeerInfo) error {
+	if !s.catalogEnabled() {
+		return nil
+	}
+	_, err := s.proposeCatalog(catalogCommand{Op: "locate", Entry: catalogEntryOf(s.ID, key, meta, providers)})
+	return err
+}
+
+// recordDeletion ghi tombstone của key vào catalog.
+func (s *FileServer) recordDeletion(key string, tomb ObjectMeta) error {
+	if !s.catalogEnabled() {
+		return nil
+	}
+	_, err := s.proposeCatalog(catalogCommand{Op: "delete", Entry: catalogEntryOf(s.ID, key, tomb, nil)})
+	if err != nil {
+		return fmt.Errorf("recording deletion of %s in catalog: %w", key, err)
+	}
+	return nil
+}
+
+func catalogEntryOf(owner string, key string, meta ObjectMeta, providers []PeerInfo) CatalogEntry {
+	e := CatalogEntry{
+		Owner:     owner,
+		Key:       key,
+		VersionID: meta.VersionID,
+		Size:      meta.Size,
+		Hash:      meta.Hash,
+		Timestamp: meta.Timestamp,
+	}
+	for _, p := range providers {
+		e.Locations = append(e.Locations, p.ID)
+	}
+	sort.Strings(e.Locations)
+	return e
+}
+
+// catalogAddrs: địa chỉ kết nối tới các node (khác node này) giữ bản sao key theo catalog.
+// Catalog tắt / không đọc được / key đã xóa → nil (Get dùng DHT như cũ).
+func (s *FileServer) catalogAddrs(key string) []string {
+	if !s.catalogEnabled() {
+		return nil
+	}
+	e, err := s.CatalogLookup(s.ID, key)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("catalog: looking up %s: %s", key, err)
+		}
+		return nil
+	}
+	var addrs []string
+	for _, id := range e.Locations {
+		if id == s.ID {
+			continue
+		}
+		p, ok := s.peerByID(id)
+		if !ok {
+			continue
+		}
+		addr, err := s.connectTo(p)
+		if err != nil {
+			log.Printf("catalog: connecting to %s: %s", p.Addr, err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// proposeCatalog đưa lệnh vào catalog và chờ nó được áp dụng; trả về index của entry Raft.
+// Voter: đề xuất tại chỗ, chuyển tới leader nếu mình không phải leader (chờ bầu nếu chưa có leader).
+// Client: gửi tới 1 voter.
+func (s *FileServer) proposeCatalog(cmd catalogCommand) (uint64, error) {
+	if !s.catalogEnabled() {
+		return 0, ErrCatalogDisabled
+	}
+	command, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+	if s.raft == nil {
+		resp, err := s.catalogRequest(MessageCatalogPropose{Command: command})
+		if err != nil {
+			return 0, err
+		}
+		r := resp.(MessageCatalogProposeResponse)
+		if len(r.Error) > 0 {
+			return r.Index, fmt.Errorf("%s", r.Error)
+		}
+		return r.Index, nil
+	}
+
+	deadline := time.Now().Add(catalogTimeout)
+	for {
+		index, err := s.raft.propose(command, time.Until(deadline))
+		var nle *NotLeaderError
+		if !errors.As(err, &nle) {
+			return index, err
+		}
+		if len(nle.Leader) > 0 {
+			resp, rerr := s.requestVoter(nle.Leader, MessageCatalogPropose{Command: command})
+			if rerr == nil {
+				if r, ok := resp.(MessageCatalogProposeResponse); ok {
+					if len(r.Error) > 0 {
+						return r.Index, fmt.Errorf("%s", r.Error)
+					}
+					return r.Index, nil
+				}
+			}
+			err = fmt.Errorf("forwarding to leader %s: %v", nle.Leader, rerr)
+		}
+		if time.Now().After(deadline) {
+			return 0, err
+		}
+		select {
+		case <-time.After(s.raft.heartbeatInterval()):
+		case <-s.quitch:
+			return 0, ErrRaftStopped
+		}
+	}
+}
+
+// catalogRead đọc nhất quán (owner, key) + dung lượng / quota của owner.
+func (s *FileServer) catalogRead(owner string, key string) (MessageCatalogReadResponse, error) {
+	if !s.catalogEnabled() {
+		return MessageCatalogReadResponse{}, ErrCatalogDisabled
+	}
+	if s.raft == nil {
+		resp, err := s.catalogRequest(MessageCatalogRead{Owner: owner, Key: key})
+		if err != nil {
+			return MessageCatalogReadResponse{}, err
+		}
+		r := resp.(MessageCatalogReadResponse)
+		if len(r.Error) > 0 {
+			return r, fmt.Errorf("%s", r.Error)
+		}
+		return r, nil
+	}
+
+	// No-op qua leader: mọi lệnh commit trước lúc đọc đều nằm trước index này.
+	index, err := s.proposeCatalog(catalogCommand{Op: "noop"})
+	if err != nil {
+		return MessageCatalogReadResponse{}, err
+	}
+	if err := s.raft.waitApplied(index, catalogTimeout); err != nil {
+		return MessageCatalogReadResponse{}, err
+	}
+	var view MessageCatalogReadResponse
+	view.Entry, view.Found = s.catalog.Lookup(owner, key)
+	view.Usage, view.Quota = s.catalog.Usage(owner)
+	return view, nil
+}
+
+// catalogRequest (client) gửi request tới voter trả lời được đầu tiên (voter trả lời gần nhất được thử trước).
+func (s *FileServer) catalogRequest(payload any) (any, error) {
+	s.peerLock.Lock()
+	hint := s.catalogVoter
+	s.peerLock.Unlock()
+
+	voters := append([]string{hint}, s.CatalogVoters...)
+	var lastErr error = fmt.Errorf("no catalog voter reachable")
+	for i, id := range voters {
+		if len(id) == 0 || (i > 0 && id == hint) {
+			continue
+		}
+		resp, err := s.requestVoter(id, payload)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.peerLock.Lock()
+		s.catalogVoter = id
+		s.peerLock.Unlock()
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// requestVoter gửi request tới voter id (dial nếu chưa kết nối).
+func (s *FileServer) requestVoter(id string, payload any) (any, error) {
+	p, ok := s.peerByID(id)
+	if !ok {
+		return nil, fmt.Errorf("no address for catalog voter %s", id)
+	}
+	addr, err := s.connectTo(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.request(addr, payload, catalogTimeout+time.Second)
+}
+
+// sendRaft gửi message Raft tới voter id (Send của raftOpts).
+func (s *FileServer) sendRaft(id string, payload any) error {
+	p, ok := s.peerByID(id)
+	if !ok {
+		return fmt.Errorf("no address for catalog voter %s", id)
+	}
+	addr, err := s.connectTo(p)
+	if err != nil {
+		return err
+	}
+	return s.sendTo(addr, &Message{Payload: payload})
+}
+
+// peerByID: địa chỉ lắng nghe của node id – từ kết nối đang có, sổ địa chỉ, hoặc membership gossip.
+func (s *FileServer) peerByID(id string) (PeerInfo, bool) {
+	s.peerLock.Lock()
+	for addr, pid := range s.peerIDs {
+		if pid == id && len(s.peerListen[addr]) > 0 {
+			s.peerLock.Unlock()
+			return PeerInfo{ID: id, Addr: s.peerListen[addr]}, true
+		}
+	}
+	addr, ok := s.addrBook[id]
+	s.peerLock.Unlock()
+	if ok {
+		return PeerInfo{ID: id, Addr: addr}, true
+	}
+
+	for _, m := range s.clusterMembers() {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return PeerInfo{}, false
+}
+
+// handleMessageRaft chuyển message Raft (người gửi đã xác thực bằng chữ ký) cho node Raft.
+func (s *FileServer) handleMessageRaft(sender string, payload any) error {
+	if s.raft == nil {
+		return fmt.Errorf("raft message %T from %s but this node is not a catalog voter", payload, sender)
+	}
+	s.raft.step(sender, payload)
+	return nil
+}
+
+// handleMessageCatalogPropose: đề xuất lệnh hộ node khác. Lệnh object chỉ được ghi vào namespace của
+// chính người gửi; lệnh quota chỉ voter được gửi. Chờ commit trong goroutine riêng (không chặn loop).
+func (s *FileServer) handleMessageCatalogPropose(from string, sender string, reqID uint64, msg MessageCatalogPropose) error {
+	var cmd catalogCommand
+	if err := json.Unmarshal(msg.Command, &cmd); err != nil {
+		return s.reply(from, reqID, MessageCatalogProposeResponse{Error: err.Error()})
+	}
+	switch {
+	case s.raft == nil:
+		return s.reply(from, reqID, MessageCatalogProposeResponse{Error: "not a catalog voter"})
+	case cmd.Op == "quota" && !s.raft.isVoter(sender):
+		return s.reply(from, reqID, MessageCatalogProposeResponse{Error: "only catalog voters may set quotas"})
+	case cmd.Op != "quota" && cmd.Op != "noop" && cmd.Entry.Owner != sender && !s.raft.isVoter(sender):
+		return s.reply(from, reqID, MessageCatalogProposeResponse{Error: "cannot write another node's namespace"})
+	}
+
+	go func() {
+		var resp MessageCatalogProposeResponse
+		index, err := s.proposeCatalog(cmd)
+		resp.Index = index
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := s.reply(from, reqID, resp); err != nil {
+			log.Printf("catalog: replying to %s: %s", from, err)
+		}
+	}()
+	return nil
+}
+
+// handleMessageCatalogRead: đọc nhất quán hộ client (trong goroutine riêng – cần chờ leader).
+func (s *FileServer) handleMessageCatalogRead(from string, reqID uint64, msg MessageCatalogRead) error {
+	if s.raft == nil {
+		return s.reply(from, reqID, MessageCatalogReadResponse{Error: "not a catalog voter"})
+	}
+	go func() {
+		view, err := s.catalogRead(msg.Owner, msg.Key)
+		if err != nil {
+			view.Error = err.Error()
+		}
+		if err := s.reply(from, reqID, view); err != nil {
+			log.Printf("catalog: replying to %s: %s", from, err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                         NÉN OBJECT (AT REST + TRÊN DÂY)                     //
+////////////////////////////////////////////////////////////////////////////////
+
+// Compression: thuật toán nén áp dụng lên nội dung object (trước khi mã hóa).
+// Rỗng = không nén.
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+)
+
+// supportedCompressions: các thuật toán node này giải nén được (gửi cho peer trong MessageHello).
+var supportedCompressions = []Compression{CompressionGzip}
+
+// supportsCompression: alg có nằm trong danh sách algs không (CompressionNone luôn được hỗ trợ).
+func supportsCompression(algs []Compression, alg Compression) bool {
+	if alg == CompressionNone {
+		return true
+	}
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// incompressibleTypes: content type (tiền tố) đã được nén sẵn – nén lại chỉ tốn CPU.
+var incompressibleTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp",
+	"video/", "audio/mpeg", "audio/aac", "audio/ogg",
+	"application/zip", "application/x-gzip", "application/x-rar-compressed",
+	"font/woff", "font/woff2",
+}
+
+// compressedMagics: chữ ký đầu file của các định dạng nén mà http.DetectContentType không nhận ra.
+var compressedMagics = [][]byte{
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'B', 'Z', 'h'},                    // bzip2
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	{0x04, 0x22, 0x4d, 0x18},           // lz4
+}
+
+// compressible đoán xem nội dung có đáng nén không dựa vào content type khai báo
+// (rỗng → đoán từ head) và chữ ký đầu file.
+func compressible(contentType string, head []byte) bool {
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(head)
+	}
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	for _, magic := range compressedMagics {
+		if bytes.HasPrefix(head, magic) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateCompression: alg có được node này hỗ trợ không.
+func validateCompression(alg Compression) error {
+	if !supportsCompression(supportedCompressions, alg) {
+		return fmt.Errorf("unsupported compression %q", alg)
+	}
+	return nil
+}
+
+// decompressReader bọc r để đọc ra nội dung gốc của dữ liệu đã nén bằng alg.
+func decompressReader(alg Compression, r io.Reader) (io.ReadCloser, error) {
+	switch alg {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", alg)
+}
+
+// compressWriter nén dữ liệu ghi qua nó bằng alg rồi chuyển xuống dst – nhưng chỉ khi đáng nén:
+// sniffLen byte đầu được giữ lại để quyết định (nội dung đã nén sẵn hoặc quá nhỏ → ghi nguyên trạng).
+// Sau Close, Algorithm() cho biết dữ liệu ở dst có thực sự được nén không.
+type compressWriter struct {
+	alg         Compression
+	contentType string
+	dst         io.Writer
+
+	head    []byte
+	decided bool
+	w       io.Writer      // đích sau khi đã quyết định (dst hoặc zw)
+	zw      io.WriteCloser // nil nếu không nén
+}
+
+func newCompressWriter(alg Compression, contentType string, dst io.Writer) *compressWriter {
+	return &compressWriter{alg: alg, contentType: contentType, dst: dst}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.decided {
+		return c.w.Write(p)
+	}
+	c.head = append(c.head, p...)
+	if len(c.head) < sniffLen {
+		return len(p), nil
+	}
+	if err := c.decide(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decide chọn nén hay không rồi đẩy phần head đã giữ lại xuống đích.
+// full = false: nội dung kết thúc trước khi đủ sniffLen byte → quá nhỏ, không nén.
+func (c *compressWriter) decide(full bool) error {
+	c.decided, c.w = true, c.dst
+	if full && compressible(c.contentType, c.head) {
+		switch c.alg {
+		case CompressionGzip:
+			c.zw = gzip.NewWriter(c.dst)
+		default:
+			return fmt.Errorf("unsupported compression %q", c.alg)
+		}
+		c.w = c.zw
+	}
+	head := c.head
+	c.head = nil
+	_, err := c.w.Write(head)
+	return err
+}
+
+// Close ghi nốt phần còn giữ lại và kết thúc stream nén (không đóng dst).
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if err := c.decide(false); err != nil {
+			return err
+		}
+	}
+	if c.zw != nil {
+		return c.zw.Close()
+	}
+	return nil
+}
+
+// Algorithm: thuật toán thực sự đã dùng (CompressionNone nếu quyết định không nén).
+func (c *compressWriter) Algorithm() Compression {
+	if c.zw == nil {
+		return CompressionNone
+	}
+	return c.alg
+}
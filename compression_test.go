@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestStoreCompression: văn bản được nén khi ghi và giải nén trong suốt khi đọc;
+// nội dung đã nén sẵn hoặc quá nhỏ được ghi nguyên trạng.
+func TestStoreCompression(t *testing.T) {
+	s := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		Backend:           NewMemoryBackend(),
+		Compression:       CompressionGzip,
+	})
+	id := generateID()
+
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200))
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1024)...)
+	tests := []struct {
+		key  string
+		data []byte
+		want Compression
+	}{
+		{"notes.txt", text, CompressionGzip},
+		{"photo.png", png, CompressionNone},
+		{"tiny.txt", []byte("tiny"), CompressionNone},
+	}
+
+	for _, tc := range tests {
+		n, err := s.Write(id, tc.key, bytes.NewReader(tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(tc.data)) {
+			t.Errorf("%s: want %d bytes written have %d", tc.key, len(tc.data), n)
+		}
+
+		meta, err := s.Stat(id, tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Compression != tc.want || meta.Size != int64(len(tc.data)) {
+			t.Errorf("%s: unexpected meta %+v", tc.key, meta)
+		}
+
+		size, r, err := s.Read(id, tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		if size != int64(len(tc.data)) || !bytes.Equal(data, tc.data) {
+			t.Errorf("%s: content mismatch after read (%d bytes)", tc.key, size)
+		}
+
+		// MemoryBackend không có ranged read → ReadRange giải nén rồi bỏ qua phần đầu
+		rr, err := s.ReadRange(id, tc.key, 3, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tc.data[3:]
+		if len(want) > 10 {
+			want = want[:10]
+		}
+		if part, _ := ioutil.ReadAll(rr); !bytes.Equal(part, want) {
+			t.Errorf("%s: range mismatch %q", tc.key, part)
+		}
+		rr.Close()
+
+		rawSize, raw, err := s.ReadRaw(id, tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.Close()
+		if tc.want != CompressionNone && rawSize >= size {
+			t.Errorf("%s: expected stored bytes (%d) smaller than content (%d)", tc.key, rawSize, size)
+		}
+	}
+
+	// Index dựng lại từ sidecar vẫn biết object nào đang nén
+	if err := s.RebuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, r, err := s.Read(id, "notes.txt"); err != nil {
+		t.Fatal(err)
+	} else if data, _ := ioutil.ReadAll(r); !bytes.Equal(data, text) {
+		t.Errorf("content mismatch after index rebuild")
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                   VERSION VECTOR & XUNG ĐỘT GHI ĐỒNG THỜI                    //
+////////////////////////////////////////////////////////////////////////////////
+
+// VersionVector: replica ID → số lần ghi của replica đó mà phiên bản này đã "thấy".
+// Phiên bản A mới hơn B khi A >= B ở mọi phần tử; không bên nào >= bên kia → ghi đồng thời (xung đột).
+type VersionVector map[string]uint64
+
+// Ordering: quan hệ giữa 2 version vector.
+type Ordering int
+
+const (
+	OrderEqual      Ordering = iota // giống hệt
+	OrderBefore                     // cũ hơn (bị vector kia bao trùm)
+	OrderAfter                      // mới hơn (bao trùm vector kia)
+	OrderConcurrent                 // ghi đồng thời – không bên nào biết về bên kia
+)
+
+// Compare so sánh v với o.
+func (v VersionVector) Compare(o VersionVector) Ordering {
+	less, greater := false, false
+	for replica, n := range v {
+		if n > o[replica] {
+			greater = true
+		} else if n < o[replica] {
+			less = true
+		}
+	}
+	for replica, n := range o {
+		if _, ok := v[replica]; !ok && n > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return OrderConcurrent
+	case less:
+		return OrderBefore
+	case greater:
+		return OrderAfter
+	}
+	return OrderEqual
+}
+
+// Merge trả về vector mới = max từng phần tử của v và o.
+func (v VersionVector) Merge(o VersionVector) VersionVector {
+	merged := make(VersionVector, len(v)+len(o))
+	for replica, n := range v {
+		merged[replica] = n
+	}
+	for replica, n := range o {
+		if n > merged[replica] {
+			merged[replica] = n
+		}
+	}
+	return merged
+}
+
+// Increment trả về bản sao của v với bộ đếm của replica tăng 1 (đánh dấu 1 lần ghi mới).
+func (v VersionVector) Increment(replica string) VersionVector {
+	next := v.Merge(nil)
+	next[replica]++
+	return next
+}
+
+func (v VersionVector) String() string {
+	parts := make([]string, 0, len(v))
+	for replica, n := range v {
+		parts = append(parts, fmt.Sprintf("%s:%d", replica, n))
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// ConflictPolicy: cách xử lý các phiên bản ghi đồng thời (siblings) của 1 key.
+//   - ConflictLastWriterWins: giữ phiên bản có Timestamp (HLC) lớn hơn, ngay khi nhận bản sao.
+//   - ConflictKeepBoth      : giữ mọi sibling; đọc key sẽ trả về *ConflictError cho tới khi được Resolve.
+//   - ConflictMerge         : giữ mọi sibling; node chủ gộp chúng bằng MergeFunc khi đọc.
+type ConflictPolicy string
+
+const (
+	ConflictLastWriterWins ConflictPolicy = ""
+	ConflictKeepBoth       ConflictPolicy = "keep-both"
+	ConflictMerge          ConflictPolicy = "merge"
+)
+
+// Sibling: 1 phiên bản ghi đồng thời của key (metadata + nội dung gốc).
+type Sibling struct {
+	Meta ObjectMeta
+	Data []byte
+}
+
+// MergeFunc gộp các sibling của key thành 1 nội dung mới (dùng với ConflictMerge).
+// Nên là hàm tất định để mọi node gộp ra cùng kết quả.
+type MergeFunc func(key string, siblings []Sibling) ([]byte, error)
+
+// ErrConflict: key có nhiều phiên bản đồng thời chưa được giải quyết (chi tiết trong *ConflictError).
+var ErrConflict = errors.New("conflicting versions")
+
+// ConflictError: các sibling của key (phiên bản hiện hành trước).
+type ConflictError struct {
+	Key      string
+	Siblings []ObjectMeta
+}
+
+func (e *ConflictError) Error() string {
+	ids := make([]string, len(e.Siblings))
+	for i, meta := range e.Siblings {
+		ids[i] = meta.VersionID
+	}
+	return fmt.Sprintf("%s: %s has %d siblings (%s)", ErrConflict, e.Key, len(e.Siblings), strings.Join(ids, ", "))
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// newerThan: a thắng b theo last-writer-wins – Timestamp (HLC) lớn hơn, hòa thì so Replica
+// rồi VersionID để mọi node chọn cùng 1 phiên bản.
+func newerThan(a ObjectMeta, b ObjectMeta) bool {
+	if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+		return c > 0
+	}
+	if a.Replica != b.Replica {
+		return a.Replica > b.Replica
+	}
+	return a.VersionID > b.VersionID
+}
+
+// ReplicaOutcome: kết quả nhận 1 bản sao (xem Store.WriteReplica).
+type ReplicaOutcome int
+
+const (
+	ReplicaApplied ReplicaOutcome = iota // mới hơn bản đang có → ghi đè
+	ReplicaStale                         // cũ hơn/trùng bản đang có → bỏ qua
+	ReplicaWon                           // đồng thời, thắng theo LWW → ghi đè
+	ReplicaLost                          // đồng thời, thua theo LWW → bỏ qua (bản đang có nhận vector gộp)
+	ReplicaSibling                       // đồng thời → giữ làm sibling
+)
+
+func (o ReplicaOutcome) String() string {
+	return [...]string{"applied", "stale", "won", "lost", "sibling"}[o]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                        SIBLINGS TRONG STORE                                //
+////////////////////////////////////////////////////////////////////////////////
+
+// siblingsDirName: sibling của object "id/<location>" nằm ở "<siblings>/id/<location>/<versionID>"
+// (+ sidecar .meta) – thư mục ẩn như lịch sử phiên bản, không tính vào index/quota.
+const siblingsDirName = ".siblings"
+
+// siblingDir: thư mục (prefix) chứa sibling của object ở path.
+func siblingDir(path string) string {
+	return siblingsDirName + "/" + path + "/"
+}
+
+// siblingsOf đọc các sibling (ngoài phiên bản hiện hành) của object ở path.
+func (s *Store) siblingsOf(path string) ([]string, []sidecar, error) {
+	paths, err := s.backend.List(siblingDir(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		siblings []string
+		sidecars []sidecar
+	)
+	for _, p := range paths {
+		if strings.HasSuffix(p, metaFileSuffix) {
+			continue
+		}
+		sc, err := s.readSidecar(p)
+		if err != nil {
+			log.Printf("siblings: skipping %s: %s", p, err)
+			continue
+		}
+		siblings = append(siblings, p)
+		sidecars = append(sidecars, sc)
+	}
+	return siblings, sidecars, nil
+}
+
+// knownVector: vector bao trùm phiên bản hiện hành, mọi sibling và lần xóa gần nhất (tombstone)
+// của (id, key) – lần ghi/xóa cục bộ tiếp theo dựa trên nó nên thay thế tất cả.
+func (s *Store) knownVector(id string, key string) VersionVector {
+	var vector VersionVector
+	path := s.objectPath(id, s.PathTransformFunc(key))
+	if meta, err := s.Stat(id, key); err == nil {
+		vector = vector.Merge(meta.Vector)
+	}
+	if tomb, ok := s.tombstone(path); ok {
+		vector = vector.Merge(tomb.Vector)
+	}
+	_, sidecars, err := s.siblingsOf(path)
+	if err != nil {
+		log.Printf("siblings of %s: %s", key, err)
+	}
+	for _, sc := range sidecars {
+		vector = vector.Merge(sc.Vector)
+	}
+	return vector
+}
+
+// deleteSiblings xóa sibling nào thỏa drop (nil → xóa hết) của object ở path.
+func (s *Store) deleteSiblings(path string, drop func(sidecar) bool) error {
+	siblings, sidecars, err := s.siblingsOf(path)
+	if err != nil {
+		return err
+	}
+	for i, p := range siblings {
+		if drop != nil && !drop(sidecars[i]) {
+			continue
+		}
+		for _, f := range []string{p + metaFileSuffix, p} {
+			if err := s.backend.Delete(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteReplica ghi bản sao nhận từ node khác (meta mang version vector của node gốc), đối chiếu
+// với phiên bản đang có:
+//   - bản sao mới hơn → ghi đè; cũ hơn/trùng (kể cả trùng 1 sibling) → bỏ qua;
+//   - ghi đồng thời → theo ConflictPolicy: LWW chọn ngay 1 bên (bên được giữ mang vector gộp
+//     của cả 2), còn lại giữ bản sao làm sibling.
+//
+// Dữ liệu từ r luôn được đọc hết kể cả khi bị bỏ qua. Bản sao không có vector (node cũ) được ghi đè như trước.
+func (s *Store) WriteReplica(id string, key string, meta ObjectMeta, r io.Reader) (ReplicaOutcome, error) {
+	return s.writeReplica(id, key, meta, func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+}
+
+// WriteDecryptReplica: WriteReplica với dữ liệu đã mã hóa (AES) – giải mã trước khi ghi.
+func (s *Store) WriteDecryptReplica(encKey []byte, id string, key string, meta ObjectMeta, r io.Reader) (ReplicaOutcome, error) {
+	return s.writeReplica(id, key, meta, func(w io.Writer) (int64, error) {
+		n, err := copyDecrypt(encKey, r, w)
+		return int64(n), err
+	})
+}
+
+func (s *Store) writeReplica(id string, key string, meta ObjectMeta, write func(io.Writer) (int64, error)) (ReplicaOutcome, error) {
+	// discard đọc bỏ dữ liệu (để stream của peer không bị kẹt) rồi trả về outcome / cause
+	discard := func(outcome ReplicaOutcome, cause error) (ReplicaOutcome, error) {
+		_, err := write(io.Discard)
+		if cause != nil {
+			return outcome, cause
+		}
+		return outcome, err
+	}
+	if len(meta.Vector) == 0 {
+		_, err := s.writeAtomic(id, key, meta, write)
+		return ReplicaApplied, err
+	}
+
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	path := s.objectPath(id, s.PathTransformFunc(key))
+	current, err := s.Stat(id, key)
+	exists := err == nil
+	if exists {
+		if ord := meta.Vector.Compare(current.Vector); ord == OrderEqual || ord == OrderBefore {
+			return discard(ReplicaStale, nil)
+		}
+	} else if tomb, ok := s.tombstone(path); ok {
+		// Key đã bị xóa: chỉ lần ghi mà lần xóa chưa "thấy" mới được nhận (đồng thời → LWW)
+		switch meta.Vector.Compare(tomb.Vector) {
+		case OrderEqual, OrderBefore:
+			return discard(ReplicaStale, nil)
+		case OrderConcurrent:
+			merged := meta.Vector.Merge(tomb.Vector)
+			if !newerThan(meta, tomb) {
+				tomb.Vector = merged
+				return discard(ReplicaLost, s.writeTombstone(path, key, tomb))
+			}
+			meta.Vector = merged
+			_, err := s.writeAtomic(id, key, meta, write)
+			return ReplicaWon, err
+		}
+	}
+	_, sidecars, err := s.siblingsOf(path)
+	if err != nil {
+		return discard(ReplicaStale, err)
+	}
+	for _, sc := range sidecars {
+		if ord := meta.Vector.Compare(sc.Vector); ord == OrderEqual || ord == OrderBefore {
+			return discard(ReplicaStale, nil)
+		}
+	}
+	// Sibling mà bản sao này đã "thấy" không còn cần giữ
+	if err := s.deleteSiblings(path, func(sc sidecar) bool {
+		return meta.Vector.Compare(sc.Vector) == OrderAfter
+	}); err != nil {
+		return discard(ReplicaStale, err)
+	}
+
+	if !exists || meta.Vector.Compare(current.Vector) == OrderAfter {
+		_, err := s.writeAtomic(id, key, meta, write)
+		return ReplicaApplied, err
+	}
+
+	// Ghi đồng thời
+	log.Printf("conflict on [%s]: %s %s vs %s %s", key, current.VersionID, current.Vector, meta.VersionID, meta.Vector)
+	if s.ConflictPolicy == ConflictLastWriterWins {
+		merged := meta.Vector.Merge(current.Vector)
+		if newerThan(meta, current) {
+			meta.Vector = merged
+			_, err := s.writeAtomic(id, key, meta, write)
+			return ReplicaWon, err
+		}
+		current.Vector = merged
+		return discard(ReplicaLost, s.writeMeta(path, key, current))
+	}
+	return ReplicaSibling, s.writeSibling(path, meta, write)
+}
+
+// writeSibling lưu dữ liệu (nguyên trạng) + metadata của 1 phiên bản đồng thời cạnh object ở path.
+func (s *Store) writeSibling(path string, meta ObjectMeta, write func(io.Writer) (int64, error)) error {
+	if len(meta.VersionID) == 0 {
+		meta.VersionID = s.newVersionID()
+	}
+	dst := siblingDir(path) + meta.VersionID
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := write(pw)
+		pw.CloseWithError(err)
+	}()
+	_, err := s.backend.Put(dst, pr)
+	pr.CloseWithError(errors.New("store: write aborted"))
+	if err != nil {
+		return err
+	}
+	return s.writeSidecar(dst, sidecar{ObjectMeta: meta})
+}
+
+// Siblings: các phiên bản đồng thời của key – phiên bản hiện hành trước, rồi các sibling theo VersionID.
+// Chỉ 1 phần tử → không có xung đột.
+func (s *Store) Siblings(id string, key string) ([]ObjectMeta, error) {
+	var siblings []ObjectMeta
+	if meta, err := s.Stat(id, key); err == nil {
+		siblings = append(siblings, meta)
+	}
+	_, sidecars, err := s.siblingsOf(s.objectPath(id, s.PathTransformFunc(key)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sidecars, func(i, j int) bool { return sidecars[i].VersionID < sidecars[j].VersionID })
+	for _, sc := range sidecars {
+		siblings = append(siblings, sc.ObjectMeta)
+	}
+	if len(siblings) == 0 {
+		return nil, fmt.Errorf("siblings %s: %w", key, os.ErrNotExist)
+	}
+	return siblings, nil
+}
+
+// ReadSibling đọc nội dung (đã giải nén) của 1 sibling theo VersionID (kể cả phiên bản hiện hành).
+func (s *Store) ReadSibling(id string, key string, versionID string) (int64, io.ReadCloser, error) {
+	if meta, err := s.Stat(id, key); err == nil && meta.VersionID == versionID {
+		size, r, err := s.Read(id, key)
+		if err != nil {
+			return 0, nil, err
+		}
+		return size, r.(io.ReadCloser), nil
+	}
+
+	sc, _, r, err := s.openSibling(id, key, versionID)
+	if err != nil {
+		return 0, nil, err
+	}
+	zr, err := decompressReader(sc.Compression, r)
+	if err != nil {
+		r.Close()
+		return 0, nil, err
+	}
+	return sc.Size, &decompressedReader{ReadCloser: zr, raw: r}, nil
+}
+
+// openSibling mở bytes nguyên trạng (kèm kích thước trong backend) + sidecar của 1 sibling
+// (không gồm phiên bản hiện hành).
+func (s *Store) openSibling(id string, key string, versionID string) (sidecar, int64, io.ReadCloser, error) {
+	if len(versionID) == 0 || strings.ContainsAny(versionID, `/\`) {
+		return sidecar{}, 0, nil, fmt.Errorf("invalid version id %q", versionID)
+	}
+	path := siblingDir(s.objectPath(id, s.PathTransformFunc(key))) + versionID
+
+	sc, err := s.readSidecar(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return sc, 0, nil, fmt.Errorf("sibling %s of %s: %w", versionID, key, os.ErrNotExist)
+		}
+		return sc, 0, nil, err
+	}
+	size, r, err := s.backend.Get(path)
+	return sc, size, r, err
+}
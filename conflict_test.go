@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestVersionVector: so sánh / gộp / tăng version vector.
+func TestVersionVector(t *testing.T) {
+	a := VersionVector{"a": 2, "b": 1}
+	for _, tc := range []struct {
+		other VersionVector
+		want  Ordering
+	}{
+		{VersionVector{"a": 2, "b": 1}, OrderEqual},
+		{VersionVector{"a": 1}, OrderAfter},
+		{VersionVector{"a": 2, "b": 1, "c": 1}, OrderBefore},
+		{VersionVector{"a": 1, "b": 2}, OrderConcurrent},
+		{nil, OrderAfter},
+	} {
+		if have := a.Compare(tc.other); have != tc.want {
+			t.Errorf("%s vs %s: want %d have %d", a, tc.other, tc.want, have)
+		}
+	}
+
+	merged := a.Merge(VersionVector{"a": 1, "c": 3})
+	if merged.String() != "{a:2,b:1,c:3}" {
+		t.Errorf("unexpected merge %s", merged)
+	}
+	if next := a.Increment("b"); next["b"] != 2 || a["b"] != 1 {
+		t.Errorf("increment must copy: %s / %s", next, a)
+	}
+}
+
+// writeOn ghi data vào writer (1 replica khác) rồi trả về metadata để dùng như bản sao nhận từ mạng.
+func writeOn(t *testing.T, writer *Store, id string, key string, data []byte) ObjectMeta {
+	if _, err := writer.Write(id, key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := writer.Stat(id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+// TestStoreWriteReplica: bản sao mới hơn ghi đè, cũ hơn bị bỏ qua, ghi đồng thời xử lý theo ConflictPolicy.
+func TestStoreWriteReplica(t *testing.T) {
+	id := generateID()
+	newReplica := func(name string, policy ConflictPolicy) *Store {
+		return NewStore(StoreOpts{
+			PathTransformFunc: CASPathTransformFunc,
+			Backend:           NewMemoryBackend(),
+			ReplicaID:         name,
+			ConflictPolicy:    policy,
+		})
+	}
+	read := func(s *Store) string {
+		_, r, err := s.Read(id, "doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	// a ghi v1 → b nhận rồi ghi đè v2 (mới hơn); a ghi v3 đồng thời với v2
+	a, b := newReplica("a", ConflictLastWriterWins), newReplica("b", ConflictLastWriterWins)
+	v1 := writeOn(t, a, id, "doc", []byte("v1"))
+	if outcome, err := b.WriteReplica(id, "doc", v1, bytes.NewReader([]byte("v1"))); err != nil || outcome != ReplicaApplied {
+		t.Fatalf("want applied have %s (%v)", outcome, err)
+	}
+	v2 := writeOn(t, b, id, "doc", []byte("v2"))
+	v3 := writeOn(t, a, id, "doc", []byte("v3"))
+	if v2.Vector.Compare(v1.Vector) != OrderAfter || v3.Vector.Compare(v2.Vector) != OrderConcurrent {
+		t.Fatalf("unexpected vectors v1=%s v2=%s v3=%s", v1.Vector, v2.Vector, v3.Vector)
+	}
+
+	t.Run("stale", func(t *testing.T) {
+		if outcome, err := b.WriteReplica(id, "doc", v1, bytes.NewReader([]byte("v1"))); err != nil || outcome != ReplicaStale {
+			t.Errorf("want stale have %s (%v)", outcome, err)
+		}
+		if read(b) != "v2" {
+			t.Errorf("stale replica must not overwrite")
+		}
+	})
+
+	t.Run("last-writer-wins", func(t *testing.T) {
+		// v3 ghi sau (HLC lớn hơn) → thắng ở mọi node bất kể thứ tự nhận
+		contents := map[string]string{v2.VersionID: "v2", v3.VersionID: "v3"}
+		for _, order := range [][]ObjectMeta{{v2, v3}, {v3, v2}} {
+			c := newReplica("c", ConflictLastWriterWins)
+			for _, meta := range order {
+				if _, err := c.WriteReplica(id, "doc", meta, bytes.NewReader([]byte(contents[meta.VersionID]))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			meta, _ := c.Stat(id, "doc")
+			if read(c) != "v3" || meta.Vector.Compare(v2.Vector.Merge(v3.Vector)) != OrderEqual {
+				t.Errorf("want v3 with merged vector, have %s %s", read(c), meta.Vector)
+			}
+		}
+	})
+
+	t.Run("keep-both", func(t *testing.T) {
+		c := newReplica("c", ConflictKeepBoth)
+		c.WriteReplica(id, "doc", v2, bytes.NewReader([]byte("v2")))
+		if outcome, err := c.WriteReplica(id, "doc", v3, bytes.NewReader([]byte("v3"))); err != nil || outcome != ReplicaSibling {
+			t.Fatalf("want sibling have %s (%v)", outcome, err)
+		}
+		siblings, err := c.Siblings(id, "doc")
+		if err != nil || len(siblings) != 2 {
+			t.Fatalf("want 2 siblings have %+v (%v)", siblings, err)
+		}
+		_, r, err := c.ReadSibling(id, "doc", v3.VersionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := io.ReadAll(r); string(data) != "v3" {
+			t.Errorf("want sibling v3 have %s", data)
+		}
+
+		// Ghi cục bộ thay thế mọi sibling
+		resolved := writeOn(t, c, id, "doc", []byte("v2+v3"))
+		if siblings, _ := c.Siblings(id, "doc"); len(siblings) != 1 {
+			t.Errorf("local write should resolve siblings, have %+v", siblings)
+		}
+		for _, meta := range []ObjectMeta{v2, v3} {
+			if resolved.Vector.Compare(meta.Vector) != OrderAfter {
+				t.Errorf("resolved %s must descend %s", resolved.Vector, meta.Vector)
+			}
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                       NGỪNG HOẠT ĐỘNG NODE (DECOMMISSION)                   //
+////////////////////////////////////////////////////////////////////////////////
+
+// Decommission cho node nghỉ hưu mà không làm mất bản sao:
+//   - Node từ chối ghi mới (Store/Delete trả về ErrDecommissioning, bản sao mới từ peer bị từ chối) và báo cho
+//     peers (MessageHello.Decommissioning) → họ không chọn node này làm chỗ đặt nữa và rebalance key của mình.
+//   - Mỗi lượt: key của chính node được rebalance sang member khác; bản sao giữ hộ namespace khác được chuyển
+//     nguyên trạng (đã mã hóa bằng khóa của chủ) tới các node được chọn cho tới khi đủ ReplicationFactor bản.
+//   - Lượt nào còn object chưa đủ bản sao xác nhận thì chờ decommissionRetry rồi chạy lại; đủ hết → Stop.
+
+// decommissionRetry: khoảng nghỉ giữa 2 lượt chuyển giao khi còn object chưa đủ bản sao.
+const decommissionRetry = 2 * time.Second
+
+// ErrDecommissioning: node đang ngừng hoạt động, không nhận ghi mới.
+var ErrDecommissioning = errors.New("node is being decommissioned")
+
+// DecommissionStatus: tiến độ ngừng hoạt động.
+type DecommissionStatus struct {
+	Active     bool      // đã bắt đầu decommission
+	Done       bool      // mọi object đã đủ bản sao, node đã dừng
+	StartedAt  time.Time // lúc bắt đầu
+	FinishedAt time.Time // lúc hoàn tất
+	Passes     int       // số lượt chuyển giao đã chạy
+	Objects    int       // số object đã xét trong lượt gần nhất (kể cả key của chính node)
+	HandedOff  int       // số object đã đủ bản sao ở node khác trong lượt gần nhất
+	Pending    int       // số object chưa đủ bản sao trong lượt gần nhất
+	Copied     int       // tổng số bản sao đã chuyển và xác nhận
+	Bytes      int64     // tổng số byte đã stream
+	LastError  string    `json:",omitempty"`
+}
+
+// decommissioner giữ trạng thái decommission của node. An toàn khi dùng đồng thời.
+type decommissioner struct {
+	mu     sync.Mutex
+	status DecommissionStatus
+}
+
+// decommissioning: node đang (hoặc đã) ngừng hoạt động.
+func (s *FileServer) decommissioning() bool {
+	s.decom.mu.Lock()
+	defer s.decom.mu.Unlock()
+	return s.decom.status.Active
+}
+
+// DecommissionStatus: bản sao tiến độ ngừng hoạt động hiện tại.
+func (s *FileServer) DecommissionStatus() DecommissionStatus {
+	s.decom.mu.Lock()
+	defer s.decom.mu.Unlock()
+	return s.decom.status
+}
+
+// Decommission dừng nhận ghi mới, chuyển mọi object node đang giữ sang node khác cho tới khi đủ bản sao,
+// rồi dừng server. Chặn tới khi xong (hoặc server bị dừng giữa chừng); tiến độ xem qua DecommissionStatus.
+func (s *FileServer) Decommission() error {
+	s.decom.mu.Lock()
+	if s.decom.status.Active {
+		s.decom.mu.Unlock()
+		return ErrDecommissioning
+	}
+	s.decom.status = DecommissionStatus{Active: true, StartedAt: time.Now().UTC()}
+	s.decom.mu.Unlock()
+	log.Printf("[%s] decommissioning: no longer accepting writes", s.Transport.Addr())
+
+	s.announceDecommission()
+	for {
+		if s.handOff() == 0 {
+			break
+		}
+		select {
+		case <-time.After(decommissionRetry):
+		case <-s.quitch:
+			return fmt.Errorf("server stopped before decommission finished")
+		}
+	}
+
+	s.decom.mu.Lock()
+	s.decom.status.Done, s.decom.status.FinishedAt = true, time.Now().UTC()
+	s.decom.mu.Unlock()
+	log.Printf("[%s] decommission finished, shutting down", s.Transport.Addr())
+	s.Stop()
+	return nil
+}
+
+// announceDecommission chào lại mọi peer với cờ Decommissioning, và kết nối tới chủ của các namespace
+// node đang giữ hộ (lời chào khi kết nối mang cờ) để họ rebalance key của mình ra khỏi node này.
+func (s *FileServer) announceDecommission() {
+	for _, addr := range s.peerAddrs() {
+		if err := s.sendTo(addr, &Message{Payload: s.hello()}); err != nil {
+			log.Printf("announcing decommission to %s: %s", addr, err)
+		}
+	}
+
+	owners := make(map[string]bool)
+	for _, id := range s.store.Namespaces() {
+		owners[id] = true
+	}
+	for _, m := range s.clusterMembers() {
+		if owners[m.ID] {
+			if _, err := s.connectTo(m); err != nil {
+				log.Printf("announcing decommission to owner %s: %s", m.Addr, err)
+			}
+		}
+	}
+}
+
+// handOff chạy 1 lượt chuyển giao, trả về số object chưa đủ bản sao.
+func (s *FileServer) handOff() int {
+	own := s.Rebalance()
+
+	var (
+		members          = s.clusterMembers()
+		handedOff, total int
+		lastErr          string
+		id, key          string
+	)
+	for {
+		var ok bool
+		if id, key, _, ok = s.store.index.next(id, key); !ok {
+			break
+		}
+		if id == s.ID {
+			continue
+		}
+		total++
+		if err := s.handOffReplica(id, key, members); err != nil {
+			lastErr = fmt.Sprintf("%s/%s: %s", id, key, err)
+			log.Printf("decommission: %s", lastErr)
+			continue
+		}
+		handedOff++
+	}
+
+	pending := total - handedOff + own.Pending
+	s.decom.mu.Lock()
+	defer s.decom.mu.Unlock()
+	st := &s.decom.status
+	st.Passes++
+	st.Objects = total + own.Checked
+	st.HandedOff = handedOff + own.Checked - own.Pending
+	st.Pending = pending
+	st.Copied += own.Copied
+	st.Bytes += own.Bytes
+	if len(lastErr) == 0 {
+		lastErr = own.LastError
+	}
+	st.LastError = lastErr
+	log.Printf("decommission pass %d: %d of %d objects handed off", st.Passes, st.HandedOff, st.Objects)
+	return pending
+}
+
+// handOffReplica bảo đảm bản sao (id, key) node đang giữ hộ có mặt ở các node được chọn (trừ chủ namespace),
+// chuyển nguyên trạng bytes tới node còn thiếu.
+func (s *FileServer) handOffReplica(id string, key string, members []PeerInfo) error {
+	meta, err := s.store.Stat(id, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // chủ vừa xóa bản sao này (rebalance)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Chỗ đặt tính như chủ namespace tính (replicaTargets) – theo key gốc trong metadata.
+	var candidates []PeerInfo
+	for _, m := range members {
+		if m.ID != id {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no other member to hand off to")
+	}
+	placeKey := meta.Key
+	if len(placeKey) == 0 {
+		placeKey = key
+	}
+	sortByDistance(dhtKey(id, placeKey), candidates)
+	if s.ReplicationFactor > 0 && len(candidates) > s.ReplicationFactor {
+		candidates = candidates[:s.ReplicationFactor]
+	}
+
+	for _, p := range candidates {
+		if err := s.handOffTo(p, id, key, meta); err != nil {
+			return fmt.Errorf("%s: %w", p.Addr, err)
+		}
+	}
+	return nil
+}
+
+// handOffTo gửi bản sao (id, key) nguyên trạng tới node p nếu p chưa có đúng phiên bản, rồi xác nhận.
+func (s *FileServer) handOffTo(p PeerInfo, id string, key string, meta ObjectMeta) error {
+	if !s.beginTransfer() {
+		return ErrShuttingDown
+	}
+	defer s.endTransfer()
+
+	addr, err := s.connectTo(p)
+	if err != nil {
+		return err
+	}
+	if ok, err := s.hasReplica(addr, id, key, meta); err != nil || ok {
+		return err
+	}
+	if !s.peerSupports(addr, meta.Compression) {
+		return fmt.Errorf("peer does not support %s compression", meta.Compression)
+	}
+
+	size, r, err := s.store.ReadRaw(id, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	msg := MessageStoreFile{ID: id, Key: key, Size: size, Meta: meta}
+	if err := s.pushObject(addr, msg, func(w io.Writer) (int64, error) {
+		return io.Copy(w, io.LimitReader(r, size))
+	}); err != nil {
+		return err
+	}
+
+	ok, err := s.hasReplica(addr, id, key, meta)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("replica not confirmed after copying")
+	}
+
+	s.decom.mu.Lock()
+	s.decom.status.Copied++
+	s.decom.status.Bytes += size
+	s.decom.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                   DHT KADEMLIA: RPC, LOOKUP & PROVIDER RECORD                //
+////////////////////////////////////////////////////////////////////////////////
+
+// Mỗi object (owner, key) có vị trí dhtKey trong không gian ID. dhtK node có ID gần vị trí đó nhất (XOR)
+// giữ "provider record" = danh sách node đang có bản sao. Get tìm các node đó bằng lookup lặp
+// (mỗi vòng hỏi dhtAlpha node gần nhất chưa hỏi → O(log n) vòng) thay vì broadcast tới mọi peer;
+// node chưa kết nối được dial khi cần nên không cần full-mesh.
+
+const (
+	// dhtRequestTimeout: thời gian chờ 1 RPC (kể cả dial + chào hỏi nếu chưa kết nối).
+	dhtRequestTimeout = 3 * time.Second
+	// providerTTL: provider record hết hạn sau khoảng này nếu không được công bố lại (mỗi lần Store).
+	providerTTL = 24 * time.Hour
+)
+
+// Thông điệp FIND_NODE (request): “cho mình dhtK node gần Target nhất mà bạn biết”.
+type MessageFindNode struct {
+	Target string
+}
+
+// Trả lời FIND_NODE / FIND_VALUE: các node gần target nhất mà bên trả lời biết;
+// với FIND_VALUE, Providers = các node đang giữ object (nếu bên trả lời có provider record).
+type MessageFindNodeResponse struct {
+	Contacts  []PeerInfo
+	Providers []PeerInfo
+}
+
+// Thông điệp FIND_VALUE (request): như FIND_NODE nhưng trả về luôn provider record của Key nếu có.
+type MessageFindValue struct {
+	Key string
+}
+
+// Thông điệp STORE (request): “các node này (đủ danh sách) đang giữ object ở Key” – thay record cũ.
+type MessageDHTStore struct {
+	Key       string
+	Providers []PeerInfo
+}
+
+// Trả lời STORE.
+type MessageDHTStoreResponse struct {
+	Error string
+}
+
+// providerRecord: 1 node đang giữ object, kèm hạn của record.
+type providerRecord struct {
+	PeerInfo
+	expires time.Time
+}
+
+// providerStore: provider record node này giữ hộ (theo dhtKey). An toàn khi dùng đồng thời.
+type providerStore struct {
+	mu      sync.Mutex
+	records map[string]map[string]providerRecord // dhtKey → node ID → record
+}
+
+func newProviderStore() *providerStore {
+	return &providerStore{records: make(map[string]map[string]providerRecord)}
+}
+
+// set thay provider record của key bằng providers (bên công bố luôn gửi đủ danh sách node đang giữ object,
+// node đã bị xóa bản sao khi rebalance không còn trong record).
+func (ps *providerStore) set(key string, providers []PeerInfo) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	records := make(map[string]providerRecord)
+	ps.records[key] = records
+	expires := time.Now().Add(providerTTL)
+	for _, p := range providers {
+		records[p.ID] = providerRecord{PeerInfo: p, expires: expires}
+	}
+}
+
+// get: các provider còn hạn của key (record hết hạn bị xóa luôn).
+func (ps *providerStore) get(key string) []PeerInfo {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var providers []PeerInfo
+	now := time.Now()
+	for id, rec := range ps.records[key] {
+		if now.After(rec.expires) {
+			delete(ps.records[key], id)
+			continue
+		}
+		providers = append(providers, rec.PeerInfo)
+	}
+	if len(ps.records[key]) == 0 {
+		delete(ps.records, key)
+	}
+	return providers
+}
+
+// self: contact của chính node này (để công bố mình là provider).
+func (s *FileServer) self() PeerInfo {
+	return PeerInfo{ID: s.ID, Addr: s.Transport.Addr()}
+}
+
+// connectTo trả về địa chỉ kết nối (key của s.peers) tới node p; chưa kết nối → dial p.Addr (nếu chưa dial)
+// và chờ MessageHello của node đó (để chắc chắn đúng node ID) tối đa dhtRequestTimeout.
+func (s *FileServer) connectTo(p PeerInfo) (string, error) {
+	find := func() (string, bool) {
+		s.peerLock.Lock()
+		defer s.peerLock.Unlock()
+		for addr, id := range s.peerIDs {
+			if id == p.ID {
+				return addr, true
+			}
+		}
+		return "", false
+	}
+	if addr, ok := find(); ok {
+		return addr, nil
+	}
+
+	// Đã có kết nối tới đúng địa chỉ đó (hoặc goroutine khác đang dial node này) nhưng chưa chào hỏi xong
+	// → chỉ chờ. Dial thêm sẽ tạo kết nối thứ 2 cùng RemoteAddr, đè lên kết nối cũ trong s.peers.
+	s.peerLock.Lock()
+	_, pending := s.peers[p.Addr]
+	for _, dialing := range []map[string]time.Time{s.dialing, s.connecting} {
+		if started, ok := dialing[p.ID]; ok && time.Since(started) < peerDialTimeout {
+			pending = true
+		}
+	}
+	if !pending {
+		s.connecting[p.ID] = time.Now()
+	}
+	s.peerLock.Unlock()
+	if !pending {
+		if err := s.Transport.Dial(p.Addr); err != nil {
+			s.peerLock.Lock()
+			delete(s.connecting, p.ID)
+			s.peerLock.Unlock()
+			return "", err
+		}
+	}
+
+	deadline := time.Now().Add(dhtRequestTimeout)
+	for time.Now().Before(deadline) {
+		if addr, ok := find(); ok {
+			return addr, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "", fmt.Errorf("dht: no hello from %s (%s) after dialing", p.ID, p.Addr)
+}
+
+// dhtRequest gửi 1 RPC DHT tới node p (dial nếu cần). Lỗi → p bị bỏ khỏi bảng định tuyến;
+// thành công → p được ghi nhận là vừa liên lạc được.
+// ⚠️ Như request: không được gọi từ handler của loop.
+func (s *FileServer) dhtRequest(p PeerInfo, payload any) (any, error) {
+	addr, err := s.connectTo(p)
+	if err == nil {
+		var resp any
+		if resp, err = s.request(addr, payload, dhtRequestTimeout); err == nil {
+			s.routes.update(p)
+			return resp, nil
+		}
+	}
+	s.routes.remove(p.ID)
+	return nil, err
+}
+
+// lookup tìm dhtK node gần target nhất bằng cách hỏi lặp: mỗi vòng gửi FIND_NODE (hoặc FIND_VALUE nếu
+// findValue) tới tối đa dhtAlpha node gần nhất chưa hỏi; dừng khi dhtK node gần nhất đã biết đều đã được hỏi.
+// Với findValue, dừng ngay khi có node trả về provider record. Chính node này và node gossip đã báo
+// hỏng / rời cụm (bảng định tuyến của node khác có thể chưa cập nhật) không có trong kết quả.
+func (s *FileServer) lookup(target string, findValue bool) (closest []PeerInfo, providers []PeerInfo) {
+	var (
+		known   = make(map[string]PeerInfo)
+		queried = make(map[string]bool)
+		failed  = make(map[string]bool)
+	)
+	add := func(contacts []PeerInfo) {
+		for _, c := range contacts {
+			if c.ID != s.ID && len(c.Addr) > 0 && !s.memberGone(c.ID) {
+				if _, ok := known[c.ID]; !ok {
+					known[c.ID] = c
+				}
+			}
+		}
+	}
+	// nearest: dhtK node gần target nhất chưa bị lỗi
+	nearest := func() []PeerInfo {
+		var contacts []PeerInfo
+		for id, c := range known {
+			if !failed[id] {
+				contacts = append(contacts, c)
+			}
+		}
+		sortByDistance(target, contacts)
+		if len(contacts) > dhtK {
+			contacts = contacts[:dhtK]
+		}
+		return contacts
+	}
+	add(s.routes.closest(target, dhtK))
+
+	var payload any = MessageFindNode{Target: target}
+	if findValue {
+		payload = MessageFindValue{Key: target}
+	}
+	for {
+		var batch []PeerInfo
+		for _, c := range nearest() {
+			if !queried[c.ID] && len(batch) < dhtAlpha {
+				queried[c.ID] = true
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 {
+			return nearest(), nil
+		}
+
+		type result struct {
+			contact PeerInfo
+			resp    MessageFindNodeResponse
+			err     error
+		}
+		results := make(chan result, len(batch))
+		for _, c := range batch {
+			go func(c PeerInfo) {
+				resp, err := s.dhtRequest(c, payload)
+				if err != nil {
+					results <- result{contact: c, err: err}
+					return
+				}
+				r, ok := resp.(MessageFindNodeResponse)
+				if !ok {
+					results <- result{contact: c, err: fmt.Errorf("unexpected response %T", resp)}
+					return
+				}
+				results <- result{contact: c, resp: r}
+			}(c)
+		}
+		for range batch {
+			r := <-results
+			if r.err != nil {
+				log.Printf("dht: querying %s: %s", r.contact.Addr, r.err)
+				failed[r.contact.ID] = true
+				continue
+			}
+			add(r.resp.Contacts)
+			providers = append(providers, r.resp.Providers...)
+		}
+		if findValue && len(providers) > 0 {
+			return nearest(), providers
+		}
+	}
+}
+
+// findProviders: các node đang giữ object (owner, key) theo provider record trên DHT (kể cả record node này giữ).
+func (s *FileServer) findProviders(ownerID string, key string) []PeerInfo {
+	target := dhtKey(ownerID, key)
+	providers := s.providers.get(target)
+	if len(providers) == 0 {
+		_, providers = s.lookup(target, true)
+	}
+
+	seen := make(map[string]bool)
+	var unique []PeerInfo
+	for _, p := range providers {
+		if !seen[p.ID] {
+			seen[p.ID] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
+
+// announce công bố providers cho object (owner, key): STORE tới dhtK node gần dhtKey nhất.
+// Node công bố cũng giữ 1 bản record (Get của chính nó không cần hỏi mạng).
+func (s *FileServer) announce(ownerID string, key string, providers []PeerInfo) {
+	target := dhtKey(ownerID, key)
+	s.providers.set(target, providers)
+
+	closest, _ := s.lookup(target, false)
+	for _, c := range closest {
+		resp, err := s.dhtRequest(c, MessageDHTStore{Key: target, Providers: providers})
+		if err == nil {
+			if r, ok := resp.(MessageDHTStoreResponse); ok && len(r.Error) > 0 {
+				err = fmt.Errorf("%s", r.Error)
+			}
+		}
+		if err != nil {
+			log.Printf("dht: storing providers of %s on %s: %s", key, c.Addr, err)
+		}
+	}
+}
+
+// refreshRoutes: lookup chính ID của node → bảng định tuyến được lấp đầy bằng các node lân cận
+// (chạy khi vừa có contact đầu tiên và định kỳ).
+func (s *FileServer) refreshRoutes() {
+	s.lookup(s.ID, false)
+}
+
+// handleMessageFindNode: trả về dhtK node gần target nhất trong bảng định tuyến.
+func (s *FileServer) handleMessageFindNode(from string, reqID uint64, msg MessageFindNode) error {
+	return s.reply(from, reqID, MessageFindNodeResponse{Contacts: s.routes.closest(msg.Target, dhtK)})
+}
+
+// handleMessageFindValue: như FIND_NODE, kèm provider record của key (nếu node này giữ).
+func (s *FileServer) handleMessageFindValue(from string, reqID uint64, msg MessageFindValue) error {
+	return s.reply(from, reqID, MessageFindNodeResponse{
+		Contacts:  s.routes.closest(msg.Key, dhtK),
+		Providers: s.providers.get(msg.Key),
+	})
+}
+
+// handleMessageDHTStore: giữ hộ provider record. Chỉ node có trong record (hoặc chính chủ namespace)
+// mới được công bố – record do sender ký nên sender phải là 1 trong các provider.
+func (s *FileServer) handleMessageDHTStore(from string, sender string, reqID uint64, msg MessageDHTStore) error {
+	var resp MessageDHTStoreResponse
+	listed := false
+	for _, p := range msg.Providers {
+		listed = listed || p.ID == sender
+	}
+	if listed {
+		s.providers.set(msg.Key, msg.Providers)
+	} else {
+		resp.Error = fmt.Sprintf("node %s is not among the announced providers", sender)
+	}
+	return s.reply(from, reqID, resp)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"DistributedFileStorage/p2p"
+	"log"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                   TỰ TÌM PEER TRONG LAN (UDP MULTICAST)                     //
+////////////////////////////////////////////////////////////////////////////////
+
+// startDiscovery announce node trong LAN (nhóm multicast mặc định) và đưa node cùng DiscoveryCluster
+// tìm thấy vào sổ địa chỉ – connection manager dial chúng như peer học được qua peer exchange.
+// DiscoveryCluster rỗng → tắt.
+func (s *FileServer) startDiscovery() error {
+	if len(s.DiscoveryCluster) == 0 {
+		return nil
+	}
+	d, err := p2p.NewDiscovery(p2p.DiscoveryOpts{
+		Cluster:   s.DiscoveryCluster,
+		NodeID:    s.ID,
+		Addr:      s.Transport.Addr(),
+		Interface: s.DiscoveryInterface,
+		OnPeer:    s.onDiscoveredPeer,
+	})
+	if err != nil {
+		return err
+	}
+	s.peerLock.Lock()
+	s.discovery = d
+	s.peerLock.Unlock()
+	log.Printf("[%s] discovering peers of cluster %q on the local network", s.Transport.Addr(), s.DiscoveryCluster)
+	return nil
+}
+
+func (s *FileServer) stopDiscovery() {
+	s.peerLock.Lock()
+	d := s.discovery
+	s.peerLock.Unlock()
+	if d != nil {
+		d.Close()
+	}
+}
+
+func (s *FileServer) onDiscoveredPeer(p p2p.DiscoveredPeer) {
+	log.Printf("[%s] discovered peer %s at %s", s.Transport.Addr(), p.ID, p.Addr)
+	s.peerLock.Lock()
+	s.addrBook[p.ID] = p.Addr
+	s.peerLock.Unlock()
+
+	s.fillPeers()
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// diskFree: không hỗ trợ trên hệ điều hành này → -1 (không biết).
+func diskFree(path string) (int64, error) {
+	return -1, nil
+}
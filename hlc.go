@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                     ĐỒNG HỒ LOGIC LAI (HYBRID LOGICAL CLOCK)                //
+////////////////////////////////////////////////////////////////////////////////
+
+// Timestamp: mốc thời gian HLC = thời gian vật lý (unix nano) + bộ đếm logic.
+// So sánh theo Wall rồi Logical; luôn tăng kể cả khi đồng hồ hệ thống đứng yên/lùi lại.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+}
+
+// IsZero: mốc rỗng (object ghi trước khi có HLC).
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0
+}
+
+// Compare: -1 nếu t trước o, 1 nếu t sau o, 0 nếu bằng nhau.
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.Wall < o.Wall:
+		return -1
+	case t.Wall > o.Wall:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	}
+	return 0
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d", t.Wall, t.Logical)
+}
+
+// HLC: đồng hồ logic lai. An toàn khi dùng đồng thời.
+type HLC struct {
+	mu   sync.Mutex
+	last Timestamp
+	now  func() time.Time // đồng hồ vật lý (thay được trong test)
+}
+
+// NewHLC tạo đồng hồ dùng time.Now làm thời gian vật lý.
+func NewHLC() *HLC {
+	return &HLC{now: time.Now}
+}
+
+// Now cấp mốc cho 1 sự kiện cục bộ: lớn hơn mọi mốc đã cấp/nhận trước đó.
+func (c *HLC) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.now().UnixNano()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update gộp mốc nhận từ node khác: mốc cấp sau đó luôn lớn hơn remote.
+func (c *HLC) Update(remote Timestamp) Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.now().UnixNano()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default: // cùng Wall
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	}
+	return c.last
+}
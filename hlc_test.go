@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHLC: mốc luôn tăng kể cả khi đồng hồ vật lý đứng yên, và vượt qua mốc nhận từ node khác.
+func TestHLC(t *testing.T) {
+	wall := time.Unix(100, 0)
+	c := &HLC{now: func() time.Time { return wall }}
+
+	t1 := c.Now()
+	t2 := c.Now()
+	if t2.Compare(t1) <= 0 || t2.Wall != t1.Wall {
+		t.Errorf("expected logical tick, have %s then %s", t1, t2)
+	}
+
+	// Node khác có đồng hồ chạy trước → mốc sau đó phải lớn hơn mốc đã nhận
+	remote := Timestamp{Wall: wall.Add(time.Second).UnixNano(), Logical: 7}
+	if t3 := c.Update(remote); t3.Compare(remote) <= 0 {
+		t.Errorf("update %s must be after remote %s", t3, remote)
+	}
+	if t4 := c.Now(); t4.Compare(remote) <= 0 {
+		t.Errorf("now %s must be after remote %s", t4, remote)
+	}
+
+	// Đồng hồ vật lý vượt lên → trở lại dùng thời gian thực
+	wall = wall.Add(time.Minute)
+	if t5 := c.Now(); t5.Wall != wall.UnixNano() || t5.Logical != 0 {
+		t.Errorf("expected physical time, have %s", t5)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                        DANH TÍNH NODE (ED25519)                            //
+////////////////////////////////////////////////////////////////////////////////
+
+// defaultIdentityFileName: tên file chứa khóa bí mật của node, nằm trong StorageRoot.
+const defaultIdentityFileName = ".identity"
+
+// Identity là cặp khóa Ed25519 cố định của một node.
+// Node ID được suy ra trực tiếp từ public key (hex 32 byte = 64 ký tự, cùng độ dài với generateID)
+// → không ai có thể "mạo danh" một ID nếu không có private key tương ứng.
+type Identity struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+// NewIdentity sinh một cặp khóa mới (không lưu xuống đĩa).
+func NewIdentity() (*Identity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{PrivateKey: priv, PublicKey: pub}, nil
+}
+
+// LoadOrCreateIdentity đọc khóa từ path; nếu file chưa có thì sinh khóa mới và lưu lại (quyền 0600).
+// File chỉ chứa seed 32 byte dạng hex.
+func LoadOrCreateIdentity(path string) (*Identity, error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid identity key file %s", path)
+		}
+		priv := ed25519.NewKeyFromSeed(seed)
+		return &Identity{PrivateKey: priv, PublicKey: priv.Public().(ed25519.PublicKey)}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	id, err := NewIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	seed := hex.EncodeToString(id.PrivateKey.Seed())
+	if err := os.WriteFile(path, []byte(seed), 0600); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// NodeID: ID của node = hex(public key).
+func (id *Identity) NodeID() string {
+	return hex.EncodeToString(id.PublicKey)
+}
+
+// Sign ký dữ liệu bằng private key của node.
+func (id *Identity) Sign(data []byte) []byte {
+	return ed25519.Sign(id.PrivateKey, data)
+}
+
+// publicKeyFromNodeID: chuyển ngược node ID (hex) → public key.
+func publicKeyFromNodeID(nodeID string) (ed25519.PublicKey, error) {
+	b, err := hex.DecodeString(nodeID)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid node id %q", nodeID)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                       ENVELOPE: MESSAGE ĐÃ KÝ                               //
+////////////////////////////////////////////////////////////////////////////////
+
+// ErrInvalidSignature: chữ ký của message không khớp với node ID người gửi.
+var ErrInvalidSignature = errors.New("invalid message signature")
+
+// ErrChannelMismatch: message hợp lệ nhưng người ký không phải peer mà kênh Noise đã xác thực
+// (peer đang chuyển tiếp envelope của node khác).
+var ErrChannelMismatch = errors.New("message signer is not the authenticated channel peer")
+
+// Envelope là gói tin thực sự đi trên dây:
+//   - From     : node ID người gửi (chính là public key dạng hex).
+//   - Data     : Message đã gob-encode.
+//   - Signature: chữ ký Ed25519 trên Data.
+//
+// Ký trên bytes Data (thay vì trên struct) để bên nhận verify đúng những byte đã ký,
+// không phụ thuộc cách gob encode lại.
+type Envelope struct {
+	From      string
+	Data      []byte
+	Signature []byte
+}
+
+// sealMessage: gob-encode msg, ký và đóng gói thành bytes Envelope sẵn sàng gửi đi.
+func sealMessage(id *Identity, msg *Message) ([]byte, error) {
+	data := new(bytes.Buffer)
+	if err := gob.NewEncoder(data).Encode(msg); err != nil {
+		return nil, err
+	}
+
+	env := Envelope{
+		From:      id.NodeID(),
+		Data:      data.Bytes(),
+		Signature: id.Sign(data.Bytes()),
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&env); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openMessage: decode Envelope, verify chữ ký rồi decode Message bên trong.
+// Trả về node ID người gửi (đã được xác thực).
+func openMessage(b []byte) (string, *Message, error) {
+	var env Envelope
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&env); err != nil {
+		return "", nil, err
+	}
+
+	pub, err := publicKeyFromNodeID(env.From)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ed25519.Verify(pub, env.Data, env.Signature) {
+		return "", nil, ErrInvalidSignature
+	}
+
+	var msg Message
+	if err := gob.NewDecoder(bytes.NewReader(env.Data)).Decode(&msg); err != nil {
+		return "", nil, err
+	}
+	return env.From, &msg, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                       CHỮ KÝ CỦA CHỦ NAMESPACE                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// ErrOwnerSignature: bản sao không mang chữ ký hợp lệ của chủ namespace (ObjectMeta.OwnerSig).
+var ErrOwnerSignature = errors.New("missing or invalid owner signature")
+
+// ownerRecord: bytes chủ namespace ký cho 1 phiên bản bản sao – namespace, key, phiên bản và hash của
+// đúng những byte được stream. Node giữ hộ lưu chữ ký trong metadata và gửi lại khi chuyển giao.
+func ownerRecord(id, key, versionID, contentHash string) []byte {
+	return []byte(strings.Join([]string{"owner-record", id, key, versionID, contentHash}, "\x00"))
+}
+
+// verifyOwnerRecord: sig có phải chữ ký của node id (chủ namespace) trên ownerRecord không.
+func verifyOwnerRecord(id, key, versionID, contentHash string, sig []byte) error {
+	pub, err := publicKeyFromNodeID(id)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, ownerRecord(id, key, versionID, contentHash), sig) {
+		return ErrOwnerSignature
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                       CHỐNG PHÁT LẠI (REPLAY)                               //
+////////////////////////////////////////////////////////////////////////////////
+
+// envelopeMaxAge: message có mốc HLC cũ hơn khoảng này (so với đồng hồ của node nhận, hoặc với mốc mới nhất
+// đã nhận từ cùng người gửi) bị coi là phát lại.
+const envelopeMaxAge = 10 * time.Minute
+
+// ErrReplayedMessage: message đã nhận rồi, hoặc quá cũ để kiểm tra được là chưa nhận.
+var ErrReplayedMessage = errors.New("stale or replayed message")
+
+// replayGuard nhớ các mốc HLC (Message.Clock – nằm trong phần đã ký) đã nhận của từng node.
+// Mỗi lần seal cấp 1 mốc mới (HLC luôn tăng) nên (người gửi, mốc) là duy nhất: gặp lại → phát lại.
+// Chỉ cần nhớ các mốc trong envelopeMaxAge gần nhất; cũ hơn thì từ chối thẳng.
+type replayGuard struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	senders map[string]*replayWindow
+}
+
+type replayWindow struct {
+	newest  Timestamp
+	seen    map[Timestamp]struct{}
+	pruneAt int
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{now: time.Now, senders: make(map[string]*replayWindow)}
+}
+
+// check ghi nhận mốc ts của sender; lỗi nếu ts rỗng, quá cũ hoặc đã gặp.
+// Mốc của người gửi chạy nhanh vẫn được nhận (HLC chấp nhận lệch giờ); cửa sổ khi đó tính theo mốc mới nhất của nó.
+func (g *replayGuard) check(sender string, ts Timestamp) error {
+	if ts.IsZero() {
+		return ErrReplayedMessage
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	w, ok := g.senders[sender]
+	if !ok {
+		w = &replayWindow{seen: make(map[Timestamp]struct{}), pruneAt: 1024}
+		g.senders[sender] = w
+	}
+	if ts.Wall < w.floor(g.now()) {
+		return ErrReplayedMessage
+	}
+	if _, dup := w.seen[ts]; dup {
+		return ErrReplayedMessage
+	}
+	w.seen[ts] = struct{}{}
+	if ts.Compare(w.newest) > 0 {
+		w.newest = ts
+	}
+
+	if len(w.seen) >= w.pruneAt {
+		floor := w.floor(g.now())
+		for seen := range w.seen {
+			if seen.Wall < floor {
+				delete(w.seen, seen)
+			}
+		}
+		w.pruneAt = 2*len(w.seen) + 1024
+	}
+	return nil
+}
+
+// floor: mốc (unix nano) cũ nhất còn được nhận.
+func (w *replayWindow) floor(now time.Time) int64 {
+	floor := now.UnixNano() - int64(envelopeMaxAge)
+	if f := w.newest.Wall - int64(envelopeMaxAge); f > floor {
+		floor = f
+	}
+	return floor
+}
+
+// ErrContentMismatch: bytes stream theo sau message không khớp hash đã ký trong message.
+var ErrContentMismatch = errors.New("streamed content does not match the signed hash")
+
+// contentHash: SHA-256 (hex) của bytes đi trên dây – được ký kèm message để gắn dữ liệu stream với chữ ký.
+func contentHash() hash.Hash {
+	return sha256.New()
+}
+
+// verifiedReader đọc r và băm dần; tới EOF mà hash khác want thì trả ErrContentMismatch thay cho io.EOF
+// → lần ghi đang đọc stream bị hủy, không commit.
+type verifiedReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+}
+
+func newVerifiedReader(r io.Reader, want string) *verifiedReader {
+	return &verifiedReader{r: r, h: contentHash(), want: want}
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.h.Sum(nil)) != v.want {
+		return n, ErrContentMismatch
+	}
+	return n, err
+}
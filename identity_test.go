@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLoadOrCreateIdentity: khóa được lưu lại và lần đọc sau cho ra cùng node ID.
+func TestLoadOrCreateIdentity(t *testing.T) {
+	dir, err := os.MkdirTemp("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, defaultIdentityFileName)
+	first, err := LoadOrCreateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadOrCreateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.NodeID() != second.NodeID() {
+		t.Errorf("want %s have %s", first.NodeID(), second.NodeID())
+	}
+	if len(first.NodeID()) != len(generateID()) {
+		t.Errorf("unexpected node id length %d", len(first.NodeID()))
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("want mode 0600 have %o", fi.Mode().Perm())
+	}
+}
+
+// TestSealOpenMessage: message ký xong mở ra được, sửa 1 byte là bị từ chối.
+func TestSealOpenMessage(t *testing.T) {
+	id, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{Payload: MessageGetFile{ID: id.NodeID(), Key: "foo"}}
+	b, err := sealMessage(id, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, opened, err := openMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender != id.NodeID() {
+		t.Errorf("want sender %s have %s", id.NodeID(), sender)
+	}
+	if opened.Payload.(MessageGetFile).Key != "foo" {
+		t.Errorf("unexpected payload %+v", opened.Payload)
+	}
+
+	// Mạo danh: ký bằng khóa khác nhưng khai From là node khác
+	other, _ := NewIdentity()
+	forged, _ := sealMessage(other, msg)
+	var env Envelope
+	if err := decodeEnvelopeForTest(forged, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.From = id.NodeID()
+	if _, _, err := openMessage(encodeEnvelopeForTest(t, &env)); err != ErrInvalidSignature {
+		t.Errorf("want %v have %v", ErrInvalidSignature, err)
+	}
+}
+
+// TestReplayGuard: mỗi mốc HLC của 1 người gửi chỉ được nhận 1 lần; mốc quá cũ (so với giờ hiện tại hoặc
+// mốc mới nhất của người gửi) bị từ chối, còn người gửi có đồng hồ chạy nhanh vẫn được nhận.
+func TestReplayGuard(t *testing.T) {
+	now := time.Now()
+	g := newReplayGuard()
+	g.now = func() time.Time { return now }
+	at := func(d time.Duration) Timestamp { return Timestamp{Wall: now.Add(d).UnixNano()} }
+
+	if err := g.check("a", at(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.check("a", at(0)); err != ErrReplayedMessage {
+		t.Errorf("duplicate accepted: %v", err)
+	}
+	if err := g.check("b", at(0)); err != nil {
+		t.Errorf("same stamp from another sender rejected: %v", err)
+	}
+	if err := g.check("a", at(-time.Second)); err != nil {
+		t.Errorf("reordered message rejected: %v", err)
+	}
+	if err := g.check("a", at(-envelopeMaxAge-time.Second)); err != ErrReplayedMessage {
+		t.Errorf("stale message accepted: %v", err)
+	}
+	if err := g.check("a", Timestamp{}); err != ErrReplayedMessage {
+		t.Errorf("message without a clock accepted: %v", err)
+	}
+
+	// Đồng hồ của c chạy nhanh 1 giờ: vẫn nhận, nhưng cửa sổ tính theo mốc mới nhất của c
+	if err := g.check("c", at(time.Hour)); err != nil {
+		t.Errorf("sender with a fast clock rejected: %v", err)
+	}
+	if err := g.check("c", at(time.Hour-envelopeMaxAge-time.Second)); err != ErrReplayedMessage {
+		t.Errorf("stamp older than the sender's window accepted: %v", err)
+	}
+}
+
+// TestVerifiedReader: đọc hết đúng nội dung đã ký thì EOF, bị tráo nội dung thì ErrContentMismatch.
+func TestVerifiedReader(t *testing.T) {
+	h := contentHash()
+	h.Write([]byte("signed body"))
+	want := hex.EncodeToString(h.Sum(nil))
+
+	if b, err := io.ReadAll(newVerifiedReader(bytes.NewReader([]byte("signed body")), want)); err != nil || string(b) != "signed body" {
+		t.Errorf("have %q %v", b, err)
+	}
+	if _, err := io.ReadAll(newVerifiedReader(bytes.NewReader([]byte("forged body")), want)); err != ErrContentMismatch {
+		t.Errorf("want %v have %v", ErrContentMismatch, err)
+	}
+}
+
+func decodeEnvelopeForTest(b []byte, env *Envelope) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(env)
+}
+
+func encodeEnvelopeForTest(t *testing.T, env *Envelope) []byte {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(env); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//              INDEX OBJECT (WRITE-AHEAD LOG + B-TREE, NHÚNG SẴN)            //
+////////////////////////////////////////////////////////////////////////////////
+
+// Index nằm trong Root/.index:
+//   - snapshot: toàn bộ index tại thời điểm checkpoint gần nhất.
+//   - wal     : các thay đổi sau checkpoint đó (append-only, fsync từng record).
+//
+// Không có snapshot → index coi như bị mất và được dựng lại từ object trên đĩa.
+const (
+	indexDirName      = ".index"
+	indexSnapshotName = "snapshot"
+	indexWALName      = "wal"
+)
+
+// maxIndexRecordSize: record lớn hơn mức này chắc chắn là rác (file bị hỏng).
+const maxIndexRecordSize = 1 << 20
+
+// errRecordCorrupt: record trong file WAL/snapshot (index, raft log) hỏng (CRC sai / bị cắt cụt).
+var errRecordCorrupt = errors.New("record corrupt")
+
+// indexEntry: thông tin của 1 object (khóa chính là (id, storeKey)).
+type indexEntry struct {
+	Key      string // key gốc (ObjectMeta.Key) – dùng cho List
+	Location string // đường dẫn file trong thư mục namespace: PathName/Filename
+	Size     int64  // số byte trên đĩa
+	Hash     string // SHA-256 (hex) của bytes trên đĩa
+	Version  uint64 // tăng 1 mỗi lần storeKey được ghi lại
+
+	Compression Compression `json:",omitempty"` // bytes trên đĩa là nội dung đã nén (xem ObjectMeta.Compression)
+}
+
+// indexRecord: 1 record trong WAL/snapshot.
+type indexRecord struct {
+	Op       string // "put" | "del"
+	ID       string
+	StoreKey string
+	Entry    *indexEntry `json:",omitempty"`
+}
+
+// KeyEntry: 1 dòng trong kết quả List.
+//   - Key     : key gốc (ObjectMeta.Key) – thứ người dùng nhìn thấy.
+//   - StoreKey: key thực sự dùng với Has/Read/Delete của Store (bản sao trên peer là hashKey(Key)).
+type KeyEntry struct {
+	Key      string
+	StoreKey string
+}
+
+// ListPage: 1 trang kết quả. NextToken rỗng → đã hết.
+type ListPage struct {
+	Entries   []KeyEntry
+	NextToken string
+}
+
+// objectIndex: index của mọi object trong 1 Store.
+//   - objects: (id, storeKey) → indexEntry – phục vụ Has/Read.
+//   - byKey  : (id, Key, storeKey) → storeKey – phục vụ List theo thứ tự key gốc.
+//
+// Cả 2 cây nằm trong RAM; mọi thay đổi được ghi vào WAL (fsync) trước khi trả về.
+// byKey không được lưu mà dựng lại từ objects khi nạp.
+// dir rỗng → index chỉ nằm trong RAM.
+type objectIndex struct {
+	dir string
+
+	mu      sync.Mutex
+	objects btree[indexEntry]
+	byKey   btree[string]
+	records int // số record trong WAL kể từ checkpoint gần nhất
+
+	usage map[string]Usage // dung lượng theo namespace, cập nhật cùng lúc với objects
+	total Usage
+
+	// reserved: dung lượng các lần ghi đang chạy giữ chỗ (xem quotaReservation) – tính vào quota,
+	// không tính vào usage và không ghi vào WAL.
+	reserved      map[string]Usage
+	reservedTotal Usage
+}
+
+// openObjectIndex nạp index từ dir (snapshot rồi replay WAL).
+// ok = false khi chưa có snapshot hoặc snapshot hỏng → người gọi phải dựng lại từ đĩa.
+func openObjectIndex(dir string) (idx *objectIndex, ok bool, err error) {
+	idx = &objectIndex{dir: dir}
+	if len(dir) == 0 {
+		return idx, true, nil
+	}
+
+	err = idx.readFile(filepath.Join(dir, indexSnapshotName), func(rec indexRecord) {
+		idx.apply(rec)
+	})
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errRecordCorrupt) {
+		return &objectIndex{dir: dir}, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	walPath := filepath.Join(dir, indexWALName)
+	err = idx.readFile(walPath, func(rec indexRecord) {
+		idx.apply(rec)
+		idx.records++
+	})
+	if errors.Is(err, errRecordCorrupt) {
+		// Crash giữa lúc append: bỏ phần đuôi hỏng để record sau không nằm sau rác
+		if err := os.Truncate(walPath, idx.walSize()); err != nil {
+			return nil, false, err
+		}
+		log.Printf("object index: truncated torn WAL tail in %s", dir)
+		err = nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+	return idx, true, nil
+}
+
+// get tìm object theo (id, storeKey).
+func (idx *objectIndex) get(id, storeKey string) (indexEntry, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.objects.Get(objectIndexKey(id, storeKey))
+}
+
+// put ghi nhận object vừa được ghi. Version được tăng tự động; trả về entry đã lưu.
+func (idx *objectIndex) put(id, storeKey string, entry indexEntry) (indexEntry, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.putLocked(id, storeKey, entry)
+}
+
+// putLocked: như put, gọi khi đang giữ idx.mu.
+func (idx *objectIndex) putLocked(id, storeKey string, entry indexEntry) (indexEntry, error) {
+	if old, ok := idx.objects.Get(objectIndexKey(id, storeKey)); ok {
+		entry.Version = old.Version + 1
+	} else {
+		entry.Version = 1
+	}
+	return entry, idx.log(indexRecord{Op: "put", ID: id, StoreKey: storeKey, Entry: &entry})
+}
+
+// relocate cập nhật vị trí của object (file bị chuyển chỗ, ví dụ do migration) – không đổi Version.
+func (idx *objectIndex) relocate(id, storeKey, location string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry, ok := idx.objects.Get(objectIndexKey(id, storeKey))
+	if !ok || entry.Location == location {
+		return nil
+	}
+	entry.Location = location
+	return idx.log(indexRecord{Op: "put", ID: id, StoreKey: storeKey, Entry: &entry})
+}
+
+// remove xóa (id, storeKey) khỏi index.
+func (idx *objectIndex) remove(id, storeKey string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.objects.Get(objectIndexKey(id, storeKey)); !ok {
+		return nil
+	}
+	return idx.log(indexRecord{Op: "del", ID: id, StoreKey: storeKey})
+}
+
+// removeWhere xóa mọi object của namespace id thỏa match.
+func (idx *objectIndex) removeWhere(id string, match func(storeKey string, entry indexEntry) bool) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var matched []string
+	idx.scan(id, func(storeKey string, entry indexEntry) bool {
+		if match(storeKey, entry) {
+			matched = append(matched, storeKey)
+		}
+		return true
+	})
+	for _, storeKey := range matched {
+		if err := idx.log(indexRecord{Op: "del", ID: id, StoreKey: storeKey}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset quên toàn bộ index trong RAM (dùng khi Store.Clear – thư mục index cũng đã bị xóa).
+func (idx *objectIndex) reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.objects, idx.byKey, idx.records = btree[indexEntry]{}, btree[string]{}, 0
+	idx.usage, idx.total = nil, Usage{}
+}
+
+// replace thay toàn bộ index bằng records (kết quả dựng lại từ đĩa) rồi checkpoint ngay.
+func (idx *objectIndex) replace(records []indexRecord) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.objects, idx.byKey = btree[indexEntry]{}, btree[string]{}
+	idx.usage, idx.total = nil, Usage{}
+	for _, rec := range records {
+		idx.apply(rec)
+	}
+	return idx.checkpoint()
+}
+
+// list trả về tối đa limit entry có Key bắt đầu bằng prefix và lớn hơn token.
+// Các entry cùng Key luôn nằm chung 1 trang, nên trang có thể dài hơn limit một chút.
+func (idx *objectIndex) list(id, prefix, token string, limit int) (ListPage, error) {
+	var page ListPage
+	after, err := decodePageToken(token)
+	if err != nil {
+		return page, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	start := prefix
+	if after > start {
+		start = after
+	}
+	idx.byKey.Ascend(id+"\x00"+start, func(k string, storeKey string) bool {
+		rest := strings.TrimPrefix(k, id+"\x00")
+		if len(rest) == len(k) {
+			return false // đã sang namespace khác
+		}
+		key := strings.TrimSuffix(rest, "\x00"+storeKey)
+		if len(token) > 0 && key <= after {
+			return true
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if limit > 0 && len(page.Entries) >= limit && key != page.Entries[len(page.Entries)-1].Key {
+			page.NextToken = encodePageToken(page.Entries[len(page.Entries)-1].Key)
+			return false
+		}
+		page.Entries = append(page.Entries, KeyEntry{Key: key, StoreKey: storeKey})
+		return true
+	})
+	return page, nil
+}
+
+// next trả về object kế tiếp sau (id, storeKey) theo thứ tự của index, bất kể namespace
+// (id rỗng → object đầu tiên). Dùng để duyệt toàn bộ index từng bước mà không giữ lock lâu.
+func (idx *objectIndex) next(id, storeKey string) (nextID, nextKey string, entry indexEntry, ok bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	after := ""
+	if len(id) > 0 {
+		after = objectIndexKey(id, storeKey)
+	}
+	idx.objects.Ascend(after, func(k string, e indexEntry) bool {
+		if len(after) > 0 && k == after {
+			return true
+		}
+		nextID, nextKey = splitObjectIndexKey(k)
+		entry, ok = e, true
+		return false
+	})
+	return
+}
+
+// scan duyệt mọi object của namespace id theo thứ tự storeKey. Gọi khi đang giữ idx.mu.
+func (idx *objectIndex) scan(id string, fn func(storeKey string, entry indexEntry) bool) {
+	idx.objects.Ascend(id+"\x00", func(k string, entry indexEntry) bool {
+		storeKey := strings.TrimPrefix(k, id+"\x00")
+		if len(storeKey) == len(k) {
+			return false
+		}
+		return fn(storeKey, entry)
+	})
+}
+
+// apply áp 1 record vào 2 cây trong RAM. Gọi khi đang giữ idx.mu (hoặc lúc nạp).
+func (idx *objectIndex) apply(rec indexRecord) {
+	k := objectIndexKey(rec.ID, rec.StoreKey)
+	if old, ok := idx.objects.Get(k); ok {
+		idx.byKey.Delete(rec.ID + "\x00" + old.Key + "\x00" + rec.StoreKey)
+		idx.account(rec.ID, -1, -old.Size)
+	}
+	switch {
+	case rec.Op == "put" && rec.Entry != nil:
+		idx.objects.Set(k, *rec.Entry)
+		idx.byKey.Set(rec.ID+"\x00"+rec.Entry.Key+"\x00"+rec.StoreKey, rec.StoreKey)
+		idx.account(rec.ID, 1, rec.Entry.Size)
+	case rec.Op == "del":
+		idx.objects.Delete(k)
+	}
+}
+
+// account cộng dồn thay đổi số object/số byte của namespace id. Gọi khi đang giữ idx.mu.
+func (idx *objectIndex) account(id string, objects int64, bytes int64) {
+	if idx.usage == nil {
+		idx.usage = make(map[string]Usage)
+	}
+	u := idx.usage[id]
+	u.Objects += objects
+	u.Bytes += bytes
+	if u.Objects == 0 {
+		delete(idx.usage, id)
+	} else {
+		idx.usage[id] = u
+	}
+	idx.total.Objects += objects
+	idx.total.Bytes += bytes
+}
+
+// log ghi record vào WAL (fsync) rồi mới áp vào RAM.
+// WAL quá dài so với số object thực tế → checkpoint (ghi snapshot mới, làm rỗng WAL).
+func (idx *objectIndex) log(rec indexRecord) error {
+	if len(idx.dir) == 0 {
+		idx.apply(rec)
+		return nil
+	}
+
+	if err := os.MkdirAll(idx.dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(idx.dir, indexWALName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = writeIndexRecord(f, rec)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	idx.apply(rec)
+	idx.records++
+	if idx.records > 2*idx.objects.Len()+1024 {
+		return idx.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint ghi snapshot mới (nguyên tử) rồi làm rỗng WAL.
+// Crash giữa 2 bước vẫn an toàn: replay WAL cũ lên snapshot mới cho cùng kết quả
+// (mỗi record "put" mang đầy đủ entry).
+func (idx *objectIndex) checkpoint() error {
+	if len(idx.dir) == 0 {
+		return nil
+	}
+
+	snapshot := filepath.Join(idx.dir, indexSnapshotName)
+	_, err := writeFileAtomic(snapshot, func(w io.Writer) (int64, error) {
+		bw := bufio.NewWriter(w)
+		var err error
+		idx.objects.Ascend("", func(k string, entry indexEntry) bool {
+			id, storeKey := splitObjectIndexKey(k)
+			err = writeIndexRecord(bw, indexRecord{Op: "put", ID: id, StoreKey: storeKey, Entry: &entry})
+			return err == nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		return 0, bw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.Truncate(filepath.Join(idx.dir, indexWALName), 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	idx.records = 0
+	return nil
+}
+
+// walSize: kích thước hợp lệ của WAL sau khi replay (dùng để cắt đuôi hỏng).
+// Tính lại từ đầu file vì chỉ chạy 1 lần lúc mở index.
+func (idx *objectIndex) walSize() int64 {
+	f, err := os.Open(filepath.Join(idx.dir, indexWALName))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var size int64
+	r := bufio.NewReader(f)
+	for {
+		n, _, err := readIndexRecord(r)
+		if err != nil {
+			return size
+		}
+		size += n
+	}
+}
+
+// readFile đọc lần lượt các record trong file index.
+func (idx *objectIndex) readFile(path string, fn func(indexRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		_, rec, err := readIndexRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(rec)
+	}
+}
+
+func writeIndexRecord(w io.Writer, rec indexRecord) error {
+	return writeRecord(w, rec)
+}
+
+// readIndexRecord đọc 1 record. io.EOF khi hết file đúng ranh giới record,
+// errRecordCorrupt khi record bị cắt cụt hoặc sai CRC. n: số byte của record.
+func readIndexRecord(r io.Reader) (n int64, rec indexRecord, err error) {
+	n, err = readRecord(r, &rec)
+	return n, rec, err
+}
+
+// writeRecord: [uint32 độ dài (LE)][uint32 CRC-32 của payload (LE)][payload JSON của v].
+func writeRecord(w io.Writer, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// readRecord đọc 1 record (ghi bởi writeRecord) vào v. io.EOF khi hết file đúng ranh giới record,
+// errRecordCorrupt khi record bị cắt cụt hoặc sai CRC. n: số byte của record.
+func readRecord(r io.Reader, v any) (n int64, err error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, errRecordCorrupt
+	}
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size > maxIndexRecordSize {
+		return 0, errRecordCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, errRecordCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return 0, errRecordCorrupt
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return 0, errRecordCorrupt
+	}
+	return int64(len(header)) + int64(size), nil
+}
+
+// objectIndexKey: khóa của cây objects. "\x00" không xuất hiện trong ID (hex) nên tách lại được.
+func objectIndexKey(id, storeKey string) string {
+	return id + "\x00" + storeKey
+}
+
+func splitObjectIndexKey(k string) (id, storeKey string) {
+	i := strings.IndexByte(k, 0)
+	return k[:i], k[i+1:]
+}
+
+// invalidateObjectIndex xóa index đã lưu trong root → lần mở Store tiếp theo sẽ dựng lại từ đĩa.
+// Dùng khi object bị chuyển chỗ bên ngoài Store (ví dụ MigrateStore).
+func invalidateObjectIndex(root string) error {
+	return os.RemoveAll(filepath.Join(root, indexDirName))
+}
+
+// encodePageToken / decodePageToken: token phân trang = key cuối cùng của trang trước (base64).
+func encodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodePageToken(token string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.New("invalid page token")
+	}
+	return string(b), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                     DỰNG LẠI INDEX TỪ OBJECT TRÊN ĐĨA                       //
+////////////////////////////////////////////////////////////////////////////////
+
+// RebuildIndex dựng lại toàn bộ index bằng cách duyệt các object (và sidecar) trong backend.
+// Tự động chạy khi NewStore không tìm thấy index hợp lệ; gọi tay khi nghi index lệch với dữ liệu.
+// Object không có sidecar (ghi trước khi có metadata) không suy ra được key nên bị bỏ qua.
+func (s *Store) RebuildIndex() error {
+	paths, err := s.backend.List("")
+	if err != nil {
+		return err
+	}
+
+	var records []indexRecord
+	for _, path := range paths {
+		// Bỏ qua sidecar, file lẻ ở gốc và thư mục ẩn (.quarantine, ...)
+		if strings.HasSuffix(path, metaFileSuffix) || !strings.Contains(path, "/") || strings.HasPrefix(path, ".") {
+			continue
+		}
+		rec, err := s.recoverIndexRecord(path)
+		if err != nil {
+			log.Printf("object index: skipping %s: %s", path, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	if err := s.index.replace(records); err != nil {
+		return err
+	}
+	log.Printf("object index: rebuilt %d entries from %s", len(records), s.Root)
+	return nil
+}
+
+// recoverIndexRecord dựng entry cho 1 object "id/..." : key lấy từ sidecar, size/hash tính lại từ dữ liệu.
+func (s *Store) recoverIndexRecord(path string) (indexRecord, error) {
+	sc, err := s.readSidecar(path)
+	if err != nil {
+		return indexRecord{}, err
+	}
+
+	name := pathBase(path)
+	storeKey := sc.StoreKey
+	if len(storeKey) == 0 {
+		// Sidecar cũ chưa ghi StoreKey: thử key gốc và hashKey(key gốc)
+		for _, candidate := range []string{sc.Key, hashKey(sc.Key)} {
+			if s.PathTransformFunc(candidate).Filename == name {
+				storeKey = candidate
+			}
+			if s.LegacyPathTransformFunc != nil && s.LegacyPathTransformFunc(candidate).Filename == name {
+				storeKey = candidate
+			}
+		}
+	}
+	if len(storeKey) == 0 {
+		return indexRecord{}, fmt.Errorf("cannot recover key")
+	}
+
+	_, r, err := s.backend.Get(path)
+	if err != nil {
+		return indexRecord{}, err
+	}
+	defer r.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, r)
+	if err != nil {
+		return indexRecord{}, err
+	}
+
+	i := strings.Index(path, "/")
+	return indexRecord{
+		Op:       "put",
+		ID:       path[:i],
+		StoreKey: storeKey,
+		Entry: &indexEntry{
+			Key:      sc.Key,
+			Location: path[i+1:],
+			Size:     size,
+			Hash:     hex.EncodeToString(hasher.Sum(nil)),
+			Version:  1,
+
+			Compression: sc.Compression,
+		},
+	}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                               STORE.LIST                                   //
+////////////////////////////////////////////////////////////////////////////////
+
+// List liệt kê các key trong namespace id có key gốc bắt đầu bằng prefix, theo thứ tự tăng dần.
+// token: NextToken của trang trước (rỗng = trang đầu). limit <= 0 → không giới hạn.
+func (s *Store) List(id string, prefix string, token string, limit int) (ListPage, error) {
+	return s.index.list(id, prefix, token, limit)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStoreList: liệt kê theo prefix, phân trang bằng token, index tồn tại qua lần mở Store mới
+// và dựng lại được từ sidecar khi file index bị mất.
+func TestStoreList(t *testing.T) {
+	s := newStore()
+	defer teardown(t, s)
+	id := generateID()
+
+	for i := 0; i < 5; i++ {
+		for _, dir := range []string{"docs", "pics"} {
+			key := fmt.Sprintf("%s/%d", dir, i)
+			if _, err := s.Write(id, key, bytes.NewReader([]byte(key))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var (
+		keys  []string
+		token string
+	)
+	for pages := 0; ; pages++ {
+		page, err := s.List(id, "pics/", token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range page.Entries {
+			keys = append(keys, entry.Key)
+		}
+		if len(page.NextToken) == 0 {
+			if pages != 2 {
+				t.Errorf("want 3 pages have %d", pages+1)
+			}
+			break
+		}
+		token = page.NextToken
+	}
+	want := []string{"pics/0", "pics/1", "pics/2", "pics/3", "pics/4"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("want %v have %v", want, keys)
+	}
+
+	// Mở lại Store: index đọc từ log trên đĩa
+	reopened := NewStore(s.StoreOpts)
+	page, err := reopened.List(id, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 10 {
+		t.Errorf("want 10 keys have %d", len(page.Entries))
+	}
+
+	// Mất index → dựng lại từ sidecar
+	if err := os.RemoveAll(filepath.Join(s.Root, indexDirName)); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := NewStore(s.StoreOpts)
+	page, err = rebuilt.List(id, "docs/", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 5 || page.Entries[0].StoreKey != "docs/0" {
+		t.Errorf("unexpected rebuilt entries %+v", page.Entries)
+	}
+
+	if _, err := s.List(id, "", "not base64!", 0); err == nil {
+		t.Errorf("expected error for invalid token")
+	}
+}
+
+// TestObjectIndexWAL: index tồn tại qua lần mở lại, version tăng khi ghi đè,
+// và đuôi WAL bị cắt cụt (crash giữa lúc append) bị bỏ qua.
+func TestObjectIndexWAL(t *testing.T) {
+	dir := t.TempDir()
+	idx, ok, err := openObjectIndex(dir)
+	if err != nil || ok {
+		t.Fatalf("expected fresh index to need rebuild (ok=%v err=%v)", ok, err)
+	}
+	if err := idx.replace(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	id := generateID()
+	for i := 0; i < 3; i++ {
+		if _, err := idx.put(id, "a", indexEntry{Key: "a", Location: fmt.Sprintf("loc/%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := idx.put(id, "b", indexEntry{Key: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.remove(id, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Giả lập crash: record cuối chỉ ghi được một nửa
+	f, err := os.OpenFile(filepath.Join(dir, indexWALName), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{42, 0, 0, 0, 1, 2})
+	f.Close()
+
+	reopened, ok, err := openObjectIndex(dir)
+	if err != nil || !ok {
+		t.Fatalf("reopen: ok=%v err=%v", ok, err)
+	}
+	entry, found := reopened.get(id, "a")
+	if !found || entry.Version != 3 || entry.Location != "loc/2" {
+		t.Errorf("unexpected entry after replay %+v", entry)
+	}
+	if _, found := reopened.get(id, "b"); found {
+		t.Errorf("expected deleted key to stay deleted")
+	}
+
+	// Append sau khi đã cắt đuôi hỏng vẫn đọc lại được
+	if _, err := reopened.put(id, "c", indexEntry{Key: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := openObjectIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := again.get(id, "c"); !found {
+		t.Errorf("expected record appended after torn tail to survive")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                    BẢNG ĐỊNH TUYẾN KADEMLIA (K-BUCKETS)                     //
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// dhtIDBytes: độ dài ID trong không gian DHT (node ID = public key Ed25519 32 byte; key = SHA-256).
+	dhtIDBytes = 32
+	// dhtK: số contact tối đa mỗi bucket, cũng là số node "gần nhất" mỗi lần lookup trả về / lưu provider.
+	dhtK = 20
+	// dhtAlpha: số RPC song song mỗi vòng lookup.
+	dhtAlpha = 3
+)
+
+// dhtKey: vị trí của object (owner, key) trong không gian ID – node gần nhất (XOR) giữ provider record của nó.
+func dhtKey(ownerID string, key string) string {
+	sum := sha256.Sum256([]byte(ownerID + "/" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// xorDistance: khoảng cách Kademlia giữa 2 ID (hex). ID sai định dạng được coi như toàn bit 0.
+func xorDistance(a string, b string) []byte {
+	ab, _ := hex.DecodeString(a)
+	bb, _ := hex.DecodeString(b)
+	dist := make([]byte, dhtIDBytes)
+	for i := range dist {
+		var x, y byte
+		if i < len(ab) {
+			x = ab[i]
+		}
+		if i < len(bb) {
+			y = bb[i]
+		}
+		dist[i] = x ^ y
+	}
+	return dist
+}
+
+// commonPrefixLen: số bit đầu giống nhau của 2 ID = chỉ số bucket (0 → khác ngay bit đầu, xa nhất).
+func commonPrefixLen(a string, b string) int {
+	for i, d := range xorDistance(a, b) {
+		if d != 0 {
+			return i*8 + bits.LeadingZeros8(d)
+		}
+	}
+	return dhtIDBytes * 8
+}
+
+// sortByDistance sắp xếp contacts theo khoảng cách XOR tới target, gần nhất trước.
+func sortByDistance(target string, contacts []PeerInfo) {
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return bytes.Compare(xorDistance(contacts[i].ID, target), xorDistance(contacts[j].ID, target)) < 0
+	})
+}
+
+// kbucket: tối đa dhtK contact có cùng độ dài prefix chung với node, ít được thấy gần đây nhất trước.
+// Bucket đầy → contact mới vào danh sách chờ (replacements) thay vì đẩy contact cũ ra:
+// node sống lâu thường tiếp tục sống, và kẻ tấn công không thể "xóa" bảng bằng cách tạo nhiều ID mới.
+type kbucket struct {
+	contacts     []PeerInfo
+	replacements []PeerInfo
+}
+
+// routingTable: bảng định tuyến Kademlia của node self. An toàn khi dùng đồng thời.
+type routingTable struct {
+	self string
+
+	mu      sync.Mutex
+	buckets [dhtIDBytes*8 + 1]kbucket
+}
+
+func newRoutingTable(self string) *routingTable {
+	return &routingTable{self: self}
+}
+
+// update ghi nhận contact vừa liên lạc được: đã có → chuyển xuống cuối bucket (mới thấy nhất, cập nhật địa chỉ);
+// chưa có → thêm vào nếu bucket còn chỗ, không thì vào danh sách chờ.
+func (rt *routingTable) update(c PeerInfo) {
+	if c.ID == rt.self || len(c.ID) != dhtIDBytes*2 || len(c.Addr) == 0 {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	b := &rt.buckets[commonPrefixLen(rt.self, c.ID)]
+	for i, existing := range b.contacts {
+		if existing.ID == c.ID {
+			b.contacts = append(b.contacts[:i], b.contacts[i+1:]...)
+			b.contacts = append(b.contacts, c)
+			return
+		}
+	}
+	if len(b.contacts) < dhtK {
+		b.contacts = append(b.contacts, c)
+		return
+	}
+	for i, existing := range b.replacements {
+		if existing.ID == c.ID {
+			b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+			break
+		}
+	}
+	b.replacements = append(b.replacements, c)
+	if len(b.replacements) > dhtK {
+		b.replacements = b.replacements[1:]
+	}
+}
+
+// remove bỏ contact không liên lạc được; contact mới thấy nhất trong danh sách chờ (nếu có) thế chỗ.
+func (rt *routingTable) remove(id string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	b := &rt.buckets[commonPrefixLen(rt.self, id)]
+	for i, existing := range b.contacts {
+		if existing.ID != id {
+			continue
+		}
+		b.contacts = append(b.contacts[:i], b.contacts[i+1:]...)
+		if n := len(b.replacements); n > 0 {
+			b.contacts = append(b.contacts, b.replacements[n-1])
+			b.replacements = b.replacements[:n-1]
+		}
+		return
+	}
+}
+
+// closest: tối đa n contact gần target nhất (XOR), gần nhất trước.
+func (rt *routingTable) closest(target string, n int) []PeerInfo {
+	rt.mu.Lock()
+	var contacts []PeerInfo
+	for i := range rt.buckets {
+		contacts = append(contacts, rt.buckets[i].contacts...)
+	}
+	rt.mu.Unlock()
+
+	sortByDistance(target, contacts)
+	if len(contacts) > n {
+		contacts = contacts[:n]
+	}
+	return contacts
+}
+
+// has: id có trong bảng (không tính danh sách chờ).
+func (rt *routingTable) has(id string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for _, c := range rt.buckets[commonPrefixLen(rt.self, id)].contacts {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// size: tổng số contact trong bảng.
+func (rt *routingTable) size() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	n := 0
+	for i := range rt.buckets {
+		n += len(rt.buckets[i].contacts)
+	}
+	return n
+}
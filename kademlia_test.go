@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomID: ID ngẫu nhiên trong không gian DHT; prefix (nếu có) ghi đè các byte đầu.
+func randomID(rnd *rand.Rand, prefix ...byte) string {
+	b := make([]byte, dhtIDBytes)
+	rnd.Read(b)
+	copy(b, prefix)
+	return hex.EncodeToString(b)
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	a := hex.EncodeToString(make([]byte, dhtIDBytes))
+	for _, tc := range []struct {
+		prefix []byte
+		want   int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x40}, 9},
+		{nil, dhtIDBytes * 8},
+	} {
+		b := make([]byte, dhtIDBytes)
+		copy(b, tc.prefix)
+		if have := commonPrefixLen(a, hex.EncodeToString(b)); have != tc.want {
+			t.Errorf("prefix %x: want %d have %d", tc.prefix, tc.want, have)
+		}
+	}
+}
+
+// TestRoutingTable: closest trả về đúng thứ tự XOR (so với duyệt toàn bộ); bucket đầy giữ contact cũ,
+// contact mới chờ trong replacements và thế chỗ khi contact cũ bị remove.
+func TestRoutingTable(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	self := randomID(rnd, 0x00)
+	rt := newRoutingTable(self)
+
+	var all []PeerInfo
+	for i := 0; i < 200; i++ {
+		c := PeerInfo{ID: randomID(rnd), Addr: fmt.Sprintf("node-%d", i)}
+		rt.update(c)
+		all = append(all, c)
+	}
+	rt.update(PeerInfo{ID: self, Addr: "self"})
+
+	// Bucket 0 (bit đầu khác self) chứa ~nửa số node → đầy, phần còn lại chờ
+	var bucket0 []PeerInfo
+	for _, c := range all {
+		if commonPrefixLen(self, c.ID) == 0 {
+			bucket0 = append(bucket0, c)
+		}
+	}
+	if rt.size() >= len(all) || len(bucket0) <= dhtK {
+		t.Fatalf("expected a full bucket 0: %d contacts, %d in bucket 0", rt.size(), len(bucket0))
+	}
+
+	target := randomID(rnd)
+	var want []PeerInfo
+	for _, c := range all {
+		if rt.has(c.ID) {
+			want = append(want, c)
+		}
+	}
+	sortByDistance(target, want)
+	have := rt.closest(target, dhtK)
+	if len(have) != dhtK {
+		t.Fatalf("want %d contacts have %d", dhtK, len(have))
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			t.Fatalf("closest[%d]: want %s have %s", i, want[i].Addr, have[i].Addr)
+		}
+	}
+
+	// Contact cũ nhất của bucket 0 bị remove → contact mới thấy nhất trong danh sách chờ thế chỗ
+	oldest, newest := bucket0[0], bucket0[len(bucket0)-1]
+	if rt.has(newest.ID) {
+		t.Fatalf("%s should be waiting in replacements", newest.Addr)
+	}
+	rt.remove(oldest.ID)
+	if rt.has(oldest.ID) || !rt.has(newest.ID) {
+		t.Errorf("replacement was not promoted")
+	}
+}
@@ -3,49 +3,136 @@ package main
 import (
 	"DistributedFileStorage/p2p"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // makeServer là hàm tiện ích tạo ra một FileServer mới.
 // Nó sẽ:
-//  1. Cấu hình TCPTransport (listen, handshake, decoder).
+//  1. Cấu hình TCPTransport (listen, handshake, decoder, kênh mã hóa Noise).
 //  2. Cấu hình FileServerOpts (key mã hóa, storage, transport, bootstrap nodes).
 //  3. Khởi tạo FileServer.
-//  4. Gắn hàm xử lý OnPeer (khi có peer mới kết nối).
+//  4. Gắn hàm xử lý OnPeer / OnPeerClose (khi có peer mới kết nối / mất kết nối).
 //
 // listenAddr: địa chỉ cổng mà server sẽ lắng nghe (ví dụ ":3000").
 // nodes...  : danh sách địa chỉ các peer khác để bootstrap (kết nối ban đầu).
 func makeServer(listenAddr string, nodes ...string) *FileServer {
+	storageRoot := listenAddr + "_network" // thư mục lưu trữ dữ liệu cục bộ
+
+	// Khóa danh tính của node: dùng cho cả node ID, chữ ký message và kênh Noise
+	identity, err := LoadOrCreateIdentity(filepath.Join(storageRoot, defaultIdentityFileName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Thiết lập transport TCP (địa chỉ listen, hàm bắt tay, bộ giải mã)
 	tcptransportOpts := p2p.TCPTransportOpts{
 		ListenAddr:    listenAddr,
 		HandshakeFunc: p2p.NOPHandshakeFunc, // handshake "no-op": chấp nhận mọi peer
 		Decoder:       p2p.DefaultDecoder{}, // dùng decoder mặc định
+		// Mã hóa mọi kết nối bằng Noise XX, xác thực 2 chiều bằng khóa danh tính.
+		// Muốn giới hạn peer được kết nối thì điền AllowedKeys.
+		UpgradeFunc: p2p.NoiseUpgradeFunc(p2p.NoiseConfig{PrivateKey: identity.PrivateKey}),
 	}
 	tcpTransport := p2p.NewTCPTransport(tcptransportOpts)
 
+	// Gom object vào vài file segment lớn thay vì 1 thư mục con cho mỗi object;
+	// compaction nền thu hồi dung lượng của object đã xóa/ghi đè.
+	backend, err := NewSegmentBackend(SegmentOpts{
+		Dir:             filepath.Join(storageRoot, "segments"),
+		CompactInterval: time.Minute,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Cấu hình FileServer
 	fileServerOpts := FileServerOpts{
-		EncKey:            newEncryptionKey(),      // sinh key ngẫu nhiên cho mã hóa
-		StorageRoot:       listenAddr + "_network", // thư mục lưu trữ dữ liệu cục bộ
-		PathTransformFunc: CASPathTransformFunc,    // cách ánh xạ key -> path
-		Transport:         tcpTransport,            // lớp giao tiếp mạng
-		BootstrapNodes:    nodes,                   // các peer ban đầu để kết nối
+		EncKey:            newEncryptionKey(),   // sinh key ngẫu nhiên cho mã hóa
+		Identity:          identity,             // cặp khóa Ed25519 của node
+		StorageRoot:       storageRoot,          // thư mục lưu trữ dữ liệu cục bộ
+		PathTransformFunc: CASPathTransformFunc, // cách ánh xạ key -> path
+		StorageBackend:    backend,              // nơi thực sự chứa object
+		Compression:       CompressionGzip,      // nén object (trừ nội dung đã nén sẵn) trước khi lưu/mã hóa
+		Transport:         tcpTransport,         // lớp giao tiếp mạng
+		BootstrapNodes:    nodes,                // các peer ban đầu để kết nối
+		GossipAddr:        listenAddr,           // membership SWIM qua UDP cùng cổng với TCP
+		DiscoveryCluster:  "dfs-demo",           // tự tìm các node demo khác trong LAN (UDP multicast)
 	}
 
 	// Khởi tạo FileServer
 	s := NewFileServer(fileServerOpts)
 
-	// Khi transport có peer mới → gọi OnPeer của FileServer để quản lý
+	// Khi transport có peer mới / mất peer → gọi OnPeer / OnPeerClose của FileServer để quản lý
 	tcpTransport.OnPeer = s.OnPeer
+	tcpTransport.OnPeerClose = s.OnPeerClose
 
 	return s
 }
 
+// parseCASOpts đọc cấu hình layout dạng "hash[:width[:depth]]", ví dụ "sha256:2:3".
+func parseCASOpts(spec string) (CASOpts, error) {
+	parts := strings.Split(spec, ":")
+	opts := CASOpts{Hash: HashAlgorithm(parts[0])}
+
+	nums := []*int{&opts.Width, &opts.Depth}
+	for i, part := range parts[1:] {
+		if i >= len(nums) {
+			return opts, fmt.Errorf("invalid layout %q", spec)
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return opts, fmt.Errorf("invalid layout %q: %w", spec, err)
+		}
+		*nums[i] = n
+	}
+	return opts.withDefaults(), opts.withDefaults().validate()
+}
+
+// runMigration: chế độ "công cụ migrate" – chuyển Store ở root sang layout mới rồi thoát.
+func runMigration(root, from, to string) error {
+	if len(to) == 0 {
+		return fmt.Errorf("-to is required: the node reads layout %s:%d (CASPathTransformFunc) unless reconfigured", DefaultCASOpts.Hash, DefaultCASOpts.Width)
+	}
+	fromOpts, err := parseCASOpts(from)
+	if err != nil {
+		return err
+	}
+	toOpts, err := parseCASOpts(to)
+	if err != nil {
+		return err
+	}
+
+	stats, err := MigrateStore(MigrateOpts{Root: root, From: fromOpts, To: toOpts})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migration done: moved=%d skipped=%d\n", stats.Moved, stats.Skipped)
+	return nil
+}
+
 func main() {
+	// Chế độ migrate: go run . -migrate :3000_network -from sha1:5 -to sha1:2:4
+	// -from mặc định là layout của CASPathTransformFunc (layout makeServer dùng); -to bắt buộc vì node chỉ đọc
+	// được layout mới khi PathTransformFunc của nó cũng được đổi theo.
+	migrateRoot := flag.String("migrate", "", "migrate the store at this root to a new layout and exit")
+	migrateFrom := flag.String("from", "sha1:5", "current layout hash[:width[:depth]]")
+	migrateTo := flag.String("to", "", "new layout hash[:width[:depth]] (required with -migrate)")
+	flag.Parse()
+
+	if len(*migrateRoot) > 0 {
+		if err := runMigration(*migrateRoot, *migrateFrom, *migrateTo); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Tạo 3 server (mô phỏng 3 node P2P chạy cùng máy)
 	// s1 lắng nghe ở cổng :3000, không bootstrap node nào
 	s1 := makeServer(":3000", "")
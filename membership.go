@@ -0,0 +1,92 @@
+package main
+
+import (
+	"DistributedFileStorage/p2p"
+	"log"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                      MEMBERSHIP CỤM (SWIM GOSSIP)                           //
+////////////////////////////////////////////////////////////////////////////////
+
+// startMembership bind cổng UDP GossipAddr và vào cụm qua GossipSeeds (rỗng → BootstrapNodes,
+// tức mỗi node gossip trên cùng host:port với TCP transport). Tên member = node ID,
+// Meta = địa chỉ TCP của transport. GossipAddr rỗng → tắt.
+func (s *FileServer) startMembership() error {
+	if len(s.GossipAddr) == 0 {
+		return nil
+	}
+	swim, err := p2p.NewSWIM(p2p.SWIMOpts{
+		Name:     s.ID,
+		BindAddr: s.GossipAddr,
+		Meta:     s.Transport.Addr(),
+		OnEvent:  s.onMemberEvent,
+	})
+	if err != nil {
+		return err
+	}
+	s.peerLock.Lock()
+	s.membership = swim
+	s.peerLock.Unlock()
+	log.Printf("gossip membership listening on %s", swim.Addr())
+
+	seeds := s.GossipSeeds
+	if len(seeds) == 0 {
+		seeds = s.BootstrapNodes
+	}
+	if err := swim.Join(seeds...); err != nil {
+		log.Printf("gossip: %s", err)
+	}
+	return nil
+}
+
+// stopMembership báo cho cụm là node rời đi (member khác ghi nhận "left" thay vì "dead") rồi đóng cổng UDP.
+func (s *FileServer) stopMembership() {
+	s.peerLock.Lock()
+	swim := s.membership
+	s.peerLock.Unlock()
+	if swim == nil {
+		return
+	}
+	if err := swim.Leave(); err != nil {
+		log.Printf("gossip: leaving cluster: %s", err)
+	}
+	swim.Close()
+}
+
+// onMemberEvent ghi log thay đổi membership; member vào / hỏng / rời cụm → hẹn rebalance
+// (suspect chưa chắc đã hỏng nên không chuyển dữ liệu đi). Member hỏng / rời cụm bị bỏ khỏi bảng định tuyến DHT.
+func (s *FileServer) onMemberEvent(e p2p.MemberEvent) {
+	log.Printf("[%s] member %s %s (addr %s, incarnation %d)", s.Transport.Addr(), e.Member.Name, e.Type, e.Member.Meta, e.Member.Incarnation)
+	switch e.Type {
+	case p2p.MemberFailed, p2p.MemberDeparted:
+		s.routes.remove(e.Member.Name)
+		s.membersChanged()
+	case p2p.MemberJoined:
+		s.membersChanged()
+	}
+}
+
+// Members: danh sách member của cụm (kể cả node này) theo góc nhìn của node – Meta là địa chỉ TCP.
+// Rỗng nếu không bật gossip (GossipAddr) hoặc server chưa Start.
+func (s *FileServer) Members() []p2p.Member {
+	s.peerLock.Lock()
+	swim := s.membership
+	s.peerLock.Unlock()
+	if swim == nil {
+		return nil
+	}
+	return swim.Members()
+}
+
+// memberGone: gossip đã ghi nhận node id hỏng (dead) hoặc rời cụm (left). Không bật gossip → false.
+func (s *FileServer) memberGone(id string) bool {
+	s.peerLock.Lock()
+	swim := s.membership
+	s.peerLock.Unlock()
+	if swim == nil {
+		return false
+	}
+	m, ok := swim.Member(id)
+	return ok && (m.Status == p2p.MemberDead || m.Status == p2p.MemberLeft)
+}
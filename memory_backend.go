@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                       MEMORY BACKEND (CHO TEST)                             //
+////////////////////////////////////////////////////////////////////////////////
+
+// MemoryBackend giữ toàn bộ object trong RAM – dùng cho test FileServer mà không đụng tới đĩa.
+// Store dùng backend này thì index cũng chỉ nằm trong RAM.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{objects: make(map[string]memoryObject)}
+}
+
+// Put đọc hết r rồi mới ghi → lỗi giữa chừng không làm thay đổi object cũ.
+func (b *MemoryBackend) Put(path string, r io.Reader) (int64, error) {
+	buf := new(bytes.Buffer)
+	n, err := io.Copy(buf, r)
+	if err != nil {
+		return n, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[path] = memoryObject{data: buf.Bytes(), modTime: time.Now().UTC()}
+	return n, nil
+}
+
+func (b *MemoryBackend) Get(path string) (int64, io.ReadCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	obj, ok := b.objects[path]
+	if !ok {
+		return 0, nil, notExist("get", path)
+	}
+	// data không bao giờ bị sửa tại chỗ (Put thay bằng slice mới) nên đọc song song an toàn
+	return int64(len(obj.data)), io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (b *MemoryBackend) Has(path string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.objects[path]
+	return ok
+}
+
+func (b *MemoryBackend) Delete(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, path)
+	return nil
+}
+
+func (b *MemoryBackend) List(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var paths []string
+	for path := range b.objects {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (b *MemoryBackend) Stat(path string) (ObjectInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	obj, ok := b.objects[path]
+	if !ok {
+		return ObjectInfo{}, notExist("stat", path)
+	}
+	return ObjectInfo{Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+func (b *MemoryBackend) Clear() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects = make(map[string]memoryObject)
+	return nil
+}
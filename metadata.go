@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                      METADATA CỦA OBJECT (SIDECAR)                         //
+////////////////////////////////////////////////////////////////////////////////
+
+// metaFileSuffix: sidecar metadata nằm cạnh file dữ liệu: <hash> → <hash>.meta
+const metaFileSuffix = ".meta"
+
+// sniffLen: số byte đầu dùng để đoán content type (giống http.DetectContentType).
+const sniffLen = 512
+
+// maxMetaSize: giới hạn kích thước metadata nhận qua mạng (chống peer gửi rác).
+const maxMetaSize = 64 * 1024
+
+// ObjectMeta: metadata của 1 object trong Store.
+// Các field này được gửi kèm MessageStoreFile nên mọi bản sao giữ metadata giống hệt bản gốc.
+type ObjectMeta struct {
+	Key         string        // key gốc (trước khi hash vào CAS)
+	Size        int64         // kích thước nội dung gốc (plaintext)
+	Hash        string        // SHA-256 (hex) của nội dung gốc
+	CreatedAt   time.Time     // thời điểm tạo trên node gốc
+	ContentType string        // MIME type (đoán từ 512 byte đầu nếu không khai báo)
+	EncKeyID    string        // định danh khóa dùng để mã hóa bản sao trên mạng (rỗng nếu không mã hóa)
+	Compression Compression   `json:",omitempty"` // thuật toán nén nội dung trước khi mã hóa (rỗng nếu không nén)
+	VersionID   string        `json:",omitempty"` // định danh phiên bản (gán khi ghi trên node gốc, xem versioning)
+	Vector      VersionVector `json:",omitempty"` // version vector – phát hiện ghi đồng thời giữa các replica (xem conflict)
+	Timestamp   Timestamp     // mốc HLC của lần ghi (last-writer-wins)
+	Replica     string        `json:",omitempty"` // replica đã thực hiện lần ghi
+	Owner       string        // node ID của chủ sở hữu
+	// OwnerSig: chữ ký của chủ namespace trên ownerRecord (gắn khi chủ đẩy bản sao, xem pushObject) –
+	// bằng chứng để node khác nhận bản sao này khi được chuyển giao.
+	OwnerSig []byte `json:",omitempty"`
+}
+
+// fill điền các field còn trống từ dữ liệu vừa ghi. Field đã có giá trị được giữ nguyên
+// (ví dụ bản sao nhận bytes đã mã hóa nhưng Size/Hash phải là của nội dung gốc).
+func (m *ObjectMeta) fill(key string, size int64, hash string, head []byte) {
+	if len(m.Key) == 0 {
+		m.Key = key
+	}
+	if len(m.Hash) == 0 {
+		m.Size = size
+		m.Hash = hash
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	if len(m.ContentType) == 0 {
+		m.ContentType = http.DetectContentType(head)
+	}
+}
+
+// encKeyID: định danh ngắn của khóa mã hóa (không làm lộ khóa).
+func encKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Stat trả về metadata của object.
+// Object cũ (ghi trước khi có sidecar) vẫn có metadata tối thiểu lấy từ file trên đĩa.
+func (s *Store) Stat(id string, key string) (ObjectMeta, error) {
+	path, ok := s.lookup(id, key)
+	if !ok {
+		return ObjectMeta{}, fmt.Errorf("stat %s: %w", key, os.ErrNotExist)
+	}
+
+	sc, err := s.readSidecar(path)
+	if errors.Is(err, os.ErrNotExist) {
+		info, err := s.backend.Stat(path)
+		if err != nil {
+			return ObjectMeta{}, err
+		}
+		return ObjectMeta{Key: key, Size: info.Size, CreatedAt: info.ModTime}, nil
+	}
+	return sc.ObjectMeta, err
+}
+
+// sidecar: nội dung file .meta = ObjectMeta + StoreKey (key đã dùng để ghi object vào Store).
+// StoreKey chỉ có ý nghĩa cục bộ (không gửi qua mạng) – nhờ nó mà index dựng lại được từ đĩa.
+//
+// ArchivedAt chỉ có ở sidecar của phiên bản cũ (xem versioning): thời điểm phiên bản bị thay thế.
+type sidecar struct {
+	ObjectMeta
+	StoreKey   string    `json:",omitempty"`
+	ArchivedAt time.Time `json:",omitempty"`
+}
+
+// writeMeta ghi sidecar (nguyên tử) cho object ở path trong backend.
+func (s *Store) writeMeta(path string, storeKey string, meta ObjectMeta) error {
+	return s.writeSidecar(path, sidecar{ObjectMeta: meta, StoreKey: storeKey})
+}
+
+func (s *Store) writeSidecar(path string, sc sidecar) error {
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	_, err = s.backend.Put(path+metaFileSuffix, bytes.NewReader(b))
+	return err
+}
+
+// readSidecar đọc toàn bộ sidecar của object ở path trong backend.
+func (s *Store) readSidecar(path string) (sidecar, error) {
+	var sc sidecar
+	_, r, err := s.backend.Get(path + metaFileSuffix)
+	if err != nil {
+		return sc, err
+	}
+	defer r.Close()
+	return sc, json.NewDecoder(io.LimitReader(r, maxMetaSize)).Decode(&sc)
+}
+
+// headWriter giữ lại tối đa max byte đầu tiên được ghi qua nó.
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (h *headWriter) Write(b []byte) (int, error) {
+	if room := h.max - len(h.buf); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		h.buf = append(h.buf, b[:room]...)
+	}
+	return len(b), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                     METADATA TRÊN DÂY (STREAM GET)                          //
+////////////////////////////////////////////////////////////////////////////////
+
+// writeMetaFrame: gửi metadata dạng [uint32 độ dài (LE)][JSON].
+// Dùng trong stream trả lời MessageGetFile để bản sao khôi phục từ mạng giữ nguyên metadata.
+func writeMetaFrame(w io.Writer, meta ObjectMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readMetaFrame: đọc metadata do writeMetaFrame gửi.
+func readMetaFrame(r io.Reader) (ObjectMeta, error) {
+	var (
+		meta ObjectMeta
+		size uint32
+	)
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return meta, err
+	}
+	if size > maxMetaSize {
+		return meta, fmt.Errorf("metadata too large (%d bytes)", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return meta, err
+	}
+	return meta, json.Unmarshal(b, &meta)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStoreStat: Write tự tính metadata, WriteWithMeta giữ nguyên metadata được truyền vào.
+func TestStoreStat(t *testing.T) {
+	s := newStore()
+	defer teardown(t, s)
+	id := generateID()
+
+	data := []byte("<html><body>hello</body></html>")
+	if _, err := s.Write(id, "index.html", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s.Stat(id, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Key != "index.html" || meta.Size != int64(len(data)) {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	if meta.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("want text/html have %s", meta.ContentType)
+	}
+	if len(meta.Hash) != 64 || meta.CreatedAt.IsZero() {
+		t.Errorf("expected hash and creation time, have %+v", meta)
+	}
+
+	// Bản sao: bytes khác (đã mã hóa) nhưng metadata phải y hệt bản gốc
+	origin := ObjectMeta{
+		Key:         "photo.png",
+		Size:        42,
+		Hash:        meta.Hash,
+		CreatedAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		ContentType: "image/png",
+		EncKeyID:    encKeyID([]byte("key")),
+		VersionID:   "17a1b2c3d4e5f607",
+		Vector:      VersionVector{"origin": 3},
+		Timestamp:   Timestamp{Wall: 1704164645000000000, Logical: 1},
+		Replica:     "origin",
+		Owner:       id,
+	}
+	if _, err := s.WriteWithMeta(id, hashKey("photo.png"), origin, bytes.NewReader([]byte("ciphertext"))); err != nil {
+		t.Fatal(err)
+	}
+	replica, err := s.Stat(id, hashKey("photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replica, origin) {
+		t.Errorf("want %+v have %+v", origin, replica)
+	}
+
+	if _, err := s.Stat(id, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error, have %v", err)
+	}
+}
+
+// TestMetaFrame: metadata gửi qua stream đọc lại được nguyên vẹn.
+func TestMetaFrame(t *testing.T) {
+	meta := ObjectMeta{Key: "foo", Size: 3, Hash: "abc", CreatedAt: time.Now().UTC().Round(0), Owner: "me"}
+
+	buf := new(bytes.Buffer)
+	if err := writeMetaFrame(buf, meta); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("file bytes")
+
+	have, err := readMetaFrame(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !have.CreatedAt.Equal(meta.CreatedAt) {
+		t.Errorf("want %v have %v", meta.CreatedAt, have.CreatedAt)
+	}
+	have.CreatedAt = meta.CreatedAt
+	if !reflect.DeepEqual(have, meta) {
+		t.Errorf("want %+v have %+v", meta, have)
+	}
+	if buf.String() != "file bytes" {
+		t.Errorf("meta frame consumed file bytes: %q", buf.String())
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                     MIGRATION: ĐỔI LAYOUT CỦA STORE                         //
+////////////////////////////////////////////////////////////////////////////////
+
+// migrationStateFile: file checkpoint nằm ngay trong Root.
+// Nhờ nó mà migration có thể chạy lại (resume) sau khi bị ngắt giữa chừng.
+const migrationStateFile = ".migration"
+
+// MigrateOpts: cấu hình cho MigrateStore.
+//   - Root: thư mục gốc của Store cần migrate.
+//   - From: layout hiện tại của dữ liệu trên đĩa.
+//   - To  : layout mới.
+//   - Keys: liệt kê key gốc trong 1 namespace (ID). Chỉ bắt buộc khi đổi thuật toán băm,
+//     vì CAS là một chiều – không thể suy ra hash mới từ hash cũ.
+type MigrateOpts struct {
+	Root string
+	From CASOpts
+	To   CASOpts
+	Keys func(id string) ([]string, error)
+}
+
+// MigrateStats: thống kê sau khi migrate.
+type MigrateStats struct {
+	Moved   int // số object đã chuyển sang layout mới
+	Skipped int // số object đã ở đúng chỗ (hoặc không tìm thấy)
+}
+
+// migrationState: nội dung file checkpoint.
+type migrationState struct {
+	From CASOpts
+	To   CASOpts
+	Done []string // các namespace (ID) đã migrate xong
+}
+
+// MigrateStore duyệt toàn bộ Root và chuyển từng object từ layout From sang layout To.
+//
+// Mỗi object được chuyển bằng os.Rename (atomic trên cùng filesystem) nên reader
+// luôn thấy file ở chỗ cũ hoặc chỗ mới, không bao giờ thấy file dở dang.
+// Kết hợp với StoreOpts.LegacyPathTransformFunc = From, node vẫn phục vụ đọc bình thường
+// trong lúc migrate (không downtime).
+//
+// Migration có thể resume: namespace đã xong được ghi vào file checkpoint, còn trong
+// 1 namespace thì object đã nằm ở layout mới sẽ tự động bị bỏ qua.
+func MigrateStore(opts MigrateOpts) (MigrateStats, error) {
+	var stats MigrateStats
+
+	opts.From = opts.From.withDefaults()
+	opts.To = opts.To.withDefaults()
+	if err := opts.From.validate(); err != nil {
+		return stats, err
+	}
+	if err := opts.To.validate(); err != nil {
+		return stats, err
+	}
+	sameHash := opts.From.Hash == opts.To.Hash
+	if !sameHash && opts.Keys == nil {
+		return stats, fmt.Errorf("changing hash %s -> %s requires a key source", opts.From.Hash, opts.To.Hash)
+	}
+
+	entries, err := os.ReadDir(opts.Root)
+	if err != nil {
+		return stats, err
+	}
+	if err := checkFSLayout(opts.Root, entries); err != nil {
+		return stats, err
+	}
+
+	state, err := loadMigrationState(opts)
+	if err != nil {
+		return stats, err
+	}
+	done := make(map[string]bool)
+	for _, id := range state.Done {
+		done[id] = true
+	}
+
+	for _, entry := range entries {
+		id := entry.Name()
+		// Bỏ qua file hệ thống (.migration, ...) và namespace đã xong
+		if !entry.IsDir() || strings.HasPrefix(id, ".") || done[id] {
+			continue
+		}
+
+		if sameHash {
+			err = migrateLayout(opts, id, &stats)
+		} else {
+			err = migrateHash(opts, id, &stats)
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		// Ghi checkpoint sau mỗi namespace
+		state.Done = append(state.Done, id)
+		if err := saveMigrationState(opts.Root, state); err != nil {
+			return stats, err
+		}
+		log.Printf("migrated namespace [%s]", id)
+	}
+
+	// Object đã đổi chỗ → index cũ không còn đúng, lần mở Store sau sẽ dựng lại từ đĩa
+	if err := invalidateObjectIndex(opts.Root); err != nil {
+		return stats, err
+	}
+
+	// Xong hết → xóa checkpoint
+	if err := os.Remove(filepath.Join(opts.Root, migrationStateFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// migrateLayout: cùng thuật toán băm, chỉ đổi Depth/Width.
+// Tên file chính là hash đầy đủ → dựng được PathKey mới mà không cần key gốc.
+func migrateLayout(opts MigrateOpts, id string, stats *MigrateStats) error {
+	idRoot := filepath.Join(opts.Root, id)
+	hashLen := opts.From.hexLen()
+
+	// Gom danh sách file trước rồi mới rename, tránh vừa duyệt vừa sửa cây thư mục.
+	var files []string
+	err := filepath.WalkDir(idRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && isHashName(d.Name(), hashLen) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, src := range files {
+		pathKey := opts.To.pathKeyFromHash(filepath.Base(src))
+		dst := filepath.Join(idRoot, filepath.FromSlash(pathKey.FullPath()))
+		if err := moveObject(idRoot, src, dst, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateHash: đổi thuật toán băm → cần key gốc để tính lại đường dẫn.
+func migrateHash(opts MigrateOpts, id string, stats *MigrateStats) error {
+	idRoot := filepath.Join(opts.Root, id)
+
+	keys, err := opts.Keys(id)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		src := filepath.Join(idRoot, filepath.FromSlash(opts.From.transform(key).FullPath()))
+		dst := filepath.Join(idRoot, filepath.FromSlash(opts.To.transform(key).FullPath()))
+		if err := moveObject(idRoot, src, dst, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveObject chuyển 1 object src → dst (kèm sidecar metadata) rồi dọn các thư mục cha rỗng của src.
+// Nếu dst đã tồn tại (object được ghi mới theo layout mới trong lúc migrate) thì
+// bản mới thắng, bản cũ bị xóa.
+func moveObject(idRoot, src, dst string, stats *MigrateStats) error {
+	if src == dst {
+		stats.Skipped++
+		return nil
+	}
+
+	if !exists(src) {
+		stats.Skipped++
+	} else if exists(dst) {
+		if err := os.Remove(src); err != nil {
+			return err
+		}
+		stats.Skipped++
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		stats.Moved++
+	}
+
+	// Sidecar metadata đi theo object (kể cả khi lần chạy trước đã chuyển object nhưng chưa kịp chuyển sidecar)
+	if exists(src + metaFileSuffix) {
+		if exists(dst + metaFileSuffix) {
+			if err := os.Remove(src + metaFileSuffix); err != nil {
+				return err
+			}
+		} else if err := os.Rename(src+metaFileSuffix, dst+metaFileSuffix); err != nil {
+			return err
+		}
+	}
+
+	return pruneEmptyDirs(filepath.Dir(src), idRoot)
+}
+
+// pruneEmptyDirs xóa dần các thư mục rỗng từ dir đi lên, dừng ở stop (không xóa stop).
+func pruneEmptyDirs(dir, stop string) error {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+		if err := os.Remove(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceNameLen: độ dài tên thư mục namespace – node ID là public key ed25519 dạng hex.
+const namespaceNameLen = 2 * ed25519.PublicKeySize
+
+// checkFSLayout: MigrateStore chỉ hiểu layout của FSBackend (Root/<namespace>/<CAS path>).
+// Root của backend khác (SegmentBackend dưới "segments/", file pack của PackedBackend, ...) có
+// mục không phải namespace → từ chối thay vì coi nó là namespace rồi dời bytes của nó đi.
+func checkFSLayout(root string, entries []os.DirEntry) error {
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !entry.IsDir() || !isHashName(name, namespaceNameLen) {
+			return fmt.Errorf("migrate %s: %q is not a namespace; only filesystem-backend stores can be migrated", root, name)
+		}
+	}
+	return nil
+}
+
+// isHashName: tên file có phải chuỗi hex đúng độ dài hash không.
+func isHashName(name string, hashLen int) bool {
+	if len(name) != hashLen {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// loadMigrationState đọc checkpoint (nếu có).
+// Checkpoint của một migration khác (From/To khác) → báo lỗi thay vì trộn 2 layout.
+func loadMigrationState(opts MigrateOpts) (*migrationState, error) {
+	state := &migrationState{From: opts.From, To: opts.To}
+
+	b, err := os.ReadFile(filepath.Join(opts.Root, migrationStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved migrationState
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	if saved.From != opts.From || saved.To != opts.To {
+		return nil, fmt.Errorf("another migration (%+v -> %+v) is in progress in %s", saved.From, saved.To, opts.Root)
+	}
+	return &saved, nil
+}
+
+// saveMigrationState ghi checkpoint (ghi file tạm rồi rename để không bao giờ hỏng).
+func saveMigrationState(root string, state *migrationState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(root, migrationStateFile)
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
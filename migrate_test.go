@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrateStoreLayout: cùng thuật toán băm, chỉ đổi fan-out → không cần key gốc.
+func TestMigrateStoreLayout(t *testing.T) {
+	s := newStore()
+	defer teardown(t, s)
+	id := generateID()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("foo_%d", i)
+		if _, err := s.Write(id, key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	to := CASOpts{Hash: HashSHA1, Width: 2, Depth: 2}
+	stats, err := MigrateStore(MigrateOpts{Root: s.Root, From: DefaultCASOpts, To: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Moved != 10 {
+		t.Errorf("want 10 moved have %d", stats.Moved)
+	}
+
+	newFunc, _ := NewCASPathTransformFunc(to)
+	migrated := NewStore(StoreOpts{Root: s.Root, PathTransformFunc: newFunc})
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("foo_%d", i)
+		_, r, err := migrated.Read(id, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != key {
+			t.Errorf("want %s have %s", key, b)
+		}
+		if s.Has(id, key) {
+			t.Errorf("expected old layout of %s to be gone", key)
+		}
+	}
+
+	// Chạy lại lần nữa: không còn gì để chuyển (idempotent / resume)
+	stats, err = MigrateStore(MigrateOpts{Root: s.Root, From: DefaultCASOpts, To: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Moved != 0 {
+		t.Errorf("want 0 moved on second run have %d", stats.Moved)
+	}
+}
+
+// TestMigrateStoreHash: đổi thuật toán băm → cần nguồn key, và resume từ checkpoint.
+func TestMigrateStoreHash(t *testing.T) {
+	s := newStore()
+	defer teardown(t, s)
+	id := generateID()
+
+	keys := []string{"a", "b", "c"}
+	for _, key := range keys {
+		if _, err := s.Write(id, key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	to := CASOpts{Hash: HashSHA256, Width: 2, Depth: 2}
+	if _, err := MigrateStore(MigrateOpts{Root: s.Root, From: DefaultCASOpts, To: to}); err == nil {
+		t.Fatalf("expected error without key source")
+	}
+
+	// Checkpoint của 1 migration khác đang dở → phải từ chối
+	state := &migrationState{From: DefaultCASOpts, To: CASOpts{Hash: HashBLAKE2b, Width: 5}}
+	if err := saveMigrationState(s.Root, state); err != nil {
+		t.Fatal(err)
+	}
+	opts := MigrateOpts{
+		Root: s.Root,
+		From: DefaultCASOpts,
+		To:   to,
+		Keys: func(string) ([]string, error) { return keys, nil },
+	}
+	if _, err := MigrateStore(opts); err == nil {
+		t.Fatalf("expected error for conflicting checkpoint")
+	}
+	os.Remove(s.Root + "/" + migrationStateFile)
+
+	stats, err := MigrateStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Moved != len(keys) {
+		t.Errorf("want %d moved have %d", len(keys), stats.Moved)
+	}
+
+	newFunc, _ := NewCASPathTransformFunc(to)
+	migrated := NewStore(StoreOpts{Root: s.Root, PathTransformFunc: newFunc})
+	for _, key := range keys {
+		if !migrated.Has(id, key) {
+			t.Errorf("expected to have key %s in new layout", key)
+		}
+	}
+}
+
+// TestMigrateStoreRejectsOtherBackends: Root của SegmentBackend (như bản demo trong main) không phải
+// layout FS → MigrateStore báo lỗi và không đụng tới "segments/".
+func TestMigrateStoreRejectsOtherBackends(t *testing.T) {
+	root := t.TempDir()
+	backend, err := NewSegmentBackend(SegmentOpts{Dir: filepath.Join(root, "segments")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	s := NewStore(StoreOpts{Root: root, PathTransformFunc: CASPathTransformFunc, Backend: backend})
+	id := generateID()
+	if _, err := s.Write(id, "foo", bytes.NewReader([]byte("foo"))); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadDir(filepath.Join(root, "segments"))
+
+	to := CASOpts{Hash: HashSHA1, Width: 2, Depth: 2}
+	if _, err := MigrateStore(MigrateOpts{Root: root, From: DefaultCASOpts, To: to}); err == nil {
+		t.Fatal("expected migrate to refuse a segment-backend root")
+	}
+	after, _ := os.ReadDir(filepath.Join(root, "segments"))
+	if len(after) != len(before) {
+		t.Errorf("segments dir changed: %d -> %d entries", len(before), len(after))
+	}
+	if _, r, err := s.Read(id, "foo"); err != nil {
+		t.Fatal(err)
+	} else if b, _ := ioutil.ReadAll(r); string(b) != "foo" {
+		t.Errorf("want foo have %s", b)
+	}
+}
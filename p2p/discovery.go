@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tự tìm peer trong mạng LAN bằng UDP multicast (kiểu mDNS, định dạng gói riêng):
+//   - Mỗi Interval node gửi "announce" (cluster, node ID, địa chỉ TCP) tới nhóm multicast.
+//   - Lúc khởi động gửi thêm "query" → các node đang chạy announce ngay, không phải chờ hết chu kỳ.
+//   - Chỉ nhận announce có cùng Cluster → nhiều cụm trên 1 mạng không bị gộp vào nhau.
+//
+// ⚠️ Gói không được ký: announce chỉ là gợi ý địa chỉ để dial, danh tính thật của node
+// được xác thực ở kênh TCP (Noise). Multicast thường không đi qua router → chỉ trong 1 LAN.
+
+const (
+	// DefaultDiscoveryGroup: nhóm multicast mặc định (dải 239.0.0.0/8 – phạm vi tổ chức).
+	DefaultDiscoveryGroup = "239.255.77.77:7946"
+	// discoveryMagic: đánh dấu gói của giao thức này (bỏ qua gói lạ gửi tới cùng nhóm).
+	discoveryMagic = "DFS-DISCOVERY/1"
+)
+
+// Loại gói discovery.
+const (
+	discoveryAnnounce byte = iota
+	discoveryQuery
+)
+
+// DiscoveryOpts: cấu hình discovery. Interval = 0 → 10s.
+type DiscoveryOpts struct {
+	Cluster   string        // tên cụm (bắt buộc): chỉ nhận node cùng tên
+	NodeID    string        // ID của node này (announce của chính mình bị bỏ qua)
+	Addr      string        // địa chỉ node khác dùng để dial (host rỗng, ví dụ ":3000" → IP nguồn của gói)
+	Group     string        // địa chỉ multicast "ip:port" (rỗng → DefaultDiscoveryGroup)
+	Interface string        // tên network interface (rỗng → interface mặc định; "lo" cho test trên 1 máy)
+	Interval  time.Duration // chu kỳ announce; peer không announce lại sau 3 chu kỳ bị coi là đã đi
+
+	OnPeer func(DiscoveredPeer) // (tùy chọn) gọi khi thấy node mới hoặc node đổi địa chỉ
+}
+
+// DiscoveredPeer: 1 node cùng cụm tìm thấy qua multicast.
+type DiscoveredPeer struct {
+	ID       string
+	Addr     string
+	LastSeen time.Time
+}
+
+// discoveryPacket: nội dung 1 gói multicast (gob).
+type discoveryPacket struct {
+	Magic   string
+	Type    byte
+	Cluster string
+	ID      string
+	Addr    string
+}
+
+// Discovery: announce node này và theo dõi các node cùng cụm trong LAN.
+type Discovery struct {
+	DiscoveryOpts
+
+	group *net.UDPAddr
+	recv  *net.UDPConn // socket đã join nhóm multicast
+	send  *net.UDPConn // socket gửi (đã chọn interface)
+
+	mu    sync.Mutex
+	peers map[string]DiscoveredPeer
+
+	quitch chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDiscovery join nhóm multicast, gửi query + announce đầu tiên rồi chạy nền cho tới khi Close.
+func NewDiscovery(opts DiscoveryOpts) (*Discovery, error) {
+	if len(opts.Cluster) == 0 {
+		return nil, errors.New("discovery: missing cluster name")
+	}
+	if len(opts.Group) == 0 {
+		opts.Group = DefaultDiscoveryGroup
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	group, err := net.ResolveUDPAddr("udp4", opts.Group)
+	if err != nil {
+		return nil, err
+	}
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("discovery: %s is not a multicast address", opts.Group)
+	}
+
+	var ifi *net.Interface
+	if len(opts.Interface) > 0 {
+		if ifi, err = net.InterfaceByName(opts.Interface); err != nil {
+			return nil, err
+		}
+	}
+	recv, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		recv.Close()
+		return nil, err
+	}
+	if ifi != nil {
+		if err := setMulticastInterface(send, ifi); err != nil {
+			recv.Close()
+			send.Close()
+			return nil, err
+		}
+	}
+
+	d := &Discovery{
+		DiscoveryOpts: opts,
+		group:         group,
+		recv:          recv,
+		send:          send,
+		peers:         make(map[string]DiscoveredPeer),
+		quitch:        make(chan struct{}),
+	}
+	d.wg.Add(2)
+	go d.readLoop()
+	go d.announceLoop()
+	return d, nil
+}
+
+// Peers: các node cùng cụm đã announce trong 3 chu kỳ gần nhất, sắp xếp theo ID.
+func (d *Discovery) Peers() []DiscoveredPeer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cutoff := time.Now().Add(-3 * d.Interval)
+	var peers []DiscoveredPeer
+	for id, p := range d.peers {
+		if p.LastSeen.Before(cutoff) {
+			delete(d.peers, id)
+			continue
+		}
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// Close rời nhóm multicast và dừng announce.
+func (d *Discovery) Close() error {
+	select {
+	case <-d.quitch:
+		return nil
+	default:
+	}
+	close(d.quitch)
+	err := d.recv.Close()
+	d.send.Close()
+	d.wg.Wait()
+	return err
+}
+
+// announceLoop: query + announce ngay khi khởi động, sau đó announce mỗi Interval.
+func (d *Discovery) announceLoop() {
+	defer d.wg.Done()
+
+	if err := d.sendPacket(discoveryQuery); err != nil {
+		log.Printf("discovery: sending query: %s", err)
+	}
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.sendPacket(discoveryAnnounce); err != nil {
+			log.Printf("discovery: sending announce: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-d.quitch:
+			return
+		}
+	}
+}
+
+func (d *Discovery) readLoop() {
+	defer d.wg.Done()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := d.recv.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("discovery: reading packet: %s", err)
+			continue
+		}
+		var pkt discoveryPacket
+		if err := gob.NewDecoder(bytes.NewReader(buf[:n])).Decode(&pkt); err != nil || pkt.Magic != discoveryMagic {
+			continue // gói của ứng dụng khác dùng chung nhóm
+		}
+		if pkt.Cluster != d.Cluster || pkt.ID == d.NodeID {
+			continue
+		}
+
+		switch pkt.Type {
+		case discoveryQuery:
+			if err := d.sendPacket(discoveryAnnounce); err != nil {
+				log.Printf("discovery: answering query: %s", err)
+			}
+		case discoveryAnnounce:
+			d.seen(pkt, from)
+		}
+	}
+}
+
+// seen ghi nhận announce; node mới hoặc đổi địa chỉ → OnPeer.
+func (d *Discovery) seen(pkt discoveryPacket, from *net.UDPAddr) {
+	addr := pkt.Addr
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+			addr = net.JoinHostPort(from.IP.String(), port)
+		}
+	}
+
+	d.mu.Lock()
+	old, known := d.peers[pkt.ID]
+	p := DiscoveredPeer{ID: pkt.ID, Addr: addr, LastSeen: time.Now()}
+	d.peers[pkt.ID] = p
+	d.mu.Unlock()
+
+	if (!known || old.Addr != addr) && d.OnPeer != nil {
+		d.OnPeer(p)
+	}
+}
+
+func (d *Discovery) sendPacket(typ byte) error {
+	buf := new(bytes.Buffer)
+	pkt := discoveryPacket{Magic: discoveryMagic, Type: typ, Cluster: d.Cluster, ID: d.NodeID, Addr: d.Addr}
+	if err := gob.NewEncoder(buf).Encode(pkt); err != nil {
+		return err
+	}
+	_, err := d.send.WriteToUDP(buf.Bytes(), d.group)
+	return err
+}
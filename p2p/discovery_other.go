@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package p2p
+
+import "net"
+
+// setMulticastInterface: không hỗ trợ chọn interface trên hệ điều hành này → gửi qua interface mặc định.
+func setMulticastInterface(conn *net.UDPConn, ifi *net.Interface) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiscovery(t *testing.T, cluster string, id string, onPeer func(DiscoveredPeer)) *Discovery {
+	d, err := NewDiscovery(DiscoveryOpts{
+		Cluster:   cluster,
+		NodeID:    id,
+		Addr:      ":" + id,
+		Group:     "239.255.77.78:17946",
+		Interface: "lo",
+		Interval:  100 * time.Millisecond,
+		OnPeer:    onPeer,
+	})
+	require.Nil(t, err)
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// TestDiscovery: node cùng cụm tìm thấy nhau trên loopback (địa chỉ host rỗng → IP nguồn),
+// node khác cụm trên cùng nhóm multicast bị bỏ qua.
+func TestDiscovery(t *testing.T) {
+	found := make(chan DiscoveredPeer, 10)
+	a := newTestDiscovery(t, "blue", "3000", func(p DiscoveredPeer) { found <- p })
+	b := newTestDiscovery(t, "blue", "4000", nil)
+	newTestDiscovery(t, "green", "5000", nil)
+
+	select {
+	case p := <-found:
+		assert.Equal(t, "4000", p.ID)
+		assert.Equal(t, "127.0.0.1:4000", p.Addr)
+	case <-time.After(2 * time.Second):
+		t.Fatal("a did not discover b")
+	}
+	assert.Eventually(t, func() bool {
+		peers := b.Peers()
+		return len(peers) == 1 && peers[0].ID == "3000"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	time.Sleep(300 * time.Millisecond) // vài chu kỳ announce của node cụm "green"
+	peers := a.Peers()
+	require.Len(t, peers, 1)
+	assert.Equal(t, "4000", peers[0].ID)
+}
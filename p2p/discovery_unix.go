@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package p2p
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setMulticastInterface chọn interface gửi gói multicast (IP_MULTICAST_IF = IPv4 đầu tiên của ifi).
+func setMulticastInterface(conn *net.UDPConn, ifi *net.Interface) error {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return err
+	}
+	var ip [4]byte
+	found := false
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			copy(ip[:], ipnet.IP.To4())
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("discovery: interface " + ifi.Name + " has no IPv4 address")
+	}
+
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, ip)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
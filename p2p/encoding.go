@@ -1,7 +1,9 @@
 package p2p
 
 import (
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
 )
 
@@ -35,20 +37,27 @@ func (dec GOBDecoder) Decode(r io.Reader, msg *RPC) error {
 // DefaultDecoder: tự chế
 // ------------------------------
 
-// DefaultDecoder là bộ giải mã đơn giản nhất:
-// đọc thẳng byte từ kết nối, không có format phức tạp.
+// MaxMessageSize: kích thước tối đa của 1 message (chống peer gửi độ dài khổng lồ).
+const MaxMessageSize = 16 << 20
+
+// DefaultDecoder là bộ giải mã đơn giản nhất, đọc frame dạng [type|length|payload]:
+//   - type  : 1 byte, IncomingMessage hoặc IncomingStream.
+//   - length: uint32 big-endian (chỉ có với IncomingMessage).
+//   - payload: đúng length byte.
+//
+// Nhờ có length, message dài bao nhiêu cũng đọc đủ, và 2 message dính liền nhau
+// trong cùng 1 lần Read của TCP vẫn được tách đúng.
 type DefaultDecoder struct{}
 
 // Decode của DefaultDecoder:
 // - Nó đọc 1 byte đầu tiên để kiểm tra xem đây có phải "stream" không.
 // - Nếu là stream, chỉ gắn cờ msg.Stream = true và return.
-// - Nếu không phải stream, thì đọc tiếp dữ liệu vào Payload.
+// - Nếu không phải stream, thì đọc length rồi đọc đúng length byte vào Payload.
 func (dec DefaultDecoder) Decode(r io.Reader, msg *RPC) error {
 	// Đọc 1 byte đầu tiên từ kết nối
 	peekBuf := make([]byte, 1)
-	if _, err := r.Read(peekBuf); err != nil {
-		// Nếu không đọc được gì (EOF chẳng hạn), trả về nil (coi như im lặng).
-		return nil
+	if _, err := io.ReadFull(r, peekBuf); err != nil {
+		return err
 	}
 
 	// Kiểm tra xem byte đầu có phải flag "IncomingStream" không.
@@ -59,18 +68,37 @@ func (dec DefaultDecoder) Decode(r io.Reader, msg *RPC) error {
 		msg.Stream = true
 		return nil
 	}
+	if peekBuf[0] != IncomingMessage {
+		return fmt.Errorf("unknown frame type 0x%x", peekBuf[0])
+	}
+
+	// Nếu không phải stream: đọc độ dài rồi đọc đủ payload.
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size > MaxMessageSize {
+		return fmt.Errorf("message too large (%d bytes)", size)
+	}
 
-	// Nếu không phải stream:
-	// Đọc tiếp tối đa 1028 byte dữ liệu từ kết nối vào buffer.
-	buf := make([]byte, 1028)
-	n, err := r.Read(buf)
-	if err != nil {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		// Nếu có lỗi khi đọc (kết nối đóng, timeout...), trả error ra ngoài.
 		return err
 	}
 
-	// Lưu dữ liệu thực sự đọc được (buf[:n]) vào msg.Payload.
-	msg.Payload = buf[:n]
+	// Lưu dữ liệu vào msg.Payload.
+	msg.Payload = buf
 
 	return nil
 }
+
+// EncodeMessage đóng gói payload thành frame [IncomingMessage|length|payload] cho DefaultDecoder.
+// Gửi cả frame trong 1 lần Send để các goroutine gửi đồng thời không chen byte vào giữa.
+func EncodeMessage(payload []byte) []byte {
+	frame := make([]byte, 5+len(payload))
+	frame[0] = IncomingMessage
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	return frame
+}
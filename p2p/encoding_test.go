@@ -0,0 +1,36 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDefaultDecoder: 2 message dính liền nhau + 1 stream flag vẫn được tách đúng,
+// message lớn hơn 1 lần Read vẫn đọc đủ.
+func TestDefaultDecoder(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 64*1024)
+
+	buf := new(bytes.Buffer)
+	buf.Write(EncodeMessage([]byte("hello")))
+	buf.Write(EncodeMessage(big))
+	buf.WriteByte(IncomingStream)
+
+	dec := DefaultDecoder{}
+
+	var rpc RPC
+	assert.Nil(t, dec.Decode(buf, &rpc))
+	assert.Equal(t, "hello", string(rpc.Payload))
+
+	rpc = RPC{}
+	assert.Nil(t, dec.Decode(buf, &rpc))
+	assert.Equal(t, big, rpc.Payload)
+
+	rpc = RPC{}
+	assert.Nil(t, dec.Decode(buf, &rpc))
+	assert.True(t, rpc.Stream)
+
+	// Hết dữ liệu → trả lỗi để read loop dừng lại
+	assert.NotNil(t, dec.Decode(buf, &rpc))
+}
@@ -1,5 +1,7 @@
 package p2p
 
+import "net"
+
 // HandshakeFunc là một "kiểu hàm" (function type).
 // Nó nhận vào một Peer (đại diện cho kết nối tới một node khác)
 // và trả về error.
@@ -12,3 +14,9 @@ type HandshakeFunc func(Peer) error
 // Hàm này luôn trả về nil, tức là "luôn chấp nhận mọi kết nối, không kiểm tra gì cả".
 // Nó dùng như mặc định, khi bạn không cần xác thực hay bắt tay phức tạp.
 func NOPHandshakeFunc(Peer) error { return nil }
+
+// UpgradeFunc "nâng cấp" một kết nối thô trước khi nó trở thành Peer
+// (ví dụ: bọc bằng kênh mã hóa Noise). Trả về kết nối mới để dùng thay cho conn.
+// - outbound = true nếu mình là bên chủ động Dial().
+// - Trả về error → kết nối bị đóng.
+type UpgradeFunc func(conn net.Conn, outbound bool) (net.Conn, error)
@@ -0,0 +1,547 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Kênh bảo mật theo Noise Protocol Framework, pattern XX:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+//
+// Suite: Noise_XX_25519_ChaChaPoly_SHA256. Hai bên trao đổi khóa tĩnh X25519 trong lúc bắt tay,
+// mỗi khóa tĩnh được ký bằng khóa danh tính Ed25519 của node → bên kia biết chính xác
+// node ID (public key) đang nói chuyện với mình và có thể đối chiếu allow-list.
+// Không cần CA như TLS.
+
+const (
+	noiseProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+	// noiseMaxMsgLen: độ dài tối đa 1 message Noise (gồm cả tag 16 byte).
+	noiseMaxMsgLen = 65535
+	// noiseMaxPlaintext: lượng dữ liệu tối đa trong 1 frame sau khi trừ tag AEAD.
+	noiseMaxPlaintext = noiseMaxMsgLen - chacha20poly1305.Overhead
+	// noiseSignPrefix: tiền tố khi ký khóa tĩnh (tránh dùng chữ ký cho mục đích khác).
+	noiseSignPrefix = "noise-static-key:"
+	// noiseHandshakeTimeout: thời gian tối đa cho cả quá trình bắt tay.
+	noiseHandshakeTimeout = 10 * time.Second
+)
+
+// ErrPeerNotAllowed: peer xác thực thành công nhưng không nằm trong allow-list.
+var ErrPeerNotAllowed = errors.New("noise: peer public key not in allow-list")
+
+// NoiseConfig: cấu hình kênh Noise.
+//   - PrivateKey : khóa danh tính Ed25519 của node (khóa tĩnh X25519 được suy ra từ seed của nó).
+//   - AllowedKeys: danh sách public key Ed25519 được phép kết nối. Rỗng → chấp nhận mọi peer
+//     đã xác thực (biết chắc danh tính nhưng không giới hạn ai).
+type NoiseConfig struct {
+	PrivateKey  ed25519.PrivateKey
+	AllowedKeys []ed25519.PublicKey
+}
+
+// NoiseUpgradeFunc trả về UpgradeFunc bọc mọi kết nối TCP bằng kênh Noise.
+// Dùng: TCPTransportOpts{UpgradeFunc: p2p.NoiseUpgradeFunc(cfg), ...}
+func NoiseUpgradeFunc(cfg NoiseConfig) UpgradeFunc {
+	return func(conn net.Conn, outbound bool) (net.Conn, error) {
+		return NewNoiseConn(conn, cfg, outbound)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                                NOISE CONN                                  //
+////////////////////////////////////////////////////////////////////////////////
+
+// NoiseConn là net.Conn đã được mã hóa bằng Noise.
+// Mỗi frame trên dây: [uint16 độ dài (big-endian)][ciphertext + tag].
+type NoiseConn struct {
+	net.Conn
+
+	remoteKey ed25519.PublicKey // khóa danh tính của peer (đã xác thực)
+
+	readLock sync.Mutex
+	recv     *cipherState
+	readBuf  []byte // plaintext còn dư của frame trước
+
+	writeLock sync.Mutex
+	send      *cipherState
+}
+
+// NewNoiseConn thực hiện bắt tay XX trên conn.
+// outbound = true → mình là initiator (bên Dial).
+func NewNoiseConn(conn net.Conn, cfg NoiseConfig, outbound bool) (*NoiseConn, error) {
+	if len(cfg.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("noise: missing identity private key")
+	}
+
+	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hs, err := newHandshakeState(cfg, outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	var send, recv *cipherState
+	if outbound {
+		send, recv, err = hs.runInitiator(conn)
+	} else {
+		send, recv, err = hs.runResponder(conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &NoiseConn{
+		Conn:      conn,
+		remoteKey: hs.remoteIdentity,
+		send:      send,
+		recv:      recv,
+	}, nil
+}
+
+// RemotePublicKey: khóa danh tính Ed25519 của peer bên kia.
+func (c *NoiseConn) RemotePublicKey() ed25519.PublicKey {
+	return c.remoteKey
+}
+
+// Read giải mã từng frame, trả về plaintext.
+func (c *NoiseConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	if len(c.readBuf) == 0 {
+		frame, err := readNoiseFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		plain, err := c.recv.decrypt(nil, frame)
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = plain
+	}
+
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write mã hóa b (cắt thành nhiều frame nếu quá dài) rồi ghi ra kết nối.
+func (c *NoiseConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > noiseMaxPlaintext {
+			chunk = chunk[:noiseMaxPlaintext]
+		}
+		frame, err := c.send.encrypt(nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeNoiseFrame(c.Conn, frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                             HANDSHAKE (XX)                                 //
+////////////////////////////////////////////////////////////////////////////////
+
+// handshakeState: trạng thái trong lúc bắt tay.
+type handshakeState struct {
+	cfg   NoiseConfig
+	ss    symmetricState
+	s     keypair // khóa tĩnh X25519
+	e     keypair // khóa tạm thời X25519
+	rs    []byte  // khóa tĩnh của peer
+	re    []byte  // khóa tạm thời của peer
+	proof []byte  // payload chứng minh danh tính của mình
+
+	remoteIdentity ed25519.PublicKey
+}
+
+// keypair X25519.
+type keypair struct {
+	priv []byte
+	pub  []byte
+}
+
+func newHandshakeState(cfg NoiseConfig, initiator bool) (*handshakeState, error) {
+	static, err := staticKeypair(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := generateKeypair()
+	if err != nil {
+		return nil, err
+	}
+
+	hs := &handshakeState{cfg: cfg, s: static, e: ephemeral}
+	hs.ss.initialize(noiseProtocolName)
+	hs.ss.mixHash(nil) // prologue rỗng
+
+	// Payload = [public key Ed25519 (32B)][chữ ký Ed25519 trên khóa tĩnh X25519 (64B)]
+	sig := ed25519.Sign(cfg.PrivateKey, append([]byte(noiseSignPrefix), static.pub...))
+	identity := cfg.PrivateKey.Public().(ed25519.PublicKey)
+	hs.proof = append(append([]byte{}, identity...), sig...)
+	return hs, nil
+}
+
+// runInitiator: phía Dial.
+func (hs *handshakeState) runInitiator(conn net.Conn) (*cipherState, *cipherState, error) {
+	// -> e
+	msg := append([]byte{}, hs.e.pub...)
+	hs.ss.mixHash(hs.e.pub)
+	msg = append(msg, hs.ss.encryptAndHash(nil)...)
+	if err := writeNoiseFrame(conn, msg); err != nil {
+		return nil, nil, err
+	}
+
+	// <- e, ee, s, es
+	msg, err := readNoiseFrame(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(msg) < 32 {
+		return nil, nil, errors.New("noise: short handshake message")
+	}
+	hs.re, msg = msg[:32], msg[32:]
+	hs.ss.mixHash(hs.re)
+	if err := hs.mixDH(hs.e.priv, hs.re); err != nil {
+		return nil, nil, err
+	}
+	if hs.rs, msg, err = hs.readStatic(msg); err != nil {
+		return nil, nil, err
+	}
+	if err := hs.mixDH(hs.e.priv, hs.rs); err != nil {
+		return nil, nil, err
+	}
+	payload, err := hs.ss.decryptAndHash(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := hs.verifyProof(payload); err != nil {
+		return nil, nil, err
+	}
+
+	// -> s, se
+	msg = hs.ss.encryptAndHash(hs.s.pub)
+	if err := hs.mixDH(hs.s.priv, hs.re); err != nil {
+		return nil, nil, err
+	}
+	msg = append(msg, hs.ss.encryptAndHash(hs.proof)...)
+	if err := writeNoiseFrame(conn, msg); err != nil {
+		return nil, nil, err
+	}
+
+	c1, c2 := hs.ss.split()
+	return c1, c2, nil
+}
+
+// runResponder: phía Accept.
+func (hs *handshakeState) runResponder(conn net.Conn) (*cipherState, *cipherState, error) {
+	// -> e
+	msg, err := readNoiseFrame(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(msg) < 32 {
+		return nil, nil, errors.New("noise: short handshake message")
+	}
+	hs.re, msg = msg[:32], msg[32:]
+	hs.ss.mixHash(hs.re)
+	if _, err := hs.ss.decryptAndHash(msg); err != nil {
+		return nil, nil, err
+	}
+
+	// <- e, ee, s, es
+	out := append([]byte{}, hs.e.pub...)
+	hs.ss.mixHash(hs.e.pub)
+	if err := hs.mixDH(hs.e.priv, hs.re); err != nil {
+		return nil, nil, err
+	}
+	out = append(out, hs.ss.encryptAndHash(hs.s.pub)...)
+	if err := hs.mixDH(hs.s.priv, hs.re); err != nil {
+		return nil, nil, err
+	}
+	out = append(out, hs.ss.encryptAndHash(hs.proof)...)
+	if err := writeNoiseFrame(conn, out); err != nil {
+		return nil, nil, err
+	}
+
+	// -> s, se
+	msg, err = readNoiseFrame(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hs.rs, msg, err = hs.readStatic(msg); err != nil {
+		return nil, nil, err
+	}
+	if err := hs.mixDH(hs.e.priv, hs.rs); err != nil {
+		return nil, nil, err
+	}
+	payload, err := hs.ss.decryptAndHash(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := hs.verifyProof(payload); err != nil {
+		return nil, nil, err
+	}
+
+	c1, c2 := hs.ss.split()
+	return c2, c1, nil
+}
+
+// readStatic: đọc khóa tĩnh đã mã hóa (32B + tag 16B) ở đầu msg.
+func (hs *handshakeState) readStatic(msg []byte) ([]byte, []byte, error) {
+	n := 32 + chacha20poly1305.Overhead
+	if len(msg) < n {
+		return nil, nil, errors.New("noise: short handshake message")
+	}
+	rs, err := hs.ss.decryptAndHash(msg[:n])
+	if err != nil {
+		return nil, nil, err
+	}
+	return rs, msg[n:], nil
+}
+
+// mixDH: MixKey(DH(priv, pub)).
+func (hs *handshakeState) mixDH(priv, pub []byte) error {
+	shared, err := curve25519.X25519(priv, pub)
+	if err != nil {
+		return err
+	}
+	hs.ss.mixKey(shared)
+	return nil
+}
+
+// verifyProof kiểm tra payload của peer: chữ ký Ed25519 trên khóa tĩnh của họ
+// và (nếu có) public key phải nằm trong allow-list.
+func (hs *handshakeState) verifyProof(payload []byte) error {
+	if len(payload) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return errors.New("noise: invalid identity payload")
+	}
+	identity := ed25519.PublicKey(payload[:ed25519.PublicKeySize])
+	sig := payload[ed25519.PublicKeySize:]
+
+	if !ed25519.Verify(identity, append([]byte(noiseSignPrefix), hs.rs...), sig) {
+		return errors.New("noise: static key not signed by peer identity")
+	}
+
+	if len(hs.cfg.AllowedKeys) > 0 {
+		allowed := false
+		for _, key := range hs.cfg.AllowedKeys {
+			if bytes.Equal(key, identity) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrPeerNotAllowed
+		}
+	}
+
+	hs.remoteIdentity = append(ed25519.PublicKey{}, identity...)
+	return nil
+}
+
+// staticKeypair suy ra khóa tĩnh X25519 từ seed Ed25519 (giống cách Ed25519 suy ra scalar),
+// nên node chỉ cần lưu đúng 1 khóa bí mật.
+func staticKeypair(priv ed25519.PrivateKey) (keypair, error) {
+	h := sha512.Sum512(priv.Seed())
+	scalar := h[:32]
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+
+	pub, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		return keypair{}, err
+	}
+	return keypair{priv: scalar, pub: pub}, nil
+}
+
+// generateKeypair sinh khóa tạm thời X25519.
+func generateKeypair() (keypair, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, priv); err != nil {
+		return keypair{}, err
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return keypair{}, err
+	}
+	return keypair{priv: priv, pub: pub}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                      SYMMETRIC STATE / CIPHER STATE                        //
+////////////////////////////////////////////////////////////////////////////////
+
+// symmetricState theo mục 5.2 của đặc tả Noise.
+type symmetricState struct {
+	cs cipherState
+	ck [32]byte
+	h  [32]byte
+}
+
+func (ss *symmetricState) initialize(protocolName string) {
+	if len(protocolName) <= sha256.Size {
+		copy(ss.h[:], protocolName)
+	} else {
+		ss.h = sha256.Sum256([]byte(protocolName))
+	}
+	ss.ck = ss.h
+}
+
+func (ss *symmetricState) mixKey(ikm []byte) {
+	ck, k := hkdf2(ss.ck[:], ikm)
+	ss.ck = ck
+	ss.cs = cipherState{k: k, hasKey: true}
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(ss.h[:])
+	h.Write(data)
+	copy(ss.h[:], h.Sum(nil))
+}
+
+func (ss *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := plaintext
+	if ss.cs.hasKey {
+		// encrypt chỉ lỗi khi hết nonce – không thể xảy ra trong lúc bắt tay
+		ciphertext, _ = ss.cs.encrypt(ss.h[:], plaintext)
+	}
+	ss.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (ss *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext := ciphertext
+	if ss.cs.hasKey {
+		var err error
+		if plaintext, err = ss.cs.decrypt(ss.h[:], ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	ss.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split: sinh 2 cipherState cho 2 chiều sau khi bắt tay xong.
+func (ss *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf2(ss.ck[:], nil)
+	return &cipherState{k: k1, hasKey: true}, &cipherState{k: k2, hasKey: true}
+}
+
+// cipherState: khóa ChaCha20-Poly1305 + bộ đếm nonce.
+type cipherState struct {
+	k      [32]byte
+	n      uint64
+	hasKey bool
+}
+
+func (cs *cipherState) nonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], cs.n)
+	return nonce
+}
+
+func (cs *cipherState) encrypt(ad, plaintext []byte) ([]byte, error) {
+	if cs.n == ^uint64(0) {
+		return nil, errors.New("noise: nonce exhausted")
+	}
+	aead, err := chacha20poly1305.New(cs.k[:])
+	if err != nil {
+		return nil, err
+	}
+	out := aead.Seal(nil, cs.nonce(), plaintext, ad)
+	cs.n++
+	return out, nil
+}
+
+func (cs *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if cs.n == ^uint64(0) {
+		return nil, errors.New("noise: nonce exhausted")
+	}
+	aead, err := chacha20poly1305.New(cs.k[:])
+	if err != nil {
+		return nil, err
+	}
+	out, err := aead.Open(nil, cs.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("noise: decrypt failed: %w", err)
+	}
+	cs.n++
+	return out, nil
+}
+
+// hkdf2: HKDF của đặc tả Noise, trả về 2 output 32 byte.
+func hkdf2(chainingKey, ikm []byte) ([32]byte, [32]byte) {
+	var out1, out2 [32]byte
+
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{0x01})
+	copy(out1[:], mac.Sum(nil))
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{0x02})
+	copy(out2[:], mac.Sum(nil))
+
+	return out1, out2
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                                 FRAMING                                    //
+////////////////////////////////////////////////////////////////////////////////
+
+func writeNoiseFrame(w io.Writer, msg []byte) error {
+	if len(msg) > noiseMaxMsgLen {
+		return errors.New("noise: message too large")
+	}
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readNoiseFrame(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
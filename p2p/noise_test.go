@@ -0,0 +1,96 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noisePair bắt tay Noise trên 2 đầu của net.Pipe và trả về cả 2 phía (hoặc lỗi của mỗi phía).
+func noisePair(t *testing.T, client, server NoiseConfig) (*NoiseConn, *NoiseConn, error, error) {
+	c1, c2 := net.Pipe()
+
+	type result struct {
+		conn *NoiseConn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := NewNoiseConn(c2, server, false)
+		if err != nil {
+			c2.Close()
+		}
+		ch <- result{conn, err}
+	}()
+
+	conn, err := NewNoiseConn(c1, client, true)
+	if err != nil {
+		c1.Close()
+	}
+	res := <-ch
+	return conn, res.conn, err, res.err
+}
+
+func newNoiseKey(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return priv
+}
+
+// TestNoiseHandshake: 2 bên bắt tay XX, biết chính xác public key của nhau
+// và truyền được dữ liệu lớn hơn 1 frame theo cả 2 chiều.
+func TestNoiseHandshake(t *testing.T) {
+	clientKey, serverKey := newNoiseKey(t), newNoiseKey(t)
+
+	client, server, cerr, serr := noisePair(t,
+		NoiseConfig{PrivateKey: clientKey},
+		NoiseConfig{PrivateKey: serverKey, AllowedKeys: []ed25519.PublicKey{clientKey.Public().(ed25519.PublicKey)}},
+	)
+	assert.Nil(t, cerr)
+	assert.Nil(t, serr)
+	defer client.Close()
+	defer server.Close()
+
+	assert.Equal(t, serverKey.Public(), client.RemotePublicKey())
+	assert.Equal(t, clientKey.Public(), server.RemotePublicKey())
+
+	// TCPPeer đưa khóa đã xác thực ra cho ứng dụng; TCP trần thì không có khóa
+	assert.Equal(t, clientKey.Public(), NewTCPPeer(server, false).RemotePublicKey())
+	plain, _ := net.Pipe()
+	assert.Nil(t, NewTCPPeer(plain, false).RemotePublicKey())
+
+	payload := make([]byte, 3*noiseMaxPlaintext+123)
+	rand.Read(payload)
+
+	go func() {
+		client.Write(payload)
+	}()
+	got := make([]byte, len(payload))
+	_, err := io.ReadFull(server, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(payload, got))
+
+	go func() {
+		server.Write([]byte("pong"))
+	}()
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(client, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", string(reply))
+}
+
+// TestNoiseAllowList: peer không nằm trong allow-list bị từ chối.
+func TestNoiseAllowList(t *testing.T) {
+	stranger := newNoiseKey(t).Public().(ed25519.PublicKey)
+
+	_, _, _, serr := noisePair(t,
+		NoiseConfig{PrivateKey: newNoiseKey(t)},
+		NoiseConfig{PrivateKey: newNoiseKey(t), AllowedKeys: []ed25519.PublicKey{stranger}},
+	)
+	assert.Equal(t, ErrPeerNotAllowed, serr)
+}
@@ -0,0 +1,767 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Membership theo giao thức SWIM (gossip qua UDP):
+//   - Mỗi ProbeInterval, node ping 1 member (vòng tròn, thứ tự xáo trộn). Không có ack trong ProbeTimeout
+//     → nhờ IndirectChecks member khác ping hộ (ping-req). Vẫn im lặng → member bị nghi ngờ (suspect).
+//   - Suspect quá SuspicionTimeout mà không tự "cãi lại" (tăng incarnation) → bị coi là chết (dead).
+//   - Mọi thay đổi (alive/suspect/dead/left) được gắn kèm (piggyback) vào ping/ack gửi đi, mỗi thay đổi
+//     truyền lại ~RetransmitMult*log(n) lần → lan tới cả cụm mà không cần kết nối full-mesh.
+//   - Join/push-pull: trao đổi toàn bộ danh sách member với 1 node (lúc vào cụm và định kỳ).
+//
+// ⚠️ Gói UDP không được mã hóa/ký (khác kênh TCP dùng Noise) → chỉ dùng trong mạng tin cậy.
+
+// MemberStatus: trạng thái của 1 member theo góc nhìn của node này.
+type MemberStatus int
+
+const (
+	MemberAlive MemberStatus = iota
+	MemberSuspect
+	MemberDead // không trả lời probe (bị phát hiện hỏng)
+	MemberLeft // tự rời cụm (Leave)
+)
+
+func (s MemberStatus) String() string {
+	switch s {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	case MemberLeft:
+		return "left"
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// Member: 1 node trong cụm.
+//   - Name       : định danh duy nhất (ví dụ node ID).
+//   - Addr       : địa chỉ UDP để gossip.
+//   - Meta       : dữ liệu của ứng dụng (ví dụ địa chỉ TCP của transport).
+//   - Incarnation: chỉ chính member đó tăng (để bác bỏ tin đồn suspect/dead về mình).
+type Member struct {
+	Name        string
+	Addr        string
+	Meta        string
+	Status      MemberStatus
+	Incarnation uint64
+}
+
+// MemberEventType: loại thay đổi membership.
+type MemberEventType int
+
+const (
+	MemberJoined MemberEventType = iota
+	MemberSuspected
+	MemberRecovered // suspect → alive
+	MemberFailed
+	MemberDeparted // Leave
+)
+
+func (t MemberEventType) String() string {
+	return [...]string{"joined", "suspected", "recovered", "failed", "left"}[t]
+}
+
+// MemberEvent: thông báo thay đổi gửi cho SWIMOpts.OnEvent.
+type MemberEvent struct {
+	Type   MemberEventType
+	Member Member
+}
+
+// SWIMOpts: cấu hình SWIM. Các khoảng thời gian = 0 → dùng mặc định.
+type SWIMOpts struct {
+	Name          string // định danh node (bắt buộc, duy nhất trong cụm)
+	BindAddr      string // địa chỉ UDP lắng nghe, ví dụ ":3000" hoặc "127.0.0.1:0"
+	AdvertiseAddr string // địa chỉ UDP báo cho member khác (rỗng → địa chỉ đã bind, host rỗng → 127.0.0.1)
+	Meta          string // dữ liệu ứng dụng gửi kèm (ví dụ địa chỉ TCP)
+
+	ProbeInterval    time.Duration // chu kỳ probe (mặc định 1s)
+	ProbeTimeout     time.Duration // chờ ack trực tiếp (mặc định 500ms)
+	IndirectChecks   int           // số member ping hộ (mặc định 3)
+	SuspicionTimeout time.Duration // suspect → dead (mặc định 5 * ProbeInterval)
+	RetransmitMult   int           // hệ số số lần truyền lại 1 thay đổi (mặc định 4)
+	PushPullInterval time.Duration // chu kỳ đồng bộ toàn bộ danh sách với 1 member ngẫu nhiên (mặc định 30s, < 0 → tắt)
+
+	OnEvent func(MemberEvent) // (tùy chọn) callback khi membership thay đổi
+}
+
+// Loại gói SWIM.
+const (
+	swimPing byte = iota
+	swimAck
+	swimPingReq
+	swimSync      // gửi toàn bộ trạng thái, yêu cầu trả lời bằng swimSyncReply
+	swimSyncReply // trả lời swimSync (không trả lời lại)
+)
+
+const (
+	// swimMaxPacket: kích thước tối đa 1 gói UDP.
+	swimMaxPacket = 65507
+	// swimMaxPiggyback: số thay đổi tối đa gắn kèm 1 gói.
+	swimMaxPiggyback = 16
+	// swimSyncChunk: số member tối đa trong 1 gói sync.
+	swimSyncChunk = 64
+)
+
+// memberUpdate: 1 thay đổi được gossip (cũng là 1 dòng trong gói sync).
+type memberUpdate struct {
+	Name        string
+	Addr        string
+	Meta        string
+	Status      MemberStatus
+	Incarnation uint64
+}
+
+// swimPacket: nội dung 1 gói UDP (gob).
+//   - Seq        : số thứ tự ping; ack mang lại đúng Seq.
+//   - Target/Addr: member cần ping hộ (ping-req).
+//   - Updates    : thay đổi gắn kèm (hoặc toàn bộ trạng thái với gói sync).
+type swimPacket struct {
+	Type       byte
+	Seq        uint64
+	From       string
+	Target     string
+	TargetAddr string
+	Updates    []memberUpdate
+}
+
+// gossipItem: thay đổi đang chờ truyền lại.
+type gossipItem struct {
+	update    memberUpdate
+	transmits int
+}
+
+// indirectProbe: ping-req đang làm hộ → khi target ack thì chuyển ack (Seq gốc) về cho requester.
+type indirectProbe struct {
+	addr *net.UDPAddr
+	seq  uint64
+}
+
+// SWIM: membership của 1 node.
+type SWIM struct {
+	SWIMOpts
+
+	conn *net.UDPConn
+
+	mu          sync.Mutex
+	members     map[string]*Member
+	incarnation uint64
+	gossip      []*gossipItem
+	suspicions  map[string]*time.Timer
+	probeOrder  []string
+	probeIndex  int
+
+	ackMu    sync.Mutex
+	seq      uint64
+	acks     map[uint64]chan struct{}
+	forwards map[uint64]indirectProbe
+
+	quitch chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewSWIM bind cổng UDP và khởi tạo membership chỉ gồm chính node này (chưa vào cụm – xem Join).
+func NewSWIM(opts SWIMOpts) (*SWIM, error) {
+	if len(opts.Name) == 0 {
+		return nil, errors.New("swim: missing node name")
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = time.Second
+	}
+	if opts.ProbeTimeout <= 0 || opts.ProbeTimeout >= opts.ProbeInterval {
+		opts.ProbeTimeout = opts.ProbeInterval / 2
+	}
+	if opts.IndirectChecks <= 0 {
+		opts.IndirectChecks = 3
+	}
+	if opts.SuspicionTimeout <= 0 {
+		opts.SuspicionTimeout = 5 * opts.ProbeInterval
+	}
+	if opts.RetransmitMult <= 0 {
+		opts.RetransmitMult = 4
+	}
+	if opts.PushPullInterval == 0 {
+		opts.PushPullInterval = 30 * time.Second
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", opts.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.AdvertiseAddr) == 0 {
+		bound := conn.LocalAddr().(*net.UDPAddr)
+		host := bound.IP.String()
+		if bound.IP.IsUnspecified() {
+			host = "127.0.0.1"
+		}
+		opts.AdvertiseAddr = net.JoinHostPort(host, fmt.Sprint(bound.Port))
+	}
+
+	s := &SWIM{
+		SWIMOpts:   opts,
+		conn:       conn,
+		members:    make(map[string]*Member),
+		suspicions: make(map[string]*time.Timer),
+		acks:       make(map[uint64]chan struct{}),
+		forwards:   make(map[uint64]indirectProbe),
+		quitch:     make(chan struct{}),
+	}
+	s.members[opts.Name] = &Member{Name: opts.Name, Addr: opts.AdvertiseAddr, Meta: opts.Meta, Status: MemberAlive}
+
+	s.wg.Add(2)
+	go s.readLoop()
+	go s.probeLoop()
+	return s, nil
+}
+
+// Addr: địa chỉ UDP member khác dùng để liên lạc với node này.
+func (s *SWIM) Addr() string {
+	return s.AdvertiseAddr
+}
+
+// Members: danh sách member (kể cả chính node này và member đã chết/rời cụm), sắp xếp theo Name.
+func (s *SWIM) Members() []Member {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := make([]Member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// Member: trạng thái hiện tại của member name.
+func (s *SWIM) Member(name string) (Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.members[name]
+	if !ok {
+		return Member{}, false
+	}
+	return *m, true
+}
+
+// Join vào cụm qua các seed (địa chỉ UDP): trao đổi toàn bộ danh sách member với từng seed.
+// Thành công nếu ít nhất 1 seed gửi được.
+func (s *SWIM) Join(seeds ...string) error {
+	var lastErr error
+	joined := 0
+	for _, seed := range seeds {
+		if len(seed) == 0 || seed == s.AdvertiseAddr {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", seed)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := s.sendState(addr, swimSync); err != nil {
+			lastErr = err
+			continue
+		}
+		joined++
+	}
+	if joined == 0 && lastErr != nil {
+		return fmt.Errorf("swim: joining cluster: %w", lastErr)
+	}
+	return nil
+}
+
+// Leave báo cho cụm là node này chủ động rời đi (member khác ghi nhận MemberLeft thay vì MemberDead)
+// rồi dừng probe. Vẫn cần Close để giải phóng cổng.
+func (s *SWIM) Leave() error {
+	s.mu.Lock()
+	s.incarnation++
+	self := s.members[s.Name]
+	self.Status, self.Incarnation = MemberLeft, s.incarnation
+	update := toUpdate(self)
+	s.queueLocked(update)
+
+	var targets []*net.UDPAddr
+	for _, m := range s.members {
+		if m.Name != s.Name && (m.Status == MemberAlive || m.Status == MemberSuspect) {
+			if addr, err := net.ResolveUDPAddr("udp", m.Addr); err == nil {
+				targets = append(targets, addr)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	// Gửi thẳng cho mọi member còn sống thay vì chờ gossip (node sắp dừng)
+	var lastErr error
+	for _, addr := range targets {
+		if err := s.send(addr, &swimPacket{Type: swimSyncReply, From: s.Name, Updates: []memberUpdate{update}}); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Close dừng SWIM và đóng cổng UDP (không báo cho cụm – member khác sẽ phát hiện node chết).
+func (s *SWIM) Close() error {
+	select {
+	case <-s.quitch:
+		return nil
+	default:
+	}
+	close(s.quitch)
+	err := s.conn.Close()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	for _, timer := range s.suspicions {
+		timer.Stop()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                                  PROBE                                     //
+////////////////////////////////////////////////////////////////////////////////
+
+// probeLoop: mỗi ProbeInterval probe 1 member; định kỳ push-pull với 1 member ngẫu nhiên.
+func (s *SWIM) probeLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.ProbeInterval)
+	defer ticker.Stop()
+	var pushPull <-chan time.Time
+	if s.PushPullInterval > 0 {
+		t := time.NewTicker(s.PushPullInterval)
+		defer t.Stop()
+		pushPull = t.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if target, ok := s.nextProbeTarget(); ok {
+				s.probe(target)
+			}
+		case <-pushPull:
+			if target, ok := s.randomMembers(1, ""); ok {
+				if addr, err := net.ResolveUDPAddr("udp", target[0].Addr); err == nil {
+					s.sendState(addr, swimSync)
+				}
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// nextProbeTarget chọn member kế tiếp theo vòng tròn (xáo trộn lại sau mỗi vòng).
+func (s *SWIM) nextProbeTarget() (Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if self := s.members[s.Name]; self.Status == MemberLeft {
+		return Member{}, false
+	}
+	for tries := 0; tries < 2; tries++ {
+		for s.probeIndex < len(s.probeOrder) {
+			m, ok := s.members[s.probeOrder[s.probeIndex]]
+			s.probeIndex++
+			if ok && (m.Status == MemberAlive || m.Status == MemberSuspect) {
+				return *m, true
+			}
+		}
+		s.probeOrder, s.probeIndex = s.probeOrder[:0], 0
+		for name := range s.members {
+			if name != s.Name {
+				s.probeOrder = append(s.probeOrder, name)
+			}
+		}
+		rand.Shuffle(len(s.probeOrder), func(i, j int) {
+			s.probeOrder[i], s.probeOrder[j] = s.probeOrder[j], s.probeOrder[i]
+		})
+	}
+	return Member{}, false
+}
+
+// randomMembers chọn tối đa n member còn sống ngẫu nhiên (trừ chính mình và exclude).
+func (s *SWIM) randomMembers(n int, exclude string) ([]Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var candidates []Member
+	for _, m := range s.members {
+		if m.Name != s.Name && m.Name != exclude && m.Status == MemberAlive {
+			candidates = append(candidates, *m)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates, len(candidates) > 0
+}
+
+// probe: ping trực tiếp → ping-req qua member khác → suspect nếu vẫn không có ack.
+func (s *SWIM) probe(target Member) {
+	addr, err := net.ResolveUDPAddr("udp", target.Addr)
+	if err != nil {
+		log.Printf("swim: resolving %s: %s", target.Addr, err)
+		return
+	}
+	seq, ackch := s.expectAck()
+	defer s.forgetAck(seq)
+
+	deadline := time.Now().Add(s.ProbeInterval)
+	if err := s.send(addr, &swimPacket{Type: swimPing, Seq: seq, From: s.Name}); err == nil {
+		select {
+		case <-ackch:
+			return
+		case <-time.After(s.ProbeTimeout):
+		case <-s.quitch:
+			return
+		}
+	}
+
+	// Không có ack trực tiếp → nhờ member khác ping hộ (tránh nghi oan do đường mạng giữa 2 node)
+	helpers, _ := s.randomMembers(s.IndirectChecks, target.Name)
+	for _, helper := range helpers {
+		if haddr, err := net.ResolveUDPAddr("udp", helper.Addr); err == nil {
+			s.send(haddr, &swimPacket{Type: swimPingReq, Seq: seq, From: s.Name, Target: target.Name, TargetAddr: target.Addr})
+		}
+	}
+	select {
+	case <-ackch:
+		return
+	case <-time.After(time.Until(deadline)):
+	case <-s.quitch:
+		return
+	}
+	s.suspect(target.Name, target.Incarnation)
+}
+
+// expectAck cấp Seq mới và kênh nhận ack tương ứng.
+func (s *SWIM) expectAck() (uint64, chan struct{}) {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+
+	s.seq++
+	ch := make(chan struct{}, 1)
+	s.acks[s.seq] = ch
+	return s.seq, ch
+}
+
+func (s *SWIM) forgetAck(seq uint64) {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+	delete(s.acks, seq)
+	delete(s.forwards, seq)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                              NHẬN GÓI UDP                                  //
+////////////////////////////////////////////////////////////////////////////////
+
+func (s *SWIM) readLoop() {
+	defer s.wg.Done()
+
+	buf := make([]byte, swimMaxPacket)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("swim: reading packet: %s", err)
+			continue
+		}
+		var pkt swimPacket
+		if err := gob.NewDecoder(bytes.NewReader(buf[:n])).Decode(&pkt); err != nil {
+			log.Printf("swim: decoding packet from %s: %s", from, err)
+			continue
+		}
+		s.handlePacket(from, &pkt)
+	}
+}
+
+func (s *SWIM) handlePacket(from *net.UDPAddr, pkt *swimPacket) {
+	for _, u := range pkt.Updates {
+		s.apply(u)
+	}
+
+	// Gói từ member chưa biết (tin gossip về nó đã hết lượt truyền trước khi node này vào cụm)
+	// → đồng bộ toàn bộ trạng thái với nó
+	if pkt.Type != swimSync && pkt.Type != swimSyncReply {
+		if _, ok := s.Member(pkt.From); !ok {
+			s.sendState(from, swimSync)
+		}
+	}
+
+	switch pkt.Type {
+	case swimPing:
+		s.send(from, &swimPacket{Type: swimAck, Seq: pkt.Seq, From: s.Name})
+
+	case swimAck:
+		s.ackMu.Lock()
+		ch, waiting := s.acks[pkt.Seq]
+		forward, forwarding := s.forwards[pkt.Seq]
+		s.ackMu.Unlock()
+		if waiting {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		if forwarding {
+			s.send(forward.addr, &swimPacket{Type: swimAck, Seq: forward.seq, From: s.Name})
+		}
+
+	case swimPingReq:
+		target, err := net.ResolveUDPAddr("udp", pkt.TargetAddr)
+		if err != nil {
+			return
+		}
+		seq, _ := s.expectAck()
+		s.ackMu.Lock()
+		s.forwards[seq] = indirectProbe{addr: from, seq: pkt.Seq}
+		s.ackMu.Unlock()
+		time.AfterFunc(s.ProbeInterval, func() { s.forgetAck(seq) })
+		s.send(target, &swimPacket{Type: swimPing, Seq: seq, From: s.Name})
+
+	case swimSync:
+		s.sendState(from, swimSyncReply)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                        CẬP NHẬT TRẠNG THÁI MEMBER                           //
+////////////////////////////////////////////////////////////////////////////////
+
+func toUpdate(m *Member) memberUpdate {
+	return memberUpdate{Name: m.Name, Addr: m.Addr, Meta: m.Meta, Status: m.Status, Incarnation: m.Incarnation}
+}
+
+// apply áp 1 thay đổi nhận được. Luật SWIM:
+//   - alive thắng khi incarnation lớn hơn (member mới luôn được nhận);
+//   - suspect thắng alive cùng incarnation; dead/left thắng mọi trạng thái cùng incarnation;
+//   - tin đồn suspect/dead về chính mình → tăng incarnation và phát alive để bác bỏ.
+//
+// Thay đổi được chấp nhận sẽ được gossip tiếp.
+func (s *SWIM) apply(u memberUpdate) {
+	s.mu.Lock()
+	event, changed := s.applyLocked(u)
+	s.mu.Unlock()
+
+	if changed && s.OnEvent != nil {
+		s.OnEvent(event)
+	}
+}
+
+func (s *SWIM) applyLocked(u memberUpdate) (MemberEvent, bool) {
+	if u.Name == s.Name {
+		self := s.members[s.Name]
+		if self.Status != MemberLeft && u.Status != MemberAlive && u.Incarnation >= s.incarnation {
+			s.incarnation = u.Incarnation + 1
+			self.Incarnation = s.incarnation
+			s.queueLocked(toUpdate(self))
+		}
+		return MemberEvent{}, false
+	}
+
+	m, known := s.members[u.Name]
+	if !known {
+		if u.Status != MemberAlive && u.Status != MemberSuspect {
+			// Chưa từng biết member này → chỉ ghi nhận để không nhận nhầm bản alive cũ hơn sau đó
+			s.members[u.Name] = &Member{Name: u.Name, Addr: u.Addr, Meta: u.Meta, Status: u.Status, Incarnation: u.Incarnation}
+			s.queueLocked(u)
+			return MemberEvent{}, false
+		}
+		m = &Member{Name: u.Name, Addr: u.Addr, Meta: u.Meta, Status: u.Status, Incarnation: u.Incarnation}
+		s.members[u.Name] = m
+		s.queueLocked(u)
+		if u.Status == MemberSuspect {
+			s.startSuspicionLocked(m)
+		}
+		return MemberEvent{Type: MemberJoined, Member: *m}, true
+	}
+
+	accept := false
+	switch u.Status {
+	case MemberAlive:
+		accept = u.Incarnation > m.Incarnation
+	case MemberSuspect:
+		accept = u.Incarnation > m.Incarnation || (u.Incarnation == m.Incarnation && m.Status == MemberAlive)
+	case MemberDead, MemberLeft:
+		accept = u.Incarnation > m.Incarnation || (u.Incarnation == m.Incarnation && m.Status != MemberDead && m.Status != MemberLeft)
+	}
+	if !accept {
+		return MemberEvent{}, false
+	}
+
+	prev := m.Status
+	m.Addr, m.Meta, m.Status, m.Incarnation = u.Addr, u.Meta, u.Status, u.Incarnation
+	s.queueLocked(u)
+	if u.Status != MemberSuspect {
+		s.stopSuspicionLocked(u.Name)
+	}
+
+	var event MemberEventType
+	switch {
+	case u.Status == MemberAlive && prev == MemberSuspect:
+		event = MemberRecovered
+	case u.Status == MemberAlive && prev == MemberAlive:
+		return MemberEvent{}, false // chỉ tăng incarnation
+	case u.Status == MemberAlive:
+		event = MemberJoined // quay lại sau khi dead/left
+	case u.Status == MemberSuspect:
+		s.startSuspicionLocked(m)
+		if prev == MemberSuspect {
+			return MemberEvent{}, false
+		}
+		event = MemberSuspected
+	case u.Status == MemberDead:
+		event = MemberFailed
+	default:
+		event = MemberDeparted
+	}
+	return MemberEvent{Type: event, Member: *m}, true
+}
+
+// suspect: probe thất bại → đánh dấu (và gossip) member là suspect.
+func (s *SWIM) suspect(name string, incarnation uint64) {
+	s.mu.Lock()
+	m, ok := s.members[name]
+	if !ok || m.Status != MemberAlive || m.Incarnation != incarnation {
+		s.mu.Unlock()
+		return
+	}
+	u := toUpdate(m)
+	u.Status = MemberSuspect
+	s.mu.Unlock()
+
+	s.apply(u)
+}
+
+// startSuspicionLocked: hết SuspicionTimeout mà member vẫn suspect (cùng incarnation) → dead.
+func (s *SWIM) startSuspicionLocked(m *Member) {
+	s.stopSuspicionLocked(m.Name)
+	name, incarnation := m.Name, m.Incarnation
+	s.suspicions[name] = time.AfterFunc(s.SuspicionTimeout, func() {
+		s.mu.Lock()
+		m, ok := s.members[name]
+		if !ok || m.Status != MemberSuspect || m.Incarnation != incarnation {
+			s.mu.Unlock()
+			return
+		}
+		u := toUpdate(m)
+		u.Status = MemberDead
+		s.mu.Unlock()
+
+		s.apply(u)
+	})
+}
+
+func (s *SWIM) stopSuspicionLocked(name string) {
+	if timer, ok := s.suspicions[name]; ok {
+		timer.Stop()
+		delete(s.suspicions, name)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                         GOSSIP (PIGGYBACK) & GỬI GÓI                        //
+////////////////////////////////////////////////////////////////////////////////
+
+// queueLocked đưa thay đổi vào hàng đợi gossip (thay thế thay đổi cũ hơn về cùng member).
+func (s *SWIM) queueLocked(u memberUpdate) {
+	for i, item := range s.gossip {
+		if item.update.Name == u.Name {
+			s.gossip = append(s.gossip[:i], s.gossip[i+1:]...)
+			break
+		}
+	}
+	s.gossip = append(s.gossip, &gossipItem{update: u})
+}
+
+// retransmitLimitLocked: số lần truyền lại mỗi thay đổi = RetransmitMult * ceil(log10(n+1)).
+func (s *SWIM) retransmitLimitLocked() int {
+	return s.RetransmitMult * int(math.Ceil(math.Log10(float64(len(s.members)+1))))
+}
+
+// piggyback lấy tối đa swimMaxPiggyback thay đổi (ít được truyền nhất trước) để gắn vào gói sắp gửi.
+func (s *SWIM) piggyback() []memberUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.SliceStable(s.gossip, func(i, j int) bool { return s.gossip[i].transmits < s.gossip[j].transmits })
+	limit := s.retransmitLimitLocked()
+	var updates []memberUpdate
+	kept := s.gossip[:0]
+	for _, item := range s.gossip {
+		if len(updates) < swimMaxPiggyback {
+			updates = append(updates, item.update)
+			item.transmits++
+		}
+		if item.transmits < limit {
+			kept = append(kept, item)
+		}
+	}
+	s.gossip = kept
+	return updates
+}
+
+// send gửi gói tới addr; ping/ack/ping-req được gắn kèm thay đổi đang chờ gossip.
+func (s *SWIM) send(addr *net.UDPAddr, pkt *swimPacket) error {
+	if pkt.Type == swimPing || pkt.Type == swimAck || pkt.Type == swimPingReq {
+		pkt.Updates = s.piggyback()
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(pkt); err != nil {
+		return err
+	}
+	if buf.Len() > swimMaxPacket {
+		return fmt.Errorf("swim: packet too large (%d bytes)", buf.Len())
+	}
+	_, err := s.conn.WriteToUDP(buf.Bytes(), addr)
+	return err
+}
+
+// sendState gửi toàn bộ danh sách member (chia thành nhiều gói) với loại typ (sync / sync reply).
+// Chỉ gói đầu mang typ → bên nhận trả lời sync đúng 1 lần.
+func (s *SWIM) sendState(addr *net.UDPAddr, typ byte) error {
+	s.mu.Lock()
+	state := make([]memberUpdate, 0, len(s.members))
+	for _, m := range s.members {
+		state = append(state, toUpdate(m))
+	}
+	s.mu.Unlock()
+
+	for len(state) > 0 {
+		n := len(state)
+		if n > swimSyncChunk {
+			n = swimSyncChunk
+		}
+		if err := s.send(addr, &swimPacket{Type: typ, From: s.Name, Updates: state[:n]}); err != nil {
+			return err
+		}
+		state, typ = state[n:], swimSyncReply
+	}
+	return nil
+}
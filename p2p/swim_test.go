@@ -0,0 +1,98 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSWIM tạo 1 node SWIM trên loopback với chu kỳ ngắn (không push-pull → chỉ dựa vào gossip).
+func newTestSWIM(t *testing.T, name string, onEvent func(MemberEvent)) *SWIM {
+	s, err := NewSWIM(SWIMOpts{
+		Name:             name,
+		BindAddr:         "127.0.0.1:0",
+		Meta:             "tcp-" + name,
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		SuspicionTimeout: 250 * time.Millisecond,
+		PushPullInterval: -1,
+		OnEvent:          onEvent,
+	})
+	require.Nil(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// statuses: trạng thái mọi member theo góc nhìn của s.
+func statuses(s *SWIM) map[string]MemberStatus {
+	out := make(map[string]MemberStatus)
+	for _, m := range s.Members() {
+		out[m.Name] = m.Status
+	}
+	return out
+}
+
+// TestSWIMMembership: node chỉ biết 1 hàng xóm vẫn hội tụ về đủ danh sách member;
+// node chết bị phát hiện (dead), node chủ động rời được ghi nhận (left).
+func TestSWIMMembership(t *testing.T) {
+	var nodes []*SWIM
+	for i := 0; i < 5; i++ {
+		s := newTestSWIM(t, fmt.Sprintf("node-%d", i), nil)
+		if i > 0 {
+			// Chuỗi: mỗi node chỉ join qua node ngay trước nó
+			require.Nil(t, s.Join(nodes[i-1].Addr()))
+		}
+		nodes = append(nodes, s)
+	}
+
+	allAlive := map[string]MemberStatus{}
+	for _, s := range nodes {
+		allAlive[s.Name] = MemberAlive
+	}
+	for _, s := range nodes {
+		assert.Eventually(t, func() bool { return fmt.Sprint(statuses(s)) == fmt.Sprint(allAlive) },
+			5*time.Second, 20*time.Millisecond, "%s did not converge", s.Name)
+	}
+	m, ok := nodes[0].Member("node-4")
+	assert.True(t, ok)
+	assert.Equal(t, "tcp-node-4", m.Meta)
+
+	// node-4 chết (không báo) → dead; node-3 rời cụm → left
+	nodes[4].Close()
+	require.Nil(t, nodes[3].Leave())
+	for _, s := range nodes[:3] {
+		assert.Eventually(t, func() bool {
+			st := statuses(s)
+			return st["node-4"] == MemberDead && st["node-3"] == MemberLeft
+		}, 5*time.Second, 20*time.Millisecond, "%s did not notice node-3/node-4", s.Name)
+	}
+}
+
+// TestSWIMRefute: tin đồn suspect về chính mình bị bác bỏ bằng incarnation mới; member khác nhận lại alive.
+func TestSWIMRefute(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []MemberEventType
+	)
+	a := newTestSWIM(t, "a", nil)
+	b := newTestSWIM(t, "b", func(e MemberEvent) {
+		mu.Lock()
+		events = append(events, e.Type)
+		mu.Unlock()
+	})
+	require.Nil(t, b.Join(a.Addr()))
+	assert.Eventually(t, func() bool { return len(a.Members()) == 2 && len(b.Members()) == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	b.suspect("a", 0)
+	assert.Eventually(t, func() bool {
+		m, _ := b.Member("a")
+		return m.Status == MemberAlive && m.Incarnation > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []MemberEventType{MemberJoined, MemberSuspected, MemberRecovered}, events)
+}
@@ -1,6 +1,7 @@
 package p2p
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"log"
@@ -17,23 +18,81 @@ type TCPPeer struct {
 	// outbound = false nếu mình là bên được Accept() (nghe và nhận kết nối)
 	outbound bool
 
-	// WaitGroup để đồng bộ khi xử lý stream (dữ liệu liên tục).
-	wg *sync.WaitGroup
+	// streamDone: CloseStream báo stream (dữ liệu liên tục) đã được đọc xong → read loop chạy tiếp.
+	streamDone chan struct{}
+	// streamStarted: đóng khi read loop đã đọc byte cờ stream và nhường kết nối (mở lại ở CloseStream).
+	streamStarted chan struct{}
+	streamMu      sync.Mutex
+	// closed: đóng khi Close được gọi → read loop đang chờ stream thoát luôn thay vì treo mãi.
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 // Hàm tạo TCPPeer mới
 func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
 	return &TCPPeer{
-		Conn:     conn,
-		outbound: outbound,
-		wg:       &sync.WaitGroup{},
+		Conn:          conn,
+		outbound:      outbound,
+		streamDone:    make(chan struct{}, 1),
+		streamStarted: make(chan struct{}),
+		closed:        make(chan struct{}),
 	}
 }
 
-// CloseStream báo hiệu stream đã kết thúc
-// (giảm counter của WaitGroup → cho phép read loop chạy tiếp).
+// Read đọc dữ liệu stream. Chỉ đọc sau khi read loop đã gặp byte cờ stream: đọc sớm hơn thì ứng dụng và
+// read loop tranh nhau byte trên cùng kết nối (ứng dụng có thể "nuốt" luôn byte cờ rồi treo).
+func (p *TCPPeer) Read(b []byte) (int, error) {
+	p.streamMu.Lock()
+	started := p.streamStarted
+	p.streamMu.Unlock()
+	select {
+	case <-started:
+	case <-p.closed:
+		return 0, net.ErrClosed
+	}
+	return p.Conn.Read(b)
+}
+
+// startStream: read loop đã đọc byte cờ → Read được phép đọc kết nối.
+func (p *TCPPeer) startStream() {
+	p.streamMu.Lock()
+	defer p.streamMu.Unlock()
+	select {
+	case <-p.streamStarted: // CloseStream thừa từ trước chưa mở lại
+	default:
+		close(p.streamStarted)
+	}
+}
+
+// CloseStream báo hiệu stream đã kết thúc (cho phép read loop chạy tiếp).
 func (p *TCPPeer) CloseStream() {
-	p.wg.Done()
+	p.streamMu.Lock()
+	select {
+	case <-p.streamStarted:
+		p.streamStarted = make(chan struct{})
+	default:
+	}
+	p.streamMu.Unlock()
+
+	select {
+	case p.streamDone <- struct{}{}:
+	default:
+	}
+}
+
+// Close đóng kết nối; read loop đang chờ 1 stream không ai đọc cũng được giải phóng.
+func (p *TCPPeer) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return p.Conn.Close()
+}
+
+// RemotePublicKey: khóa danh tính của peer nếu kết nối đã được nâng cấp lên kênh xác thực
+// (ví dụ NoiseConn), nil nếu là TCP trần.
+func (p *TCPPeer) RemotePublicKey() ed25519.PublicKey {
+	if c, ok := p.Conn.(interface{ RemotePublicKey() ed25519.PublicKey }); ok {
+		return c.RemotePublicKey()
+	}
+	return nil
 }
 
 // Send gửi dữ liệu ra TCP connection
@@ -48,8 +107,10 @@ func (p *TCPPeer) Send(b []byte) error {
 type TCPTransportOpts struct {
 	ListenAddr    string           // địa chỉ để listen (ví dụ ":3000")
 	HandshakeFunc HandshakeFunc    // hàm bắt tay khi peer kết nối
+	UpgradeFunc   UpgradeFunc      // (tùy chọn) bọc kết nối trước khi handshake, ví dụ NoiseUpgradeFunc
 	Decoder       Decoder          // bộ giải mã bytes → RPC
 	OnPeer        func(Peer) error // callback khi có peer mới
+	OnPeerClose   func(Peer)       // (tùy chọn) callback khi kết nối tới peer (đã qua OnPeer) bị đóng
 }
 
 // -----------------------------
@@ -81,6 +142,9 @@ func (t *TCPTransport) Consume() <-chan RPC {
 
 // Close: đóng listener (ngừng nhận kết nối mới)
 func (t *TCPTransport) Close() error {
+	if t.listener == nil {
+		return nil // chưa ListenAndAccept
+	}
 	return t.listener.Close()
 }
 
@@ -141,6 +205,15 @@ func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 		conn.Close()
 	}()
 
+	// Bước 0: nâng cấp kết nối (ví dụ mã hóa Noise) nếu có cấu hình
+	if t.UpgradeFunc != nil {
+		var upgraded net.Conn
+		if upgraded, err = t.UpgradeFunc(conn, outbound); err != nil {
+			return
+		}
+		conn = upgraded
+	}
+
 	// Tạo peer mới
 	peer := NewTCPPeer(conn, outbound)
 
@@ -155,6 +228,9 @@ func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 			return
 		}
 	}
+	if t.OnPeerClose != nil {
+		defer t.OnPeerClose(peer)
+	}
 
 	// Bước 3: Read loop – đọc RPC liên tục
 	for {
@@ -171,10 +247,15 @@ func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 
 		// Nếu là stream:
 		if rpc.Stream {
-			peer.wg.Add(1)
 			fmt.Printf("[%s] incoming stream, waiting...\n", conn.RemoteAddr())
-			// chờ đến khi CloseStream() được gọi
-			peer.wg.Wait()
+			peer.startStream()
+			// chờ đến khi CloseStream() được gọi (hoặc kết nối bị đóng)
+			select {
+			case <-peer.streamDone:
+			case <-peer.closed:
+				err = net.ErrClosed
+				return
+			}
 			fmt.Printf("[%s] stream closed, resuming read loop\n", conn.RemoteAddr())
 			continue
 		}
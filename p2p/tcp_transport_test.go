@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,3 +27,33 @@ func TestTCPTransport(t *testing.T) {
 	// assert.Nil kiểm tra kết quả trả về là nil (tức là không có lỗi)
 	assert.Nil(t, tr.ListenAndAccept())
 }
+
+// TestTCPTransportOnPeerClose: khi 1 bên đóng kết nối, bên kia được báo qua OnPeerClose.
+func TestTCPTransportOnPeerClose(t *testing.T) {
+	closed := make(chan Peer, 1)
+	server := NewTCPTransport(TCPTransportOpts{
+		ListenAddr:    "127.0.0.1:0",
+		HandshakeFunc: NOPHandshakeFunc,
+		Decoder:       DefaultDecoder{},
+		OnPeerClose:   func(p Peer) { closed <- p },
+	})
+	assert.Nil(t, server.ListenAndAccept())
+	defer server.Close()
+
+	peers := make(chan Peer, 1)
+	client := NewTCPTransport(TCPTransportOpts{
+		HandshakeFunc: NOPHandshakeFunc,
+		Decoder:       DefaultDecoder{},
+		OnPeer:        func(p Peer) error { peers <- p; return nil },
+	})
+	assert.Nil(t, client.Dial(server.listener.Addr().String()))
+
+	p := <-peers
+	p.Close()
+	select {
+	case remote := <-closed:
+		assert.Equal(t, p.LocalAddr().String(), remote.RemoteAddr().String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPeerClose was not called")
+	}
+}
@@ -1,17 +1,22 @@
 package p2p
 
-import "net"
+import (
+	"crypto/ed25519"
+	"net"
+)
 
 // Peer là giao diện đại diện cho "một nút từ xa" (remote node) đang kết nối với chúng ta.
 // Lưu ý: nó "nhúng" (embed) luôn net.Conn, nên mọi phương thức của net.Conn đều dùng được:
 //   - Read, Write, Close, LocalAddr, RemoteAddr, SetDeadline, ...
-// Bên cạnh đó, Peer bổ sung 2 hàm tiện ích cho P2P:
+// Bên cạnh đó, Peer bổ sung các hàm tiện ích cho P2P:
 //   - Send([]byte) error  : gửi dữ liệu thô ra kết nối một cách thống nhất
 //   - CloseStream()       : thông báo kết thúc một luồng (stream) dài đang mở
+//   - RemotePublicKey()   : khóa danh tính của peer đã được kênh mã hóa (Noise) xác thực
 type Peer interface {
-	net.Conn           // kế thừa toàn bộ API của kết nối TCP/UDP/... từ Go
-	Send([]byte) error // gửi dữ liệu tới peer
-	CloseStream()      // báo hiệu "đóng stream" (phục vụ cơ chế stream-control)
+	net.Conn                            // kế thừa toàn bộ API của kết nối TCP/UDP/... từ Go
+	Send([]byte) error                  // gửi dữ liệu tới peer
+	CloseStream()                       // báo hiệu "đóng stream" (phục vụ cơ chế stream-control)
+	RemotePublicKey() ed25519.PublicKey // nil nếu kết nối không qua kênh xác thực
 }
 
 // Transport là giao diện trừu tượng hóa "lớp giao tiếp mạng" giữa các node.
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                   PACKED BACKEND (1 FILE, APPEND-ONLY LOG)                  //
+////////////////////////////////////////////////////////////////////////////////
+
+// Mỗi record trong file pack:
+//
+//	[1B op 'P'|'D'][uint16 độ dài path][path][int64 mod time (unix nano)][uint64 độ dài data][data][uint32 CRC-32 của data]
+//
+// Số nguyên đều là little-endian. Lúc đang ghi, độ dài data = packPending và chỉ được sửa lại
+// thành độ dài thật khi đã ghi xong data + CRC → record dở dang (crash) luôn bị nhận ra khi mở lại.
+const (
+	packOpPut    = 'P'
+	packOpDelete = 'D'
+	packPending  = math.MaxUint64
+	maxPackPath  = math.MaxUint16
+)
+
+// errPackChecksum: record đầy đủ (độ dài đã biết) nhưng data không khớp CRC – bit hỏng giữa log,
+// không phải đuôi ghi dở.
+var errPackChecksum = errors.New("checksum mismatch")
+
+// PackedBackend gom mọi object vào 1 file log duy nhất – hợp với rất nhiều object nhỏ
+// (không tốn inode/thư mục như FSBackend). Vị trí của từng object được giữ trong RAM
+// và dựng lại bằng cách đọc log khi mở.
+//
+// Ghi đè/xóa chỉ append record mới, dung lượng cũ chưa được thu hồi.
+// Put giữ lock ghi trong suốt quá trình stream → các lần ghi được tuần tự hóa.
+type PackedBackend struct {
+	path string
+
+	wmu  sync.Mutex // chỉ 1 writer append tại 1 thời điểm
+	f    *os.File
+	size int64 // độ dài phần log hợp lệ
+
+	mu      sync.RWMutex // bảo vệ entries
+	entries map[string]packEntry
+}
+
+// packEntry: vị trí data của 1 object trong file pack.
+type packEntry struct {
+	offset  int64
+	size    int64
+	modTime time.Time
+}
+
+// NewPackedBackend mở (hoặc tạo) file pack ở path. Đuôi log bị cắt cụt do crash được cắt bỏ.
+func NewPackedBackend(path string) (*PackedBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &PackedBackend{path: path, f: f, entries: make(map[string]packEntry)}
+	if err := b.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// load đọc lại toàn bộ log để dựng bảng vị trí.
+func (b *PackedBackend) load() error {
+	size, err := scanPackFile(b.f, func(op byte, path string, entry packEntry, n int64) {
+		if op == packOpPut {
+			b.entries[path] = entry
+		} else {
+			delete(b.entries, path)
+		}
+	})
+	b.size = size
+	return err
+}
+
+// scanPackFile đọc lần lượt các record hợp lệ trong f và trả về độ dài phần hợp lệ.
+// Chỉ đuôi ghi dở (crash giữa lúc ghi: đọc thiếu, header chưa đủ, độ dài còn packPending) bị cắt bỏ.
+// Record đầy đủ mà sai CRC được bỏ qua theo độ dài của nó (ghi log) – các record sau vẫn được đọc.
+func scanPackFile(f *os.File, fn func(op byte, path string, entry packEntry, n int64)) (int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, 0, math.MaxInt64))
+	var offset int64
+	for {
+		op, path, entry, n, err := readPackRecord(r, offset)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if errors.Is(err, errPackChecksum) {
+			log.Printf("skipping corrupt record for %q at offset %d in %s (%d bytes)", path, offset, f.Name(), n)
+			offset += n
+			continue
+		}
+		if err != nil {
+			log.Printf("truncating %s at offset %d: %s", f.Name(), offset, err)
+			return offset, f.Truncate(offset)
+		}
+		fn(op, path, entry, n)
+		offset += n
+	}
+}
+
+// readPackRecord đọc 1 record bắt đầu ở offset. n: tổng số byte của record.
+func readPackRecord(r io.Reader, offset int64) (op byte, path string, entry packEntry, n int64, err error) {
+	var head [3]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		if err != io.EOF {
+			err = errors.New("truncated record header")
+		}
+		return
+	}
+	op = head[0]
+	if op != packOpPut && op != packOpDelete {
+		err = fmt.Errorf("unknown record type %q", op)
+		return
+	}
+
+	pathBuf := make([]byte, binary.LittleEndian.Uint16(head[1:]))
+	var fixed [16]byte
+	if _, err = io.ReadFull(r, pathBuf); err == nil {
+		_, err = io.ReadFull(r, fixed[:])
+	}
+	if err != nil {
+		err = errors.New("truncated record header")
+		return
+	}
+	path = string(pathBuf)
+	size := binary.LittleEndian.Uint64(fixed[8:])
+	if size == packPending || size > math.MaxInt64 {
+		err = errors.New("incomplete record")
+		return
+	}
+
+	crc := crc32.NewIEEE()
+	if _, err = io.CopyN(crc, r, int64(size)); err != nil {
+		err = errors.New("truncated record data")
+		return
+	}
+	var sum [4]byte
+	if _, err = io.ReadFull(r, sum[:]); err != nil {
+		err = errors.New("truncated record checksum")
+		return
+	}
+	headerLen := int64(len(head)+len(pathBuf)) + int64(len(fixed))
+	n = headerLen + int64(size) + int64(len(sum))
+	if binary.LittleEndian.Uint32(sum[:]) != crc.Sum32() {
+		err = errPackChecksum
+		return
+	}
+
+	entry = packEntry{
+		offset:  offset + headerLen,
+		size:    int64(size),
+		modTime: time.Unix(0, int64(binary.LittleEndian.Uint64(fixed[:8]))).UTC(),
+	}
+	return
+}
+
+// packHeader dựng phần đầu record (độ dài data = size).
+func packHeader(op byte, path string, modTime time.Time, size uint64) []byte {
+	h := make([]byte, 3+len(path)+16)
+	h[0] = op
+	binary.LittleEndian.PutUint16(h[1:], uint16(len(path)))
+	copy(h[3:], path)
+	binary.LittleEndian.PutUint64(h[3+len(path):], uint64(modTime.UnixNano()))
+	binary.LittleEndian.PutUint64(h[3+len(path)+8:], size)
+	return h
+}
+
+// Put append record mới: header (độ dài = packPending) → data → CRC → sửa độ dài → fsync.
+// Lỗi ở bất kỳ bước nào → cắt file về như trước khi ghi.
+func (b *PackedBackend) Put(path string, r io.Reader) (int64, error) {
+	if len(path) > maxPackPath {
+		return 0, fmt.Errorf("path too long (%d bytes)", len(path))
+	}
+
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	start, modTime := b.size, time.Now().UTC()
+	header := packHeader(packOpPut, path, modTime, packPending)
+	dataOffset := start + int64(len(header))
+
+	n, err := b.appendRecord(start, header, r)
+	if err != nil {
+		return n, err
+	}
+
+	b.mu.Lock()
+	b.entries[path] = packEntry{offset: dataOffset, size: n, modTime: modTime}
+	b.mu.Unlock()
+	return n, nil
+}
+
+// appendRecord ghi record ở cuối log. Gọi khi đang giữ wmu.
+func (b *PackedBackend) appendRecord(start int64, header []byte, r io.Reader) (int64, error) {
+	n, end, err := appendPackRecord(b.f, start, header, r)
+	if err == nil {
+		b.size = end
+	}
+	return n, err
+}
+
+// appendPackRecord ghi record (header với độ dài packPending) ở start: data → CRC → sửa độ dài → fsync.
+// Trả về số byte data và vị trí kết thúc record. Lỗi → cắt file về start.
+func appendPackRecord(f *os.File, start int64, header []byte, r io.Reader) (n int64, end int64, err error) {
+	defer func() {
+		if err != nil {
+			f.Truncate(start)
+		}
+	}()
+
+	if _, err = f.WriteAt(header, start); err != nil {
+		return 0, 0, err
+	}
+	dataOffset := start + int64(len(header))
+	crc := crc32.NewIEEE()
+	if n, err = io.Copy(io.MultiWriter(&offsetWriter{f: f, off: dataOffset}, crc), r); err != nil {
+		return n, 0, err
+	}
+
+	var tail [4]byte
+	binary.LittleEndian.PutUint32(tail[:], crc.Sum32())
+	if _, err = f.WriteAt(tail[:], dataOffset+n); err != nil {
+		return n, 0, err
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(n))
+	if _, err = f.WriteAt(size[:], dataOffset-8); err != nil {
+		return n, 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return n, 0, err
+	}
+	return n, dataOffset + n + int64(len(tail)), nil
+}
+
+func (b *PackedBackend) Get(path string) (int64, io.ReadCloser, error) {
+	b.mu.RLock()
+	entry, ok := b.entries[path]
+	b.mu.RUnlock()
+	if !ok {
+		return 0, nil, notExist("get", path)
+	}
+	return entry.size, io.NopCloser(io.NewSectionReader(b.f, entry.offset, entry.size)), nil
+}
+
+func (b *PackedBackend) Has(path string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.entries[path]
+	return ok
+}
+
+// Delete append record xóa (tombstone).
+func (b *PackedBackend) Delete(path string) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	if !b.Has(path) {
+		return nil
+	}
+	header := packHeader(packOpDelete, path, time.Now().UTC(), packPending)
+	if _, err := b.appendRecord(b.size, header, strings.NewReader("")); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	delete(b.entries, path)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *PackedBackend) List(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var paths []string
+	for path := range b.entries {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (b *PackedBackend) Stat(path string) (ObjectInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.entries[path]
+	if !ok {
+		return ObjectInfo{}, notExist("stat", path)
+	}
+	return ObjectInfo{Size: entry.size, ModTime: entry.modTime}, nil
+}
+
+// Clear làm rỗng file pack.
+func (b *PackedBackend) Clear() error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	if err := b.f.Truncate(0); err != nil {
+		return err
+	}
+	b.size = 0
+	b.mu.Lock()
+	b.entries = make(map[string]packEntry)
+	b.mu.Unlock()
+	return b.f.Sync()
+}
+
+// Close đóng file pack.
+func (b *PackedBackend) Close() error {
+	return b.f.Close()
+}
+
+// offsetWriter ghi tuần tự vào file bắt đầu từ off (dùng WriteAt).
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"DistributedFileStorage/p2p"
+	"log"
+	"net"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                  TRAO ĐỔI PEER & QUẢN LÝ KẾT NỐI (PEER EXCHANGE)            //
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// defaultTargetPeers: số kết nối peer mặc định connection manager cố duy trì.
+	defaultTargetPeers = 8
+	// peerExchangeInterval: chu kỳ gửi lại danh sách peer cho mọi peer (và dial bù nếu thiếu kết nối).
+	peerExchangeInterval = 30 * time.Second
+	// peerDialTimeout: quá khoảng này mà chưa nhận MessageHello từ node đang dial → được dial lại.
+	peerDialTimeout = 10 * time.Second
+)
+
+// PeerInfo: 1 node đang kết nối – ID và địa chỉ node đó lắng nghe (không phải cổng tạm của kết nối).
+type PeerInfo struct {
+	ID   string
+	Addr string
+}
+
+// Thông điệp “đây là các peer mình đang kết nối”: gửi ngay sau MessageHello và định kỳ.
+// Bên nhận ghi vào sổ địa chỉ và dial thêm nếu chưa đủ TargetPeers kết nối.
+type MessagePeers struct {
+	Peers []PeerInfo
+}
+
+// targetPeers: số kết nối cần duy trì (TargetPeers; 0 → mặc định, < 0 → không tự dial thêm).
+func (s *FileServer) targetPeers() int {
+	if s.TargetPeers == 0 {
+		return defaultTargetPeers
+	}
+	return s.TargetPeers
+}
+
+// listenAddrOf: địa chỉ lắng nghe peer báo trong MessageHello; host rỗng / 0.0.0.0 (ví dụ ":3000")
+// được thay bằng IP của kết nối (remote) để node khác dial được.
+func listenAddrOf(remote string, listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if ip := net.ParseIP(host); len(host) > 0 && (ip == nil || !ip.IsUnspecified()) {
+		return listen
+	}
+	remoteHost, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return listen
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
+
+// knownPeers: các peer đang kết nối đã chào hỏi (biết ID + địa chỉ lắng nghe), trừ node exclude.
+func (s *FileServer) knownPeers(exclude string) []PeerInfo {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	var peers []PeerInfo
+	for addr, id := range s.peerIDs {
+		if id != exclude && len(s.peerListen[addr]) > 0 {
+			peers = append(peers, PeerInfo{ID: id, Addr: s.peerListen[addr]})
+		}
+	}
+	return peers
+}
+
+// sendPeers gửi danh sách peer đang kết nối (trừ chính peer nhận) cho peer addr.
+func (s *FileServer) sendPeers(addr string) error {
+	s.peerLock.Lock()
+	id := s.peerIDs[addr]
+	s.peerLock.Unlock()
+	return s.sendTo(addr, &Message{Payload: MessagePeers{Peers: s.knownPeers(id)}})
+}
+
+// handleMessagePeers: ghi các peer mới biết vào sổ địa chỉ rồi dial bù.
+func (s *FileServer) handleMessagePeers(msg MessagePeers) error {
+	s.peerLock.Lock()
+	for _, p := range msg.Peers {
+		if p.ID != s.ID && len(p.Addr) > 0 {
+			s.addrBook[p.ID] = p.Addr
+		}
+	}
+	s.peerLock.Unlock()
+
+	s.fillPeers()
+	return nil
+}
+
+// fillPeers dial các node trong sổ địa chỉ chưa kết nối cho tới khi đủ targetPeers kết nối
+// (tính cả kết nối đang dial). Node đang có kết nối (theo ID, hoặc kết nối tới đúng địa chỉ chưa chào hỏi xong)
+// không bị dial lại.
+func (s *FileServer) fillPeers() {
+	target := s.targetPeers()
+	if target < 0 {
+		return
+	}
+
+	s.peerLock.Lock()
+	connected := make(map[string]bool)
+	for _, id := range s.peerIDs {
+		connected[id] = true
+	}
+	pending := 0
+	for _, started := range s.dialing {
+		if time.Since(started) < peerDialTimeout {
+			pending++
+		}
+	}
+	var dials []PeerInfo
+	for id, addr := range s.addrBook {
+		if len(s.peers)+pending+len(dials) >= target {
+			break
+		}
+		if _, dialed := s.peers[addr]; connected[id] || dialed || time.Since(s.dialing[id]) < peerDialTimeout {
+			continue
+		}
+		s.dialing[id] = time.Now()
+		dials = append(dials, PeerInfo{ID: id, Addr: addr})
+	}
+	s.peerLock.Unlock()
+
+	for _, p := range dials {
+		go func(p PeerInfo) {
+			log.Printf("[%s] dialing peer %s learned from peer exchange", s.Transport.Addr(), p.Addr)
+			if err := s.Transport.Dial(p.Addr); err != nil {
+				log.Printf("dial error: %s", err)
+				s.peerLock.Lock()
+				delete(s.dialing, p.ID)
+				s.peerLock.Unlock()
+			}
+		}(p)
+	}
+}
+
+// exchangePeers: mỗi peerExchangeInterval gửi danh sách peer cho mọi peer, dial bù kết nối đã mất
+// và làm mới bảng định tuyến DHT.
+func (s *FileServer) exchangePeers() {
+	ticker := time.NewTicker(peerExchangeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, addr := range s.peerAddrs() {
+				if err := s.sendPeers(addr); err != nil {
+					log.Printf("sending peers to %s: %s", addr, err)
+				}
+			}
+			s.fillPeers()
+			s.refreshRoutes()
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// OnPeerClose được gọi khi kết nối tới peer bị đóng: xóa peer khỏi các map rồi dial bù
+// (node đó vẫn còn trong sổ địa chỉ nên có thể được dial lại). Không bật gossip → hẹn rebalance.
+func (s *FileServer) OnPeerClose(p p2p.Peer) {
+	addr := p.RemoteAddr().String()
+
+	s.peerLock.Lock()
+	delete(s.peers, addr)
+	delete(s.peerCaps, addr)
+	delete(s.peerFree, addr)
+	delete(s.peerIDs, addr)
+	delete(s.peerListen, addr)
+	delete(s.writeLocks, addr)
+	gossip := s.membership != nil
+	s.peerLock.Unlock()
+	log.Printf("disconnected from remote %s", addr)
+
+	select {
+	case <-s.quitch:
+	default:
+		s.fillPeers()
+		if !gossip {
+			s.membersChanged()
+		}
+	}
+}
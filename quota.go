@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                        QUOTA & THỐNG KÊ DUNG LƯỢNG                          //
+////////////////////////////////////////////////////////////////////////////////
+
+// Usage: dung lượng đang dùng (số object + số byte trong backend, không tính sidecar).
+type Usage struct {
+	Objects int64
+	Bytes   int64
+}
+
+// Quota: giới hạn dung lượng. Giá trị <= 0 → không giới hạn.
+type Quota struct {
+	MaxBytes   int64
+	MaxObjects int64
+}
+
+// ErrQuotaExceeded: ghi bị từ chối vì vượt quota (dùng errors.Is; chi tiết nằm trong *QuotaError).
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError: chi tiết quota bị vượt.
+//   - Scope: "namespace" (quota của ID) hoặc "node" (quota toàn node).
+//   - Need : số byte của lần ghi (nếu biết trước).
+type QuotaError struct {
+	Scope string
+	ID    string
+	Limit Quota
+	Used  Usage
+	Need  int64
+}
+
+func (e *QuotaError) Error() string {
+	target := e.Scope
+	if e.Scope == "namespace" {
+		target = "namespace " + e.ID
+	}
+	return fmt.Sprintf("%s: %s uses %d objects / %d bytes (limit %d objects / %d bytes, need %d bytes)",
+		ErrQuotaExceeded, target, e.Used.Objects, e.Used.Bytes, e.Limit.MaxObjects, e.Limit.MaxBytes, e.Need)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Usage: dung lượng namespace id đang dùng.
+func (s *Store) Usage(id string) Usage {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	return s.index.usage[id]
+}
+
+// TotalUsage: dung lượng toàn Store (mọi namespace).
+func (s *Store) TotalUsage() Usage {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	return s.index.total
+}
+
+// Namespaces: các namespace đang có object, đã sắp xếp.
+func (s *Store) Namespaces() []string {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	ids := make([]string, 0, len(s.index.usage))
+	for id := range s.index.usage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// namespaceQuota: quota riêng của id (NamespaceQuotas) hoặc quota mặc định (NamespaceQuota).
+func (s *Store) namespaceQuota(id string) Quota {
+	if q, ok := s.NamespaceQuotas[id]; ok {
+		return q
+	}
+	return s.NamespaceQuota
+}
+
+// FreeBytes: số byte còn ghi được theo NodeQuota và dung lượng trống của đĩa (FSBackend).
+// -1 → không giới hạn / không biết.
+func (s *Store) FreeBytes() int64 {
+	free := int64(-1)
+	if s.NodeQuota.MaxBytes > 0 {
+		free = s.NodeQuota.MaxBytes - s.TotalUsage().Bytes
+		if free < 0 {
+			free = 0
+		}
+	}
+	if fs, ok := s.backend.(*FSBackend); ok {
+		if disk, err := diskFree(fs.Root); err == nil && disk >= 0 && (free < 0 || disk < free) {
+			free = disk
+		}
+	}
+	return free
+}
+
+// CheckQuota kiểm tra trước 1 lần ghi size byte vào (id, key) (size < 0 → chưa biết kích thước).
+// Ghi đè object đã có được trừ đi dung lượng cũ.
+func (s *Store) CheckQuota(id string, key string, size int64) error {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	return s.checkQuotaLocked(id, key, 1, size)
+}
+
+// hasQuota: có quota nào áp lên lần ghi vào namespace id không.
+func (s *Store) hasQuota(id string) bool {
+	limited := func(q Quota) bool { return q.MaxBytes > 0 || q.MaxObjects > 0 }
+	return limited(s.namespaceQuota(id)) || limited(s.NodeQuota)
+}
+
+// checkQuotaLocked: thêm objects object + size byte (size < 0 → chưa biết) vào (id, key) có vượt quota
+// không → *QuotaError. Dung lượng đang giữ chỗ của các lần ghi chưa xong được tính như đã dùng;
+// object (id, key) sẽ bị ghi đè thì được trừ ra. Gọi khi đang giữ index.mu.
+func (s *Store) checkQuotaLocked(id string, key string, objects int64, size int64) error {
+	idx := s.index
+	old, replacing := idx.objects.Get(objectIndexKey(id, key))
+	used, total := idx.usage[id], idx.total
+	reserved := idx.reserved[id]
+	used.Objects, used.Bytes = used.Objects+reserved.Objects, used.Bytes+reserved.Bytes
+	total.Objects, total.Bytes = total.Objects+idx.reservedTotal.Objects, total.Bytes+idx.reservedTotal.Bytes
+	if replacing {
+		used.Objects, used.Bytes = used.Objects-1, used.Bytes-old.Size
+		total.Objects, total.Bytes = total.Objects-1, total.Bytes-old.Size
+	}
+
+	check := func(scope string, limit Quota, used Usage) error {
+		if limit.MaxObjects > 0 && used.Objects+objects > limit.MaxObjects {
+			return &QuotaError{Scope: scope, ID: id, Limit: limit, Used: used, Need: size}
+		}
+		if limit.MaxBytes <= 0 {
+			return nil
+		}
+		if left := limit.MaxBytes - used.Bytes; left < 0 || (size >= 0 && size > left) {
+			return &QuotaError{Scope: scope, ID: id, Limit: limit, Used: used, Need: size}
+		}
+		return nil
+	}
+	if err := check("namespace", s.namespaceQuota(id), used); err != nil {
+		return err
+	}
+	return check("node", s.NodeQuota, total)
+}
+
+// quotaReservation: phần quota 1 lần ghi đang giữ chỗ. Kiểm tra và giữ chỗ cùng dưới index.mu nên
+// các lần ghi song song không cùng nhìn thấy 1 khoảng trống; commit trả lại phần giữ chỗ đúng lúc
+// index cộng dung lượng thật, release trả lại khi lần ghi thất bại.
+type quotaReservation struct {
+	s       *Store
+	id, key string
+	held    Usage
+	err     error // *QuotaError khi lần ghi vượt quota giữa chừng
+}
+
+// reserveQuota giữ chỗ 1 object cho lần ghi vào (id, key); byte được giữ dần qua grow.
+// nil khi namespace/node không có quota.
+func (s *Store) reserveQuota(id string, key string) (*quotaReservation, error) {
+	if !s.hasQuota(id) {
+		return nil, nil
+	}
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	if err := s.checkQuotaLocked(id, key, 1, -1); err != nil {
+		return nil, err
+	}
+	r := &quotaReservation{s: s, id: id, key: key}
+	r.addLocked(Usage{Objects: 1})
+	return r, nil
+}
+
+// grow giữ chỗ thêm n byte; vượt quota → *QuotaError (Need = tổng số byte lần ghi cần).
+func (r *quotaReservation) grow(n int64) error {
+	idx := r.s.index
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := r.s.checkQuotaLocked(r.id, r.key, 0, n); err != nil {
+		if qerr, ok := err.(*QuotaError); ok {
+			qerr.Used.Objects -= r.held.Objects
+			qerr.Used.Bytes -= r.held.Bytes
+			qerr.Need = r.held.Bytes + n
+		}
+		r.err = err
+		return err
+	}
+	r.addLocked(Usage{Bytes: n})
+	return nil
+}
+
+// exceeded: lỗi quota lần ghi gặp phải (nil nếu không vượt).
+func (r *quotaReservation) exceeded() error {
+	r.s.index.mu.Lock()
+	defer r.s.index.mu.Unlock()
+	return r.err
+}
+
+// commit ghi entry vào index và trả lại phần giữ chỗ trong cùng 1 lần giữ index.mu.
+func (r *quotaReservation) commit(entry indexEntry) (indexEntry, error) {
+	idx := r.s.index
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	r.addLocked(Usage{Objects: -r.held.Objects, Bytes: -r.held.Bytes})
+	return idx.putLocked(r.id, r.key, entry)
+}
+
+// release trả lại phần giữ chỗ còn lại (lần ghi thất bại). Gọi được trên nil và sau commit.
+func (r *quotaReservation) release() {
+	if r == nil {
+		return
+	}
+	r.s.index.mu.Lock()
+	defer r.s.index.mu.Unlock()
+	r.addLocked(Usage{Objects: -r.held.Objects, Bytes: -r.held.Bytes})
+}
+
+// addLocked cộng delta vào phần giữ chỗ của lần ghi và của index. Gọi khi đang giữ index.mu.
+func (r *quotaReservation) addLocked(delta Usage) {
+	idx := r.s.index
+	if idx.reserved == nil {
+		idx.reserved = make(map[string]Usage)
+	}
+	u := idx.reserved[r.id]
+	u.Objects, u.Bytes = u.Objects+delta.Objects, u.Bytes+delta.Bytes
+	if u == (Usage{}) {
+		delete(idx.reserved, r.id)
+	} else {
+		idx.reserved[r.id] = u
+	}
+	idx.reservedTotal.Objects += delta.Objects
+	idx.reservedTotal.Bytes += delta.Bytes
+	r.held.Objects += delta.Objects
+	r.held.Bytes += delta.Bytes
+}
+
+// quotaWriter giữ chỗ quota cho từng đoạn dữ liệu trước khi chuyển xuống w; vượt quota → *QuotaError.
+// Dùng khi kích thước chưa biết trước (stream).
+type quotaWriter struct {
+	w   io.Writer
+	res *quotaReservation
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if err := q.res.grow(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return q.w.Write(p)
+}
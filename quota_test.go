@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestStoreQuota: dung lượng theo namespace được cộng dồn (kể cả ghi đè/xóa/dựng lại index),
+// ghi vượt quota namespace hoặc quota node bị từ chối với *QuotaError.
+func TestStoreQuota(t *testing.T) {
+	a, b := generateID(), generateID()
+	s := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		Backend:           NewMemoryBackend(),
+		NamespaceQuota:    Quota{MaxBytes: 100},
+		NamespaceQuotas:   map[string]Quota{b: {MaxObjects: 1}},
+		NodeQuota:         Quota{MaxBytes: 150},
+	})
+
+	if _, err := s.Write(a, "one", bytes.NewReader(make([]byte, 60))); err != nil {
+		t.Fatal(err)
+	}
+	// Ghi đè: chỉ tính phần chênh lệch
+	if _, err := s.Write(a, "one", bytes.NewReader(make([]byte, 90))); err != nil {
+		t.Fatal(err)
+	}
+	if u := s.Usage(a); u.Objects != 1 || u.Bytes != 90 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+
+	_, err := s.Write(a, "two", bytes.NewReader(make([]byte, 20)))
+	var qerr *QuotaError
+	if !errors.As(err, &qerr) || !errors.Is(err, ErrQuotaExceeded) || qerr.Scope != "namespace" {
+		t.Fatalf("expected namespace quota error, have %v", err)
+	}
+	if s.Has(a, "two") {
+		t.Errorf("rejected write must not be stored")
+	}
+
+	// b: chỉ 1 object, và quota node (150) còn 60 byte
+	if _, err := s.Write(b, "x", bytes.NewReader(make([]byte, 10))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckQuota(b, "y", 1); !errors.As(err, &qerr) || qerr.Limit.MaxObjects != 1 {
+		t.Errorf("expected object count quota error, have %v", err)
+	}
+	if _, err := s.Write(b, "x", bytes.NewReader(make([]byte, 70))); !errors.As(err, &qerr) || qerr.Scope != "node" {
+		t.Errorf("expected node quota error, have %v", err)
+	}
+	if free := s.FreeBytes(); free != 50 {
+		t.Errorf("want 50 free bytes have %d", free)
+	}
+
+	if err := s.Delete(a, "one"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if u := s.TotalUsage(); u.Objects != 1 || u.Bytes != 10 {
+		t.Errorf("unexpected total usage after rebuild %+v", u)
+	}
+}
+
+// gatedReader chỉ trả dữ liệu sau khi gate được đóng – để nhiều lần ghi cùng qua bước kiểm tra quota.
+type gatedReader struct {
+	r    io.Reader
+	gate chan struct{}
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	<-g.gate
+	return g.r.Read(p)
+}
+
+// TestStoreQuotaConcurrent: nhiều lần ghi song song không cùng dùng 1 khoảng trống quota.
+func TestStoreQuotaConcurrent(t *testing.T) {
+	id := generateID()
+	s := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		Backend:           NewMemoryBackend(),
+		NamespaceQuota:    Quota{MaxBytes: 100},
+	})
+
+	var (
+		gate = make(chan struct{})
+		wg   sync.WaitGroup
+		ok   int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &gatedReader{r: bytes.NewReader(make([]byte, 30)), gate: gate}
+			_, err := s.Write(id, fmt.Sprintf("key_%d", i), r)
+			if err == nil {
+				atomic.AddInt32(&ok, 1)
+			} else if !errors.Is(err, ErrQuotaExceeded) {
+				t.Error(err)
+			}
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	if u := s.Usage(id); ok != 3 || u.Objects != 3 || u.Bytes != 90 {
+		t.Errorf("want 3 writes / 90 bytes within quota, have %d writes usage %+v", ok, u)
+	}
+	if u := s.TotalUsage(); u.Bytes != 90 {
+		t.Errorf("unexpected total usage %+v", u)
+	}
+	if err := s.CheckQuota(id, "more", 10); err != nil {
+		t.Errorf("reservations of failed writes must be released: %v", err)
+	}
+}
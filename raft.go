@@ -0,0 +1,1069 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                       RAFT: ĐỒNG THUẬN CHO METADATA                         //
+////////////////////////////////////////////////////////////////////////////////
+
+// Raft giữ 1 log lệnh được nhân bản trên các node bầu chọn (voter); lệnh đã commit (được đa số ghi nhận)
+// được áp dụng theo đúng thứ tự lên state machine ở mọi voter → mọi voter có cùng trạng thái.
+//   - Bầu leader: follower không nghe leader trong ElectionTimeout (ngẫu nhiên [T, 2T)) → tăng term, xin phiếu;
+//     được đa số → leader. Mỗi voter chỉ bầu 1 lần mỗi term, và chỉ bầu cho log ít nhất mới bằng log của mình.
+//   - Nhân bản log: leader gửi AppendEntries (kiêm heartbeat mỗi ElectionTimeout/10); follower chỉ nhận khi
+//     entry ngay trước khớp (index + term), lệch thì cắt phần xung đột và nhận của leader.
+//   - Snapshot: log dài quá SnapshotEntries → chụp state machine, bỏ phần log đã nằm trong snapshot;
+//     follower tụt lại sau snapshot được gửi nguyên snapshot (InstallSnapshot).
+//   - Leader không nghe được đa số trong 1 ElectionTimeout thì tự lùi về follower (client tìm leader mới).
+//
+// Module không biết gì về mạng: message đi qua hàm Send (FileServer gửi qua p2p.Transport, đã ký) và
+// được đưa vào bằng step. Message có thể mất / tới trễ / lặp – Raft chịu được cả 3.
+//
+// Trạng thái bền (Dir, rỗng → chỉ trong RAM): "state" (term + phiếu đã bầu), "log" (append-only, fsync
+// trước khi trả lời), "snapshot".
+
+const (
+	raftDirName      = ".raft"
+	raftStateName    = "state"
+	raftLogName      = "log"
+	raftSnapshotName = "snapshot"
+
+	// defaultRaftElectionTimeout: thời gian chờ leader (tối thiểu) trước khi tự ứng cử.
+	defaultRaftElectionTimeout = time.Second
+	// defaultRaftSnapshotEntries: số entry đã áp dụng kể từ snapshot trước để chụp snapshot mới.
+	defaultRaftSnapshotEntries = 1024
+	// raftMaxBatch: số entry tối đa trong 1 AppendEntries.
+	raftMaxBatch = 64
+	// raftOutboxSize: số message chờ gửi tối đa cho mỗi voter (đầy → bỏ, heartbeat sau gửi lại).
+	raftOutboxSize = 256
+)
+
+var (
+	// ErrNotLeader: node không phải leader (dùng errors.Is; leader đang biết nằm trong *NotLeaderError).
+	ErrNotLeader = errors.New("raft: not the leader")
+	// ErrRaftStopped: Raft đã dừng.
+	ErrRaftStopped = errors.New("raft: stopped")
+	// errRaftLeadershipLost: entry bị leader mới ghi đè trước khi commit (lệnh không được áp dụng).
+	errRaftLeadershipLost = errors.New("raft: leadership lost before the entry was committed")
+	// errRaftOutcomeUnknown: entry nằm trong snapshot nhận từ leader – không biết kết quả áp dụng.
+	errRaftOutcomeUnknown = errors.New("raft: entry was compacted into a snapshot, outcome unknown")
+)
+
+// NotLeaderError: lệnh gửi tới node không phải leader. Leader rỗng → chưa biết leader (đang bầu).
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if len(e.Leader) == 0 {
+		return fmt.Sprintf("%s (no leader elected)", ErrNotLeader)
+	}
+	return fmt.Sprintf("%s (leader is %s)", ErrNotLeader, e.Leader)
+}
+
+func (e *NotLeaderError) Unwrap() error {
+	return ErrNotLeader
+}
+
+// RaftRole: vai trò hiện tại của node.
+type RaftRole int
+
+const (
+	RaftFollower RaftRole = iota
+	RaftCandidate
+	RaftLeader
+)
+
+func (r RaftRole) String() string {
+	switch r {
+	case RaftFollower:
+		return "follower"
+	case RaftCandidate:
+		return "candidate"
+	case RaftLeader:
+		return "leader"
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// raftEntry: 1 lệnh trong log. Command rỗng = no-op (leader ghi khi vừa được bầu để commit log của term trước).
+type raftEntry struct {
+	Index   uint64
+	Term    uint64
+	Command []byte `json:",omitempty"`
+}
+
+// Thông điệp RequestVote: ứng viên (người gửi) xin phiếu cho Term.
+type MessageRaftVote struct {
+	Term         uint64
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// Trả lời RequestVote.
+type MessageRaftVoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// Thông điệp AppendEntries: leader (người gửi) nhân bản Entries nối sau (PrevLogIndex, PrevLogTerm);
+// không có entry = heartbeat.
+type MessageRaftAppend struct {
+	Term         uint64
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []raftEntry
+	LeaderCommit uint64
+}
+
+// Trả lời AppendEntries. Success → MatchIndex = entry cuối follower đã khớp với leader;
+// thất bại → MatchIndex = gợi ý: leader thử lại từ MatchIndex+1.
+type MessageRaftAppendResponse struct {
+	Term       uint64
+	Success    bool
+	MatchIndex uint64
+}
+
+// Thông điệp InstallSnapshot: leader gửi nguyên snapshot (trạng thái sau entry LastIndex) cho follower tụt hậu.
+type MessageRaftSnapshot struct {
+	Term      uint64
+	LastIndex uint64
+	LastTerm  uint64
+	Data      []byte
+}
+
+// Trả lời InstallSnapshot: MatchIndex = entry cuối follower đã có (0 → chưa cài được).
+type MessageRaftSnapshotResponse struct {
+	Term       uint64
+	MatchIndex uint64
+}
+
+// raftStateMachine: trạng thái được nhân bản. Apply phải tất định (cùng log → cùng trạng thái ở mọi node).
+type raftStateMachine interface {
+	Apply(index uint64, command []byte) error
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// raftOpts: cấu hình Raft. Voters gồm cả ID của chính node.
+type raftOpts struct {
+	ID              string
+	Voters          []string
+	Dir             string                             // thư mục trạng thái bền (rỗng → chỉ trong RAM)
+	ElectionTimeout time.Duration                      // 0 → defaultRaftElectionTimeout
+	SnapshotEntries int                                // 0 → defaultRaftSnapshotEntries
+	Send            func(to string, payload any) error // gửi message tới voter (có thể chặn, gọi từ goroutine riêng)
+	StateMachine    raftStateMachine
+}
+
+// RaftStatus: trạng thái Raft của node (admin API).
+type RaftStatus struct {
+	ID            string
+	Role          string
+	Term          uint64
+	Leader        string
+	Voters        []string
+	LastIndex     uint64
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	SnapshotIndex uint64
+}
+
+// raftWaiter: lệnh đang chờ commit ở index của nó (done nhận kết quả Apply đúng 1 lần).
+type raftWaiter struct {
+	term uint64
+	done chan error
+}
+
+// raftHardState: phần trạng thái phải bền trước khi trả lời (term + phiếu).
+type raftHardState struct {
+	Term     uint64
+	VotedFor string
+}
+
+// raftSnapshot: file snapshot trên đĩa.
+type raftSnapshot struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// raft: 1 node Raft. An toàn khi dùng đồng thời (mọi trạng thái nằm dưới mu).
+type raft struct {
+	raftOpts
+
+	mu       sync.Mutex
+	role     RaftRole
+	term     uint64
+	votedFor string
+	leader   string
+
+	log       []raftEntry // các entry sau snapshot: log[i] có Index = snapIndex+1+i
+	snapIndex uint64
+	snapTerm  uint64
+	snapData  []byte
+
+	commitIndex uint64
+	lastApplied uint64
+	applied     chan struct{} // đóng (và thay mới) mỗi khi lastApplied tăng
+
+	votes        map[string]bool      // phiếu đã nhận (candidate)
+	nextIndex    map[string]uint64    // entry tiếp theo gửi cho từng voter (leader)
+	matchIndex   map[string]uint64    // entry cuối đã khớp ở từng voter (leader)
+	lastAck      map[string]time.Time // lần cuối voter trả lời leader
+	snapshotSent map[string]time.Time // lần cuối gửi snapshot (tránh gửi lại liên tục)
+
+	electionDeadline time.Time
+	waiters          map[uint64]*raftWaiter
+	outbox           map[string]chan any
+
+	quitch   chan struct{}
+	stopOnce sync.Once
+}
+
+// newRaft khởi tạo node Raft và nạp trạng thái bền (snapshot được Restore vào StateMachine).
+func newRaft(opts raftOpts) (*raft, error) {
+	if opts.ElectionTimeout <= 0 {
+		opts.ElectionTimeout = defaultRaftElectionTimeout
+	}
+	if opts.SnapshotEntries <= 0 {
+		opts.SnapshotEntries = defaultRaftSnapshotEntries
+	}
+	voters := make([]string, 0, len(opts.Voters)+1)
+	seen := make(map[string]bool)
+	for _, id := range append([]string{opts.ID}, opts.Voters...) {
+		if len(id) > 0 && !seen[id] {
+			seen[id] = true
+			voters = append(voters, id)
+		}
+	}
+	sort.Strings(voters)
+	opts.Voters = voters
+
+	r := &raft{
+		raftOpts:     opts,
+		applied:      make(chan struct{}),
+		nextIndex:    make(map[string]uint64),
+		matchIndex:   make(map[string]uint64),
+		lastAck:      make(map[string]time.Time),
+		snapshotSent: make(map[string]time.Time),
+		waiters:      make(map[uint64]*raftWaiter),
+		outbox:       make(map[string]chan any),
+		quitch:       make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	for _, id := range r.Voters {
+		if id != r.ID {
+			r.outbox[id] = make(chan any, raftOutboxSize)
+		}
+	}
+	r.resetElectionTimer()
+	return r, nil
+}
+
+// run chạy đồng hồ của Raft (heartbeat / hết hạn bầu cử) và các goroutine gửi message; thoát khi stop.
+func (r *raft) run() {
+	for id, ch := range r.outbox {
+		go r.sender(id, ch)
+	}
+
+	ticker := time.NewTicker(r.heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.tick()
+		case <-r.quitch:
+			return
+		}
+	}
+}
+
+// stop dừng Raft; lệnh đang chờ commit nhận ErrRaftStopped.
+func (r *raft) stop() {
+	r.stopOnce.Do(func() {
+		close(r.quitch)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for index, w := range r.waiters {
+			delete(r.waiters, index)
+			w.done <- ErrRaftStopped
+		}
+	})
+}
+
+// sender gửi lần lượt message trong outbox tới voter to. Chỉ log khi chuyển từ gửi được sang lỗi (và ngược lại)
+// để voter đang chết không làm ngập log bằng heartbeat.
+func (r *raft) sender(to string, ch chan any) {
+	failing := false
+	for {
+		select {
+		case payload := <-ch:
+			err := r.Send(to, payload)
+			if err != nil && !failing {
+				log.Printf("raft: sending to %s: %s", to, err)
+			} else if err == nil && failing {
+				log.Printf("raft: %s reachable again", to)
+			}
+			failing = err != nil
+		case <-r.quitch:
+			return
+		}
+	}
+}
+
+// enqueue đưa message vào outbox của voter to (đầy → bỏ).
+func (r *raft) enqueue(to string, payload any) {
+	select {
+	case r.outbox[to] <- payload:
+	default:
+	}
+}
+
+func (r *raft) heartbeatInterval() time.Duration {
+	return r.ElectionTimeout / 10
+}
+
+func (r *raft) resetElectionTimer() {
+	jitter := time.Duration(rand.Int63n(int64(r.ElectionTimeout)))
+	r.electionDeadline = time.Now().Add(r.ElectionTimeout + jitter)
+}
+
+func (r *raft) quorum() int {
+	return len(r.Voters)/2 + 1
+}
+
+func (r *raft) isVoter(id string) bool {
+	i := sort.SearchStrings(r.Voters, id)
+	return i < len(r.Voters) && r.Voters[i] == id
+}
+
+func (r *raft) lastIndex() uint64 {
+	return r.snapIndex + uint64(len(r.log))
+}
+
+// termAt: term của entry index (0 nếu không biết: ngoài log hoặc đã bị gộp vào snapshot).
+func (r *raft) termAt(index uint64) uint64 {
+	switch {
+	case index == r.snapIndex:
+		return r.snapTerm
+	case index < r.snapIndex || index > r.lastIndex():
+		return 0
+	}
+	return r.log[index-r.snapIndex-1].Term
+}
+
+// Status: trạng thái hiện tại.
+func (r *raft) Status() RaftStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RaftStatus{
+		ID:            r.ID,
+		Role:          r.role.String(),
+		Term:          r.term,
+		Leader:        r.leader,
+		Voters:        append([]string(nil), r.Voters...),
+		LastIndex:     r.lastIndex(),
+		CommitIndex:   r.commitIndex,
+		AppliedIndex:  r.lastApplied,
+		SnapshotIndex: r.snapIndex,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                           ĐỀ XUẤT LỆNH & CHỜ ÁP DỤNG                        //
+////////////////////////////////////////////////////////////////////////////////
+
+// propose ghi command vào log (chỉ leader) và chờ tới khi nó được commit + áp dụng (tối đa timeout).
+// Trả về index của entry và lỗi của StateMachine.Apply. Không phải leader → *NotLeaderError.
+func (r *raft) propose(command []byte, timeout time.Duration) (uint64, error) {
+	r.mu.Lock()
+	select {
+	case <-r.quitch:
+		r.mu.Unlock()
+		return 0, ErrRaftStopped
+	default:
+	}
+	if r.role != RaftLeader {
+		err := &NotLeaderError{Leader: r.leader}
+		r.mu.Unlock()
+		return 0, err
+	}
+	entry := raftEntry{Index: r.lastIndex() + 1, Term: r.term, Command: command}
+	if err := r.appendLog([]raftEntry{entry}); err != nil {
+		r.mu.Unlock()
+		return 0, err
+	}
+	w := &raftWaiter{term: entry.Term, done: make(chan error, 1)}
+	r.waiters[entry.Index] = w
+	r.broadcastAppend()
+	r.maybeCommit()
+	r.mu.Unlock()
+
+	select {
+	case err := <-w.done:
+		return entry.Index, err
+	case <-time.After(timeout):
+		r.mu.Lock()
+		delete(r.waiters, entry.Index)
+		r.mu.Unlock()
+		return entry.Index, fmt.Errorf("raft: entry %d not committed after %s", entry.Index, timeout)
+	}
+}
+
+// waitApplied chờ tới khi entry index đã được áp dụng lên state machine của node này (tối đa timeout).
+func (r *raft) waitApplied(index uint64, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		applied, ch := r.lastApplied, r.applied
+		r.mu.Unlock()
+		if applied >= index {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return fmt.Errorf("raft: entry %d not applied after %s (applied %d)", index, timeout, applied)
+		case <-r.quitch:
+			return ErrRaftStopped
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                        BẦU CỬ & CHUYỂN VAI TRÒ                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// tick: leader gửi heartbeat (và tự lùi nếu mất đa số); follower/candidate hết hạn chờ → ứng cử.
+func (r *raft) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.role == RaftLeader {
+		if !r.quorumActive(now) {
+			log.Printf("raft: %s lost contact with a majority, stepping down (term %d)", r.ID, r.term)
+			r.becomeFollower(r.term, "")
+			return
+		}
+		r.broadcastAppend()
+		return
+	}
+	if now.After(r.electionDeadline) {
+		r.campaign()
+	}
+}
+
+// quorumActive: đa số voter (kể cả leader) đã trả lời trong 1 ElectionTimeout gần nhất.
+func (r *raft) quorumActive(now time.Time) bool {
+	active := 1
+	for id, at := range r.lastAck {
+		if id != r.ID && now.Sub(at) < r.ElectionTimeout {
+			active++
+		}
+	}
+	return active >= r.quorum()
+}
+
+// campaign: sang term mới, tự bầu cho mình rồi xin phiếu các voter khác.
+func (r *raft) campaign() {
+	r.resetElectionTimer()
+	r.term++
+	r.votedFor = r.ID
+	if err := r.persistState(); err != nil {
+		log.Printf("raft: persisting vote: %s", err)
+		r.term--
+		r.votedFor = ""
+		return
+	}
+	r.role, r.leader = RaftCandidate, ""
+	r.votes = map[string]bool{r.ID: true}
+	if len(r.votes) >= r.quorum() {
+		r.becomeLeader()
+		return
+	}
+
+	msg := MessageRaftVote{Term: r.term, LastLogIndex: r.lastIndex(), LastLogTerm: r.termAt(r.lastIndex())}
+	for _, id := range r.Voters {
+		if id != r.ID {
+			r.enqueue(id, msg)
+		}
+	}
+}
+
+// becomeFollower: lùi về follower của term (term lớn hơn → phiếu của term mới chưa bầu).
+// Không lưu được term mới → giữ nguyên term/phiếu cũ (như campaign), vẫn lùi về follower ở term hiện tại
+// và trả về false: người gọi không được hành động theo term mới.
+func (r *raft) becomeFollower(term uint64, leader string) bool {
+	ok := true
+	if term > r.term {
+		oldTerm, oldVote := r.term, r.votedFor
+		r.term, r.votedFor = term, ""
+		if err := r.persistState(); err != nil {
+			log.Printf("raft: persisting term: %s", err)
+			r.term, r.votedFor = oldTerm, oldVote
+			ok, leader = false, ""
+		}
+	}
+	if r.role != RaftFollower {
+		r.resetElectionTimer()
+	}
+	r.role, r.leader, r.votes = RaftFollower, leader, nil
+	return ok
+}
+
+// becomeLeader: ghi entry no-op của term mới (commit được nó là commit luôn log của term trước) và
+// bắt đầu nhân bản.
+func (r *raft) becomeLeader() {
+	r.role, r.leader, r.votes = RaftLeader, r.ID, nil
+	now := time.Now()
+	for _, id := range r.Voters {
+		r.nextIndex[id], r.matchIndex[id], r.lastAck[id] = r.lastIndex()+1, 0, now
+		delete(r.snapshotSent, id)
+	}
+	log.Printf("raft: %s elected leader for term %d", r.ID, r.term)
+
+	if err := r.appendLog([]raftEntry{{Index: r.lastIndex() + 1, Term: r.term}}); err != nil {
+		log.Printf("raft: appending no-op entry: %s", err)
+	}
+	r.broadcastAppend()
+	r.maybeCommit()
+}
+
+// upToDate: log của ứng viên (lastIndex, lastTerm) ít nhất mới bằng log của node này.
+func (r *raft) upToDate(lastIndex, lastTerm uint64) bool {
+	myTerm := r.termAt(r.lastIndex())
+	return lastTerm > myTerm || (lastTerm == myTerm && lastIndex >= r.lastIndex())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                              XỬ LÝ MESSAGE                                  //
+////////////////////////////////////////////////////////////////////////////////
+
+// step xử lý 1 message Raft từ voter from (ID đã xác thực bằng chữ ký). Không chặn: trả lời qua outbox.
+func (r *raft) step(from string, payload any) {
+	if from == r.ID || !r.isVoter(from) {
+		log.Printf("raft: ignoring %T from non-voter %s", payload, from)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.quitch:
+		return
+	default:
+	}
+
+	switch m := payload.(type) {
+	case MessageRaftVote:
+		r.handleVote(from, m)
+	case MessageRaftVoteResponse:
+		r.handleVoteResponse(from, m)
+	case MessageRaftAppend:
+		r.handleAppend(from, m)
+	case MessageRaftAppendResponse:
+		r.handleAppendResponse(from, m)
+	case MessageRaftSnapshot:
+		r.handleSnapshot(from, m)
+	case MessageRaftSnapshotResponse:
+		r.handleSnapshotResponse(from, m)
+	}
+}
+
+func (r *raft) handleVote(from string, m MessageRaftVote) {
+	if m.Term > r.term {
+		r.becomeFollower(m.Term, "")
+	}
+	granted := false
+	if m.Term == r.term && (r.votedFor == "" || r.votedFor == from) && r.upToDate(m.LastLogIndex, m.LastLogTerm) {
+		r.votedFor = from
+		if err := r.persistState(); err != nil {
+			log.Printf("raft: persisting vote: %s", err)
+			r.votedFor = ""
+		} else {
+			granted = true
+			r.resetElectionTimer()
+		}
+	}
+	r.enqueue(from, MessageRaftVoteResponse{Term: r.term, Granted: granted})
+}
+
+func (r *raft) handleVoteResponse(from string, m MessageRaftVoteResponse) {
+	if m.Term > r.term {
+		r.becomeFollower(m.Term, "")
+		return
+	}
+	if r.role != RaftCandidate || m.Term != r.term || !m.Granted {
+		return
+	}
+	r.votes[from] = true
+	if len(r.votes) >= r.quorum() {
+		r.becomeLeader()
+	}
+}
+
+func (r *raft) handleAppend(from string, m MessageRaftAppend) {
+	reply := func(success bool, match uint64) {
+		r.enqueue(from, MessageRaftAppendResponse{Term: r.term, Success: success, MatchIndex: match})
+	}
+	if m.Term < r.term {
+		reply(false, r.lastIndex())
+		return
+	}
+	if m.Term > r.term || r.role != RaftFollower {
+		if !r.becomeFollower(m.Term, from) {
+			reply(false, r.lastIndex())
+			return
+		}
+	}
+	r.leader = from
+	r.resetElectionTimer()
+
+	prev, prevTerm, entries := m.PrevLogIndex, m.PrevLogTerm, m.Entries
+	if prev < r.snapIndex {
+		// Phần đầu đã nằm trong snapshot (đã commit → chắc chắn khớp leader).
+		skip := r.snapIndex - prev
+		if uint64(len(entries)) <= skip {
+			reply(true, r.snapIndex)
+			return
+		}
+		entries = entries[skip:]
+		prev, prevTerm = r.snapIndex, r.snapTerm
+	}
+	if prev > r.lastIndex() {
+		reply(false, r.lastIndex())
+		return
+	}
+	if t := r.termAt(prev); t != prevTerm {
+		// Bỏ qua cả đoạn term xung đột: leader thử lại từ trước entry đầu tiên của term đó.
+		hint := prev - 1
+		for hint > r.snapIndex && r.termAt(hint) == t {
+			hint--
+		}
+		if hint < r.commitIndex {
+			hint = r.commitIndex
+		}
+		reply(false, hint)
+		return
+	}
+
+	for i, e := range entries {
+		if e.Index <= r.lastIndex() {
+			if r.termAt(e.Index) == e.Term {
+				continue
+			}
+			if e.Index <= r.commitIndex {
+				log.Printf("raft: leader %s conflicts with committed entry %d, ignoring", from, e.Index)
+				reply(false, r.commitIndex)
+				return
+			}
+			if err := r.truncateLog(e.Index); err != nil {
+				log.Printf("raft: truncating log: %s", err)
+				reply(false, r.commitIndex)
+				return
+			}
+		}
+		if err := r.appendLog(entries[i:]); err != nil {
+			log.Printf("raft: appending entries: %s", err)
+			reply(false, r.lastIndex())
+			return
+		}
+		break
+	}
+
+	match := prev + uint64(len(entries))
+	if m.LeaderCommit > r.commitIndex {
+		commit := m.LeaderCommit
+		if commit > match {
+			commit = match
+		}
+		if commit > r.commitIndex {
+			r.commitIndex = commit
+			r.applyCommitted()
+		}
+	}
+	reply(true, match)
+}
+
+func (r *raft) handleAppendResponse(from string, m MessageRaftAppendResponse) {
+	if m.Term > r.term {
+		r.becomeFollower(m.Term, "")
+		return
+	}
+	if r.role != RaftLeader || m.Term != r.term {
+		return
+	}
+	r.lastAck[from] = time.Now()
+
+	if m.Success {
+		if m.MatchIndex > r.matchIndex[from] {
+			r.matchIndex[from] = m.MatchIndex
+		}
+		if r.nextIndex[from] <= r.matchIndex[from] {
+			r.nextIndex[from] = r.matchIndex[from] + 1
+		}
+		r.maybeCommit()
+		if r.nextIndex[from] <= r.lastIndex() {
+			r.sendAppend(from)
+		}
+		return
+	}
+
+	next := m.MatchIndex + 1
+	if next <= r.matchIndex[from] {
+		next = r.matchIndex[from] + 1
+	}
+	if next < r.nextIndex[from] {
+		r.nextIndex[from] = next
+		r.sendAppend(from)
+	}
+}
+
+func (r *raft) handleSnapshot(from string, m MessageRaftSnapshot) {
+	reply := func(match uint64) {
+		r.enqueue(from, MessageRaftSnapshotResponse{Term: r.term, MatchIndex: match})
+	}
+	if m.Term < r.term {
+		reply(0)
+		return
+	}
+	if m.Term > r.term || r.role != RaftFollower {
+		if !r.becomeFollower(m.Term, from) {
+			reply(0)
+			return
+		}
+	}
+	r.leader = from
+	r.resetElectionTimer()
+
+	if m.LastIndex <= r.commitIndex {
+		reply(m.LastIndex) // đã có (và đã commit) mọi thứ trong snapshot
+		return
+	}
+	if err := r.installSnapshot(m.LastIndex, m.LastTerm, m.Data); err != nil {
+		log.Printf("raft: installing snapshot %d from %s: %s", m.LastIndex, from, err)
+		reply(0)
+		return
+	}
+	log.Printf("raft: installed snapshot at index %d from %s", m.LastIndex, from)
+	reply(m.LastIndex)
+}
+
+func (r *raft) handleSnapshotResponse(from string, m MessageRaftSnapshotResponse) {
+	if m.Term > r.term {
+		r.becomeFollower(m.Term, "")
+		return
+	}
+	if r.role != RaftLeader || m.Term != r.term {
+		return
+	}
+	r.lastAck[from] = time.Now()
+	delete(r.snapshotSent, from)
+	if m.MatchIndex > r.matchIndex[from] {
+		r.matchIndex[from] = m.MatchIndex
+		r.nextIndex[from] = m.MatchIndex + 1
+		r.maybeCommit()
+		r.sendAppend(from)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                     NHÂN BẢN, COMMIT & ÁP DỤNG LOG                          //
+////////////////////////////////////////////////////////////////////////////////
+
+func (r *raft) broadcastAppend() {
+	for _, id := range r.Voters {
+		if id != r.ID {
+			r.sendAppend(id)
+		}
+	}
+}
+
+// sendAppend gửi cho voter id các entry từ nextIndex (tối đa raftMaxBatch), hoặc snapshot nếu các entry đó
+// đã bị gộp vào snapshot.
+func (r *raft) sendAppend(id string) {
+	next := r.nextIndex[id]
+	if next <= r.snapIndex {
+		if at, ok := r.snapshotSent[id]; ok && time.Since(at) < r.ElectionTimeout {
+			return // snapshot trước còn đang trên đường
+		}
+		r.snapshotSent[id] = time.Now()
+		r.enqueue(id, MessageRaftSnapshot{Term: r.term, LastIndex: r.snapIndex, LastTerm: r.snapTerm, Data: r.snapData})
+		return
+	}
+
+	prev := next - 1
+	var entries []raftEntry
+	if next <= r.lastIndex() {
+		from := next - r.snapIndex - 1
+		to := from + raftMaxBatch
+		if to > uint64(len(r.log)) {
+			to = uint64(len(r.log))
+		}
+		entries = append(entries, r.log[from:to]...)
+	}
+	r.enqueue(id, MessageRaftAppend{
+		Term:         r.term,
+		PrevLogIndex: prev,
+		PrevLogTerm:  r.termAt(prev),
+		Entries:      entries,
+		LeaderCommit: r.commitIndex,
+	})
+}
+
+// maybeCommit (leader): entry mới nhất của term hiện tại đã có ở đa số voter → commit tới đó.
+func (r *raft) maybeCommit() {
+	for n := r.lastIndex(); n > r.commitIndex && r.termAt(n) == r.term; n-- {
+		count := 1
+		for id, match := range r.matchIndex {
+			if id != r.ID && match >= n {
+				count++
+			}
+		}
+		if count >= r.quorum() {
+			r.commitIndex = n
+			r.applyCommitted()
+			return
+		}
+	}
+}
+
+// applyCommitted áp dụng các entry đã commit chưa áp dụng, báo kết quả cho lệnh đang chờ, chụp snapshot khi cần.
+func (r *raft) applyCommitted() {
+	if r.lastApplied >= r.commitIndex {
+		return
+	}
+	for r.lastApplied < r.commitIndex {
+		e := r.log[r.lastApplied-r.snapIndex]
+		var err error
+		if len(e.Command) > 0 {
+			err = r.StateMachine.Apply(e.Index, e.Command)
+		}
+		r.lastApplied = e.Index
+		if w, ok := r.waiters[e.Index]; ok {
+			delete(r.waiters, e.Index)
+			if w.term != e.Term {
+				err = errRaftLeadershipLost
+			}
+			w.done <- err
+		}
+	}
+	close(r.applied)
+	r.applied = make(chan struct{})
+
+	if r.lastApplied-r.snapIndex >= uint64(r.SnapshotEntries) {
+		if err := r.takeSnapshot(); err != nil {
+			log.Printf("raft: taking snapshot: %s", err)
+		}
+	}
+}
+
+// takeSnapshot chụp state machine ở lastApplied và bỏ phần log đã nằm trong snapshot.
+func (r *raft) takeSnapshot() error {
+	data, err := r.StateMachine.Snapshot()
+	if err != nil {
+		return err
+	}
+	index, term := r.lastApplied, r.termAt(r.lastApplied)
+	if err := r.persistSnapshot(raftSnapshot{Index: index, Term: term, Data: data}); err != nil {
+		return err
+	}
+	r.log = append([]raftEntry(nil), r.log[index-r.snapIndex:]...)
+	r.snapIndex, r.snapTerm, r.snapData = index, term, data
+	return r.rewriteLog()
+}
+
+// installSnapshot thay state machine bằng snapshot của leader. Log sau snapshot được giữ nếu khớp,
+// không thì bỏ hết.
+func (r *raft) installSnapshot(index, term uint64, data []byte) error {
+	if err := r.StateMachine.Restore(data); err != nil {
+		return err
+	}
+	if err := r.persistSnapshot(raftSnapshot{Index: index, Term: term, Data: data}); err != nil {
+		return err
+	}
+	if index < r.lastIndex() && r.termAt(index) == term {
+		r.log = append([]raftEntry(nil), r.log[index-r.snapIndex:]...)
+	} else {
+		r.log = nil
+	}
+	r.snapIndex, r.snapTerm, r.snapData = index, term, data
+	r.commitIndex, r.lastApplied = index, index
+	for i, w := range r.waiters {
+		if i <= index {
+			delete(r.waiters, i)
+			w.done <- errRaftOutcomeUnknown
+		}
+	}
+	close(r.applied)
+	r.applied = make(chan struct{})
+	return r.rewriteLog()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                             TRẠNG THÁI BỀN                                  //
+////////////////////////////////////////////////////////////////////////////////
+
+// appendLog ghi entries (fsync) rồi nối vào log trong RAM.
+func (r *raft) appendLog(entries []raftEntry) error {
+	if len(r.Dir) > 0 {
+		if err := os.MkdirAll(r.Dir, os.ModePerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(r.Dir, raftLogName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		bw := bufio.NewWriter(f)
+		for _, e := range entries {
+			if err = writeRecord(bw, e); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.log = append(r.log, entries...)
+	return nil
+}
+
+// truncateLog bỏ mọi entry từ index trở đi (chưa commit – bị leader mới ghi đè).
+func (r *raft) truncateLog(index uint64) error {
+	r.log = r.log[:index-r.snapIndex-1]
+	for i, w := range r.waiters {
+		if i >= index {
+			delete(r.waiters, i)
+			w.done <- errRaftLeadershipLost
+		}
+	}
+	return r.rewriteLog()
+}
+
+// rewriteLog ghi lại (nguyên tử) file log đúng bằng log trong RAM.
+func (r *raft) rewriteLog() error {
+	if len(r.Dir) == 0 {
+		return nil
+	}
+	_, err := writeFileAtomic(filepath.Join(r.Dir, raftLogName), func(w io.Writer) (int64, error) {
+		bw := bufio.NewWriter(w)
+		for _, e := range r.log {
+			if err := writeRecord(bw, e); err != nil {
+				return 0, err
+			}
+		}
+		return 0, bw.Flush()
+	})
+	return err
+}
+
+func (r *raft) persistState() error {
+	if len(r.Dir) == 0 {
+		return nil
+	}
+	return writeJSONFile(filepath.Join(r.Dir, raftStateName), raftHardState{Term: r.term, VotedFor: r.votedFor})
+}
+
+func (r *raft) persistSnapshot(snap raftSnapshot) error {
+	if len(r.Dir) == 0 {
+		return nil
+	}
+	return writeJSONFile(filepath.Join(r.Dir, raftSnapshotName), snap)
+}
+
+// load nạp term/phiếu, snapshot và log từ Dir. Đuôi log hỏng (crash giữa lúc ghi) bị bỏ.
+func (r *raft) load() error {
+	if len(r.Dir) == 0 {
+		return nil
+	}
+
+	var hs raftHardState
+	if err := readJSONFile(filepath.Join(r.Dir, raftStateName), &hs); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading raft state: %w", err)
+	}
+	r.term, r.votedFor = hs.Term, hs.VotedFor
+
+	var snap raftSnapshot
+	err := readJSONFile(filepath.Join(r.Dir, raftSnapshotName), &snap)
+	switch {
+	case err == nil:
+		if err := r.StateMachine.Restore(snap.Data); err != nil {
+			return fmt.Errorf("restoring raft snapshot: %w", err)
+		}
+		r.snapIndex, r.snapTerm, r.snapData = snap.Index, snap.Term, snap.Data
+		r.commitIndex, r.lastApplied = snap.Index, snap.Index
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("reading raft snapshot: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(r.Dir, raftLogName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	for {
+		var e raftEntry
+		_, err := readRecord(br, &e)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("raft: dropping torn log tail after index %d: %s", r.lastIndex(), err)
+			return r.rewriteLog()
+		}
+		if e.Index <= r.snapIndex {
+			continue // đã nằm trong snapshot (crash giữa lúc cắt log)
+		}
+		if e.Index != r.lastIndex()+1 {
+			log.Printf("raft: log gap at index %d (expected %d), dropping the rest", e.Index, r.lastIndex()+1)
+			return r.rewriteLog()
+		}
+		r.log = append(r.log, e)
+	}
+}
+
+// writeJSONFile ghi v (JSON) vào path một cách nguyên tử.
+func writeJSONFile(path string, v any) error {
+	_, err := writeFileAtomic(path, func(w io.Writer) (int64, error) {
+		return 0, json.NewEncoder(w).Encode(v)
+	})
+	return err
+}
+
+func readJSONFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
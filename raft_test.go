@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMachine: state machine đơn giản – ghi lại các lệnh theo thứ tự áp dụng.
+type testMachine struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (m *testMachine) Apply(index uint64, command []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = append(m.commands, string(command))
+	return nil
+}
+
+func (m *testMachine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.commands)
+}
+
+func (m *testMachine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = nil
+	return json.Unmarshal(data, &m.commands)
+}
+
+func (m *testMachine) applied() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.commands...)
+}
+
+// raftTestNet: mạng trong RAM giữa các node Raft; node bị cô lập không gửi / nhận được gì.
+type raftTestNet struct {
+	mu       sync.Mutex
+	nodes    map[string]*raft
+	machines map[string]*testMachine
+	isolated map[string]bool
+}
+
+func newRaftTestNet() *raftTestNet {
+	return &raftTestNet{
+		nodes:    make(map[string]*raft),
+		machines: make(map[string]*testMachine),
+		isolated: make(map[string]bool),
+	}
+}
+
+// start tạo (hoặc tạo lại từ dir) và chạy node id.
+func (n *raftTestNet) start(t *testing.T, id string, voters []string, dir string, snapshotEntries int) *raft {
+	m := &testMachine{}
+	r, err := newRaft(raftOpts{
+		ID:              id,
+		Voters:          voters,
+		Dir:             dir,
+		ElectionTimeout: 100 * time.Millisecond,
+		SnapshotEntries: snapshotEntries,
+		Send: func(to string, payload any) error {
+			n.mu.Lock()
+			dst, cut := n.nodes[to], n.isolated[id] || n.isolated[to]
+			n.mu.Unlock()
+			if dst == nil || cut {
+				return fmt.Errorf("%s unreachable", to)
+			}
+			dst.step(id, payload)
+			return nil
+		},
+		StateMachine: m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.mu.Lock()
+	n.nodes[id], n.machines[id] = r, m
+	n.mu.Unlock()
+	go r.run()
+	t.Cleanup(r.stop)
+	return r
+}
+
+func (n *raftTestNet) isolate(id string, cut bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.isolated[id] = cut
+}
+
+// leader chờ tới khi có đúng 1 leader trong số các node không bị cô lập.
+func (n *raftTestNet) leader(t *testing.T) *raft {
+	var leader *raft
+	waitFor(t, "raft leader", func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		leader = nil
+		for id, r := range n.nodes {
+			if n.isolated[id] || r.Status().Role != RaftLeader.String() {
+				continue
+			}
+			if leader != nil {
+				return false
+			}
+			leader = r
+		}
+		return leader != nil
+	})
+	return leader
+}
+
+// waitApplied chờ mọi node trong ids áp dụng đúng want.
+func (n *raftTestNet) waitApplied(t *testing.T, want []string, ids ...string) {
+	for _, id := range ids {
+		m := n.machines[id]
+		waitFor(t, id+" to apply the log", func() bool { return reflect.DeepEqual(m.applied(), want) })
+	}
+}
+
+func propose(t *testing.T, r *raft, commands ...string) {
+	for _, c := range commands {
+		if _, err := r.propose([]byte(c), 2*time.Second); err != nil {
+			t.Fatalf("propose %s on %s: %v", c, r.ID, err)
+		}
+	}
+}
+
+// TestRaftReplication: 3 voter bầu được 1 leader, lệnh được áp dụng theo cùng thứ tự ở mọi node;
+// follower từ chối đề xuất và chỉ ra leader.
+func TestRaftReplication(t *testing.T) {
+	net, voters := newRaftTestNet(), []string{"a", "b", "c"}
+	for _, id := range voters {
+		net.start(t, id, voters, "", 0)
+	}
+	leader := net.leader(t)
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("cmd-%d", i))
+	}
+	propose(t, leader, want...)
+	net.waitApplied(t, want, voters...)
+
+	for _, id := range voters {
+		if r := net.nodes[id]; r != leader {
+			_, err := r.propose([]byte("x"), time.Second)
+			var nle *NotLeaderError
+			if !errors.As(err, &nle) || nle.Leader != leader.ID {
+				t.Errorf("propose on follower %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// TestRaftLeaderFailover: leader bị cô lập → 2 node còn lại bầu leader mới ở term cao hơn; lệnh ghi trên leader
+// cũ (không có đa số) không được commit và bị ghi đè khi nó quay lại.
+func TestRaftLeaderFailover(t *testing.T) {
+	net, voters := newRaftTestNet(), []string{"a", "b", "c"}
+	for _, id := range voters {
+		net.start(t, id, voters, "", 0)
+	}
+	old := net.leader(t)
+	propose(t, old, "before")
+	oldTerm := old.Status().Term
+
+	net.isolate(old.ID, true)
+	if _, err := old.propose([]byte("lost"), 300*time.Millisecond); err == nil {
+		t.Fatal("entry committed without a majority")
+	}
+	leader := net.leader(t)
+	if leader == old || leader.Status().Term <= oldTerm {
+		t.Fatalf("no new leader: %+v", leader.Status())
+	}
+	waitFor(t, "old leader to step down", func() bool { return old.Status().Role != RaftLeader.String() })
+	propose(t, leader, "after")
+
+	net.isolate(old.ID, false)
+	net.waitApplied(t, []string{"before", "after"}, voters...)
+	if have := old.Status(); have.Leader != leader.ID || have.Term != leader.Status().Term {
+		t.Errorf("old leader did not rejoin: %+v", have)
+	}
+}
+
+// TestRaftSnapshot: log được cắt sau snapshot; node tụt hậu nhận nguyên snapshot rồi theo kịp.
+func TestRaftSnapshot(t *testing.T) {
+	net, voters := newRaftTestNet(), []string{"a", "b", "c"}
+	for _, id := range voters {
+		net.start(t, id, voters, "", 5)
+	}
+	leader := net.leader(t)
+	var lagging string
+	for _, id := range voters {
+		if id != leader.ID {
+			lagging = id
+			break
+		}
+	}
+	net.isolate(lagging, true)
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("cmd-%d", i))
+	}
+	propose(t, leader, want...)
+	if st := leader.Status(); st.SnapshotIndex == 0 || st.LastIndex-st.SnapshotIndex > 5 {
+		t.Fatalf("log not compacted: %+v", st)
+	}
+
+	net.isolate(lagging, false)
+	net.waitApplied(t, want, voters...)
+	if st := net.nodes[lagging].Status(); st.SnapshotIndex == 0 {
+		t.Errorf("%s caught up without a snapshot: %+v", lagging, st)
+	}
+}
+
+// TestRaftRestart: term, snapshot và log được nạp lại từ đĩa; lệnh đã commit được áp dụng lại đúng 1 lần.
+func TestRaftRestart(t *testing.T) {
+	dir := t.TempDir()
+	net := newRaftTestNet()
+	r := net.start(t, "a", nil, dir, 5)
+	leader := net.leader(t)
+
+	var want []string
+	for i := 0; i < 12; i++ {
+		want = append(want, fmt.Sprintf("cmd-%d", i))
+	}
+	propose(t, leader, want...)
+	before := r.Status()
+	r.stop()
+
+	r = net.start(t, "a", nil, dir, 5)
+	if st := r.Status(); st.Term != before.Term || st.LastIndex != before.LastIndex || st.SnapshotIndex != before.SnapshotIndex {
+		t.Fatalf("state not restored: before %+v after %+v", before, st)
+	}
+	net.leader(t)
+	net.waitApplied(t, want, "a")
+	propose(t, r, "after restart")
+	net.waitApplied(t, append(want, "after restart"), "a")
+}
+
+// TestRaftPersistFailure: không lưu được term mới → node giữ term cũ, không bầu và không nhận entry
+// của term chưa lưu.
+func TestRaftPersistFailure(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRaft(raftOpts{ID: "a", Voters: []string{"b"}, Dir: dir, StateMachine: &testMachine{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Thư mục (không rỗng) nằm đúng chỗ file state → ghi đè bằng rename luôn lỗi
+	if err := os.MkdirAll(filepath.Join(dir, raftStateName, "blocked"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	r.step("b", MessageRaftVote{Term: 5})
+	if resp := (<-r.outbox["b"]).(MessageRaftVoteResponse); resp.Granted || resp.Term != 0 {
+		t.Errorf("vote answered in an unpersisted term: %+v", resp)
+	}
+	r.step("b", MessageRaftAppend{Term: 6, Entries: []raftEntry{{Index: 1, Term: 6}}})
+	if resp := (<-r.outbox["b"]).(MessageRaftAppendResponse); resp.Success || resp.Term != 0 {
+		t.Errorf("entries accepted without a persisted term: %+v", resp)
+	}
+	if st := r.Status(); st.Term != 0 || st.LastIndex != 0 || st.Role != RaftFollower.String() {
+		t.Errorf("term advanced in memory only: %+v", st)
+	}
+}
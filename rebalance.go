@@ -0,0 +1,476 @@
+package main
+
+import (
+	"DistributedFileStorage/p2p"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                   CHỖ ĐẶT BẢN SAO & REBALANCE KHI CỤM THAY ĐỔI              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Với ReplicationFactor = R > 0, bản sao của object (owner, key) nằm trên R member gần dhtKey nhất (XOR).
+// Member vào/ra làm tập node đó thay đổi → rebalancer của chủ namespace duyệt các key của mình:
+//   - stream bản hiện hành tới node mới được chọn (có giới hạn tốc độ), hỏi lại node đó để xác nhận;
+//   - chỉ khi mọi node được chọn đã xác nhận mới bảo các node không còn được chọn xóa bản thừa;
+//   - công bố lại provider record trên DHT.
+//
+// ReplicationFactor = 0 giữ cách cũ (mọi peer đang kết nối); rebalancer vẫn chạy được (định kỳ / admin)
+// để chép bù cho peer mới vào.
+
+const (
+	// rebalanceDelay: chờ membership ổn định (nhiều sự kiện liên tiếp) trước khi rebalance.
+	rebalanceDelay = 2 * time.Second
+	// rebalanceRequestTimeout: thời gian chờ 1 node trả lời khi xác nhận / xóa bản sao.
+	rebalanceRequestTimeout = 10 * time.Second
+)
+
+// Thông điệp “cho mình metadata của object (id, key)” (request) – dùng để xác nhận bản sao.
+type MessageStatObject struct {
+	ID  string
+	Key string
+}
+
+// Trả lời cho MessageStatObject. Found = false nếu node không giữ object.
+type MessageStatObjectResponse struct {
+	Meta  ObjectMeta
+	Found bool
+	Error string
+}
+
+// Thông điệp “xóa bản sao thừa của (id, key)” (request). Chỉ chủ namespace được gửi; bản sao chỉ bị xóa
+// nếu đúng phiên bản VersionID (bản mới hơn vừa được ghi thì giữ lại). Không để lại tombstone.
+type MessageDropReplica struct {
+	ID        string
+	Key       string
+	VersionID string
+}
+
+// Trả lời cho MessageDropReplica.
+type MessageDropReplicaResponse struct {
+	Error string
+}
+
+// clusterMembers: các node (trừ node này và node đang ngừng hoạt động) có thể giữ bản sao. Bật gossip → member
+// alive/suspect theo SWIM (Meta = địa chỉ TCP); không thì các peer đang kết nối. Sắp xếp theo ID.
+func (s *FileServer) clusterMembers() []PeerInfo {
+	s.peerLock.Lock()
+	swim := s.membership
+	s.peerLock.Unlock()
+
+	s.peerLock.Lock()
+	draining := make(map[string]bool)
+	for id := range s.draining {
+		draining[id] = true
+	}
+	s.peerLock.Unlock()
+
+	var members []PeerInfo
+	if swim == nil {
+		seen := make(map[string]bool)
+		for _, p := range s.knownPeers(s.ID) {
+			if !seen[p.ID] && !draining[p.ID] {
+				seen[p.ID] = true
+				members = append(members, p)
+			}
+		}
+	} else {
+		for _, m := range swim.Members() {
+			if m.Name == s.ID || draining[m.Name] || len(m.Meta) == 0 || (m.Status != p2p.MemberAlive && m.Status != p2p.MemberSuspect) {
+				continue
+			}
+			members = append(members, PeerInfo{ID: m.Name, Addr: listenAddrOf(m.Addr, m.Meta)})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// replicaTargets: các node nên giữ bản sao của key (namespace của node này) trong số members.
+func (s *FileServer) replicaTargets(key string, members []PeerInfo) []PeerInfo {
+	targets := append([]PeerInfo(nil), members...)
+	if s.ReplicationFactor <= 0 {
+		return targets
+	}
+	sortByDistance(dhtKey(s.ID, key), targets)
+	if len(targets) > s.ReplicationFactor {
+		targets = targets[:s.ReplicationFactor]
+	}
+	return targets
+}
+
+// placementAddrs: địa chỉ kết nối tới các node được chọn giữ bản sao của key (dial nếu cần).
+// Node không kết nối được bị bỏ qua – rebalancer sẽ chép bù sau.
+func (s *FileServer) placementAddrs(key string) []string {
+	var addrs []string
+	for _, p := range s.replicaTargets(key, s.clusterMembers()) {
+		addr, err := s.connectTo(p)
+		if err != nil {
+			log.Printf("placing replica of %s on %s: %s", key, p.Addr, err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// membersChanged: member vào/ra → hẹn 1 lượt rebalance (chỉ khi chỗ đặt phụ thuộc vào membership).
+func (s *FileServer) membersChanged() {
+	if s.ReplicationFactor > 0 {
+		s.rebalancer.schedule()
+	}
+}
+
+// Rebalance chạy ngay 1 lượt rebalance (chờ lượt đang chạy nếu có) và trả về trạng thái sau lượt đó.
+func (s *FileServer) Rebalance() RebalanceStatus {
+	s.rebalancer.pass()
+	return s.rebalancer.Status()
+}
+
+// RebalanceStatus: tiến độ và kết quả của rebalancer.
+type RebalanceStatus struct {
+	Running    bool
+	Passes     int       // số lượt đã hoàn tất
+	StartedAt  time.Time // bắt đầu lượt hiện tại (hoặc gần nhất)
+	FinishedAt time.Time // kết thúc lượt gần nhất
+	Members    int       // số node khác trong cụm lúc bắt đầu lượt
+	Checked    int       // số key đã xét trong lượt hiện tại
+	Copied     int       // số bản sao đã chép và xác nhận trong lượt hiện tại
+	Bytes      int64     // số byte đã stream trong lượt hiện tại
+	Dropped    int       // số bản thừa đã xóa trong lượt hiện tại
+	Pending    int       // số key chưa đủ bản sao xác nhận (bản thừa được giữ lại)
+	LastError  string    `json:",omitempty"`
+}
+
+// rebalancer đưa bản sao các object của node về đúng chỗ đặt theo membership hiện tại.
+type rebalancer struct {
+	s        *FileServer
+	interval time.Duration
+	rate     int64
+
+	trigger chan struct{}
+	passMu  sync.Mutex // 1 lượt tại 1 thời điểm
+
+	mu     sync.Mutex
+	status RebalanceStatus
+	timer  *time.Timer // hẹn giờ của schedule
+}
+
+func newRebalancer(s *FileServer, interval time.Duration, bytesPerSecond int64) *rebalancer {
+	return &rebalancer{s: s, interval: interval, rate: bytesPerSecond, trigger: make(chan struct{}, 1)}
+}
+
+// run chạy 1 lượt mỗi interval (nếu > 0) hoặc khi được Trigger, cho tới khi server dừng.
+func (rb *rebalancer) run() {
+	var tick <-chan time.Time
+	if rb.interval > 0 {
+		ticker := time.NewTicker(rb.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-rb.trigger:
+		case <-rb.s.quitch:
+			return
+		}
+		rb.pass()
+	}
+}
+
+// Trigger yêu cầu chạy 1 lượt ngay (không chờ nếu đã có yêu cầu đang chờ).
+func (rb *rebalancer) Trigger() {
+	select {
+	case rb.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// schedule hẹn Trigger sau rebalanceDelay; gọi lại trong lúc chờ → hẹn lại từ đầu.
+func (rb *rebalancer) schedule() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.timer != nil {
+		rb.timer.Stop()
+	}
+	rb.timer = time.AfterFunc(rebalanceDelay, rb.Trigger)
+}
+
+// Status: bản sao trạng thái hiện tại.
+func (rb *rebalancer) Status() RebalanceStatus {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.status
+}
+
+// pass duyệt mọi key trong namespace của node 1 lượt.
+func (rb *rebalancer) pass() {
+	rb.passMu.Lock()
+	defer rb.passMu.Unlock()
+
+	s := rb.s
+	members := s.clusterMembers()
+
+	rb.mu.Lock()
+	rb.status.Running, rb.status.StartedAt = true, time.Now().UTC()
+	rb.status.Members = len(members)
+	rb.status.Checked, rb.status.Copied, rb.status.Bytes, rb.status.Dropped, rb.status.Pending = 0, 0, 0, 0, 0
+	rb.status.LastError = ""
+	rb.mu.Unlock()
+
+	throttle := newThrottle(rb.rate)
+	for id, key := s.ID, ""; ; {
+		select {
+		case <-s.quitch:
+			return
+		default:
+		}
+
+		var ok bool
+		if id, key, _, ok = s.store.index.next(id, key); !ok || id != s.ID {
+			break
+		}
+		err := rb.rebalanceKey(key, members, throttle)
+
+		rb.mu.Lock()
+		rb.status.Checked++
+		if err != nil {
+			rb.status.Pending++
+			rb.status.LastError = fmt.Sprintf("%s: %s", key, err)
+		}
+		rb.mu.Unlock()
+		if err != nil {
+			log.Printf("rebalance %s: %s", key, err)
+		}
+	}
+
+	rb.mu.Lock()
+	rb.status.Running, rb.status.FinishedAt = false, time.Now().UTC()
+	rb.status.Passes++
+	log.Printf("rebalance pass %d: checked %d keys across %d members, copied %d replicas (%d bytes), dropped %d, %d pending",
+		rb.status.Passes, rb.status.Checked, rb.status.Members, rb.status.Copied, rb.status.Bytes, rb.status.Dropped, rb.status.Pending)
+	rb.mu.Unlock()
+}
+
+// rebalanceKey đưa bản sao của key về các node được chọn: chép tới node còn thiếu / giữ bản cũ, xác nhận,
+// rồi mới xóa bản ở node không còn được chọn và công bố lại provider record.
+// Lỗi → key chưa đủ bản sao xác nhận, mọi bản sao cũ được giữ nguyên.
+func (rb *rebalancer) rebalanceKey(key string, members []PeerInfo, throttle *throttle) error {
+	s := rb.s
+	meta, err := s.store.Stat(s.ID, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // vừa bị xóa
+	}
+	if err != nil {
+		return err
+	}
+
+	targets := s.replicaTargets(key, members)
+	holders := s.findProviders(s.ID, key)
+
+	var (
+		providers = []PeerInfo{s.self()}
+		chosen    = make(map[string]bool)
+		member    = make(map[string]bool)
+		failed    []string
+	)
+	for _, m := range members {
+		member[m.ID] = true
+	}
+	for _, t := range targets {
+		chosen[t.ID] = true
+		if err := rb.placeReplica(t, key, meta, throttle); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", t.Addr, err))
+			continue
+		}
+		providers = append(providers, t)
+	}
+
+	for _, h := range holders {
+		if h.ID == s.ID || chosen[h.ID] {
+			continue
+		}
+		if !member[h.ID] {
+			continue // node đã rời cụm: chỉ bỏ khỏi provider record
+		}
+		if len(failed) > 0 {
+			providers = append(providers, h) // chưa đủ bản sao mới → bản cũ vẫn phục vụ Get
+			continue
+		}
+		if err := rb.dropReplica(h, key, meta); err != nil {
+			log.Printf("rebalance: dropping replica of %s on %s: %s", key, h.Addr, err)
+			providers = append(providers, h)
+			continue
+		}
+		rb.mu.Lock()
+		rb.status.Dropped++
+		rb.mu.Unlock()
+	}
+
+	if !sameProviders(holders, providers) {
+		s.announce(s.ID, key, providers)
+		if err := s.recordLocations(key, meta, providers); err != nil {
+			log.Printf("rebalance: recording locations of %s in catalog: %s", key, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d replicas not confirmed: %v", len(failed), len(targets), failed)
+	}
+	return nil
+}
+
+// placeReplica bảo đảm node p giữ đúng phiên bản meta của key: hỏi trước, thiếu / cũ thì stream rồi hỏi lại.
+func (rb *rebalancer) placeReplica(p PeerInfo, key string, meta ObjectMeta, throttle *throttle) error {
+	s := rb.s
+	if !s.beginTransfer() {
+		return ErrShuttingDown
+	}
+	defer s.endTransfer()
+
+	addr, err := s.connectTo(p)
+	if err != nil {
+		return err
+	}
+	if ok, err := s.hasReplica(addr, s.ID, hashKey(key), meta); err != nil || ok {
+		return err
+	}
+
+	if free := s.peerFreeBytes(addr); free >= 0 && free < meta.Size+16 {
+		return fmt.Errorf("peer reports %d free bytes", free)
+	}
+	var (
+		size     int64
+		r        io.Reader
+		wireMeta = meta
+	)
+	if meta.Compression != CompressionNone && s.peerSupports(addr, meta.Compression) {
+		var rc io.ReadCloser
+		if size, rc, err = s.store.ReadRaw(s.ID, key); err != nil {
+			return err
+		}
+		defer rc.Close()
+		r = rc
+	} else {
+		wireMeta.Compression = CompressionNone
+		if size, r, err = s.store.Read(s.ID, key); err != nil {
+			return err
+		}
+		rc := r.(io.ReadCloser)
+		defer rc.Close()
+	}
+	if err := s.replicateStream(addr, key, wireMeta, size, throttle.reader(r)); err != nil {
+		return err
+	}
+
+	// Message trên cùng 1 kết nối được xử lý lần lượt → lúc trả lời, bản sao đã được ghi xong
+	ok, err := s.hasReplica(addr, s.ID, hashKey(key), meta)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("replica not confirmed after copying")
+	}
+
+	rb.mu.Lock()
+	rb.status.Copied++
+	rb.status.Bytes += size
+	rb.mu.Unlock()
+	return nil
+}
+
+// hasReplica: peer addr đang giữ đúng phiên bản meta của object (id, key) – key là key phía peer
+// (hashKey(key) với namespace của node này).
+func (s *FileServer) hasReplica(addr string, id string, key string, meta ObjectMeta) (bool, error) {
+	resp, err := s.request(addr, MessageStatObject{ID: id, Key: key}, rebalanceRequestTimeout)
+	if err != nil {
+		return false, err
+	}
+	stat, ok := resp.(MessageStatObjectResponse)
+	if !ok {
+		return false, fmt.Errorf("unexpected response %T", resp)
+	}
+	if len(stat.Error) > 0 {
+		return false, fmt.Errorf("%s", stat.Error)
+	}
+	return stat.Found && stat.Meta.VersionID == meta.VersionID && stat.Meta.Hash == meta.Hash, nil
+}
+
+// dropReplica bảo node p xóa bản sao thừa của key.
+func (rb *rebalancer) dropReplica(p PeerInfo, key string, meta ObjectMeta) error {
+	s := rb.s
+	addr, err := s.connectTo(p)
+	if err != nil {
+		return err
+	}
+	resp, err := s.request(addr, MessageDropReplica{ID: s.ID, Key: hashKey(key), VersionID: meta.VersionID}, rebalanceRequestTimeout)
+	if err != nil {
+		return err
+	}
+	if r, ok := resp.(MessageDropReplicaResponse); !ok {
+		return fmt.Errorf("unexpected response %T", resp)
+	} else if len(r.Error) > 0 {
+		return fmt.Errorf("%s", r.Error)
+	}
+	return nil
+}
+
+// sameProviders: 2 danh sách có cùng tập node ID.
+func sameProviders(a []PeerInfo, b []PeerInfo) bool {
+	ids := make(map[string]bool)
+	for _, p := range a {
+		ids[p.ID] = true
+	}
+	seen := make(map[string]bool)
+	for _, p := range b {
+		if !ids[p.ID] {
+			return false
+		}
+		seen[p.ID] = true
+	}
+	return len(seen) == len(ids)
+}
+
+// handleMessageStatObject: trả về metadata của object (id, key) nếu node này giữ.
+func (s *FileServer) handleMessageStatObject(from string, reqID uint64, msg MessageStatObject) error {
+	resp := MessageStatObjectResponse{}
+	meta, err := s.store.Stat(msg.ID, msg.Key)
+	switch {
+	case err == nil:
+		resp.Meta, resp.Found = meta, true
+	case !errors.Is(err, os.ErrNotExist):
+		resp.Error = err.Error()
+	}
+	return s.reply(from, reqID, resp)
+}
+
+// handleMessageDropReplica: chủ namespace báo bản sao ở đây đã thừa → xóa nếu vẫn là phiên bản đó.
+func (s *FileServer) handleMessageDropReplica(from string, sender string, reqID uint64, msg MessageDropReplica) error {
+	resp := MessageDropReplicaResponse{}
+	meta, err := s.store.Stat(msg.ID, msg.Key)
+	switch {
+	case msg.ID != sender:
+		resp.Error = fmt.Sprintf("node %s cannot drop replicas of namespace %s", sender, msg.ID)
+	case errors.Is(err, os.ErrNotExist):
+		// đã không còn
+	case err != nil:
+		resp.Error = err.Error()
+	case meta.VersionID != msg.VersionID:
+		resp.Error = fmt.Sprintf("holding version %s, not %s", meta.VersionID, msg.VersionID)
+	default:
+		if err := s.store.Delete(msg.ID, msg.Key); err != nil {
+			resp.Error = err.Error()
+		} else {
+			fmt.Printf("[%s] dropped surplus replica of (%s) at the request of %s\n", s.Transport.Addr(), msg.Key, from)
+		}
+	}
+	return s.reply(from, reqID, resp)
+}
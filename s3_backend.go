@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                      S3 BACKEND (S3 REST + SIGV4)                           //
+////////////////////////////////////////////////////////////////////////////////
+
+// defaultS3PartSize: object lớn hơn mức này được upload bằng multipart (S3 yêu cầu part >= 5MB, trừ part cuối).
+const defaultS3PartSize = 8 << 20
+
+// emptyPayloadHash: SHA-256 của body rỗng (dùng cho GET/HEAD/DELETE).
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Opts: cấu hình kết nối tới 1 dịch vụ tương thích S3 (AWS, MinIO, Ceph RGW, ...).
+//   - Endpoint : ví dụ "https://s3.eu-west-1.amazonaws.com" hoặc "http://127.0.0.1:9000".
+//   - Prefix   : tiền tố thêm vào trước mọi key (nhiều node dùng chung 1 bucket).
+//   - PartSize : kích thước mỗi part khi upload multipart (<= 0 → 8MB).
+//
+// Luôn dùng path-style URL: <Endpoint>/<Bucket>/<key>.
+type S3Opts struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+	PartSize  int64
+	Client    *http.Client
+}
+
+// S3Backend lưu object vào bucket S3: mỗi path của Store là 1 object key.
+type S3Backend struct {
+	S3Opts
+}
+
+func NewS3Backend(opts S3Opts) *S3Backend {
+	opts.Endpoint = strings.TrimRight(opts.Endpoint, "/")
+	if len(opts.Region) == 0 {
+		opts.Region = "us-east-1"
+	}
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultS3PartSize
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &S3Backend{S3Opts: opts}
+}
+
+// S3Error: lỗi do dịch vụ S3 trả về.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Put: object nhỏ hơn PartSize → 1 lệnh PUT; lớn hơn → multipart upload.
+// Object chỉ xuất hiện trong bucket khi upload hoàn tất nên Put vẫn là nguyên tử.
+func (b *S3Backend) Put(path string, r io.Reader) (int64, error) {
+	// Buffer lớn dần theo dữ liệu: object nhỏ không phải cấp phát trọn PartSize.
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, b.PartSize)
+	if err == io.EOF {
+		resp, err := b.do(http.MethodPut, path, nil, buf.Bytes(), nil)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return n, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return b.putMultipart(path, buf.Bytes(), r)
+}
+
+// putMultipart: khởi tạo upload → upload từng part → complete. Lỗi → abort để S3 dọn part dở.
+func (b *S3Backend) putMultipart(path string, first []byte, r io.Reader) (total int64, err error) {
+	var initiated struct {
+		UploadId string
+	}
+	if err := b.doXML(http.MethodPost, path, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return 0, err
+	}
+	uploadID := initiated.UploadId
+	defer func() {
+		if err != nil {
+			if resp, aerr := b.do(http.MethodDelete, path, url.Values{"uploadId": {uploadID}}, nil, nil); aerr == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []part
+	chunk := first
+	for {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(len(parts) + 1)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := b.do(http.MethodPut, path, query, chunk, nil)
+		if err != nil {
+			return total, err
+		}
+		resp.Body.Close()
+		parts = append(parts, part{PartNumber: len(parts) + 1, ETag: resp.Header.Get("ETag")})
+		total += int64(len(chunk))
+
+		n, err := io.ReadFull(r, first)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
+		chunk = first[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return total, err
+	}
+	// CompleteMultipartUpload có thể trả 200 kèm <Error> trong body → doXML xử lý
+	return total, b.doXML(http.MethodPost, path, url.Values{"uploadId": {uploadID}}, body, nil)
+}
+
+func (b *S3Backend) Get(path string) (int64, io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, path, nil, nil, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.ContentLength, resp.Body, nil
+}
+
+// GetRange đọc length byte bắt đầu từ offset (ranged GET) – không phải tải cả object.
+func (b *S3Backend) GetRange(path string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+	resp, err := b.do(http.MethodGet, path, nil, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *S3Backend) Has(path string) bool {
+	_, err := b.Stat(path)
+	return err == nil
+}
+
+func (b *S3Backend) Delete(path string) error {
+	resp, err := b.do(http.MethodDelete, path, nil, nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List dùng ListObjectsV2, lặp qua continuation token cho tới hết.
+func (b *S3Backend) List(prefix string) ([]string, error) {
+	var (
+		paths []string
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.Prefix + prefix}}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := b.doXML(http.MethodGet, "", query, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Contents {
+			paths = append(paths, strings.TrimPrefix(obj.Key, b.Prefix))
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (b *S3Backend) Stat(path string) (ObjectInfo, error) {
+	resp, err := b.do(http.MethodHead, path, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Size: resp.ContentLength, ModTime: modTime.UTC()}, nil
+}
+
+// Clear xóa mọi object dưới Prefix (không xóa bucket).
+func (b *S3Backend) Clear() error {
+	paths, err := b.List("")
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := b.Delete(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do gửi 1 request đã ký. path rỗng → thao tác trên bucket (ví dụ List).
+// Status >= 300 → đọc body lỗi và trả về *S3Error (404 → lỗi bọc os.ErrNotExist).
+func (b *S3Backend) do(method, path string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u := b.Endpoint + "/" + s3Escape(b.Bucket, true) + "/"
+	if len(path) > 0 {
+		u += s3Escape(b.Prefix+path, false)
+	}
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	signS3Request(req, payloadHash, b.AccessKey, b.SecretKey, b.Region, time.Now())
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notExist(strings.ToLower(method), path)
+	}
+	s3err := &S3Error{StatusCode: resp.StatusCode}
+	xml.NewDecoder(io.LimitReader(resp.Body, maxMetaSize)).Decode(s3err)
+	return nil, s3err
+}
+
+// doXML: do + giải mã body XML vào out (out nil → bỏ qua body). Body là <Error> → *S3Error.
+func (b *S3Backend) doXML(method, path string, query url.Values, body []byte, out interface{}) error {
+	resp, err := b.do(method, path, query, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3err := &S3Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(data, s3err)
+		return s3err
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                          AWS SIGNATURE VERSION 4                            //
+////////////////////////////////////////////////////////////////////////////////
+
+// signS3Request ký req theo SigV4 (service "s3"): thêm x-amz-date, x-amz-content-sha256
+// và Authorization. Mọi header đang có trong req (cùng với host) đều được ký.
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	signedHeaders, signature := s3Signature(req, payloadHash, secretKey, region, amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// s3Signature tính chữ ký SigV4 của req (mọi header trừ Authorization + host).
+// Trả về danh sách header đã ký và chữ ký (hex).
+func s3Signature(req *http.Request, payloadHash, secretKey, region, amzDate string) (string, string) {
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if name == "authorization" || name == "user-agent" || name == "accept-encoding" || name == "content-length" {
+			continue
+		}
+		headers[name] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery: query string đã sắp xếp theo key, mã hóa theo quy tắc của SigV4.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape: URI-encode theo SigV4 – giữ nguyên A-Z a-z 0-9 - _ . ~ (và '/' nếu encodeSlash = false).
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                   KIỂM TRA TOÀN VẸN OBJECT (STORE)                          //
+////////////////////////////////////////////////////////////////////////////////
+
+// quarantineDirName: object hỏng được chuyển vào "<quarantine>/<id>/<location>" trong backend
+// (thư mục ẩn → không bị index/List coi là namespace).
+const quarantineDirName = ".quarantine"
+
+// ErrCorrupted: bytes trong backend không còn khớp với size/hash đã ghi trong index.
+var ErrCorrupted = errors.New("object corrupted")
+
+// ErrObjectChanged: object đã được ghi lại (Version/Location khác) kể từ lúc được kiểm tra.
+var ErrObjectChanged = errors.New("object changed since it was verified")
+
+// CorruptionError: sai lệch Verify tìm thấy ở phiên bản Version của object (errors.Is(err, ErrCorrupted)).
+type CorruptionError struct {
+	ID, Key            string
+	Version            uint64
+	WantSize, HaveSize int64
+	WantHash, HaveHash string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s/%s: %s (want %d bytes sha256 %s, have %d bytes sha256 %s)",
+		e.ID, e.Key, ErrCorrupted, e.WantSize, e.WantHash, e.HaveSize, e.HaveHash)
+}
+
+func (e *CorruptionError) Unwrap() error { return ErrCorrupted }
+
+// Verify đọc lại toàn bộ bytes của object và so với size + SHA-256 trong index.
+// throttle (có thể nil) giới hạn tốc độ đọc. Trả về số byte đã đọc; không khớp → *CorruptionError.
+// Object được ghi lại trong lúc đang đọc (bytes mới, entry cũ) không bị coi là hỏng.
+func (s *Store) Verify(id string, key string, throttle *throttle) (int64, error) {
+	entry, ok := s.index.get(id, key)
+	if !ok {
+		return 0, fmt.Errorf("verify %s: %w", key, os.ErrNotExist)
+	}
+	_, r, err := s.readStream(id, key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, throttle.reader(r))
+	if err != nil {
+		return n, err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if n == entry.Size && sum == entry.Hash {
+		return n, nil
+	}
+	// Lần ghi mới có thể đã thay bytes giữa lúc đọc index và lúc hash xong → đọc lại entry
+	now, ok := s.index.get(id, key)
+	if !ok {
+		return n, fmt.Errorf("verify %s: %w", key, os.ErrNotExist)
+	}
+	if now.Version != entry.Version || now.Location != entry.Location {
+		return n, nil // bản mới sẽ được kiểm tra ở lượt sau
+	}
+	return n, &CorruptionError{ID: id, Key: key, Version: entry.Version,
+		WantSize: entry.Size, HaveSize: n, WantHash: entry.Hash, HaveHash: sum}
+}
+
+// Quarantine chuyển phiên bản version của object (dữ liệu + sidecar) vào thư mục cách ly rồi xóa
+// khỏi index. Bytes được giữ lại để điều tra, nhưng Has/Read coi như object không còn. Trả về đường dẫn
+// cách ly. Object đã được ghi lại (Version/Location khác) → ErrObjectChanged, không đụng gì.
+func (s *Store) Quarantine(id string, key string, version uint64) (string, error) {
+	path, ok := s.lookup(id, key)
+	if !ok {
+		return "", fmt.Errorf("quarantine %s: %w", key, os.ErrNotExist)
+	}
+	entry, _ := s.index.get(id, key)
+	if entry.Version != version {
+		return "", fmt.Errorf("quarantine %s: %w", key, ErrObjectChanged)
+	}
+	dst := quarantineDirName + "/" + path
+
+	for _, suffix := range []string{"", metaFileSuffix} {
+		_, r, err := s.backend.Get(path + suffix)
+		if errors.Is(err, os.ErrNotExist) && len(suffix) > 0 {
+			continue // object cũ không có sidecar
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = s.backend.Put(dst+suffix, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	// Kiểm tra lại ngay trước khi xóa: lần ghi xen vào lúc đang sao chép phải được giữ nguyên
+	if now, ok := s.index.get(id, key); !ok || now.Version != version || now.Location != entry.Location {
+		for _, p := range []string{dst + metaFileSuffix, dst} {
+			s.backend.Delete(p)
+		}
+		return "", fmt.Errorf("quarantine %s: %w", key, ErrObjectChanged)
+	}
+	// Xóa sidecar trước (giống Delete)
+	for _, p := range []string{path + metaFileSuffix, path} {
+		if err := s.backend.Delete(p); err != nil {
+			return "", err
+		}
+	}
+	log.Printf("quarantined [%s] to %s", pathBase(path), dst)
+
+	return dst, s.index.remove(id, key)
+}
+
+// throttle giới hạn tốc độ đọc (byte/giây) dùng chung cho nhiều reader. nil → không giới hạn.
+type throttle struct {
+	rate int64
+
+	mu    sync.Mutex
+	start time.Time
+	n     int64
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &throttle{rate: bytesPerSecond, start: time.Now()}
+}
+
+func (t *throttle) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
+
+// wait ghi nhận n byte vừa đọc và ngủ cho tới khi tốc độ trung bình về dưới giới hạn.
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	t.mu.Unlock()
+
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// Đọc từng đoạn nhỏ để không "vượt" giới hạn quá xa trong 1 lần Read
+	if max := int(r.t.rate); len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.r.Read(p)
+	r.t.wait(n)
+	return n, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                        SCRUBBER CHẠY NỀN (FILESERVER)                       //
+////////////////////////////////////////////////////////////////////////////////
+
+// maxScrubFindings: số phát hiện gần nhất được giữ lại để xem qua admin API.
+const maxScrubFindings = 100
+
+// ScrubFinding: 1 object hỏng mà scrubber tìm thấy và kết quả xử lý.
+type ScrubFinding struct {
+	Time       time.Time
+	ID         string
+	Key        string
+	Error      string // mô tả sai lệch (size/hash mong đợi và thực tế)
+	Quarantine string // đường dẫn cách ly trong backend (rỗng nếu không cách ly được)
+	Repaired   bool   // đã lấy được bản tốt từ peer
+	RepairedBy string // địa chỉ peer đã cung cấp bản tốt
+	RepairErr  string `json:",omitempty"`
+
+	Unrepairable bool `json:",omitempty"` // lớn hơn maxRepairSize → không xin được bản tốt qua message
+}
+
+// ScrubStatus: tiến độ và kết quả của scrubber.
+type ScrubStatus struct {
+	Running    bool
+	Passes     int       // số lượt quét đã hoàn tất
+	StartedAt  time.Time // bắt đầu lượt quét hiện tại (hoặc gần nhất)
+	FinishedAt time.Time // kết thúc lượt quét gần nhất
+	Scanned    int       // số object đã kiểm tra trong lượt hiện tại
+	Bytes      int64     // số byte đã đọc trong lượt hiện tại
+	Corrupted  int       // tổng số object hỏng tìm thấy (mọi lượt)
+	Repaired   int       // tổng số object đã sửa từ peer
+	// Unrepairable: tổng số object hỏng quá lớn để sửa tự động (cần khôi phục thủ công)
+	Unrepairable int
+	Findings     []ScrubFinding
+}
+
+// scrubber duyệt toàn bộ Store theo index, tính lại hash của từng object (có giới hạn tốc độ),
+// cách ly object hỏng và xin bản tốt từ peers.
+type scrubber struct {
+	s        *FileServer
+	interval time.Duration
+	rate     int64
+
+	trigger chan struct{}
+	passMu  sync.Mutex // 1 lượt quét tại 1 thời điểm
+
+	mu     sync.Mutex
+	status ScrubStatus
+}
+
+func newScrubber(s *FileServer, interval time.Duration, bytesPerSecond int64) *scrubber {
+	return &scrubber{s: s, interval: interval, rate: bytesPerSecond, trigger: make(chan struct{}, 1)}
+}
+
+// run quét định kỳ (mỗi interval, hoặc ngay khi được Trigger) cho tới khi server dừng.
+func (sc *scrubber) run() {
+	for {
+		select {
+		case <-time.After(sc.interval):
+		case <-sc.trigger:
+		case <-sc.s.quitch:
+			return
+		}
+		sc.pass()
+	}
+}
+
+// Trigger yêu cầu chạy 1 lượt quét ngay (không chờ nếu đã có yêu cầu đang chờ).
+// Scrubber không chạy định kỳ (interval <= 0) thì lượt quét chạy trong goroutine riêng.
+func (sc *scrubber) Trigger() {
+	if sc.interval <= 0 {
+		go sc.pass()
+		return
+	}
+	select {
+	case sc.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status: bản sao trạng thái hiện tại.
+func (sc *scrubber) Status() ScrubStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	status := sc.status
+	status.Findings = append([]ScrubFinding(nil), sc.status.Findings...)
+	return status
+}
+
+// pass quét toàn bộ Store 1 lượt.
+func (sc *scrubber) pass() {
+	sc.passMu.Lock()
+	defer sc.passMu.Unlock()
+
+	sc.mu.Lock()
+	sc.status.Running, sc.status.StartedAt = true, time.Now().UTC()
+	sc.status.Scanned, sc.status.Bytes = 0, 0
+	sc.mu.Unlock()
+
+	var (
+		store    = sc.s.store
+		throttle = newThrottle(sc.rate)
+		id, key  string
+	)
+	for {
+		select {
+		case <-sc.s.quitch:
+			return
+		default:
+		}
+
+		var ok bool
+		if id, key, _, ok = store.index.next(id, key); !ok {
+			break
+		}
+		n, err := store.Verify(id, key, throttle)
+
+		sc.mu.Lock()
+		sc.status.Scanned++
+		sc.status.Bytes += n
+		sc.mu.Unlock()
+
+		var corrupt *CorruptionError
+		if errors.As(err, &corrupt) {
+			sc.handleCorrupted(corrupt)
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("scrub %s/%s: %s", id, key, err)
+		}
+	}
+
+	sc.mu.Lock()
+	sc.status.Running, sc.status.FinishedAt = false, time.Now().UTC()
+	sc.status.Passes++
+	log.Printf("scrub pass %d: checked %d objects (%d bytes), %d corrupted so far",
+		sc.status.Passes, sc.status.Scanned, sc.status.Bytes, sc.status.Corrupted)
+	sc.mu.Unlock()
+}
+
+// handleCorrupted cách ly object hỏng, thử lấy bản tốt từ peers và ghi lại kết quả.
+func (sc *scrubber) handleCorrupted(cause *CorruptionError) {
+	id, key := cause.ID, cause.Key
+	meta, _ := sc.s.store.Stat(id, key)
+	quarantine, err := sc.s.store.Quarantine(id, key, cause.Version)
+	if errors.Is(err, ErrObjectChanged) || errors.Is(err, os.ErrNotExist) {
+		return // đã được ghi lại/xóa sau khi kiểm tra → không còn là bản hỏng
+	}
+	log.Printf("scrub: %s", cause)
+	finding := ScrubFinding{Time: time.Now().UTC(), ID: id, Key: key, Error: cause.Error()}
+
+	switch {
+	case err != nil:
+		finding.RepairErr = fmt.Sprintf("quarantine: %s", err)
+	case cause.WantSize > maxRepairSize:
+		finding.Quarantine, finding.Unrepairable = quarantine, true
+		finding.RepairErr = fmt.Sprintf("object too large to repair from peers (%d bytes > %d)", cause.WantSize, maxRepairSize)
+	default:
+		finding.Quarantine = quarantine
+		if addr, err := sc.s.repairObject(id, key, meta); err != nil {
+			finding.RepairErr = err.Error()
+		} else {
+			finding.Repaired, finding.RepairedBy = true, addr
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.status.Corrupted++
+	if finding.Repaired {
+		sc.status.Repaired++
+	}
+	if finding.Unrepairable {
+		sc.status.Unrepairable++
+	}
+	sc.status.Findings = append(sc.status.Findings, finding)
+	if len(sc.status.Findings) > maxScrubFindings {
+		sc.status.Findings = sc.status.Findings[len(sc.status.Findings)-maxScrubFindings:]
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                       SỬA OBJECT TỪ BẢN SAO TRÊN PEERS                      //
+////////////////////////////////////////////////////////////////////////////////
+
+// repairRequestTimeout: thời gian tối đa chờ 1 peer trả về bản sao.
+const repairRequestTimeout = 10 * time.Second
+
+// maxRepairSize: object lớn hơn không vừa 1 message (xem p2p.MaxMessageSize).
+const maxRepairSize = 15 << 20
+
+// Thông điệp “gửi mình bytes nguyên trạng của object (id, key)” (request, cần RequestID).
+// Chỉ dùng để sửa object hỏng nên dữ liệu đi kèm trong message trả lời, không qua stream.
+type MessageGetObject struct {
+	ID  string
+	Key string
+}
+
+// Trả lời cho MessageGetObject. Bên trả lời tự kiểm tra bản của mình trước khi gửi.
+type MessageObjectData struct {
+	Meta  ObjectMeta
+	Data  []byte
+	Error string
+}
+
+// repairObject xin bản tốt của object (id, key) từ lần lượt từng peer và ghi lại vào Store.
+//   - Namespace của chính node: peers giữ bản đã mã hóa dưới hashKey(key) → giải mã bằng EncKey.
+//   - Namespace khác (bản sao giữ hộ): xin đúng (id, key) và ghi nguyên trạng.
+func (s *FileServer) repairObject(id string, key string, meta ObjectMeta) (string, error) {
+	remoteKey := key
+	if id == s.ID {
+		remoteKey = hashKey(key)
+	}
+
+	var lastErr error = fmt.Errorf("no peers")
+	for _, addr := range s.peerAddrs() {
+		resp, err := s.request(addr, MessageGetObject{ID: id, Key: remoteKey}, repairRequestTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		data, ok := resp.(MessageObjectData)
+		if !ok || len(data.Error) > 0 {
+			lastErr = fmt.Errorf("%s: %s", addr, data.Error)
+			continue
+		}
+		if len(data.Meta.Hash) == 0 {
+			data.Meta = meta
+		}
+
+		r := bytes.NewReader(data.Data)
+		if id == s.ID {
+			_, err = s.store.WriteDecryptWithMeta(s.EncKey, id, key, data.Meta, r)
+		} else {
+			_, err = s.store.WriteWithMeta(id, key, data.Meta, r)
+		}
+		if err != nil {
+			return "", err
+		}
+		log.Printf("scrub: repaired %s/%s from %s", id, key, addr)
+		return addr, nil
+	}
+	return "", fmt.Errorf("no peer has a good copy: %w", lastErr)
+}
+
+// handleMessageGetObject: peer xin bản của object để sửa bản hỏng của họ.
+// Bản của mình cũng được kiểm tra trước khi gửi để không lan truyền dữ liệu hỏng.
+func (s *FileServer) handleMessageGetObject(from string, reqID uint64, msg MessageGetObject) error {
+	resp := MessageObjectData{}
+	if n, err := s.store.Verify(msg.ID, msg.Key, nil); err != nil {
+		resp.Error = err.Error()
+	} else if n > maxRepairSize {
+		resp.Error = fmt.Sprintf("object too large to send in a message (%d bytes)", n)
+	} else if !s.peerSupports(from, s.statCompression(msg.ID, msg.Key)) {
+		resp.Error = "peer does not support the object's compression"
+	} else {
+		resp.Meta, _ = s.store.Stat(msg.ID, msg.Key)
+		if _, r, err := s.store.ReadRaw(msg.ID, msg.Key); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Data, err = io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		}
+	}
+	return s.reply(from, reqID, resp)
+}
+
+// statCompression: thuật toán nén của object (CompressionNone nếu không đọc được metadata).
+func (s *FileServer) statCompression(id string, key string) Compression {
+	meta, _ := s.store.Stat(id, key)
+	return meta.Compression
+}
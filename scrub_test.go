@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStoreVerifyQuarantine: Verify phát hiện bytes bị hỏng, Quarantine chuyển object sang
+// .quarantine và xóa khỏi index (kể cả sau khi dựng lại index).
+func TestStoreVerifyQuarantine(t *testing.T) {
+	backend := NewMemoryBackend()
+	s := NewStore(StoreOpts{Root: t.TempDir(), PathTransformFunc: CASPathTransformFunc, Backend: backend})
+	id := generateID()
+	s.Write(id, "good", bytes.NewReader([]byte("good bytes")))
+	s.Write(id, "bad", bytes.NewReader([]byte("soon to rot")))
+
+	path, _ := s.lookup(id, "bad")
+	backend.Put(path, bytes.NewReader([]byte("soon to r0t")))
+
+	if _, err := s.Verify(id, "good", nil); err != nil {
+		t.Errorf("unexpected error for intact object: %v", err)
+	}
+	_, err := s.Verify(id, "bad", newThrottle(1<<20))
+	var corrupt *CorruptionError
+	if !errors.Is(err, ErrCorrupted) || !errors.As(err, &corrupt) {
+		t.Fatalf("expected ErrCorrupted, have %v", err)
+	}
+
+	if _, err := s.Quarantine(id, "bad", corrupt.Version+1); !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("expected ErrObjectChanged for a stale version, have %v", err)
+	}
+	dst, err := s.Quarantine(id, "bad", corrupt.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id, "bad") || !backend.Has(dst) || !backend.Has(dst+metaFileSuffix) {
+		t.Errorf("expected object moved to %s", dst)
+	}
+	if err := s.RebuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id, "bad") || !s.Has(id, "good") {
+		t.Errorf("quarantined object came back after index rebuild")
+	}
+}
+
+// rewriteOnGet: backend ghi lại object ngay khi Verify bắt đầu đọc (lần ghi xen giữa đọc index và hash).
+type rewriteOnGet struct {
+	StorageBackend
+	rewrite func() // chạy 1 lần ở lần Get kế tiếp
+}
+
+func (b *rewriteOnGet) Get(path string) (int64, io.ReadCloser, error) {
+	if rewrite := b.rewrite; rewrite != nil {
+		b.rewrite = nil
+		rewrite()
+	}
+	return b.StorageBackend.Get(path)
+}
+
+// TestStoreVerifyConcurrentWrite: object được ghi lại trong lúc Verify đang đọc không bị coi là hỏng.
+func TestStoreVerifyConcurrentWrite(t *testing.T) {
+	backend := &rewriteOnGet{StorageBackend: NewMemoryBackend()}
+	s := NewStore(StoreOpts{Root: t.TempDir(), PathTransformFunc: CASPathTransformFunc, Backend: backend})
+	id := generateID()
+	s.Write(id, "hot", bytes.NewReader([]byte("first version")))
+	backend.rewrite = func() {
+		if _, err := s.Write(id, "hot", bytes.NewReader([]byte("second, longer version"))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := s.Verify(id, "hot", nil); err != nil {
+		t.Errorf("fresh object reported as corrupt: %v", err)
+	}
+	if _, err := s.Verify(id, "hot", nil); err != nil {
+		t.Errorf("unexpected error on second pass: %v", err)
+	}
+}
+
+// TestThrottle: đọc 2KB với giới hạn 8KB/s phải mất khoảng 250ms.
+func TestThrottle(t *testing.T) {
+	start := time.Now()
+	io.Copy(io.Discard, newThrottle(8<<10).reader(bytes.NewReader(make([]byte, 2<<10))))
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("throttle too fast: %s", elapsed)
+	}
+}
+
+// TestScrubberRepair: bản local bị hỏng được cách ly và sửa lại từ bản sao (đã mã hóa) trên peer;
+// kết quả xem được qua admin API.
+func TestScrubberRepair(t *testing.T) {
+	s1, s2 := newTestCluster(t)
+
+	data := []byte("precious data that must survive bit rot")
+	if err := s1.Store("precious.txt", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replication", func() bool { return s2.store.Has(s1.ID, hashKey("precious.txt")) })
+
+	path, _ := s1.store.lookup(s1.ID, "precious.txt")
+	s1.store.backend.Put(path, bytes.NewReader([]byte("precious data that must survive bit r0t")))
+
+	s1.scrubber.pass()
+
+	status := s1.scrubber.Status()
+	if status.Corrupted != 1 || status.Repaired != 1 || len(status.Findings) != 1 {
+		t.Fatalf("unexpected scrub status %+v", status)
+	}
+	if f := status.Findings[0]; f.Key != "precious.txt" || len(f.Quarantine) == 0 {
+		t.Errorf("unexpected finding %+v", f)
+	}
+	_, r, err := s1.store.Read(s1.ID, "precious.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := io.ReadAll(r); !bytes.Equal(have, data) {
+		t.Errorf("want %q have %q", data, have)
+	}
+
+	srv := httptest.NewServer(s1.adminHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/scrub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var have ScrubStatus
+	if err := json.NewDecoder(resp.Body).Decode(&have); err != nil {
+		t.Fatal(err)
+	}
+	if have.Passes != 1 || have.Repaired != 1 || len(have.Findings) != 1 {
+		t.Errorf("unexpected admin response %+v", have)
+	}
+}
+
+// TestScrubberUnrepairable: object hỏng lớn hơn maxRepairSize vẫn bị cách ly nhưng được báo là
+// không sửa được (không xin peer qua message).
+func TestScrubberUnrepairable(t *testing.T) {
+	s1, _ := newTestCluster(t)
+	id := generateID()
+
+	large := bytes.Repeat([]byte{0x5a}, maxRepairSize+1)
+	if _, err := s1.store.Write(id, "huge.bin", bytes.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := s1.store.lookup(id, "huge.bin")
+	large[0] = 0xa5
+	s1.store.backend.Put(path, bytes.NewReader(large))
+
+	s1.scrubber.pass()
+
+	status := s1.scrubber.Status()
+	if status.Corrupted != 1 || status.Unrepairable != 1 || status.Repaired != 0 || len(status.Findings) != 1 {
+		t.Fatalf("unexpected scrub status %+v", status)
+	}
+	if f := status.Findings[0]; !f.Unrepairable || len(f.Quarantine) == 0 || len(f.RepairErr) == 0 {
+		t.Errorf("unexpected finding %+v", f)
+	}
+	if s1.store.Has(id, "huge.bin") {
+		t.Errorf("corrupt object still served")
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//              SEGMENT BACKEND (LOG-STRUCTURED, NHIỀU FILE + COMPACTION)      //
+////////////////////////////////////////////////////////////////////////////////
+
+// segmentFileSuffix: mỗi segment là 1 file "<id 8 chữ số>.seg" trong Dir.
+const segmentFileSuffix = ".seg"
+
+// SegmentOpts: cấu hình cho SegmentBackend.
+//   - Dir            : thư mục chứa các file segment.
+//   - SegmentSize    : segment đang ghi vượt quá mức này thì chuyển sang segment mới (<= 0 → 64MB).
+//   - CompactRatio   : segment có tỉ lệ byte "chết" (bị ghi đè/xóa) >= mức này thì được compact (<= 0 → 0.5).
+//   - CompactInterval: chu kỳ chạy compaction nền (0 → không chạy nền, gọi Compact bằng tay).
+type SegmentOpts struct {
+	Dir             string
+	SegmentSize     int64
+	CompactRatio    float64
+	CompactInterval time.Duration
+}
+
+// SegmentBackend: biến thể nhiều file của PackedBackend (cùng định dạng record).
+// Object nhỏ được append vào segment đang ghi; segment cũ chỉ còn để đọc và được
+// compaction chép phần còn sống sang segment mới rồi xóa đi → thu hồi dung lượng.
+// Bảng vị trí (path → segment/offset) nằm trong RAM, dựng lại khi mở bằng cách đọc các segment theo thứ tự.
+type SegmentBackend struct {
+	SegmentOpts
+
+	wmu sync.Mutex // 1 writer (Put/Delete/Compact) tại 1 thời điểm
+
+	mu         sync.RWMutex
+	segments   map[uint32]*segment
+	active     *segment
+	entries    map[string]segmentEntry
+	tombstones map[string]uint32 // path → segment chứa record xóa mới nhất
+
+	quitch chan struct{}
+	wg     sync.WaitGroup
+}
+
+// segment: 1 file segment.
+//   - live : tổng byte của các record còn được index trỏ tới.
+//   - refs : số reader đang mở – segment đã bị compact chỉ đóng file khi refs về 0.
+type segment struct {
+	id      uint32
+	f       *os.File
+	size    int64
+	live    int64
+	refs    int32
+	removed int32
+}
+
+// segmentEntry: vị trí object + segment chứa nó + độ dài cả record (để tính byte chết).
+type segmentEntry struct {
+	packEntry
+	seg    uint32
+	recLen int64
+}
+
+// NewSegmentBackend mở (hoặc tạo) các segment trong opts.Dir.
+func NewSegmentBackend(opts SegmentOpts) (*SegmentBackend, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+	if opts.CompactRatio <= 0 {
+		opts.CompactRatio = 0.5
+	}
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	b := &SegmentBackend{
+		SegmentOpts: opts,
+		segments:    make(map[uint32]*segment),
+		entries:     make(map[string]segmentEntry),
+		tombstones:  make(map[string]uint32),
+		quitch:      make(chan struct{}),
+	}
+	if err := b.load(); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	if opts.CompactInterval > 0 {
+		b.wg.Add(1)
+		go b.compactLoop()
+	}
+	return b, nil
+}
+
+// load mở mọi segment theo thứ tự id và replay record; segment cuối cùng là segment đang ghi.
+func (b *SegmentBackend) load() error {
+	names, err := filepath.Glob(filepath.Join(b.Dir, "*"+segmentFileSuffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var id uint32
+		if _, err := fmt.Sscanf(filepath.Base(name), "%08d"+segmentFileSuffix, &id); err != nil {
+			continue
+		}
+		f, err := os.OpenFile(name, os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		seg := &segment{id: id, f: f}
+		b.segments[id] = seg
+		seg.size, err = scanPackFile(f, func(op byte, path string, entry packEntry, n int64) {
+			b.apply(seg, op, path, entry, n)
+		})
+		if err != nil {
+			return err
+		}
+		b.active = seg
+	}
+
+	if b.active == nil {
+		return b.roll()
+	}
+	return nil
+}
+
+// apply cập nhật bảng vị trí + số byte sống sau khi 1 record được ghi vào seg. Gọi khi giữ mu (hoặc lúc load).
+func (b *SegmentBackend) apply(seg *segment, op byte, path string, entry packEntry, n int64) {
+	if old, ok := b.entries[path]; ok {
+		if s, ok := b.segments[old.seg]; ok {
+			s.live -= old.recLen
+		}
+	}
+	if op == packOpPut {
+		b.entries[path] = segmentEntry{packEntry: entry, seg: seg.id, recLen: n}
+		delete(b.tombstones, path)
+		seg.live += n
+		return
+	}
+	delete(b.entries, path)
+	b.tombstones[path] = seg.id
+}
+
+// roll tạo segment mới làm segment đang ghi. Gọi khi giữ wmu (hoặc lúc load).
+func (b *SegmentBackend) roll() error {
+	var id uint32
+	if b.active != nil {
+		id = b.active.id + 1
+	}
+	f, err := os.OpenFile(b.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(b.Dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	seg := &segment{id: id, f: f}
+	b.mu.Lock()
+	b.segments[id] = seg
+	b.active = seg
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *SegmentBackend) segmentPath(id uint32) string {
+	return filepath.Join(b.Dir, fmt.Sprintf("%08d%s", id, segmentFileSuffix))
+}
+
+// append ghi 1 record vào segment đang ghi (chuyển segment mới nếu đã đầy). Gọi khi giữ wmu.
+func (b *SegmentBackend) append(op byte, path string, modTime time.Time, r io.Reader) error {
+	if len(path) > maxPackPath {
+		return fmt.Errorf("path too long (%d bytes)", len(path))
+	}
+	if b.active.size >= b.SegmentSize {
+		if err := b.roll(); err != nil {
+			return err
+		}
+	}
+
+	seg, start := b.active, b.active.size
+	header := packHeader(op, path, modTime, packPending)
+	n, end, err := appendPackRecord(seg.f, start, header, r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	seg.size = end
+	b.apply(seg, op, path, packEntry{offset: start + int64(len(header)), size: n, modTime: modTime}, end-start)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *SegmentBackend) Put(path string, r io.Reader) (int64, error) {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	counter := &countingReader{r: r}
+	if err := b.append(packOpPut, path, time.Now().UTC(), counter); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// Delete append record xóa (tombstone) vào segment đang ghi.
+func (b *SegmentBackend) Delete(path string) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	if !b.Has(path) {
+		return nil
+	}
+	return b.append(packOpDelete, path, time.Now().UTC(), strings.NewReader(""))
+}
+
+// Get trả về reader trên đúng đoạn data trong segment. Segment bị compact trong lúc đang đọc
+// vẫn đọc được (file chỉ bị đóng khi reader cuối cùng Close).
+func (b *SegmentBackend) Get(path string) (int64, io.ReadCloser, error) {
+	b.mu.RLock()
+	entry, ok := b.entries[path]
+	var seg *segment
+	if ok {
+		seg = b.segments[entry.seg]
+		atomic.AddInt32(&seg.refs, 1)
+	}
+	b.mu.RUnlock()
+	if !ok {
+		return 0, nil, notExist("get", path)
+	}
+
+	return entry.size, &segmentReader{
+		SectionReader: io.NewSectionReader(seg.f, entry.offset, entry.size),
+		seg:           seg,
+	}, nil
+}
+
+func (b *SegmentBackend) Has(path string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.entries[path]
+	return ok
+}
+
+func (b *SegmentBackend) List(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var paths []string
+	for path := range b.entries {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (b *SegmentBackend) Stat(path string) (ObjectInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.entries[path]
+	if !ok {
+		return ObjectInfo{}, notExist("stat", path)
+	}
+	return ObjectInfo{Size: entry.size, ModTime: entry.modTime}, nil
+}
+
+// Clear xóa mọi segment và bắt đầu lại từ segment rỗng.
+func (b *SegmentBackend) Clear() error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	b.mu.Lock()
+	for id, seg := range b.segments {
+		b.release(seg)
+		delete(b.segments, id)
+	}
+	b.entries = make(map[string]segmentEntry)
+	b.tombstones = make(map[string]uint32)
+	b.active = nil
+	b.mu.Unlock()
+
+	if err := b.roll(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SegmentStats: thống kê dung lượng (phục vụ giám sát / quyết định compact).
+type SegmentStats struct {
+	Segments  int
+	Objects   int
+	TotalSize int64 // tổng kích thước các file segment
+	LiveSize  int64 // phần còn được tham chiếu
+}
+
+func (b *SegmentBackend) Stats() SegmentStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := SegmentStats{Segments: len(b.segments), Objects: len(b.entries)}
+	for _, seg := range b.segments {
+		stats.TotalSize += seg.size
+		stats.LiveSize += seg.live
+	}
+	return stats
+}
+
+// Compact chép phần còn sống của các segment cũ có nhiều byte chết sang segment đang ghi
+// rồi xóa segment cũ. Trả về số byte đĩa thu hồi được.
+//
+// Record xóa (tombstone) chỉ được bỏ khi không còn segment nào cũ hơn (có thể chứa bản ghi
+// cũ của cùng path) – nếu không, path đã xóa sẽ "sống lại" khi mở lại backend.
+func (b *SegmentBackend) Compact() (int64, error) {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	var (
+		reclaimed  int64
+		candidates []*segment
+	)
+	b.mu.RLock()
+	for _, seg := range b.segments {
+		if seg == b.active || seg.size == 0 {
+			continue
+		}
+		if float64(seg.size-seg.live)/float64(seg.size) >= b.CompactRatio {
+			candidates = append(candidates, seg)
+		}
+	}
+	b.mu.RUnlock()
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].id < candidates[j].id })
+
+	for _, seg := range candidates {
+		before := b.diskSize()
+		if err := b.compactSegment(seg); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += before - b.diskSize()
+	}
+	if reclaimed > 0 {
+		log.Printf("segment backend: compacted %d segments in %s, reclaimed %d bytes", len(candidates), b.Dir, reclaimed)
+	}
+	return reclaimed, nil
+}
+
+// compactSegment chép record còn sống của seg sang segment đang ghi rồi xóa seg. Gọi khi giữ wmu.
+func (b *SegmentBackend) compactSegment(seg *segment) error {
+	type item struct {
+		path  string
+		entry segmentEntry
+	}
+	var (
+		live       []item
+		tombstones []string
+		hasOlder   bool
+	)
+	b.mu.RLock()
+	for path, entry := range b.entries {
+		if entry.seg == seg.id {
+			live = append(live, item{path, entry})
+		}
+	}
+	for path, id := range b.tombstones {
+		if id == seg.id {
+			tombstones = append(tombstones, path)
+		}
+	}
+	for id := range b.segments {
+		if id < seg.id {
+			hasOlder = true
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, it := range live {
+		data := io.NewSectionReader(seg.f, it.entry.offset, it.entry.size)
+		if err := b.append(packOpPut, it.path, it.entry.modTime, data); err != nil {
+			return err
+		}
+	}
+	for _, path := range tombstones {
+		if hasOlder {
+			if err := b.append(packOpDelete, path, time.Now().UTC(), strings.NewReader("")); err != nil {
+				return err
+			}
+			continue
+		}
+		b.mu.Lock()
+		delete(b.tombstones, path)
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	delete(b.segments, seg.id)
+	b.mu.Unlock()
+	b.release(seg)
+	return nil
+}
+
+// release xóa file segment; file chỉ bị đóng khi không còn reader nào.
+func (b *SegmentBackend) release(seg *segment) {
+	if err := os.Remove(seg.f.Name()); err != nil && !os.IsNotExist(err) {
+		log.Printf("segment backend: removing %s: %s", seg.f.Name(), err)
+	}
+	atomic.StoreInt32(&seg.removed, 1)
+	if atomic.LoadInt32(&seg.refs) == 0 {
+		seg.f.Close()
+	}
+}
+
+func (b *SegmentBackend) diskSize() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var size int64
+	for _, seg := range b.segments {
+		size += seg.size
+	}
+	return size
+}
+
+// compactLoop chạy Compact định kỳ cho tới khi Close.
+func (b *SegmentBackend) compactLoop() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := b.Compact(); err != nil {
+				log.Printf("segment backend: compaction failed: %s", err)
+			}
+		case <-b.quitch:
+			return
+		}
+	}
+}
+
+// Close dừng compaction nền và đóng mọi segment.
+func (b *SegmentBackend) Close() error {
+	select {
+	case <-b.quitch:
+	default:
+		close(b.quitch)
+	}
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, seg := range b.segments {
+		seg.f.Close()
+	}
+	return nil
+}
+
+// segmentReader: reader trên 1 đoạn của segment, giữ segment không bị đóng cho tới khi Close.
+type segmentReader struct {
+	*io.SectionReader
+	seg    *segment
+	closed int32
+}
+
+func (r *segmentReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+	if atomic.AddInt32(&r.seg.refs, -1) == 0 && atomic.LoadInt32(&r.seg.removed) == 1 {
+		return r.seg.f.Close()
+	}
+	return nil
+}
+
+// countingReader đếm số byte đã đọc qua nó.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -5,9 +5,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,12 +23,39 @@ import (
 
 // FileServerOpts gom toàn bộ tham số cấu hình để tạo 1 FileServer (1 node P2P).
 type FileServerOpts struct {
-	ID                string            // ID duy nhất cho node. Nếu rỗng sẽ tự generate (random).
-	EncKey            []byte            // Khóa đối xứng để mã hóa/giải mã dữ liệu (AES-CTR ở file crypto).
-	StorageRoot       string            // Thư mục gốc trên đĩa để lưu dữ liệu (mỗi node 1 “kho riêng”).
-	PathTransformFunc PathTransformFunc // Hàm chuyển key -> path (ví dụ CASPathTransformFunc: băm SHA-1 chia folder).
-	Transport         p2p.Transport     // Lớp giao tiếp mạng (ở đây là TCPTransport).
-	BootstrapNodes    []string          // Danh sách địa chỉ peers để dial ngay khi start (kết nối vào mạng).
+	ID                      string            // ID của node. Luôn được suy ra từ public key của Identity (giá trị khác sẽ bị ghi đè).
+	Identity                *Identity         // Cặp khóa Ed25519 của node. Nếu nil → đọc/tạo từ IdentityFile.
+	IdentityFile            string            // File lưu khóa bí mật (mặc định: <StorageRoot>/.identity).
+	EncKey                  []byte            // Khóa đối xứng để mã hóa/giải mã dữ liệu (AES-CTR ở file crypto).
+	StorageRoot             string            // Thư mục gốc trên đĩa để lưu dữ liệu (mỗi node 1 “kho riêng”).
+	PathTransformFunc       PathTransformFunc // Hàm chuyển key -> path (ví dụ CASPathTransformFunc: băm SHA-1 chia folder).
+	LegacyPathTransformFunc PathTransformFunc // Layout cũ đang được migrate (tùy chọn) – vẫn đọc được trong lúc migrate.
+	StorageBackend          StorageBackend    // Nơi chứa bytes (nil → filesystem dưới StorageRoot; MemoryBackend cho test).
+	Compression             Compression       // Nén object khi ghi (rỗng → không nén). Chỉ gửi dạng nén cho peer cũng hỗ trợ.
+	ScrubInterval           time.Duration     // Chu kỳ giữa 2 lượt scrub (kiểm tra hash mọi object). 0 → chỉ chạy khi được yêu cầu.
+	ScrubBytesPerSecond     int64             // Giới hạn tốc độ đọc của scrubber (<= 0 → không giới hạn).
+	AdminAddr               string            // Địa chỉ HTTP của admin API (ví dụ "127.0.0.1:8080"). Rỗng → tắt.
+	NamespaceQuota          Quota             // Quota mặc định cho mỗi namespace (0 → không giới hạn).
+	NamespaceQuotas         map[string]Quota  // Quota riêng theo ID, ghi đè NamespaceQuota.
+	NodeQuota               Quota             // Tổng quota của node; dung lượng còn trống được báo cho peers.
+	Versioning              bool              // Giữ phiên bản cũ khi key bị ghi đè/xóa (xem Versions/GetVersion/Restore).
+	Retention               RetentionPolicy   // Quy tắc giữ phiên bản cũ (KeepLast / KeepFor).
+	ReplicaID               string            // Tên node trong version vector (rỗng → ngẫu nhiên mỗi lần khởi động).
+	ConflictPolicy          ConflictPolicy    // Xử lý ghi đồng thời: LWW theo HLC (mặc định), keep-both hoặc merge.
+	MergeFunc               MergeFunc         // Hàm gộp sibling khi ConflictPolicy = ConflictMerge.
+	TombstoneTTL            time.Duration     // Thời gian giữ tombstone của key đã xóa (0 → giữ mãi).
+	Transport               p2p.Transport     // Lớp giao tiếp mạng (ở đây là TCPTransport).
+	BootstrapNodes          []string          // Danh sách địa chỉ peers để dial ngay khi start (kết nối vào mạng).
+	GossipAddr              string            // Địa chỉ UDP cho membership SWIM (ví dụ ":3000"). Rỗng → tắt.
+	GossipSeeds             []string          // Địa chỉ UDP để vào cụm gossip (rỗng → BootstrapNodes).
+	TargetPeers             int               // Số kết nối peer cần duy trì nhờ peer exchange (0 → 8, < 0 → không tự dial thêm).
+	DiscoveryCluster        string            // Tên cụm để tự tìm peer trong LAN qua UDP multicast. Rỗng → tắt.
+	DiscoveryInterface      string            // Interface dùng cho multicast (rỗng → mặc định).
+	ReplicationFactor       int               // Số node khác giữ bản sao mỗi key, chọn theo khoảng cách XOR (0 → mọi peer đang kết nối).
+	RebalanceInterval       time.Duration     // Chu kỳ rebalance định kỳ (0 → chỉ khi membership đổi hoặc được yêu cầu).
+	RebalanceBytesPerSecond int64             // Giới hạn tốc độ stream của rebalancer (<= 0 → không giới hạn).
+	CatalogVoters           []string          // Node ID các node chạy Raft cho metadata catalog (rỗng → tắt catalog).
+	CatalogElectionTimeout  time.Duration     // Thời gian chờ leader trước khi tự ứng cử (0 → 1s; heartbeat = 1/10).
 }
 
 // FileServer là “node ứng dụng” thực sự:
@@ -34,32 +66,119 @@ type FileServer struct {
 	FileServerOpts // “embed” options → có thể truy cập trực tiếp (s.ID, s.Transport, ...)
 
 	// ---- Trạng thái runtime được bảo vệ đồng bộ ----
-	peerLock sync.Mutex          // Mutex bảo vệ map peers khi có concurrent read/write (OnPeer vs broadcast/handle).
-	peers    map[string]p2p.Peer // Danh sách peers: key = peer.RemoteAddr().String(), value = kết nối (Peer).
+	peerLock   sync.Mutex               // Mutex bảo vệ map peers khi có concurrent read/write (OnPeer vs broadcast/handle).
+	peers      map[string]p2p.Peer      // Danh sách peers: key = peer.RemoteAddr().String(), value = kết nối (Peer).
+	peerCaps   map[string][]Compression // Thuật toán nén mỗi peer hỗ trợ (từ MessageHello; chưa nhận → không nén).
+	peerFree   map[string]int64         // Dung lượng trống peer báo gần nhất (MessageCapacity; -1 = không giới hạn).
+	peerIDs    map[string]string        // Node ID của peer (từ MessageHello đã ký).
+	peerListen map[string]string        // Địa chỉ lắng nghe của peer (từ MessageHello) – dùng để giới thiệu cho node khác.
+	addrBook   map[string]string        // Sổ địa chỉ: node ID → địa chỉ lắng nghe (từ MessagePeers / MessageHello).
+	dialing    map[string]time.Time     // Node đang được dial (theo ID) → thời điểm bắt đầu.
+	connecting map[string]time.Time     // Như dialing, nhưng cho dial theo yêu cầu (connectTo: DHT, Raft).
+	draining   map[string]bool          // Node đang ngừng hoạt động (theo ID, từ MessageHello) – không được chọn giữ bản sao; không phải bằng chứng sở hữu (xem authorizeStore).
+	writeLocks map[string]*sync.Mutex   // Khóa ghi theo kết nối (xem peerWriteLock).
 
-	store  *Store        // Store cục bộ (ghi/đọc file theo PathTransformFunc).
-	quitch chan struct{} // Kênh “tín hiệu dừng” server (close(quitch) để shutdown loop).
+	membership *p2p.SWIM      // Membership cụm qua gossip (nil nếu tắt / chưa Start). Bảo vệ bởi peerLock.
+	discovery  *p2p.Discovery // Tìm peer trong LAN (nil nếu tắt / chưa Start). Bảo vệ bởi peerLock.
+
+	catalogVoter string // Voter trả lời request catalog gần nhất (client thử trước). Bảo vệ bởi peerLock.
+
+	routes    *routingTable  // Bảng định tuyến Kademlia (k-buckets theo node ID).
+	providers *providerStore // Provider record node này giữ hộ trên DHT.
+
+	store      *Store          // Store cục bộ (ghi/đọc file theo PathTransformFunc).
+	clock      *HLC            // Đồng hồ logic lai: gửi kèm mọi message, cấp mốc cho mọi lần ghi/xóa.
+	replay     *replayGuard    // Mốc HLC đã nhận của từng node – chặn envelope bị phát lại.
+	scrubber   *scrubber       // Kiểm tra toàn vẹn object chạy nền.
+	rebalancer *rebalancer     // Đưa bản sao về đúng chỗ đặt khi cụm thay đổi.
+	decom      *decommissioner // Trạng thái ngừng hoạt động (Decommission).
+	catalog    *Catalog        // Metadata catalog (state machine của Raft; chỉ có dữ liệu trên voter).
+	raft       *raft           // Node Raft của catalog (nil nếu node không phải voter).
+	admin      *http.Server    // Admin API (nil nếu AdminAddr rỗng).
+	quitch     chan struct{}   // Kênh “tín hiệu dừng” server (close(quitch) để shutdown loop).
+	done       chan struct{}   // Đóng khi Start trả về (mọi thứ đã được dọn dẹp).
+
+	// ---- Dừng êm (xem Shutdown) ----
+	shutdownMu   sync.RWMutex
+	shuttingDown bool           // Shutdown đã bắt đầu: không nhận lượt truyền mới.
+	transfers    sync.WaitGroup // Lượt truyền đang chạy (beginTransfer / endTransfer).
+	background   sync.WaitGroup // Goroutine nền Start phải chờ (goBackground).
+
+	// ---- Request/response: chờ message trả lời theo RequestID ----
+	reqLock   sync.Mutex
+	nextReqID uint64
+	pending   map[uint64]chan *Message
 }
 
 // NewFileServer khởi tạo 1 node FileServer với opts.
 // Thiết lập Store, tạo channel quitch, map peers…
-// Nếu thiếu ID thì tự sinh (generateID dùng crypto/rand).
+// Node ID = hex(public key) của Identity; khóa được đọc từ IdentityFile (hoặc sinh mới và lưu lại)
+// để node giữ nguyên ID qua các lần khởi động.
 func NewFileServer(opts FileServerOpts) *FileServer {
-	if len(opts.ID) == 0 {
-		opts.ID = generateID()
+	storeOpts := StoreOpts{
+		Root:                    opts.StorageRoot,
+		PathTransformFunc:       opts.PathTransformFunc,
+		LegacyPathTransformFunc: opts.LegacyPathTransformFunc,
+		Backend:                 opts.StorageBackend,
+		Compression:             opts.Compression,
+		NamespaceQuota:          opts.NamespaceQuota,
+		NamespaceQuotas:         opts.NamespaceQuotas,
+		NodeQuota:               opts.NodeQuota,
+		Versioning:              opts.Versioning,
+		Retention:               opts.Retention,
+		ReplicaID:               opts.ReplicaID,
+		ConflictPolicy:          opts.ConflictPolicy,
+		Clock:                   NewHLC(),
 	}
 
-	storeOpts := StoreOpts{
-		Root:              opts.StorageRoot,
-		PathTransformFunc: opts.PathTransformFunc,
+	store := NewStore(storeOpts)
+
+	if opts.Identity == nil {
+		if len(opts.IdentityFile) == 0 {
+			opts.IdentityFile = filepath.Join(store.Root, defaultIdentityFileName)
+		}
+		identity, err := LoadOrCreateIdentity(opts.IdentityFile)
+		if err != nil {
+			// Không đọc/ghi được file khóa → dùng khóa tạm (ID sẽ đổi ở lần chạy sau).
+			log.Printf("could not load identity from %s, using ephemeral key: %s", opts.IdentityFile, err)
+			if identity, err = NewIdentity(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		opts.Identity = identity
+	}
+	if len(opts.ID) > 0 && opts.ID != opts.Identity.NodeID() {
+		log.Printf("ignoring configured node id %s, node id is derived from the identity key", opts.ID)
 	}
+	opts.ID = opts.Identity.NodeID()
 
-	return &FileServer{
+	s := &FileServer{
 		FileServerOpts: opts,
-		store:          NewStore(storeOpts),
+		store:          store,
+		clock:          store.Clock,
+		replay:         newReplayGuard(),
 		quitch:         make(chan struct{}),
+		done:           make(chan struct{}),
 		peers:          make(map[string]p2p.Peer),
+		peerCaps:       make(map[string][]Compression),
+		peerFree:       make(map[string]int64),
+		peerIDs:        make(map[string]string),
+		peerListen:     make(map[string]string),
+		addrBook:       make(map[string]string),
+		dialing:        make(map[string]time.Time),
+		connecting:     make(map[string]time.Time),
+		draining:       make(map[string]bool),
+		writeLocks:     make(map[string]*sync.Mutex),
+		routes:         newRoutingTable(opts.ID),
+		providers:      newProviderStore(),
+		decom:          &decommissioner{},
+		catalog:        newCatalog(),
+		pending:        make(map[uint64]chan *Message),
 	}
+	s.scrubber = newScrubber(s, opts.ScrubInterval, opts.ScrubBytesPerSecond)
+	s.rebalancer = newRebalancer(s, opts.RebalanceInterval, opts.RebalanceBytesPerSecond)
+	s.raft = newCatalogRaft(s)
+	return s
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -68,18 +187,55 @@ func NewFileServer(opts FileServerOpts) *FileServer {
 
 // Message là “phong bì” gói Payload (any) để gob encode/decode gửi qua mạng.
 // Chú ý: mọi type cụ thể dùng trong Payload cần được gob.Register trong init().
+//   - RequestID != 0: đây là request, bên nhận trả lời bằng message có ReplyTo = RequestID.
+//   - ReplyTo   != 0: đây là response, được chuyển thẳng cho goroutine đang chờ (xem request).
+//   - Clock: mốc HLC của node gửi lúc gửi; bên nhận cập nhật đồng hồ của mình theo nó.
 type Message struct {
-	Payload any
+	RequestID uint64
+	ReplyTo   uint64
+	Clock     Timestamp
+	Payload   any
+}
+
+// Thông điệp chào hỏi, gửi ngay khi kết nối: báo cho peer biết node này giải nén được những gì.
+// Hai bên chỉ truyền object ở dạng nén khi bên nhận đã báo là hỗ trợ thuật toán đó.
+//   - ListenAddr: địa chỉ node này lắng nghe (để peer giới thiệu cho node khác – xem MessagePeers).
+//   - Decommissioning: node đang ngừng hoạt động (gửi lại cho mọi peer khi bắt đầu Decommission).
+type MessageHello struct {
+	Compression     []Compression
+	ListenAddr      string
+	Decommissioning bool
+}
+
+// Thông điệp báo dung lượng của node: gửi khi kết nối, định kỳ, và sau mỗi lần nhận (hoặc từ chối) 1 bản sao.
+// Node gửi bản sao bỏ qua peer không còn đủ chỗ.
+//   - FreeBytes: số byte còn ghi được (-1 = không giới hạn / không biết).
+type MessageCapacity struct {
+	Used      Usage
+	FreeBytes int64
 }
 
 // Thông điệp “hãy lưu file này” (metadata, không kèm bytes file).
-// - ID: ID của node phát tán (để peers quyết định lưu vào không gian nào).
-// - Key: key (ở code hiện tại đang hash MD5(key gốc) trước khi đi vào CAS). Có thể xem là “định danh nội dung”.
-// - Size: tổng số byte sẽ gửi qua stream (ở đây size+16 để tính thêm IV 16B của AES-CTR).
+//   - ID: ID của node phát tán (để peers quyết định lưu vào không gian nào).
+//   - Key: key (ở code hiện tại đang hash MD5(key gốc) trước khi đi vào CAS). Có thể xem là “định danh nội dung”.
+//   - Size: tổng số byte sẽ gửi qua stream (ở đây size+16 để tính thêm IV 16B của AES-CTR).
+//   - Meta: metadata của object trên node gốc → bản sao lưu y hệt (key gốc, size, hash, owner, ...).
+//     Meta.Compression cho biết bytes trong stream (trước khi mã hóa) đã được nén thế nào.
+//   - Hash: SHA-256 (hex) của đúng Size byte stream theo sau. Nằm trong phần đã ký → bytes stream cũng
+//     được gắn với chữ ký; bên nhận hủy lần ghi nếu không khớp.
 type MessageStoreFile struct {
 	ID   string
 	Key  string
 	Size int64
+	Meta ObjectMeta
+	Hash string
+}
+
+// Thông điệp “key này đã bị xóa”: Tombstone mang version vector + mốc HLC của lần xóa trên node gốc.
+type MessageDeleteFile struct {
+	ID        string
+	Key       string
+	Tombstone ObjectMeta
 }
 
 // Thông điệp “mình cần file này” (request).
@@ -88,31 +244,168 @@ type MessageGetFile struct {
 	Key string
 }
 
+// Thông điệp “liệt kê giúp mình các key của namespace ID” (request, cần RequestID).
+// Token/Limit dùng để phân trang giống Store.List.
+type MessageListKeys struct {
+	ID     string
+	Prefix string
+	Token  string
+	Limit  int
+}
+
+// Trả lời cho MessageListKeys: danh sách key gốc (ObjectMeta.Key), đã sắp xếp.
+type MessageListKeysResponse struct {
+	Keys      []string
+	NextToken string
+	Error     string
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                            GỬI MESSAGE ĐẾN PEERS                           //
 ////////////////////////////////////////////////////////////////////////////////
 
-// broadcast encode msg bằng gob rồi gửi đến TẤT CẢ peers.
-// ⚠️ CHÚ Ý RACE: s.peers là map; OnPeer có thể thêm peer đồng thời.
-// Tốt nhất: giữ lock khi duyệt (hoặc copy ra slice trước), tránh concurrent map read/write.
+// broadcast encode msg bằng gob, ký bằng khóa của node (Envelope) rồi gửi đến TẤT CẢ peers.
+// Peers được copy ra slice dưới peerLock (OnPeer, peer exchange, DHT, rebalancer thêm peer từ goroutine khác).
 func (s *FileServer) broadcast(msg *Message) error {
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(msg); err != nil {
+	b, err := s.seal(msg)
+	if err != nil {
 		return err
 	}
 
-	// (Có thể lock để tránh race; ở đây giữ nguyên logic gốc)
-	for _, peer := range s.peers {
-		// Gửi 1 byte “IncomingMessage” để DefaultDecoder hiểu đây là message (không phải stream).
-		peer.Send([]byte{p2p.IncomingMessage})
-		// Tiếp theo là bytes gob.
-		if err := peer.Send(buf.Bytes()); err != nil {
+	type target struct {
+		addr string
+		peer p2p.Peer
+	}
+	s.peerLock.Lock()
+	targets := make([]target, 0, len(s.peers))
+	for addr, peer := range s.peers {
+		targets = append(targets, target{addr, peer})
+	}
+	s.peerLock.Unlock()
+
+	for _, t := range targets {
+		addr, peer := t.addr, t.peer
+		// Frame [IncomingMessage|length|Envelope] để DefaultDecoder hiểu đây là message (không phải stream).
+		mu := s.peerWriteLock(addr)
+		mu.Lock()
+		err := peer.Send(p2p.EncodeMessage(b))
+		mu.Unlock()
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// sendTo gửi msg (đã ký) tới đúng 1 peer theo địa chỉ.
+func (s *FileServer) sendTo(addr string, msg *Message) error {
+	s.peerLock.Lock()
+	peer, ok := s.peers[addr]
+	s.peerLock.Unlock()
+	if !ok {
+		return fmt.Errorf("peer %s not in map", addr)
+	}
+
+	b, err := s.seal(msg)
+	if err != nil {
+		return err
+	}
+	mu := s.peerWriteLock(addr)
+	mu.Lock()
+	defer mu.Unlock()
+	return peer.Send(p2p.EncodeMessage(b))
+}
+
+// peerWriteLock: khóa tuần tự hóa mọi lần ghi lên kết nối addr. Stream giữ khóa từ byte cờ tới byte cuối
+// để message của goroutine khác (heartbeat Raft, capacity, ...) không chen vào giữa dữ liệu.
+func (s *FileServer) peerWriteLock(addr string) *sync.Mutex {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	mu, ok := s.writeLocks[addr]
+	if !ok {
+		mu = &sync.Mutex{}
+		s.writeLocks[addr] = mu
+	}
+	return mu
+}
+
+// seal gắn mốc HLC hiện tại vào msg rồi ký (sealMessage).
+func (s *FileServer) seal(msg *Message) ([]byte, error) {
+	msg.Clock = s.clock.Now()
+	return sealMessage(s.Identity, msg)
+}
+
+// request gửi payload tới peer addr và chờ message trả lời (tối đa timeout).
+// ⚠️ Không được gọi từ bên trong handler của loop: response cũng đi qua loop → sẽ tự chờ chính mình.
+func (s *FileServer) request(addr string, payload any, timeout time.Duration) (any, error) {
+	s.reqLock.Lock()
+	s.nextReqID++
+	id := s.nextReqID
+	ch := make(chan *Message, 1)
+	s.pending[id] = ch
+	s.reqLock.Unlock()
+
+	defer func() {
+		s.reqLock.Lock()
+		delete(s.pending, id)
+		s.reqLock.Unlock()
+	}()
+
+	if err := s.sendTo(addr, &Message{RequestID: id, Payload: payload}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		return msg.Payload, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("request to %s timed out after %s", addr, timeout)
+	case <-s.quitch:
+		return nil, fmt.Errorf("server stopped")
+	}
+}
+
+// reply trả lời request có ID reqID của peer addr.
+func (s *FileServer) reply(addr string, reqID uint64, payload any) error {
+	return s.sendTo(addr, &Message{ReplyTo: reqID, Payload: payload})
+}
+
+// deliverResponse chuyển response cho goroutine đang chờ (nếu còn chờ).
+func (s *FileServer) deliverResponse(msg *Message) {
+	s.reqLock.Lock()
+	ch, ok := s.pending[msg.ReplyTo]
+	s.reqLock.Unlock()
+
+	if ok {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// peerInfo: node ID + địa chỉ lắng nghe của peer đang kết nối ở addr (false nếu peer chưa chào hỏi).
+func (s *FileServer) peerInfo(addr string) (PeerInfo, bool) {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	id, listen := s.peerIDs[addr], s.peerListen[addr]
+	return PeerInfo{ID: id, Addr: listen}, len(id) > 0 && len(listen) > 0
+}
+
+// peerAddrs: danh sách địa chỉ peers hiện tại (copy dưới lock).
+func (s *FileServer) peerAddrs() []string {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	addrs := make([]string, 0, len(s.peers))
+	for addr := range s.peers {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                        PUBLIC API: GET (TẢI FILE VỀ)                        //
 ////////////////////////////////////////////////////////////////////////////////
@@ -120,18 +413,31 @@ func (s *FileServer) broadcast(msg *Message) error {
 // Get trả về io.Reader để đọc file theo key.
 // Quy trình:
 // 1) Nếu đã có local → mở từ đĩa trả về ngay.
-// 2) Nếu chưa có → broadcast MessageGetFile tới peers.
-// 3) Chờ peers nào có file sẽ stream về: [IncomingStream][int64 fileSize][file bytes].
-// 4) Ghi (giải mã) vào store cục bộ; trả về reader đọc từ disk.
+// 2) Nếu chưa có → tìm các node giữ bản sao qua DHT (findProviders) rồi gửi MessageGetFile cho chúng;
+// DHT không biết node nào → gửi cho mọi peer như trước.
+// 3) Chờ peers nào có file sẽ stream về: [IncomingStream][uint32 số bản] rồi mỗi bản [int64 fileSize][metadata][file bytes].
+// Các bản gồm phiên bản hiện hành và các sibling peer đang giữ.
+// 4) Ghi (giải mã) vào store cục bộ, đối chiếu version vector như khi nhận bản sao; trả về reader đọc từ disk.
+//
+// Key có nhiều phiên bản ghi đồng thời được xử lý theo ConflictPolicy (xem resolveSiblings):
+// keep-both trả về *ConflictError, merge/LWW ghi phiên bản đã gộp/chọn như 1 lần Store mới.
 //
 // ⚠️ LƯU Ý THIẾT KẾ:
 //   - Hiện tại code “đi vòng” TẤT CẢ peers và cố đọc fileSize. Peers nào KHÔNG trả stream sẽ không có bytes,
 //     thao tác binary.Read(peer, ...) có thể BLOCK. Đây là điểm đơn giản hóa/dễ treo.
 //   - Giải pháp tốt hơn: có cơ chế “đánh dấu” peer nào đã gửi IncomingStream (ví dụ qua channel) rồi CHỈ đọc từ những peer đó.
 func (s *FileServer) Get(key string) (io.Reader, error) {
+	if !s.beginTransfer() {
+		return nil, ErrShuttingDown
+	}
+	defer s.endTransfer()
+
 	// 1) Có local → dùng luôn
 	if s.store.Has(s.ID, key) {
 		fmt.Printf("[%s] serving file (%s) from local disk\n", s.Transport.Addr(), key)
+		if err := s.resolveSiblings(key); err != nil {
+			return nil, err
+		}
 		_, r, err := s.store.Read(s.ID, key)
 		return r, err
 	}
@@ -139,54 +445,258 @@ func (s *FileServer) Get(key string) (io.Reader, error) {
 	// 2) Không có local → hỏi mạng
 	fmt.Printf("[%s] dont have file (%s) locally, fetching from network...\n", s.Transport.Addr(), key)
 
+	addrs := s.catalogAddrs(key)
+	if len(addrs) == 0 {
+		addrs = s.providerAddrs(key)
+	}
+	if len(addrs) == 0 {
+		addrs = s.peerAddrs()
+	}
 	msg := Message{
 		Payload: MessageGetFile{
 			ID:  s.ID,
 			Key: hashKey(key), // NOTE: đang hash MD5 trước khi đi vào CAS - điều này là thừa (CAS đã hash), nhưng vẫn OK vì “key” chỉ là định danh.
 		},
 	}
-	if err := s.broadcast(&msg); err != nil {
-		return nil, err
+	for _, addr := range addrs {
+		if err := s.sendTo(addr, &msg); err != nil {
+			return nil, err
+		}
 	}
 
 	// Tạm “ngủ” để peers có thời gian xử lý và bắt đầu stream.
 	// Trong thiết kế thực tế: dùng ack/response, timeout/ctx thay vì sleep.
 	time.Sleep(time.Millisecond * 500)
 
-	// 3) Thử đọc stream từ tất cả peers (đơn giản; dễ block nếu peer không stream)
-	for _, peer := range s.peers {
-		// Đọc kích thước file (int64, little-endian) để biết cần đọc bao nhiêu bytes tiếp theo.
-		// Nếu peer không stream thì lệnh này có thể BLOCK (cần cải tiến như đã note).
-		var fileSize int64
-		binary.Read(peer, binary.LittleEndian, &fileSize)
-
-		// Đọc đúng fileSize bytes từ peer và ghi (có giải mã AES-CTR) vào store cục bộ.
-		n, err := s.store.WriteDecrypt(s.EncKey, s.ID, key, io.LimitReader(peer, fileSize))
-		if err != nil {
-			return nil, err
+	// 3) Thử đọc stream từ các peers đã hỏi (đơn giản; dễ block nếu peer không stream)
+	for _, addr := range addrs {
+		s.peerLock.Lock()
+		peer, ok := s.peers[addr]
+		s.peerLock.Unlock()
+		if !ok {
+			continue
 		}
 
-		fmt.Printf("[%s] received (%d) bytes over the network from (%s)\n", s.Transport.Addr(), n, peer.RemoteAddr())
+		// Đọc số bản peer sẽ gửi. Nếu peer không stream thì lệnh này có thể BLOCK (cần cải tiến như đã note).
+		var count uint32
+		binary.Read(peer, binary.LittleEndian, &count)
+
+		for i := uint32(0); i < count; i++ {
+			// Kích thước file (int64, little-endian) để biết cần đọc bao nhiêu bytes tiếp theo.
+			var fileSize int64
+			binary.Read(peer, binary.LittleEndian, &fileSize)
+
+			// Ngay sau fileSize là metadata của object (giữ nguyên metadata gốc khi khôi phục từ mạng).
+			meta, err := readMetaFrame(peer)
+			if err != nil {
+				return nil, err
+			}
+
+			// Đọc đúng fileSize bytes từ peer, giải mã (AES-CTR) và đối chiếu với bản đã nhận trước đó.
+			outcome, err := s.store.WriteDecryptReplica(s.EncKey, s.ID, key, meta, io.LimitReader(peer, fileSize))
+			if err != nil {
+				return nil, err
+			}
+
+			fmt.Printf("[%s] received version %s of (%s) over the network from (%s): %s\n", s.Transport.Addr(), meta.VersionID, key, peer.RemoteAddr(), outcome)
+		}
 
 		// Báo transport: stream đã xong → read loop bên dưới sẽ tiếp tục.
 		peer.CloseStream()
 	}
 
 	// 4) Trả về reader đọc từ disk (đã có sau khi ghi)
+	if err := s.resolveSiblings(key); err != nil {
+		return nil, err
+	}
 	_, r, err := s.store.Read(s.ID, key)
 	return r, err
 }
 
+// GetRange: như Get nhưng chỉ trả về length byte bắt đầu từ offset (length < 0 → tới hết).
+// Chưa có local → kéo object về qua Get trước; đọc local đi qua Store.ReadRange (ranged read ở backend).
+func (s *FileServer) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if !s.store.Has(s.ID, key) {
+		r, err := s.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+	}
+
+	if !s.beginTransfer() {
+		return nil, ErrShuttingDown
+	}
+	defer s.endTransfer()
+	if err := s.resolveSiblings(key); err != nil {
+		return nil, err
+	}
+	return s.store.ReadRange(s.ID, key, offset, length)
+}
+
+// providerAddrs: địa chỉ kết nối tới các node (trừ node này) đang giữ bản sao của key theo DHT
+// (dial những node chưa kết nối).
+func (s *FileServer) providerAddrs(key string) []string {
+	var addrs []string
+	for _, p := range s.findProviders(s.ID, key) {
+		if p.ID == s.ID {
+			continue
+		}
+		addr, err := s.connectTo(p)
+		if err != nil {
+			log.Printf("dht: connecting to provider %s: %s", p.Addr, err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                 PUBLIC API: XUNG ĐỘT (SIBLINGS & GIẢI QUYẾT)                 //
+////////////////////////////////////////////////////////////////////////////////
+
+// Siblings: các phiên bản ghi đồng thời của key trên node này (phiên bản hiện hành trước).
+func (s *FileServer) Siblings(key string) ([]ObjectMeta, error) {
+	return s.store.Siblings(s.ID, key)
+}
+
+// GetSibling trả về nội dung của 1 sibling theo VersionID.
+func (s *FileServer) GetSibling(key string, versionID string) (io.ReadCloser, error) {
+	_, r, err := s.store.ReadSibling(s.ID, key, versionID)
+	return r, err
+}
+
+// Resolve ghi nội dung đã giải quyết cho key. Version vector của lần ghi bao trùm mọi sibling
+// node này đang biết nên chúng bị thay thế ở đây và ở mọi peer nhận bản sao.
+func (s *FileServer) Resolve(key string, r io.Reader) error {
+	return s.Store(key, r)
+}
+
+// resolveSiblings áp ConflictPolicy khi key có nhiều sibling trên node này:
+//   - keep-both (hoặc merge mà không có MergeFunc): trả về *ConflictError để người gọi tự Resolve;
+//   - merge: gộp nội dung mọi sibling bằng MergeFunc rồi Resolve;
+//   - LWW  : sibling còn sót lại (ví dụ nhận trước khi đổi policy) → Resolve bằng phiên bản có HLC lớn nhất.
+func (s *FileServer) resolveSiblings(key string) error {
+	siblings, err := s.store.Siblings(s.ID, key)
+	if err != nil || len(siblings) < 2 {
+		return nil
+	}
+	if s.ConflictPolicy == ConflictKeepBoth || (s.ConflictPolicy == ConflictMerge && s.MergeFunc == nil) {
+		return &ConflictError{Key: key, Siblings: siblings}
+	}
+
+	read := func(meta ObjectMeta) ([]byte, error) {
+		_, r, err := s.store.ReadSibling(s.ID, key, meta.VersionID)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+
+	var resolved []byte
+	if s.ConflictPolicy == ConflictMerge {
+		contents := make([]Sibling, len(siblings))
+		for i, meta := range siblings {
+			data, err := read(meta)
+			if err != nil {
+				return err
+			}
+			contents[i] = Sibling{Meta: meta, Data: data}
+		}
+		if resolved, err = s.MergeFunc(key, contents); err != nil {
+			return fmt.Errorf("merging siblings of %s: %w", key, err)
+		}
+	} else {
+		winner := siblings[0]
+		for _, meta := range siblings[1:] {
+			if newerThan(meta, winner) {
+				winner = meta
+			}
+		}
+		if resolved, err = read(winner); err != nil {
+			return err
+		}
+	}
+	log.Printf("resolved %d siblings of [%s]", len(siblings), key)
+	return s.Resolve(key, bytes.NewReader(resolved))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                    PUBLIC API: PHIÊN BẢN (LỊCH SỬ & KHÔI PHỤC)               //
+////////////////////////////////////////////////////////////////////////////////
+
+// Versions: lịch sử phiên bản của key trên node này, mới nhất trước (cần bật Versioning).
+func (s *FileServer) Versions(key string) ([]ObjectVersion, error) {
+	return s.store.Versions(s.ID, key)
+}
+
+// GetVersion trả về nội dung của 1 phiên bản cụ thể của key (chỉ đọc từ node này).
+func (s *FileServer) GetVersion(key string, versionID string) (io.ReadCloser, error) {
+	_, r, err := s.store.ReadVersion(s.ID, key, versionID)
+	return r, err
+}
+
+// Restore đưa phiên bản versionID trở lại làm nội dung hiện hành của key rồi phát tán cho peers
+// như 1 lần Store mới (phiên bản hiện hành trước đó vẫn nằm trong lịch sử).
+func (s *FileServer) Restore(key string, versionID string) error {
+	_, r, err := s.store.ReadVersion(s.ID, key, versionID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.Store(key, r)
+}
+
+// pruneInterval: chu kỳ áp Retention.KeepFor cho lịch sử của mọi object.
+const pruneInterval = time.Hour
+
+// pruneVersions chạy Store.PruneVersions định kỳ cho tới khi server dừng.
+func (s *FileServer) pruneVersions() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := s.store.PruneVersions(); err != nil {
+				log.Printf("pruning versions: %s", err)
+			} else if n > 0 {
+				log.Printf("pruned %d expired versions", n)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                      PUBLIC API: STORE (LƯU & PHÁT TÁN)                     //
 ////////////////////////////////////////////////////////////////////////////////
 
-// Store lưu file “key” vào local, sau đó broadcast cho peers
+// Store lưu file “key” vào local, sau đó thông báo cho từng peer
 // rồi stream nội dung thật sự (đã mã hóa) đến họ.
 //
 // Lưu ý: dùng TeeReader để vừa ghi local vừa giữ bản copy (fileBuffer)
 // để lát nữa stream ra mạng, không cần đọc lại từ nguồn.
+// Nếu Store đã nén object khi ghi, peer hỗ trợ cùng thuật toán nhận luôn bytes đã nén
+// (nén trước, mã hóa sau); peer khác nhận nội dung gốc.
+// ReplicationFactor > 0 → chỉ gửi tới các node được chọn (xem replicaTargets), không phải mọi peer.
+// Cuối cùng công bố trên DHT (announce) các node đang giữ object để Get tìm được chúng.
 func (s *FileServer) Store(key string, r io.Reader) error {
+	if s.decommissioning() {
+		return ErrDecommissioning
+	}
+	if !s.beginTransfer() {
+		return ErrShuttingDown
+	}
+	defer s.endTransfer()
+	if err := s.checkCatalogQuota(key); err != nil {
+		return err
+	}
 	// TeeReader: đọc từ r → ghi song song vào fileBuffer (để dùng stream ra mạng).
 	var (
 		fileBuffer = new(bytes.Buffer)
@@ -194,85 +704,415 @@ func (s *FileServer) Store(key string, r io.Reader) error {
 	)
 
 	// 1) Ghi vào local store (không mã hóa ở đây; mã hóa khi stream ra mạng)
-	size, err := s.store.Write(s.ID, key, tee)
+	if _, err := s.store.WriteWithMeta(s.ID, key, ObjectMeta{Owner: s.ID, EncKeyID: encKeyID(s.EncKey)}, tee); err != nil {
+		return err
+	}
+	meta, err := s.store.Stat(s.ID, key)
 	if err != nil {
 		return err
 	}
+	var compressed []byte
+	if meta.Compression != CompressionNone {
+		_, raw, err := s.store.ReadRaw(s.ID, key)
+		if err != nil {
+			return err
+		}
+		compressed, err = io.ReadAll(raw)
+		raw.Close()
+		if err != nil {
+			return err
+		}
+	}
 
-	// 2) Thông báo metadata cho peers: “mình có file mới”
+	// 2) + 3) Với từng peer: thông báo metadata “mình có file mới” rồi stream dữ liệu
+	addrs := s.peerAddrs()
+	if s.ReplicationFactor > 0 {
+		addrs = s.placementAddrs(key)
+	}
+	providers := []PeerInfo{s.self()}
+	for _, addr := range addrs {
+		if free := s.peerFreeBytes(addr); free >= 0 && free < int64(fileBuffer.Len())+16 {
+			log.Printf("skipping replica on %s: peer reports %d free bytes", addr, free)
+			continue
+		}
+		body, wireMeta := fileBuffer.Bytes(), meta
+		if meta.Compression != CompressionNone {
+			if s.peerSupports(addr, meta.Compression) {
+				body = compressed
+			} else {
+				wireMeta.Compression = CompressionNone
+			}
+		}
+		if err := s.replicate(addr, key, wireMeta, body); err != nil {
+			return err
+		}
+		if p, ok := s.peerInfo(addr); ok {
+			providers = append(providers, p)
+		}
+	}
+
+	// 4) Công bố trên DHT các node đang giữ object → Get tìm được bản sao mà không cần broadcast
+	s.announce(s.ID, key, providers)
+
+	// 5) Ghi phiên bản + vị trí vào metadata catalog (nếu bật)
+	return s.recordObject(key, meta, providers)
+}
+
+// replicate gửi MessageStoreFile rồi stream body (mã hóa AES-CTR) tới peer addr.
+func (s *FileServer) replicate(addr string, key string, meta ObjectMeta, body []byte) error {
+	return s.replicateStream(addr, key, meta, int64(len(body)), bytes.NewReader(body))
+}
+
+// replicateStream như replicate nhưng đọc đúng size byte từ r (không cần giữ cả object trong RAM).
+func (s *FileServer) replicateStream(addr string, key string, meta ObjectMeta, size int64, r io.Reader) error {
 	// Size + 16 vì khi stream AES-CTR sẽ prepend IV 16B → tổng bytes đọc/ghi ở phía nhận tăng thêm 16.
-	msg := Message{
-		Payload: MessageStoreFile{
-			ID:   s.ID,
-			Key:  hashKey(key), // như trên: hash MD5 trước CAS là thừa, nhưng vẫn là 1 key hợp lệ.
-			Size: size + 16,
-		},
+	msg := MessageStoreFile{
+		ID:   s.ID,
+		Key:  hashKey(key), // như trên: hash MD5 trước CAS là thừa, nhưng vẫn là 1 key hợp lệ.
+		Size: size + 16,
+		Meta: meta,
 	}
-	if err := s.broadcast(&msg); err != nil {
+	// copyEncrypt: prepend IV(16B) + ciphertext(=size)
+	return s.pushObject(addr, msg, func(w io.Writer) (int64, error) {
+		n, err := copyEncrypt(s.EncKey, io.LimitReader(r, size), w)
+		return int64(n), err
+	})
+}
+
+// pushSpoolMemory: body tới cỡ này được chuẩn bị trong RAM, lớn hơn thì qua file tạm.
+const pushSpoolMemory = 4 << 20
+
+// pushObject gửi MessageStoreFile rồi stream đúng msg.Size byte (do write ghi ra) tới peer addr.
+// Bytes được chuẩn bị trước (write có thể mã hóa với IV ngẫu nhiên) để ký hash của chúng trong message.
+func (s *FileServer) pushObject(addr string, msg MessageStoreFile, write func(io.Writer) (int64, error)) error {
+	body, hash, err := spoolBody(msg.Size, write)
+	if err != nil {
 		return err
 	}
+	defer body.Close()
+	msg.Hash = hash
+	if msg.ID == s.ID {
+		msg.Meta.OwnerSig = s.Identity.Sign(ownerRecord(msg.ID, msg.Key, msg.Meta.VersionID, hash))
+	}
 
-	// Cho peers thời gian xử lý message metadata (đơn giản).
+	if err := s.sendTo(addr, &Message{Payload: msg}); err != nil {
+		return err
+	}
+
+	// Cho peer thời gian xử lý message metadata (đơn giản).
 	time.Sleep(time.Millisecond * 5)
 
-	// 3) Stream dữ liệu thật sự: gửi byte flag IncomingStream, rồi mã hóa AES-CTR và đẩy ra TẤT CẢ peers.
-	peers := []io.Writer{}
-	for _, peer := range s.peers {
-		peers = append(peers, peer)
+	s.peerLock.Lock()
+	peer, ok := s.peers[addr]
+	s.peerLock.Unlock()
+	if !ok {
+		return fmt.Errorf("peer %s not in map", addr)
 	}
-	mw := io.MultiWriter(peers...)       // ghi 1 lần ra nhiều peer
-	mw.Write([]byte{p2p.IncomingStream}) // byte cờ để transport “tạm dừng read-loop” và nhường việc đọc cho ứng dụng
 
-	// copyEncrypt: prepend IV(16B) + ciphertext(=len(plain))
-	n, err := copyEncrypt(s.EncKey, fileBuffer, mw)
+	// Byte cờ để transport “tạm dừng read-loop” và nhường việc đọc cho ứng dụng
+	mu := s.peerWriteLock(addr)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := peer.Send([]byte{p2p.IncomingStream}); err != nil {
+		return err
+	}
+	n, err := io.Copy(peer, body)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[%s] received and written (%d) bytes to disk\n", s.Transport.Addr(), n)
+	fmt.Printf("[%s] written (%d) bytes over the network to %s (compression: %q)\n", s.Transport.Addr(), n, addr, msg.Meta.Compression)
 	return nil
 }
 
+// spoolBody chạy write vào bộ đệm (RAM hoặc file tạm) và trả về reader đọc lại đúng những byte đó kèm
+// hash (contentHash) của chúng. write phải ghi đúng size byte.
+func spoolBody(size int64, write func(io.Writer) (int64, error)) (io.ReadCloser, string, error) {
+	h := contentHash()
+	if size <= pushSpoolMemory {
+		buf := new(bytes.Buffer)
+		if n, err := write(io.MultiWriter(buf, h)); err != nil {
+			return nil, "", err
+		} else if n != size {
+			return nil, "", fmt.Errorf("body is %d bytes, announced %d", n, size)
+		}
+		return io.NopCloser(buf), hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	f, err := os.CreateTemp("", "dfs-push-*")
+	if err != nil {
+		return nil, "", err
+	}
+	spool := &tempFile{f}
+	n, err := write(io.MultiWriter(f, h))
+	if err == nil && n != size {
+		err = fmt.Errorf("body is %d bytes, announced %d", n, size)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		return nil, "", err
+	}
+	return spool, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tempFile: file tạm tự xóa khi Close.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                      PUBLIC API: DELETE (XÓA & PHÁT TÁN)                    //
+////////////////////////////////////////////////////////////////////////////////
+
+// Delete xóa key trên node này rồi báo cho mọi peer xóa bản sao. Lần xóa được đánh mốc HLC và
+// version vector (tombstone) nên bản sao cũ hơn đến sau không làm key "sống lại", còn lần ghi
+// đồng thời với lần xóa được quyết định theo HLC.
+func (s *FileServer) Delete(key string) error {
+	if s.decommissioning() {
+		return ErrDecommissioning
+	}
+	tomb, err := s.store.Remove(s.ID, key)
+	if err != nil {
+		return err
+	}
+	err = s.broadcast(&Message{
+		Payload: MessageDeleteFile{
+			ID:        s.ID,
+			Key:       hashKey(key), // bản sao ở peers nằm dưới hashKey(key) (xem replicate)
+			Tombstone: tomb,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordDeletion(key, tomb)
+}
+
+// pruneTombstones xóa tombstone cũ hơn TombstoneTTL định kỳ cho tới khi server dừng.
+func (s *FileServer) pruneTombstones() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := s.store.PruneTombstones(s.TombstoneTTL); err != nil {
+				log.Printf("pruning tombstones: %s", err)
+			} else if n > 0 {
+				log.Printf("pruned %d expired tombstones", n)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                    PUBLIC API: LIST (LIỆT KÊ KEY)                           //
+////////////////////////////////////////////////////////////////////////////////
+
+// listRequestTimeout: thời gian tối đa chờ 1 peer trả lời MessageListKeys.
+const listRequestTimeout = 2 * time.Second
+
+// List liệt kê các key (key gốc) của node này có tiền tố prefix, trên toàn cluster:
+// gộp kết quả của Store cục bộ với kết quả các peers đang giữ bản sao, bỏ trùng, sắp xếp tăng dần.
+//
+// Phân trang: mỗi nguồn trả về tối đa limit key nhỏ nhất sau token → limit key nhỏ nhất của
+// tập gộp luôn đúng. nextToken rỗng → đã hết. Peer không trả lời kịp sẽ bị bỏ qua (log lại).
+func (s *FileServer) List(prefix string, token string, limit int) ([]string, string, error) {
+	local, err := s.store.List(s.ID, prefix, token, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		seen    = make(map[string]bool)
+		keys    []string
+		hasMore = len(local.NextToken) > 0
+	)
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, entry := range local.Entries {
+		add(entry.Key)
+	}
+
+	for _, addr := range s.peerAddrs() {
+		resp, err := s.request(addr, MessageListKeys{ID: s.ID, Prefix: prefix, Token: token, Limit: limit}, listRequestTimeout)
+		if err != nil {
+			log.Printf("list keys from %s: %s", addr, err)
+			continue
+		}
+		page, ok := resp.(MessageListKeysResponse)
+		if !ok || len(page.Error) > 0 {
+			log.Printf("list keys from %s: unexpected response %+v", addr, resp)
+			continue
+		}
+		for _, key := range page.Keys {
+			add(key)
+		}
+		hasMore = hasMore || len(page.NextToken) > 0
+	}
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys, hasMore = keys[:limit], true
+	}
+
+	nextToken := ""
+	if hasMore && len(keys) > 0 {
+		nextToken = encodePageToken(keys[len(keys)-1])
+	}
+	return keys, nextToken, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                        QUẢN LÝ VÒNG ĐỜI & PEERS                             //
 ////////////////////////////////////////////////////////////////////////////////
 
-// Stop báo cho server dừng (close channel), loop sẽ thoát.
+// Stop báo cho server dừng (close channel), loop sẽ thoát. Gọi nhiều lần không sao.
+// Không chờ lượt truyền đang chạy – dùng Shutdown để dừng êm.
 func (s *FileServer) Stop() {
-	close(s.quitch)
+	select {
+	case <-s.quitch:
+	default:
+		close(s.quitch)
+	}
 }
 
 // OnPeer được gọi khi transport chấp nhận 1 peer mới.
 // Thêm peer vào map (dưới lock) để các API khác (broadcast/Store/Get) có thể sử dụng.
+// Ngay sau đó gửi MessageHello để peer biết node này hỗ trợ những gì.
 func (s *FileServer) OnPeer(p p2p.Peer) error {
+	addr := p.RemoteAddr().String()
+
+	s.peerLock.Lock()
+	s.peers[addr] = p
+	s.peerLock.Unlock()
+	log.Printf("connected with remote %s", p.RemoteAddr())
+
+	if err := s.sendTo(addr, &Message{Payload: s.hello()}); err != nil {
+		return err
+	}
+	return s.sendTo(addr, &Message{Payload: s.capacity()})
+}
+
+// hello: lời chào node gửi cho peer (khả năng nén, địa chỉ lắng nghe, trạng thái ngừng hoạt động).
+func (s *FileServer) hello() MessageHello {
+	return MessageHello{Compression: supportedCompressions, ListenAddr: s.Transport.Addr(), Decommissioning: s.decommissioning()}
+}
+
+// capacity: dung lượng hiện tại của node (để báo cho peers).
+func (s *FileServer) capacity() MessageCapacity {
+	return MessageCapacity{Used: s.store.TotalUsage(), FreeBytes: s.store.FreeBytes()}
+}
+
+// advertiseCapacity gửi MessageCapacity cho mọi peer mỗi capacityInterval cho tới khi server dừng.
+func (s *FileServer) advertiseCapacity() {
+	ticker := time.NewTicker(capacityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			msg := &Message{Payload: s.capacity()}
+			for _, addr := range s.peerAddrs() {
+				if err := s.sendTo(addr, msg); err != nil {
+					log.Printf("advertising capacity to %s: %s", addr, err)
+				}
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// capacityInterval: chu kỳ báo dung lượng cho peers.
+const capacityInterval = 30 * time.Second
+
+// peerFreeBytes: dung lượng trống peer addr báo gần nhất (-1 nếu chưa biết / không giới hạn).
+func (s *FileServer) peerFreeBytes(addr string) int64 {
 	s.peerLock.Lock()
 	defer s.peerLock.Unlock()
 
-	s.peers[p.RemoteAddr().String()] = p
-	log.Printf("connected with remote %s", p.RemoteAddr())
-	return nil
+	if free, ok := s.peerFree[addr]; ok {
+		return free
+	}
+	return -1
+}
+
+// peerSupports: peer addr đã báo (qua MessageHello) là giải nén được alg chưa.
+func (s *FileServer) peerSupports(addr string, alg Compression) bool {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+	return supportsCompression(s.peerCaps[addr], alg)
 }
 
 // loop là “trái tim” của server: chờ dữ liệu từ Transport.Consume()
-// - Nếu nhận được RPC message: decode gob → gọi handleMessage.
-// - Nếu nhận tín hiệu dừng (quitch): đóng transport & thoát.
+// - Nếu nhận được RPC message: decode gob → gọi handleMessage (đang Shutdown → bỏ qua, chỉ nhận trả lời).
+// - Nếu nhận tín hiệu dừng (quitch): rời cụm, đóng transport, đóng mọi kết nối peer & thoát.
 func (s *FileServer) loop() {
 	defer func() {
 		log.Println("file server stopped due to error or user quit action")
+		s.stopMembership()
+		s.stopDiscovery()
 		s.Transport.Close()
+		if s.raft != nil {
+			s.raft.stop()
+		}
+		s.closePeers()
+		if s.admin != nil {
+			s.admin.Close()
+		}
 	}()
 
 	for {
 		select {
 		case rpc := <-s.Transport.Consume():
-			// rpc.Payload là bytes gob (vì broadcast đã gửi IncomingMessage + gob)
-			var msg Message
-			if err := gob.NewDecoder(bytes.NewReader(rpc.Payload)).Decode(&msg); err != nil {
+			// rpc.Payload là bytes Envelope (vì broadcast đã gửi IncomingMessage + Envelope đã ký)
+			sender, msg, err := openMessage(rpc.Payload)
+			if err != nil {
 				log.Println("decoding error: ", err)
+				continue
 			}
-			if err := s.handleMessage(rpc.From, &msg); err != nil {
+			err = s.verifyChannel(rpc.From, sender)
+			if err == nil {
+				err = s.replay.check(sender, msg.Clock)
+			}
+			if err != nil {
+				log.Printf("[%s] dropping %T from %s: %s", s.Transport.Addr(), msg.Payload, rpc.From, err)
+				if v, ok := msg.Payload.(MessageStoreFile); ok {
+					s.discardStream(rpc.From, v.Size)
+				}
+				continue
+			}
+			if !msg.Clock.IsZero() {
+				s.clock.Update(msg.Clock)
+			}
+			if msg.ReplyTo != 0 {
+				s.deliverResponse(msg)
+				continue
+			}
+			if !s.beginTransfer() {
+				log.Printf("[%s] shutting down, dropping %T from %s", s.Transport.Addr(), msg.Payload, rpc.From)
+				continue
+			}
+			if err := s.handleMessage(rpc.From, sender, msg); err != nil {
 				log.Println("handle message error: ", err)
 			}
+			s.endTransfer()
 
 		case <-s.quitch:
 			return
@@ -282,12 +1122,43 @@ func (s *FileServer) loop() {
 
 // handleMessage phân loại message theo kiểu payload (đã được gob.Register)
 // và chuyển cho handler tương ứng.
-func (s *FileServer) handleMessage(from string, msg *Message) error {
+// - from  : địa chỉ mạng của peer (key trong s.peers).
+// - sender: node ID người gửi, đã được xác thực bằng chữ ký.
+func (s *FileServer) handleMessage(from string, sender string, msg *Message) error {
 	switch v := msg.Payload.(type) {
+	case MessageHello:
+		return s.handleMessageHello(from, sender, v)
+	case MessagePeers:
+		return s.handleMessagePeers(v)
+	case MessageCapacity:
+		return s.handleMessageCapacity(from, v)
 	case MessageStoreFile:
-		return s.handleMessageStoreFile(from, v)
+		return s.handleMessageStoreFile(from, sender, v)
 	case MessageGetFile:
 		return s.handleMessageGetFile(from, v)
+	case MessageDeleteFile:
+		return s.handleMessageDeleteFile(from, sender, v)
+	case MessageListKeys:
+		return s.handleMessageListKeys(from, msg.RequestID, v)
+	case MessageGetObject:
+		return s.handleMessageGetObject(from, msg.RequestID, v)
+	case MessageFindNode:
+		return s.handleMessageFindNode(from, msg.RequestID, v)
+	case MessageFindValue:
+		return s.handleMessageFindValue(from, msg.RequestID, v)
+	case MessageDHTStore:
+		return s.handleMessageDHTStore(from, sender, msg.RequestID, v)
+	case MessageStatObject:
+		return s.handleMessageStatObject(from, msg.RequestID, v)
+	case MessageDropReplica:
+		return s.handleMessageDropReplica(from, sender, msg.RequestID, v)
+	case MessageRaftVote, MessageRaftVoteResponse, MessageRaftAppend, MessageRaftAppendResponse,
+		MessageRaftSnapshot, MessageRaftSnapshotResponse:
+		return s.handleMessageRaft(sender, v)
+	case MessageCatalogPropose:
+		return s.handleMessageCatalogPropose(from, sender, msg.RequestID, v)
+	case MessageCatalogRead:
+		return s.handleMessageCatalogRead(from, msg.RequestID, v)
 	}
 	return nil
 }
@@ -296,12 +1167,69 @@ func (s *FileServer) handleMessage(from string, msg *Message) error {
 //                           HANDLERS CHO MESSAGE                              //
 ////////////////////////////////////////////////////////////////////////////////
 
+// handleMessageHello: ghi nhận các thuật toán nén peer hỗ trợ, ID, địa chỉ lắng nghe (cả vào bảng định tuyến DHT)
+// và trạng thái ngừng hoạt động của peer, rồi gửi cho peer danh sách các peer khác đang kết nối (peer exchange).
+func (s *FileServer) handleMessageHello(from string, sender string, msg MessageHello) error {
+	s.peerLock.Lock()
+	s.peerCaps[from] = msg.Compression
+	s.peerIDs[from] = sender
+	delete(s.dialing, sender)
+	delete(s.connecting, sender)
+	var listen string
+	if len(msg.ListenAddr) > 0 {
+		listen = listenAddrOf(from, msg.ListenAddr)
+		s.peerListen[from] = listen
+		s.addrBook[sender] = listen
+	}
+	gossip := s.membership != nil
+	startedDraining := msg.Decommissioning && !s.draining[sender]
+	if msg.Decommissioning {
+		s.draining[sender] = true
+	} else {
+		delete(s.draining, sender)
+	}
+	s.peerLock.Unlock()
+
+	// Contact đầu tiên của bảng định tuyến → lookup chính mình để biết các node lân cận
+	if len(listen) > 0 {
+		first := s.routes.size() == 0
+		s.routes.update(PeerInfo{ID: sender, Addr: listen})
+		if first {
+			go s.refreshRoutes()
+		}
+	}
+	// Không bật gossip → tập member là các peer đang kết nối
+	if !gossip {
+		s.membersChanged()
+	}
+	// Peer bắt đầu ngừng hoạt động → chuyển bản sao của mình ra khỏi node đó ngay
+	if startedDraining {
+		log.Printf("[%s] peer %s is decommissioning", s.Transport.Addr(), listen)
+		s.rebalancer.Trigger()
+	}
+	return s.sendPeers(from)
+}
+
+// handleMessageCapacity: ghi nhận dung lượng trống peer vừa báo.
+func (s *FileServer) handleMessageCapacity(from string, msg MessageCapacity) error {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	s.peerFree[from] = msg.FreeBytes
+	return nil
+}
+
 // handleMessageGetFile: nhận yêu cầu “hãy gửi file này cho mình” từ peer `from`.
 // Nếu có file trong local store:
 //   - Gửi byte IncomingStream → để peer kia “vào chế độ stream”.
-//   - Gửi fileSize (int64 LE) → cho bên kia biết đọc bao nhiêu byte.
-//   - Gửi bytes file (không mã hóa ở đây — CHÚ Ý: không đồng nhất với Store(), nơi ta mã hóa khi phát tán).
+//   - Gửi số bản (uint32 LE): phiên bản hiện hành + các sibling (ghi đồng thời) đang giữ.
+//   - Với mỗi bản: fileSize (int64 LE) → cho bên kia biết đọc bao nhiêu byte;
+//     metadata (uint32 độ dài + JSON) → bên kia lưu metadata giống hệt (kể cả version vector);
+//     bytes file (không mã hóa ở đây — CHÚ Ý: không đồng nhất với Store(), nơi ta mã hóa khi phát tán).
 //     → Nếu muốn đồng bộ bảo mật, có thể mã hóa cả chiều GET này, hoặc dùng AEAD (AES-GCM).
+//
+// Bytes được gửi nguyên trạng (không giải nén). Bản sao ở đây đã được mã hóa bằng khóa của chủ
+// nên không thể giải nén hộ → object nén chỉ gửi được cho peer hỗ trợ thuật toán đó.
 func (s *FileServer) handleMessageGetFile(from string, msg MessageGetFile) error {
 	if !s.store.Has(msg.ID, msg.Key) {
 		// Không có file → log thông tin để debug.
@@ -310,61 +1238,226 @@ func (s *FileServer) handleMessageGetFile(from string, msg MessageGetFile) error
 
 	fmt.Printf("[%s] serving file (%s) over the network\n", s.Transport.Addr(), msg.Key)
 
-	fileSize, r, err := s.store.Read(msg.ID, msg.Key)
+	siblings, err := s.store.Siblings(msg.ID, msg.Key)
 	if err != nil {
 		return err
 	}
-	// Đảm bảo đóng file nếu r là ReadCloser
-	if rc, ok := r.(io.ReadCloser); ok {
-		defer rc.Close()
+	// Mở hết các bản trước khi stream: số bản đã gửi thì phải gửi đủ
+	type replica struct {
+		meta ObjectMeta
+		size int64
+		r    io.ReadCloser
+	}
+	var replicas []replica
+	defer func() {
+		for _, rep := range replicas {
+			rep.r.Close()
+		}
+	}()
+	for i, meta := range siblings {
+		if !s.peerSupports(from, meta.Compression) {
+			log.Printf("[%s] cannot serve version %s of (%s): peer %s does not support %s compression", s.Transport.Addr(), meta.VersionID, msg.Key, from, meta.Compression)
+			continue
+		}
+		var (
+			size int64
+			r    io.ReadCloser
+		)
+		if i == 0 {
+			size, r, err = s.store.ReadRaw(msg.ID, msg.Key)
+		} else {
+			_, size, r, err = s.store.openSibling(msg.ID, msg.Key, meta.VersionID)
+		}
+		if err != nil {
+			return err
+		}
+		replicas = append(replicas, replica{meta: meta, size: size, r: r})
+	}
+	if len(replicas) == 0 {
+		return fmt.Errorf("[%s] cannot serve file (%s): peer %s does not support its compression", s.Transport.Addr(), msg.Key, from)
 	}
 
 	// Tìm peer đích để gửi
+	s.peerLock.Lock()
 	peer, ok := s.peers[from]
+	s.peerLock.Unlock()
 	if !ok {
 		return fmt.Errorf("peer %s not in map", from)
 	}
 
 	// 1) báo IncomingStream để bên kia pause read-loop
+	mu := s.peerWriteLock(from)
+	mu.Lock()
+	defer mu.Unlock()
 	peer.Send([]byte{p2p.IncomingStream})
-	// 2) gửi trước fileSize (LE int64) để bên kia LimitReader cho đúng số byte
-	binary.Write(peer, binary.LittleEndian, fileSize)
-	// 3) gửi bytes file
-	n, err := io.Copy(peer, r)
+	// 2) số bản sẽ gửi
+	binary.Write(peer, binary.LittleEndian, uint32(len(replicas)))
+	for _, rep := range replicas {
+		// 3) fileSize (LE int64) để bên kia LimitReader cho đúng số byte
+		binary.Write(peer, binary.LittleEndian, rep.size)
+		// 4) metadata của bản này
+		if err := writeMetaFrame(peer, rep.meta); err != nil {
+			return err
+		}
+		// 5) bytes file
+		n, err := io.Copy(peer, rep.r)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[%s] written (%d) bytes over the network to %s\n", s.Transport.Addr(), n, from)
+	}
+	return nil
+}
+
+// handleMessageDeleteFile: chủ namespace báo đã xóa key → xóa bản sao (nếu lần xóa mới hơn bản đang giữ).
+func (s *FileServer) handleMessageDeleteFile(from string, sender string, msg MessageDeleteFile) error {
+	if msg.ID != sender {
+		return fmt.Errorf("peer (%s) signed as %s cannot delete from namespace %s", from, sender, msg.ID)
+	}
+	outcome, err := s.store.DeleteReplica(msg.ID, msg.Key, msg.Tombstone)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("[%s] received delete of (%s) at %s from %s: %s\n", s.Transport.Addr(), msg.Key, msg.Tombstone.Timestamp, from, outcome)
+	return nil
+}
+
+// handleMessageListKeys: peer hỏi danh sách key trong namespace msg.ID → trả lời 1 trang.
+func (s *FileServer) handleMessageListKeys(from string, reqID uint64, msg MessageListKeys) error {
+	resp := MessageListKeysResponse{}
+
+	page, err := s.store.List(msg.ID, msg.Prefix, msg.Token, msg.Limit)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	for i, entry := range page.Entries {
+		// Nhiều bản ghi cùng key gốc chỉ cần trả về 1 lần
+		if i == 0 || entry.Key != page.Entries[i-1].Key {
+			resp.Keys = append(resp.Keys, entry.Key)
+		}
+	}
+	resp.NextToken = page.NextToken
 
-	fmt.Printf("[%s] written (%d) bytes over the network to %s\n", s.Transport.Addr(), n, from)
+	return s.reply(from, reqID, resp)
+}
+
+// verifyChannel: kết nối from đã xác thực khóa danh tính của peer (Noise) thì message phải do chính
+// khóa đó ký. Kết nối TCP trần không có khóa để so → chỉ dựa vào chữ ký của envelope.
+func (s *FileServer) verifyChannel(from string, sender string) error {
+	s.peerLock.Lock()
+	peer, ok := s.peers[from]
+	s.peerLock.Unlock()
+	if !ok {
+		return fmt.Errorf("message from unknown connection %s", from)
+	}
+	if key := peer.RemotePublicKey(); key != nil && hex.EncodeToString(key) != sender {
+		return ErrChannelMismatch
+	}
 	return nil
 }
 
+// discardStream đọc bỏ size byte stream mà peer from gửi kèm 1 message bị từ chối, để read loop của
+// kết nối không bị kẹt chờ CloseStream.
+func (s *FileServer) discardStream(from string, size int64) {
+	s.peerLock.Lock()
+	peer, ok := s.peers[from]
+	s.peerLock.Unlock()
+	if !ok {
+		return
+	}
+	io.CopyN(io.Discard, peer, size)
+	peer.CloseStream()
+}
+
 // handleMessageStoreFile: khi peer khác thông báo “mình chuẩn bị stream 1 file cỡ Size cho bạn”,
 // ta đọc đúng Size byte từ kết nối peer và ghi vào store.
 // ⚠️ Ở nhánh Store (push) phía bạn đã MÃ HÓA khi stream (copyEncrypt) → ở đây ghi RAW (không decrypt).
 //
 //	Trong code này, nhánh “lắng nghe push” không decrypt (khác với nhánh Get() dùng WriteDecrypt).
 //	Bạn có thể điều chỉnh để đồng nhất (decrypt ở đây), hoặc chỉ mã hóa trên đường truyền (không mã hóa lưu trữ).
-func (s *FileServer) handleMessageStoreFile(from string, msg MessageStoreFile) error {
+//
+// Chỉ chấp nhận ghi khi authorizeStore cho phép (chính chủ, hoặc chuyển giao mang chữ ký của chủ).
+// Node đang ngừng hoạt động không nhận bản sao mới.
+// Nếu không, stream đi kèm vẫn phải được đọc bỏ để read-loop của peer không bị kẹt.
+func (s *FileServer) handleMessageStoreFile(from string, sender string, msg MessageStoreFile) error {
+	s.peerLock.Lock()
 	peer, ok := s.peers[from]
+	s.peerLock.Unlock()
 	if !ok {
 		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
 	}
 
-	// Ghi đúng msg.Size bytes từ peer vào store.
+	if len(msg.Hash) == 0 {
+		io.CopyN(io.Discard, peer, msg.Size)
+		peer.CloseStream()
+		return fmt.Errorf("replica of (%s) from %s carries no signed content hash", msg.Key, from)
+	}
+
+	if err := s.authorizeStore(sender, msg); err != nil {
+		io.CopyN(io.Discard, peer, msg.Size)
+		peer.CloseStream()
+		return fmt.Errorf("peer (%s) signed as %s cannot store into namespace %s: %w", from, sender, msg.ID, err)
+	}
+	if s.decommissioning() {
+		io.CopyN(io.Discard, peer, msg.Size)
+		peer.CloseStream()
+		return fmt.Errorf("refusing replica of (%s) from %s: %w", msg.Key, from, ErrDecommissioning)
+	}
+
+	// Sau khi xử lý (nhận hay từ chối) → báo lại dung lượng để bên gửi chọn chỗ đặt bản sao cho đúng
+	defer func() {
+		if err := s.sendTo(from, &Message{Payload: s.capacity()}); err != nil {
+			log.Printf("sending capacity to %s: %s", from, err)
+		}
+	}()
+
+	// Vượt quota → từ chối ngay, vẫn đọc bỏ stream
+	if err := s.store.CheckQuota(msg.ID, msg.Key, msg.Size); err != nil {
+		io.CopyN(io.Discard, peer, msg.Size)
+		peer.CloseStream()
+		return err
+	}
+
+	// Ghi đúng msg.Size bytes từ peer vào store, đối chiếu version vector với bản đang có
+	// (bản cũ hơn bị bỏ qua, ghi đồng thời xử lý theo ConflictPolicy).
 	// (Nếu muốn decrypt khi ghi, hãy dùng WriteDecrypt với key tương ứng.)
-	n, err := s.store.Write(msg.ID, msg.Key, io.LimitReader(peer, msg.Size))
+	stream := newVerifiedReader(io.LimitReader(peer, msg.Size), msg.Hash)
+	outcome, err := s.store.WriteReplica(msg.ID, msg.Key, msg.Meta, stream)
 	if err != nil {
+		// Phần stream chưa đọc phải được đọc bỏ để read-loop của peer không bị kẹt
+		io.Copy(io.Discard, stream)
+		peer.CloseStream()
 		return err
 	}
 
-	fmt.Printf("[%s] written %d bytes to disk\n", s.Transport.Addr(), n)
+	fmt.Printf("[%s] received version %s of (%s) from %s: %s\n", s.Transport.Addr(), msg.Meta.VersionID, msg.Key, from, outcome)
 
 	// Báo transport: stream đã hoàn tất → cho read-loop tiếp tục chạy.
 	peer.CloseStream()
 	return nil
 }
 
+// authorizeStore: sender có được ghi bản sao msg vào namespace msg.ID không.
+//   - msg.ID phải là node ID hợp lệ (hex của public key) – nó đi thẳng vào đường dẫn trong backend.
+//   - Chính chủ namespace gửi → được.
+//   - Node khác chỉ được chuyển giao khi đang ngừng hoạt động (cờ trong MessageHello – do chính nó tự báo)
+//     VÀ bản sao mang chữ ký của chủ trên đúng phiên bản + bytes đang stream (ObjectMeta.OwnerSig).
+func (s *FileServer) authorizeStore(sender string, msg MessageStoreFile) error {
+	if _, err := publicKeyFromNodeID(msg.ID); err != nil {
+		return err
+	}
+	if msg.ID == sender {
+		return nil
+	}
+	s.peerLock.Lock()
+	draining := s.draining[sender]
+	s.peerLock.Unlock()
+	if !draining {
+		return fmt.Errorf("not the namespace owner")
+	}
+	return verifyOwnerRecord(msg.ID, msg.Key, msg.Meta.VersionID, msg.Hash, msg.Meta.OwnerSig)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                             KHỞI ĐỘNG / KẾT NỐI                             //
 ////////////////////////////////////////////////////////////////////////////////
@@ -388,16 +1481,48 @@ func (s *FileServer) bootstrapNetwork() error {
 
 // Start: entrypoint của FileServer.
 // - ListenAndAccept: mở cổng, chấp nhận kết nối.
+// - startAdmin / scrubber: admin API và kiểm tra toàn vẹn chạy nền (nếu được cấu hình).
+// - rebalancer: chờ membership thay đổi / chu kỳ RebalanceInterval để đưa bản sao về đúng chỗ.
+// - startMembership: vào cụm gossip SWIM (nếu có GossipAddr).
+// - startDiscovery: tự tìm peer cùng cụm trong LAN (nếu có DiscoveryCluster).
 // - bootstrapNetwork: dial vào peers khởi động.
 // - loop: bắt đầu tiêu thụ message RPC.
+//
+// Start trả về sau khi server dừng (Stop / Shutdown) và mọi goroutine nền đã thoát.
 func (s *FileServer) Start() error {
+	defer close(s.done)
 	fmt.Printf("[%s] starting fileserver...\n", s.Transport.Addr())
 
 	if err := s.Transport.ListenAndAccept(); err != nil {
 		return err
 	}
+	if err := s.startAdmin(); err != nil {
+		return err
+	}
+	if err := s.startMembership(); err != nil {
+		return err
+	}
+	if err := s.startDiscovery(); err != nil {
+		return err
+	}
+	if s.ScrubInterval > 0 {
+		s.goBackground(s.scrubber.run)
+	}
+	s.goBackground(s.rebalancer.run)
+	if s.raft != nil {
+		s.goBackground(s.raft.run)
+	}
+	s.goBackground(s.advertiseCapacity)
+	s.goBackground(s.exchangePeers)
+	if s.Versioning && s.Retention.KeepFor > 0 {
+		s.goBackground(s.pruneVersions)
+	}
+	if s.TombstoneTTL > 0 {
+		s.goBackground(s.pruneTombstones)
+	}
 	s.bootstrapNetwork()
 	s.loop()
+	s.background.Wait()
 	return nil
 }
 
@@ -408,6 +1533,33 @@ func (s *FileServer) Start() error {
 // init đăng ký các kiểu payload để gob có thể encode/decode chính xác.
 // Nếu quên đăng ký, gob sẽ không biết cách giải mã “any” bên trong Message.Payload.
 func init() {
+	gob.Register(MessageHello{})
+	gob.Register(MessageCapacity{})
+	gob.Register(MessagePeers{})
 	gob.Register(MessageStoreFile{})
 	gob.Register(MessageGetFile{})
+	gob.Register(MessageDeleteFile{})
+	gob.Register(MessageListKeys{})
+	gob.Register(MessageListKeysResponse{})
+	gob.Register(MessageGetObject{})
+	gob.Register(MessageObjectData{})
+	gob.Register(MessageFindNode{})
+	gob.Register(MessageFindNodeResponse{})
+	gob.Register(MessageFindValue{})
+	gob.Register(MessageDHTStore{})
+	gob.Register(MessageDHTStoreResponse{})
+	gob.Register(MessageStatObject{})
+	gob.Register(MessageStatObjectResponse{})
+	gob.Register(MessageDropReplica{})
+	gob.Register(MessageDropReplicaResponse{})
+	gob.Register(MessageRaftVote{})
+	gob.Register(MessageRaftVoteResponse{})
+	gob.Register(MessageRaftAppend{})
+	gob.Register(MessageRaftAppendResponse{})
+	gob.Register(MessageRaftSnapshot{})
+	gob.Register(MessageRaftSnapshotResponse{})
+	gob.Register(MessageCatalogPropose{})
+	gob.Register(MessageCatalogProposeResponse{})
+	gob.Register(MessageCatalogRead{})
+	gob.Register(MessageCatalogReadResponse{})
 }
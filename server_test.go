@@ -0,0 +1,800 @@
+package main
+
+import (
+	"DistributedFileStorage/p2p"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                              HELPER FUNCTIONS                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// freeAddr trả về 1 địa chỉ loopback đang rảnh.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// newTestServer tạo và khởi động 1 FileServer trên cổng ngẫu nhiên.
+// Dữ liệu, index và identity đều nằm trong RAM (MemoryBackend) – không đụng tới đĩa.
+func newTestServer(t *testing.T, nodes ...string) *FileServer {
+	return newTestServerWith(t, nil, nodes...)
+}
+
+// newTestServerWith như newTestServer nhưng cho phép chỉnh opts (configure có thể nil) trước khi khởi tạo.
+func newTestServerWith(t *testing.T, configure func(*FileServerOpts), nodes ...string) *FileServer {
+	identity, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := p2p.NewTCPTransport(p2p.TCPTransportOpts{
+		ListenAddr:    freeAddr(t),
+		HandshakeFunc: p2p.NOPHandshakeFunc,
+		Decoder:       p2p.DefaultDecoder{},
+	})
+	opts := FileServerOpts{
+		Identity:          identity,
+		EncKey:            newEncryptionKey(),
+		StorageRoot:       identity.NodeID(),
+		StorageBackend:    NewMemoryBackend(),
+		PathTransformFunc: CASPathTransformFunc,
+		Transport:         tr,
+		BootstrapNodes:    nodes,
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	s := NewFileServer(opts)
+	tr.OnPeer = s.OnPeer
+	tr.OnPeerClose = s.OnPeerClose
+
+	go s.Start()
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// waitFor chờ đến khi cond đúng (tối đa 5s).
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// connectedTo: s đang kết nối (đã chào hỏi) với other.
+func connectedTo(s *FileServer, other *FileServer) bool {
+	for _, p := range s.knownPeers("") {
+		if p.ID == other.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestCluster: s1 lắng nghe, s2 bootstrap vào s1, chờ 2 bên thấy nhau.
+func newTestCluster(t *testing.T) (*FileServer, *FileServer) {
+	s1 := newTestServer(t)
+	time.Sleep(100 * time.Millisecond) // chờ s1 mở cổng
+	s2 := newTestServer(t, s1.Transport.Addr())
+	waitFor(t, "peers connected", func() bool {
+		return len(s1.peerAddrs()) == 1 && len(s2.peerAddrs()) == 1
+	})
+	return s1, s2
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                                  TESTS                                     //
+////////////////////////////////////////////////////////////////////////////////
+
+// TestFileServerList: key bị mất ở local vẫn được liệt kê nhờ bản sao trên peer,
+// và phân trang hoạt động trên kết quả đã gộp.
+func TestFileServerList(t *testing.T) {
+	s1, s2 := newTestCluster(t)
+
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("pics/%d.png", i)
+		if err := s1.Store(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s1.Store("docs/readme.txt", bytes.NewReader([]byte("readme")))
+	waitFor(t, "replication", func() bool {
+		page, _ := s2.store.List(s1.ID, "", "", 0)
+		return len(page.Entries) == 5
+	})
+
+	// Xóa 1 file ở local – bản sao trên s2 vẫn còn
+	if err := s1.store.Delete(s1.ID, "pics/3.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		keys  []string
+		token string
+	)
+	for {
+		page, next, err := s1.List("pics/", token, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, page...)
+		if len(next) == 0 {
+			break
+		}
+		token = next
+	}
+
+	want := []string{"pics/0.png", "pics/1.png", "pics/2.png", "pics/3.png"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("want %v have %v", want, keys)
+	}
+}
+
+// TestFileServerCompression: bản sao nhận dạng nén khi peer đã báo hỗ trợ (MessageHello),
+// nhận nội dung gốc khi không; khôi phục từ mạng vẫn ra đúng nội dung.
+func TestFileServerCompression(t *testing.T) {
+	s1, s2 := newTestCluster(t)
+	s1.store.Compression = CompressionGzip
+	addr := s1.peerAddrs()[0]
+	waitFor(t, "hello", func() bool { return s1.peerSupports(addr, CompressionGzip) })
+
+	data := bytes.Repeat([]byte("compressible line of text\n"), 100)
+	if err := s1.Store("a.txt", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replication", func() bool { return s2.store.Has(s1.ID, hashKey("a.txt")) })
+	if meta, _ := s2.store.Stat(s1.ID, hashKey("a.txt")); meta.Compression != CompressionGzip {
+		t.Errorf("expected compressed replica, have %+v", meta)
+	}
+
+	// Mất bản local → lấy lại từ s2 (bytes nén, đã mã hóa) và đọc ra nội dung gốc
+	if err := s1.store.Delete(s1.ID, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s1.Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := io.ReadAll(r); !bytes.Equal(have, data) {
+		t.Errorf("content mismatch after fetching compressed object from network")
+	}
+
+	// Peer không hỗ trợ nén → nhận nội dung gốc
+	s1.peerLock.Lock()
+	s1.peerCaps[addr] = nil
+	s1.peerLock.Unlock()
+	if err := s1.Store("b.txt", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replication", func() bool { return s2.store.Has(s1.ID, hashKey("b.txt")) })
+	if meta, _ := s2.store.Stat(s1.ID, hashKey("b.txt")); meta.Compression != CompressionNone {
+		t.Errorf("expected uncompressed replica, have %+v", meta)
+	}
+}
+
+// TestFileServerCapacity: node đầy báo dung lượng cho peer → bản sao không được gửi tới nữa.
+func TestFileServerCapacity(t *testing.T) {
+	s1, s2 := newTestCluster(t)
+	s2.store.NodeQuota = Quota{MaxBytes: 100}
+	addr := s1.peerAddrs()[0]
+
+	// Vượt quota: s2 từ chối và báo lại dung lượng còn trống
+	if err := s1.Store("big", bytes.NewReader(make([]byte, 200))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "capacity", func() bool { return s1.peerFreeBytes(addr) == 100 })
+	if s2.store.Has(s1.ID, hashKey("big")) {
+		t.Errorf("replica over quota must be rejected")
+	}
+
+	// Vừa quota: được nhận
+	if err := s1.Store("small", bytes.NewReader(make([]byte, 40))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replication", func() bool { return s2.store.Has(s1.ID, hashKey("small")) })
+	waitFor(t, "capacity", func() bool { return s1.peerFreeBytes(addr) == 100-56 })
+
+	// Peer không còn đủ chỗ → bị bỏ qua (không gửi)
+	if err := s1.Store("medium", bytes.NewReader(make([]byte, 50))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if s2.store.Has(s1.ID, hashKey("medium")) {
+		t.Errorf("full peer should have been skipped")
+	}
+}
+
+// TestFileServerSiblings: key có 2 phiên bản ghi đồng thời → keep-both báo *ConflictError,
+// merge gộp bằng MergeFunc và thay thế cả 2 bằng 1 phiên bản mới.
+func TestFileServerSiblings(t *testing.T) {
+	s := newTestServer(t)
+	s.ConflictPolicy, s.store.ConflictPolicy = ConflictKeepBoth, ConflictKeepBoth
+
+	for _, replica := range []string{"a", "b"} {
+		writer := NewStore(StoreOpts{Backend: NewMemoryBackend(), ReplicaID: replica})
+		meta := writeOn(t, writer, s.ID, "notes.txt", []byte(replica))
+		if _, err := s.store.WriteReplica(s.ID, "notes.txt", meta, bytes.NewReader([]byte(replica))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var conflict *ConflictError
+	if _, err := s.Get("notes.txt"); !errors.As(err, &conflict) || len(conflict.Siblings) != 2 {
+		t.Fatalf("expected conflict with 2 siblings, have %v", err)
+	}
+
+	s.ConflictPolicy = ConflictMerge
+	s.MergeFunc = func(key string, siblings []Sibling) ([]byte, error) {
+		parts := make([]string, len(siblings))
+		for i, sibling := range siblings {
+			parts[i] = string(sibling.Data)
+		}
+		sort.Strings(parts)
+		return []byte(strings.Join(parts, "+")), nil
+	}
+	r, err := s.Get("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := io.ReadAll(r); string(have) != "a+b" {
+		t.Errorf("want merged a+b have %s", have)
+	}
+	if siblings, _ := s.Siblings("notes.txt"); len(siblings) != 1 {
+		t.Errorf("merge should leave a single version, have %+v", siblings)
+	}
+}
+
+// TestFileServerDelete: xóa được phát tán tới bản sao; đồng hồ HLC đi kèm mọi message nên node nhận
+// luôn cấp mốc sau mốc của node gửi, kể cả khi đồng hồ vật lý của node gửi chạy nhanh.
+func TestFileServerDelete(t *testing.T) {
+	s1, s2 := newTestCluster(t)
+
+	// Đồng hồ s1 chạy nhanh 1 giờ
+	s1.clock.mu.Lock()
+	s1.clock.now = func() time.Time { return time.Now().Add(time.Hour) }
+	s1.clock.mu.Unlock()
+
+	if err := s1.Store("doc", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replication", func() bool { return s2.store.Has(s1.ID, hashKey("doc")) })
+	meta, _ := s2.store.Stat(s1.ID, hashKey("doc"))
+	if now := s2.clock.Now(); now.Compare(meta.Timestamp) <= 0 {
+		t.Errorf("receiver clock %s must be after write %s", now, meta.Timestamp)
+	}
+
+	if err := s1.Delete("doc"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "delete", func() bool { return !s2.store.Has(s1.ID, hashKey("doc")) })
+	if s1.store.Has(s1.ID, "doc") {
+		t.Errorf("expected local copy to be deleted")
+	}
+	tomb, ok := s2.store.tombstone(s2.store.objectPath(s1.ID, s2.store.PathTransformFunc(hashKey("doc"))))
+	if !ok || tomb.Timestamp.Compare(meta.Timestamp) <= 0 {
+		t.Errorf("expected tombstone after write, have %+v", tomb)
+	}
+}
+
+// TestFileServerVerifyChannel: trên kết nối Noise chỉ message do chính khóa đã xác thực ký mới được nhận
+// (peer không chuyển tiếp được envelope của node khác); kết nối TCP trần chỉ dựa vào chữ ký.
+func TestFileServerVerifyChannel(t *testing.T) {
+	s := newTestServer(t)
+	peerID, _ := NewIdentity()
+	other, _ := NewIdentity()
+
+	c1, c2 := net.Pipe()
+	go p2p.NewNoiseConn(c2, p2p.NoiseConfig{PrivateKey: peerID.PrivateKey}, false)
+	conn, err := p2p.NewNoiseConn(c1, p2p.NoiseConfig{PrivateKey: s.Identity.PrivateKey}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := net.Pipe()
+	s.peerLock.Lock()
+	s.peers["noise"] = p2p.NewTCPPeer(conn, true)
+	s.peers["plain"] = p2p.NewTCPPeer(plain, true)
+	s.peerLock.Unlock()
+
+	if err := s.verifyChannel("noise", peerID.NodeID()); err != nil {
+		t.Errorf("authenticated peer rejected: %v", err)
+	}
+	if err := s.verifyChannel("noise", other.NodeID()); err != ErrChannelMismatch {
+		t.Errorf("relayed envelope accepted: %v", err)
+	}
+	if err := s.verifyChannel("plain", other.NodeID()); err != nil {
+		t.Errorf("plain connection rejected: %v", err)
+	}
+}
+
+// TestFileServerAuthorizeStore: chỉ chủ namespace, hoặc node đang ngừng hoạt động chuyển giao bản sao mang
+// chữ ký của chủ trên đúng phiên bản + bytes, được ghi vào namespace; namespace phải là node ID hợp lệ.
+func TestFileServerAuthorizeStore(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := NewIdentity()
+	drainer, _ := NewIdentity()
+
+	msg := MessageStoreFile{ID: owner.NodeID(), Key: "k", Hash: "h1", Meta: ObjectMeta{VersionID: "v1"}}
+	if err := s.authorizeStore(owner.NodeID(), msg); err != nil {
+		t.Errorf("owner rejected: %v", err)
+	}
+	if err := s.authorizeStore(drainer.NodeID(), msg); err == nil {
+		t.Errorf("non-owner accepted")
+	}
+
+	s.peerLock.Lock()
+	s.draining[drainer.NodeID()] = true
+	s.peerLock.Unlock()
+	if err := s.authorizeStore(drainer.NodeID(), msg); !errors.Is(err, ErrOwnerSignature) {
+		t.Errorf("unsigned hand-off accepted: %v", err)
+	}
+	msg.Meta.OwnerSig = drainer.Sign(ownerRecord(msg.ID, msg.Key, "v1", "h1"))
+	if err := s.authorizeStore(drainer.NodeID(), msg); !errors.Is(err, ErrOwnerSignature) {
+		t.Errorf("hand-off signed by the sender itself accepted: %v", err)
+	}
+	msg.Meta.OwnerSig = owner.Sign(ownerRecord(msg.ID, msg.Key, "v1", "h1"))
+	if err := s.authorizeStore(drainer.NodeID(), msg); err != nil {
+		t.Errorf("owner-signed hand-off rejected: %v", err)
+	}
+	msg.Hash = "h2"
+	if err := s.authorizeStore(drainer.NodeID(), msg); !errors.Is(err, ErrOwnerSignature) {
+		t.Errorf("hand-off with other bytes accepted: %v", err)
+	}
+
+	bad := MessageStoreFile{ID: "../../etc", Key: "k", Hash: "h1"}
+	if err := s.authorizeStore(bad.ID, bad); err == nil {
+		t.Errorf("invalid namespace accepted")
+	}
+}
+
+// TestFileServerMembers: node chỉ biết 1 seed vẫn thấy mọi member của cụm (kèm địa chỉ TCP);
+// node dừng được các node còn lại ghi nhận là đã rời cụm.
+func TestFileServerMembers(t *testing.T) {
+	gossip := func(seeds ...string) func(*FileServerOpts) {
+		return func(opts *FileServerOpts) {
+			opts.GossipAddr = "127.0.0.1:0"
+			opts.GossipSeeds = seeds
+		}
+	}
+	gossipAddr := func(s *FileServer) string {
+		waitFor(t, "gossip started", func() bool { return len(s.Members()) > 0 })
+		return s.Members()[0].Addr
+	}
+
+	s1 := newTestServerWith(t, gossip())
+	s2 := newTestServerWith(t, gossip(gossipAddr(s1)))
+	s3 := newTestServerWith(t, gossip(gossipAddr(s2)))
+
+	addrs := map[string]string{s1.ID: s1.Transport.Addr(), s2.ID: s2.Transport.Addr(), s3.ID: s3.Transport.Addr()}
+	for _, s := range []*FileServer{s1, s2, s3} {
+		waitFor(t, "membership converged", func() bool {
+			members := s.Members()
+			if len(members) != len(addrs) {
+				return false
+			}
+			for _, m := range members {
+				if m.Status != p2p.MemberAlive || m.Meta != addrs[m.Name] {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	s3.Stop()
+	for _, s := range []*FileServer{s1, s2} {
+		waitFor(t, "s3 left", func() bool {
+			for _, m := range s.Members() {
+				if m.Name == s3.ID {
+					return m.Status == p2p.MemberLeft
+				}
+			}
+			return false
+		})
+	}
+}
+
+// TestFileServerPeerExchange: s3 bootstrap vào s1 và s2 → s1, s2 biết nhau qua peer exchange và tự kết nối;
+// s4 (TargetPeers = 1) biết thêm s1, s2 nhưng connection manager không dial thêm.
+func TestFileServerPeerExchange(t *testing.T) {
+	s1 := newTestServer(t)
+	s2 := newTestServer(t)
+	time.Sleep(100 * time.Millisecond) // chờ s1, s2 mở cổng
+	s3 := newTestServer(t, s1.Transport.Addr(), s2.Transport.Addr())
+
+	waitFor(t, "s1 and s2 connected", func() bool { return connectedTo(s1, s2) && connectedTo(s2, s1) })
+
+	s4 := newTestServerWith(t, func(opts *FileServerOpts) { opts.TargetPeers = 1 }, s3.Transport.Addr())
+	waitFor(t, "s4 learned s1, s2, s3", func() bool {
+		s4.peerLock.Lock()
+		defer s4.peerLock.Unlock()
+		return len(s4.addrBook) == 3
+	})
+	s4.fillPeers()
+	s4.peerLock.Lock()
+	defer s4.peerLock.Unlock()
+	if len(s4.dialing) != 0 {
+		t.Errorf("s4 dialed %v beyond its target of 1 connection", s4.dialing)
+	}
+}
+
+// TestFileServerDHT: các node nối thành chuỗi (không có peer exchange) vẫn tìm được node giữ bản sao qua DHT;
+// Get của chủ key chỉ hỏi các node đó.
+func TestFileServerDHT(t *testing.T) {
+	noExchange := func(opts *FileServerOpts) { opts.TargetPeers = -1 }
+	nodes := []*FileServer{newTestServerWith(t, noExchange)}
+	for i := 1; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond) // chờ node trước mở cổng
+		nodes = append(nodes, newTestServerWith(t, noExchange, nodes[i-1].Transport.Addr()))
+	}
+	for _, s := range nodes {
+		waitFor(t, "routing tables filled", func() bool { return s.routes.size() == len(nodes)-1 })
+	}
+
+	owner, holder := nodes[4], nodes[3]
+	if err := owner.Store("dht.txt", bytes.NewReader([]byte("found via dht"))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replication", func() bool { return holder.store.Has(owner.ID, hashKey("dht.txt")) })
+
+	// nodes[0] cũng giữ provider record (cụm nhỏ hơn dhtK) → bỏ đi để buộc FIND_VALUE qua mạng
+	nodes[0].providers.mu.Lock()
+	delete(nodes[0].providers.records, dhtKey(owner.ID, "dht.txt"))
+	nodes[0].providers.mu.Unlock()
+
+	found := map[string]bool{}
+	for _, p := range nodes[0].findProviders(owner.ID, "dht.txt") {
+		found[p.ID] = true
+	}
+	if !found[owner.ID] || !found[holder.ID] {
+		t.Errorf("providers of dht.txt: %v", found)
+	}
+
+	if err := owner.store.Delete(owner.ID, "dht.txt"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := owner.Get("dht.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "found via dht" {
+		t.Errorf("unexpected content %q", b)
+	}
+}
+
+// TestFileServerDiscovery: 2 node không có bootstrap, cùng DiscoveryCluster → tự tìm thấy nhau qua multicast
+// (loopback) và kết nối; node khác cụm không bị kết nối vào.
+func TestFileServerDiscovery(t *testing.T) {
+	cluster := func(name string) func(*FileServerOpts) {
+		return func(opts *FileServerOpts) {
+			opts.DiscoveryCluster = name
+			opts.DiscoveryInterface = "lo"
+		}
+	}
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	s1 := newTestServerWith(t, cluster(name))
+	other := newTestServerWith(t, cluster(name+"-other"))
+	time.Sleep(100 * time.Millisecond) // chờ s1 announce
+	s2 := newTestServerWith(t, cluster(name))
+
+	waitFor(t, "s1 and s2 connected", func() bool { return connectedTo(s1, s2) && connectedTo(s2, s1) })
+	if n := len(other.peerAddrs()); n != 0 {
+		t.Errorf("node of another cluster has %d peers", n)
+	}
+}
+
+// openCounter đếm số reader backend đang mở (Get chưa Close).
+type openCounter struct {
+	StorageBackend
+	open int64
+}
+
+func (b *openCounter) Get(path string) (int64, io.ReadCloser, error) {
+	size, r, err := b.StorageBackend.Get(path)
+	if err != nil {
+		return size, r, err
+	}
+	atomic.AddInt64(&b.open, 1)
+	return size, &countedReader{ReadCloser: r, open: &b.open}, nil
+}
+
+type countedReader struct {
+	io.ReadCloser
+	open *int64
+	once sync.Once
+}
+
+func (r *countedReader) Close() error {
+	r.once.Do(func() { atomic.AddInt64(r.open, -1) })
+	return r.ReadCloser.Close()
+}
+
+// TestFileServerRebalance: ReplicationFactor = 1 → mỗi key chỉ có 1 bản sao trên node gần nhất.
+// s3 vào cụm → key gần s3 hơn được chép sang s3 rồi mới bị xóa khỏi s2; s3 rời cụm → mọi key về lại s2.
+func TestFileServerRebalance(t *testing.T) {
+	gossip := func(seeds ...string) func(*FileServerOpts) {
+		return func(opts *FileServerOpts) {
+			opts.GossipAddr = "127.0.0.1:0"
+			opts.GossipSeeds = seeds
+			opts.ReplicationFactor = 1
+			opts.TargetPeers = -1
+		}
+	}
+	gossipAddr := func(s *FileServer) string {
+		waitFor(t, "gossip started", func() bool { return len(s.Members()) > 0 })
+		return s.Members()[0].Addr
+	}
+	alive := func(s *FileServer, n int) func() bool {
+		return func() bool { return len(s.clusterMembers()) == n }
+	}
+
+	backend := &openCounter{StorageBackend: NewMemoryBackend()}
+	s1 := newTestServerWith(t, func(opts *FileServerOpts) {
+		gossip()(opts)
+		opts.StorageRoot, opts.StorageBackend = t.TempDir(), backend
+	})
+	s2 := newTestServerWith(t, gossip(gossipAddr(s1)))
+	waitFor(t, "s2 joined", alive(s1, 1))
+
+	keys := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt", "f.txt", "g.txt", "h.txt"}
+	for _, key := range keys {
+		if err := s1.Store(key, strings.NewReader("content of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range keys {
+		key := key
+		waitFor(t, "replication to s2", func() bool { return s2.store.Has(s1.ID, hashKey(key)) })
+	}
+
+	s3 := newTestServerWith(t, gossip(gossipAddr(s1)))
+	waitFor(t, "s3 joined", alive(s1, 2))
+	status := s1.Rebalance()
+	if status.Checked != len(keys) || status.Pending != 0 {
+		t.Errorf("rebalance after join: %+v", status)
+	}
+	waitFor(t, "readers closed after rebalance", func() bool { return atomic.LoadInt64(&backend.open) == 0 })
+
+	moved := 0
+	for _, key := range keys {
+		target := s1.replicaTargets(key, s1.clusterMembers())[0]
+		onS2, onS3 := s2.store.Has(s1.ID, hashKey(key)), s3.store.Has(s1.ID, hashKey(key))
+		if (target.ID == s3.ID) != onS3 || (target.ID == s2.ID) != onS2 {
+			t.Errorf("%s: target %s, on s2 %v, on s3 %v", key, target.Addr, onS2, onS3)
+		}
+		if target.ID == s3.ID {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(keys) {
+		t.Fatalf("expected keys on both nodes, %d of %d moved to s3", moved, len(keys))
+	}
+
+	s3.Stop()
+	waitFor(t, "s3 left", alive(s1, 1))
+	if status := s1.Rebalance(); status.Copied != moved || status.Pending != 0 {
+		t.Errorf("rebalance after leave: %+v", status)
+	}
+	for _, key := range keys {
+		if !s2.store.Has(s1.ID, hashKey(key)) {
+			t.Errorf("%s not back on s2", key)
+		}
+	}
+	for _, key := range keys {
+		r, err := s1.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(r); string(b) != "content of "+key {
+			t.Errorf("%s: unexpected content %q", key, b)
+		}
+	}
+}
+
+// TestFileServerDecommission: s2 ngừng hoạt động → từ chối ghi mới, mọi bản sao của s1 nó giữ hộ
+// được chuyển sang s3 (node còn lại) trước khi s2 dừng.
+func TestFileServerDecommission(t *testing.T) {
+	placed := func(opts *FileServerOpts) { opts.ReplicationFactor = 1 }
+	s1 := newTestServerWith(t, placed)
+	time.Sleep(100 * time.Millisecond) // chờ s1 mở cổng
+	s2 := newTestServerWith(t, placed, s1.Transport.Addr())
+	s3 := newTestServerWith(t, placed, s1.Transport.Addr())
+	waitFor(t, "full mesh", func() bool {
+		return connectedTo(s1, s2) && connectedTo(s1, s3) && connectedTo(s2, s3) && connectedTo(s3, s2)
+	})
+
+	var keys []string
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("file-%d.txt", i)
+		if err := s1.Store(key, strings.NewReader("content of "+key)); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	onS2 := 0
+	for _, key := range keys {
+		if s2.store.Has(s1.ID, hashKey(key)) {
+			onS2++
+		}
+	}
+	if onS2 == 0 {
+		t.Fatal("no replica placed on s2")
+	}
+
+	if err := s2.Decommission(); err != nil {
+		t.Fatal(err)
+	}
+	status := s2.DecommissionStatus()
+	if !status.Done || status.Pending != 0 {
+		t.Errorf("decommission status: %+v", status)
+	}
+	if err := s2.Store("late.txt", strings.NewReader("late")); !errors.Is(err, ErrDecommissioning) {
+		t.Errorf("store after decommission: %v", err)
+	}
+	for _, key := range keys {
+		if !s3.store.Has(s1.ID, hashKey(key)) {
+			t.Errorf("%s not handed off to s3", key)
+		}
+	}
+}
+
+// TestFileServerShutdown: Shutdown chờ lượt Store đang chạy xong, từ chối Store mới, đóng kết nối peer
+// rồi mới trả về; hết hạn ctx khi còn lượt truyền → trả về lỗi của ctx.
+func TestFileServerShutdown(t *testing.T) {
+	s1, s2 := newTestCluster(t)
+
+	pr, pw := io.Pipe()
+	stored := make(chan error, 1)
+	go func() { stored <- s1.Store("slow.txt", pr) }()
+	if _, err := pw.Write([]byte("first half, ")); err != nil { // Store đã bắt đầu đọc
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shut := make(chan error, 1)
+	go func() { shut <- s1.Shutdown(ctx) }()
+	waitFor(t, "shutdown to begin", func() bool {
+		s1.shutdownMu.RLock()
+		defer s1.shutdownMu.RUnlock()
+		return s1.shuttingDown
+	})
+
+	if err := s1.Store("late.txt", strings.NewReader("late")); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("store during shutdown: %v", err)
+	}
+	select {
+	case err := <-shut:
+		t.Fatalf("shutdown returned before the active store finished: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if err := <-stored; err != nil {
+		t.Errorf("active store: %v", err)
+	}
+	if err := <-shut; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	select {
+	case <-s1.done:
+	default:
+		t.Error("Start has not returned after Shutdown")
+	}
+	waitFor(t, "s2 to drop s1", func() bool { return !connectedTo(s2, s1) })
+
+	// Hết hạn khi lượt truyền chưa xong.
+	pr, pw = io.Pipe()
+	defer pw.Close()
+	go s2.Store("stuck.txt", pr)
+	if _, err := pw.Write([]byte("never finished")); err != nil {
+		t.Fatal(err)
+	}
+	short, cancelShort := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelShort()
+	if err := s2.Shutdown(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown past deadline: %v", err)
+	}
+}
+
+// TestFileServerCatalog: 3 voter nhân bản catalog bằng Raft; Store/Delete của voter lẫn client (không phải voter)
+// được ghi vào catalog và đọc nhất quán từ node bất kỳ; quota của cụm chặn ghi mới.
+func TestFileServerCatalog(t *testing.T) {
+	var (
+		identities []*Identity
+		voters     []string
+	)
+	for i := 0; i < 3; i++ {
+		identity, err := NewIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, identity)
+		voters = append(voters, identity.NodeID())
+	}
+	withCatalog := func(identity *Identity) func(*FileServerOpts) {
+		return func(opts *FileServerOpts) {
+			if identity != nil {
+				opts.Identity, opts.StorageRoot = identity, identity.NodeID()
+			}
+			opts.CatalogVoters = voters
+			opts.CatalogElectionTimeout = 200 * time.Millisecond
+		}
+	}
+	s1 := newTestServerWith(t, withCatalog(identities[0]))
+	time.Sleep(100 * time.Millisecond) // chờ s1 mở cổng
+	s2 := newTestServerWith(t, withCatalog(identities[1]), s1.Transport.Addr())
+	s3 := newTestServerWith(t, withCatalog(identities[2]), s1.Transport.Addr())
+	client := newTestServerWith(t, withCatalog(nil), s1.Transport.Addr())
+	waitFor(t, "catalog leader", func() bool {
+		leaders := 0
+		for _, s := range []*FileServer{s1, s2, s3} {
+			if s.CatalogStatus().Role == RaftLeader.String() {
+				leaders++
+			}
+		}
+		return leaders == 1
+	})
+
+	if err := s1.Store("a.txt", strings.NewReader("catalog me")); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s1.store.Stat(s1.ID, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := client.CatalogLookup(s1.ID, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.VersionID != meta.VersionID || entry.Size != meta.Size || len(entry.Locations) < 2 {
+		t.Errorf("catalog entry %+v does not match %+v", entry, meta)
+	}
+
+	if err := client.Store("c.txt", strings.NewReader("from a client")); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := s3.CatalogLookup(client.ID, "c.txt"); err != nil || entry.Owner != client.ID {
+		t.Errorf("client write not in catalog: %+v %v", entry, err)
+	}
+
+	if err := s2.SetCatalogQuota(s1.ID, Quota{MaxObjects: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var qerr *QuotaError
+	if err := s1.Store("b.txt", strings.NewReader("over quota")); !errors.As(err, &qerr) || qerr.Scope != "cluster" {
+		t.Errorf("store over cluster quota: %v", err)
+	}
+	if err := s1.Store("a.txt", strings.NewReader("overwrite is fine")); err != nil {
+		t.Errorf("overwrite within quota: %v", err)
+	}
+
+	if err := s1.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := s2.CatalogLookup(s1.ID, "a.txt"); err != nil || !entry.Deleted {
+		t.Errorf("no tombstone in catalog: %+v %v", entry, err)
+	}
+	if used, _, err := client.CatalogUsage(s1.ID); err != nil || used != (Usage{}) {
+		t.Errorf("usage after delete: %+v %v", used, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                        DỪNG ÊM (GRACEFUL SHUTDOWN)                          //
+////////////////////////////////////////////////////////////////////////////////
+
+// Shutdown dừng node theo thứ tự:
+//   1. Không nhận kết nối mới (đóng listener), không xử lý message mới (trừ trả lời cho request đang chờ);
+//      Store/Get mới trả về ErrShuttingDown.
+//   2. Chờ các lượt truyền đang chạy (Store, Get, message đang xử lý, chép bản sao của rebalancer) xong
+//      hoặc tới hạn của ctx.
+//   3. Dừng loop và các goroutine nền, rời cụm gossip, đóng mọi kết nối peer; Start trả về.
+//
+// Stop thì bỏ qua bước 2 (lượt truyền đang chạy bị cắt khi kết nối đóng).
+
+// ErrShuttingDown: server đang dừng, không nhận lượt truyền mới.
+var ErrShuttingDown = errors.New("file server is shutting down")
+
+// beginTransfer ghi nhận 1 lượt truyền bắt đầu; false nếu server đang dừng (không được bắt đầu).
+// Mỗi lần trả về true phải kèm đúng 1 lần endTransfer.
+func (s *FileServer) beginTransfer() bool {
+	s.shutdownMu.RLock()
+	defer s.shutdownMu.RUnlock()
+
+	if s.shuttingDown {
+		return false
+	}
+	s.transfers.Add(1)
+	return true
+}
+
+func (s *FileServer) endTransfer() {
+	s.transfers.Done()
+}
+
+// goBackground chạy fn trong goroutine nền mà Start chờ kết thúc trước khi trả về.
+// fn phải thoát khi quitch đóng.
+func (s *FileServer) goBackground(fn func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+}
+
+// Shutdown dừng server êm: chờ lượt truyền đang chạy tới hạn của ctx rồi dọn dẹp, trả về khi Start đã trả về.
+// Hết hạn trước khi xong → vẫn dọn dẹp (cắt các lượt truyền còn lại) và trả về ctx.Err().
+// Chỉ dùng với server đã Start.
+func (s *FileServer) Shutdown(ctx context.Context) error {
+	s.shutdownMu.Lock()
+	first := !s.shuttingDown
+	s.shuttingDown = true
+	s.shutdownMu.Unlock()
+
+	if first {
+		log.Printf("[%s] shutting down: waiting for active transfers", s.Transport.Addr())
+		if err := s.Transport.Close(); err != nil {
+			log.Printf("closing transport: %s", err)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.transfers.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Printf("[%s] shutdown deadline reached, aborting active transfers", s.Transport.Addr())
+	}
+
+	s.Stop()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// closePeers đóng mọi kết nối peer (OnPeerClose xóa chúng khỏi các map).
+func (s *FileServer) closePeers() {
+	s.peerLock.Lock()
+	peers := make([]interface{ Close() error }, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.peerLock.Unlock()
+
+	for _, p := range peers {
+		p.Close()
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -17,16 +22,116 @@ import (
 
 const defaultRootFolderName = "ggnetwork"
 
+// tempFilePrefix: tiền tố của file tạm trong lúc ghi (xem writeFileAtomic).
+const tempFilePrefix = ".tmp-"
+
 // CASPathTransformFunc: hàm chuyển đổi key → đường dẫn theo cơ chế CAS (Content Addressable Storage).
 // Ý tưởng: thay vì lưu file trực tiếp theo tên key, ta hash nó (SHA-1).
 // → hash được cắt thành nhiều đoạn để tạo cây thư mục, tránh việc có hàng ngàn file trong 1 folder.
+// Đây là layout mặc định (SHA-1, mỗi tầng 5 ký tự, hết chiều dài hash) – giữ nguyên để tương thích
+// với dữ liệu cũ. Muốn layout khác thì dùng NewCASPathTransformFunc.
 func CASPathTransformFunc(key string) PathKey {
-	// Tạo SHA-1 hash từ key
-	hash := sha1.Sum([]byte(key))
-	hashStr := hex.EncodeToString(hash[:]) // chuyển sang chuỗi hex
+	return DefaultCASOpts.transform(key)
+}
+
+// HashAlgorithm: tên thuật toán băm dùng để map key → path.
+type HashAlgorithm string
+
+const (
+	HashSHA1    HashAlgorithm = "sha1"    // 40 ký tự hex (mặc định cũ)
+	HashSHA256  HashAlgorithm = "sha256"  // 64 ký tự hex
+	HashBLAKE2b HashAlgorithm = "blake2b" // BLAKE2b-256, 64 ký tự hex
+)
+
+// CASOpts: cấu hình cho PathTransformFunc kiểu CAS.
+//   - Hash : thuật toán băm (rỗng → SHA-1).
+//   - Width: số ký tự hex cho mỗi tầng thư mục (fan-out width, <= 0 → 5).
+//   - Depth: số tầng thư mục (fan-out depth). 0 → cắt hết chiều dài hash như layout cũ.
+type CASOpts struct {
+	Hash  HashAlgorithm
+	Width int
+	Depth int
+}
 
-	blocksize := 5 // mỗi thư mục con chứa 5 ký tự hash
+// DefaultCASOpts: layout gốc của CASPathTransformFunc (SHA-1, 5 ký tự, 8 tầng).
+var DefaultCASOpts = CASOpts{Hash: HashSHA1, Width: 5}
+
+// NewCASPathTransformFunc tạo PathTransformFunc theo cấu hình.
+// Trả về lỗi nếu thuật toán không hỗ trợ hoặc Depth*Width vượt quá chiều dài hash.
+func NewCASPathTransformFunc(opts CASOpts) (PathTransformFunc, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return opts.transform, nil
+}
+
+// withDefaults: điền giá trị mặc định cho các field bỏ trống.
+func (o CASOpts) withDefaults() CASOpts {
+	if len(o.Hash) == 0 {
+		o.Hash = HashSHA1
+	}
+	if o.Width <= 0 {
+		o.Width = 5
+	}
+	return o
+}
+
+// validate kiểm tra cấu hình có hợp lệ không.
+func (o CASOpts) validate() error {
+	size := o.hexLen()
+	if size == 0 {
+		return fmt.Errorf("unsupported hash algorithm %q", o.Hash)
+	}
+	if o.Depth < 0 || o.Depth*o.Width > size {
+		return fmt.Errorf("invalid fan-out depth=%d width=%d for %s (%d hex chars)", o.Depth, o.Width, o.Hash, size)
+	}
+	return nil
+}
+
+// hexLen: chiều dài chuỗi hex của thuật toán (0 nếu không hỗ trợ).
+func (o CASOpts) hexLen() int {
+	switch o.Hash {
+	case HashSHA1:
+		return sha1.Size * 2
+	case HashSHA256:
+		return sha256.Size * 2
+	case HashBLAKE2b:
+		return blake2b.Size256 * 2
+	}
+	return 0
+}
+
+// hash băm key theo thuật toán đã chọn và trả về chuỗi hex.
+func (o CASOpts) hash(key string) string {
+	switch o.withDefaults().Hash {
+	case HashSHA256:
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	case HashBLAKE2b:
+		sum := blake2b.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	default:
+		sum := sha1.Sum([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// transform: key → PathKey theo cấu hình.
+func (o CASOpts) transform(key string) PathKey {
+	return o.pathKeyFromHash(o.hash(key))
+}
+
+// pathKeyFromHash dựng PathKey từ chuỗi hash có sẵn.
+// Nhờ vậy có thể đổi layout (Depth/Width) mà không cần biết key gốc – dùng khi migrate.
+func (o CASOpts) pathKeyFromHash(hashStr string) PathKey {
+	o = o.withDefaults()
+
+	blocksize := o.Width // mỗi thư mục con chứa Width ký tự hash
 	sliceLen := len(hashStr) / blocksize
+	if o.Depth > 0 && o.Depth < sliceLen {
+		sliceLen = o.Depth
+	}
 	paths := make([]string, sliceLen)
 
 	// Cắt chuỗi hash thành các phần nhỏ để làm cây thư mục
@@ -54,7 +159,6 @@ type PathKey struct {
 }
 
 // FirstPathName: lấy thư mục con đầu tiên trong chuỗi path.
-// Dùng để xóa nguyên "cây con" trong Delete().
 func (p PathKey) FirstPathName() string {
 	paths := strings.Split(p.PathName, "/")
 	if len(paths) == 0 {
@@ -76,6 +180,40 @@ func (p PathKey) FullPath() string {
 type StoreOpts struct {
 	Root              string            // Thư mục gốc chứa toàn bộ dữ liệu
 	PathTransformFunc PathTransformFunc // Hàm chuyển đổi key → PathKey (nếu nil → mặc định)
+
+	// LegacyPathTransformFunc: layout cũ (tùy chọn) khi đang migrate sang layout mới.
+	// Has/Read/Delete sẽ tìm ở layout mới trước rồi mới tới layout cũ → vẫn đọc được trong lúc migrate.
+	// Write luôn ghi vào layout mới.
+	LegacyPathTransformFunc PathTransformFunc
+
+	// Backend: nơi chứa bytes của object (nil → FSBackend trên Root).
+	// Với MemoryBackend, index cũng chỉ nằm trong RAM (không đụng tới đĩa).
+	Backend StorageBackend
+
+	// Compression: nén nội dung object khi ghi (rỗng → không nén). Nội dung đã nén sẵn
+	// (ảnh, video, zip, ...) hoặc nhỏ hơn 512 byte được ghi nguyên trạng.
+	Compression Compression
+
+	// Quota (0 → không giới hạn), tính theo số byte thực sự nằm trong backend (sau khi nén):
+	//   - NamespaceQuota : mặc định cho mỗi namespace (ID).
+	//   - NamespaceQuotas: quota riêng cho từng ID (ghi đè NamespaceQuota).
+	//   - NodeQuota      : tổng của mọi namespace trên node.
+	// Ghi vượt quota bị từ chối với lỗi *QuotaError (errors.Is(err, ErrQuotaExceeded)).
+	NamespaceQuota  Quota
+	NamespaceQuotas map[string]Quota
+	NodeQuota       Quota
+
+	// Versioning: giữ phiên bản cũ khi object bị ghi đè hoặc xóa (xem Versions/ReadVersion/RestoreVersion).
+	// Retention quyết định phiên bản cũ nào được giữ. Phiên bản cũ không tính vào quota.
+	Versioning bool
+	Retention  RetentionPolicy
+
+	// ReplicaID: tên của Store này trong version vector (rỗng → ngẫu nhiên mỗi lần khởi tạo).
+	// Clock cấp mốc HLC cho mỗi lần ghi cục bộ (nil → đồng hồ riêng). ConflictPolicy quyết định
+	// cách xử lý bản sao ghi đồng thời (xem WriteReplica); sibling không tính vào quota.
+	ReplicaID      string
+	Clock          *HLC
+	ConflictPolicy ConflictPolicy
 }
 
 // DefaultPathTransformFunc: cách map key → path đơn giản (key = filename, không hash)
@@ -86,10 +224,18 @@ var DefaultPathTransformFunc = func(key string) PathKey {
 	}
 }
 
-// Store: đại diện cho "kho lưu trữ" trên ổ đĩa.
-// Nó dùng Root để lưu file, và PathTransformFunc để map key → đường dẫn file.
+// Store: đại diện cho "kho lưu trữ".
+// Nó dùng PathTransformFunc để map key → đường dẫn object, còn bytes nằm ở StorageBackend.
 type Store struct {
 	StoreOpts
+
+	backend StorageBackend
+	index   *objectIndex // index (id, key) → vị trí/size/hash/version (phục vụ Has/Read/List)
+
+	versionMu   sync.Mutex
+	lastVersion int64 // VersionID (unix nano) cấp gần nhất – xem newVersionID
+
+	replicaMu sync.Mutex // tuần tự hóa việc đối chiếu bản sao (WriteReplica)
 }
 
 // NewStore: khởi tạo Store mới với cấu hình.
@@ -102,109 +248,452 @@ func NewStore(opts StoreOpts) *Store {
 	if len(opts.Root) == 0 {
 		opts.Root = defaultRootFolderName
 	}
+	if err := validateCompression(opts.Compression); err != nil {
+		log.Printf("store: %s, writing objects uncompressed", err)
+		opts.Compression = CompressionNone
+	}
+	if len(opts.ReplicaID) == 0 {
+		opts.ReplicaID = generateID()[:16]
+	}
+	if opts.Clock == nil {
+		opts.Clock = NewHLC()
+	}
 
-	return &Store{
+	s := &Store{
 		StoreOpts: opts,
+		backend:   opts.Backend,
+	}
+	indexDir := filepath.Join(opts.Root, indexDirName)
+	switch s.backend.(type) {
+	case nil:
+		// Mặc định: filesystem (dọn luôn file tạm còn sót lại từ lần chạy trước)
+		s.backend = NewFSBackend(opts.Root)
+	case *MemoryBackend:
+		indexDir = ""
+	}
+
+	// Nạp index; chưa có (hoặc hỏng) thì dựng lại từ object trong backend
+	index, ok, err := openObjectIndex(indexDir)
+	if err != nil {
+		log.Printf("opening object index in %s: %s", indexDir, err)
+		index, ok = &objectIndex{dir: indexDir}, false
 	}
+	s.index = index
+	if !ok {
+		if err := s.RebuildIndex(); err != nil {
+			log.Printf("rebuilding object index in %s: %s", indexDir, err)
+		}
+	}
+
+	return s
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 //                           STORE METHODS                                    //
 ////////////////////////////////////////////////////////////////////////////////
 
-// Has: kiểm tra xem file có tồn tại trên đĩa không.
+// Has: kiểm tra xem object có tồn tại không.
+// Key không có trong index → không tồn tại (không cần đụng tới backend).
 func (s *Store) Has(id string, key string) bool {
-	// Tạo pathKey từ key
-	pathKey := s.PathTransformFunc(key)
-	// Đường dẫn đầy đủ: Root/ID/Path/Filename
-	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
+	_, ok := s.lookup(id, key)
+	return ok
+}
+
+// lookup trả về đường dẫn (trong backend) của object theo index.
+// Object không còn ở vị trí đã ghi trong index (ví dụ bị migration chuyển đi) thì tìm lại
+// bằng locate và cập nhật index.
+func (s *Store) lookup(id string, key string) (string, bool) {
+	entry, ok := s.index.get(id, key)
+	if !ok {
+		return "", false
+	}
+	path := id + "/" + entry.Location
+	if s.backend.Has(path) {
+		return path, true
+	}
+
+	pathKey, ok := s.locate(id, key)
+	if !ok {
+		return "", false
+	}
+	if err := s.index.relocate(id, key, pathKey.FullPath()); err != nil {
+		log.Printf("object index: relocating [%s]: %s", key, err)
+	}
+	return s.objectPath(id, pathKey), true
+}
+
+// objectPath: đường dẫn ID/Path/Filename của một PathKey trong backend.
+func (s *Store) objectPath(id string, pathKey PathKey) string {
+	return fmt.Sprintf("%s/%s", id, pathKey.FullPath())
+}
 
-	_, err := os.Stat(fullPathWithRoot)
-	// Nếu file không tồn tại → trả về false
-	return !errors.Is(err, os.ErrNotExist)
+// locate tìm PathKey thực sự đang chứa key:
+// layout hiện tại trước, sau đó tới layout cũ (nếu có).
+// Khi không thấy ở layout cũ thì kiểm tra lại layout mới một lần nữa
+// vì migration có thể vừa rename file sang chỗ mới giữa 2 lần Stat.
+func (s *Store) locate(id string, key string) (PathKey, bool) {
+	pathKey := s.PathTransformFunc(key)
+	if s.backend.Has(s.objectPath(id, pathKey)) {
+		return pathKey, true
+	}
+	if s.LegacyPathTransformFunc == nil {
+		return pathKey, false
+	}
+	legacy := s.LegacyPathTransformFunc(key)
+	if s.backend.Has(s.objectPath(id, legacy)) {
+		return legacy, true
+	}
+	return pathKey, s.backend.Has(s.objectPath(id, pathKey))
 }
 
-// Clear: xóa toàn bộ thư mục Root (dọn sạch store)
+// Clear: xóa sạch store (toàn bộ dữ liệu trong backend + index).
 func (s *Store) Clear() error {
-	return os.RemoveAll(s.Root)
+	s.index.reset()
+	if len(s.index.dir) > 0 {
+		if err := os.RemoveAll(s.index.dir); err != nil {
+			return err
+		}
+	}
+	return s.backend.Clear()
 }
 
-// Delete: xóa file (hoặc cả cây thư mục con liên quan đến key)
-// Ở đây nó xóa từ "FirstPathName", nghĩa là xóa luôn nhóm file cùng nhánh.
+// Delete: xóa đúng 1 object (dữ liệu + sidecar metadata). Với FSBackend các thư mục cha
+// vừa trở nên rỗng cũng được dọn; key khác dùng chung nhánh thư mục không bị ảnh hưởng.
+// Key không tồn tại → không làm gì.
 func (s *Store) Delete(id string, key string) error {
-	pathKey := s.PathTransformFunc(key)
+	path, ok := s.lookup(id, key)
+	if !ok {
+		pathKey, found := s.locate(id, key)
+		if !found {
+			return s.index.remove(id, key)
+		}
+		path = s.objectPath(id, pathKey)
+	}
+	if s.Versioning {
+		if err := s.archive(id, key); err != nil {
+			return err
+		}
+	}
 
-	defer func() {
-		log.Printf("deleted [%s] from disk", pathKey.Filename)
-	}()
+	// Xóa sidecar trước: nếu crash giữa chừng, object không có sidecar vẫn đọc được
+	for _, p := range []string{path + metaFileSuffix, path} {
+		if err := s.backend.Delete(p); err != nil {
+			return err
+		}
+	}
+	log.Printf("deleted [%s] from disk", pathBase(path))
+
+	if err := s.deleteSiblings(path, nil); err != nil {
+		return err
+	}
+	if s.Versioning {
+		if _, err := s.applyRetention(path); err != nil {
+			log.Printf("versions: applying retention to [%s]: %s", pathBase(path), err)
+		}
+	}
+	return s.index.remove(id, key)
+}
 
-	// Xóa nguyên folder con đầu tiên chứa file này
-	firstPathNameWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FirstPathName())
-	return os.RemoveAll(firstPathNameWithRoot)
+// DeletePrefix xóa mọi object trong namespace id có key gốc bắt đầu bằng prefix.
+// Trả về số object đã xóa. prefix rỗng → xóa hết object của namespace (xem DeleteNamespace).
+func (s *Store) DeletePrefix(id string, prefix string) (int, error) {
+	page, err := s.List(id, prefix, "", 0)
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range page.Entries {
+		if err := s.Delete(id, entry.StoreKey); err != nil {
+			return i, err
+		}
+	}
+	return len(page.Entries), nil
+}
+
+// DeleteNamespace xóa toàn bộ namespace id (mọi thứ dưới id/ trong backend) – thao tác hàng loạt có chủ đích.
+func (s *Store) DeleteNamespace(id string) error {
+	if len(id) == 0 || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("invalid namespace %q", id)
+	}
+	paths, err := s.backend.List(id + "/")
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{versionsDirName, siblingsDirName, tombstonesDirName} {
+		hidden, err := s.backend.List(dir + "/" + id + "/")
+		if err != nil {
+			return err
+		}
+		paths = append(paths, hidden...)
+	}
+	for _, path := range paths {
+		if err := s.backend.Delete(path); err != nil {
+			return err
+		}
+	}
+	log.Printf("deleted namespace [%s] from disk", id)
+
+	return s.index.removeWhere(id, func(string, indexEntry) bool { return true })
 }
 
 // Write: ghi dữ liệu từ io.Reader vào file (không mã hóa).
+// Metadata (size, hash, content type, ...) được tính tự động và lưu vào sidecar.
 func (s *Store) Write(id string, key string, r io.Reader) (int64, error) {
 	return s.writeStream(id, key, r)
 }
 
+// WriteWithMeta: giống Write nhưng giữ nguyên các field metadata đã có trong meta
+// (ví dụ metadata nhận từ node gốc qua MessageStoreFile); field trống sẽ được tính từ dữ liệu.
+func (s *Store) WriteWithMeta(id string, key string, meta ObjectMeta, r io.Reader) (int64, error) {
+	return s.writeAtomic(id, key, meta, func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+}
+
 // WriteDecrypt: ghi dữ liệu từ io.Reader vào file, với dữ liệu đã mã hóa (AES).
 // Nó sẽ giải mã (decrypt) trước khi ghi ra đĩa.
 func (s *Store) WriteDecrypt(encKey []byte, id string, key string, r io.Reader) (int64, error) {
-	f, err := s.openFileForWriting(id, key)
-	if err != nil {
-		return 0, err
-	}
-	// copyDecrypt vừa giải mã vừa ghi ra file
-	n, err := copyDecrypt(encKey, r, f)
-	return int64(n), err
+	return s.WriteDecryptWithMeta(encKey, id, key, ObjectMeta{}, r)
 }
 
-// openFileForWriting: mở file để ghi (tạo thư mục cha nếu chưa có).
-func (s *Store) openFileForWriting(id string, key string) (*os.File, error) {
-	pathKey := s.PathTransformFunc(key)
-	// Tạo cây thư mục (nếu chưa có)
-	pathNameWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.PathName)
-	if err := os.MkdirAll(pathNameWithRoot, os.ModePerm); err != nil {
-		return nil, err
-	}
-
-	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
-	// Trả về file handle (tạo mới file)
-	return os.Create(fullPathWithRoot)
+// WriteDecryptWithMeta: WriteDecrypt kèm metadata có sẵn (xem WriteWithMeta).
+func (s *Store) WriteDecryptWithMeta(encKey []byte, id string, key string, meta ObjectMeta, r io.Reader) (int64, error) {
+	return s.writeAtomic(id, key, meta, func(w io.Writer) (int64, error) {
+		// copyDecrypt vừa giải mã vừa ghi ra file
+		n, err := copyDecrypt(encKey, r, w)
+		return int64(n), err
+	})
 }
 
 // writeStream: hàm phụ cho Write (copy dữ liệu từ Reader → file).
 func (s *Store) writeStream(id string, key string, r io.Reader) (int64, error) {
-	f, err := s.openFileForWriting(id, key)
+	return s.WriteWithMeta(id, key, ObjectMeta{}, r)
+}
+
+// writeAtomic ghi object vào backend (Put là nguyên tử) rồi ghi sidecar metadata
+// và cập nhật index.
+// Trong lúc ghi, dữ liệu đi qua hasher SHA-256 và bộ "nhìn trộm" 512 byte đầu
+// để điền Hash/ContentType cho metadata.
+//
+// Nội dung chỉ được nén (theo s.Compression) khi metadata do chính Store tính ra; bytes nhận
+// kèm metadata có sẵn (bản sao từ peer) được ghi nguyên trạng. Trả về số byte nội dung (trước khi nén).
+func (s *Store) writeAtomic(id string, key string, meta ObjectMeta, write func(io.Writer) (int64, error)) (int64, error) {
+	pathKey := s.PathTransformFunc(key)
+	path := s.objectPath(id, pathKey)
+
+	res, err := s.reserveQuota(id, key)
 	if err != nil {
 		return 0, err
 	}
-	return io.Copy(f, r)
+	defer res.release()
+	if s.Versioning {
+		if err := s.archive(id, key); err != nil {
+			return 0, err
+		}
+	}
+	if len(meta.VersionID) == 0 {
+		meta.VersionID = s.newVersionID()
+	}
+	// Không có vector → lần ghi cục bộ: thay thế mọi phiên bản (kể cả sibling) Store đang biết
+	local := len(meta.Vector) == 0
+	if local {
+		meta.Vector = s.knownVector(id, key).Increment(s.ReplicaID)
+		meta.Timestamp = s.Clock.Now()
+		meta.Replica = s.ReplicaID
+	}
+
+	var (
+		hasher  = sha256.New() // nội dung gốc
+		stored  = sha256.New() // bytes thực sự nằm trong backend
+		sniff   = &headWriter{max: sniffLen}
+		content = &countingWriter{}
+		cw      *compressWriter
+	)
+	if len(meta.Hash) == 0 && s.Compression != CompressionNone {
+		cw = newCompressWriter(s.Compression, meta.ContentType, nil)
+	}
+	// write đẩy dữ liệu vào pipe, backend đọc từ đầu kia
+	pr, pw := io.Pipe()
+	go func() {
+		var dst io.Writer = io.MultiWriter(pw, stored)
+		if res != nil {
+			dst = &quotaWriter{w: dst, res: res}
+		}
+		if cw != nil {
+			cw.dst, dst = dst, cw
+		}
+		_, err := write(io.MultiWriter(dst, hasher, sniff, content))
+		if err == nil && cw != nil {
+			err = cw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	n, err := s.backend.Put(path, pr)
+	// Backend dừng sớm (lỗi) → mở khóa goroutine đang ghi vào pipe
+	pr.CloseWithError(errors.New("store: write aborted"))
+	if res != nil {
+		if qerr := res.exceeded(); qerr != nil {
+			return content.n, qerr
+		}
+	}
+	if err != nil {
+		return content.n, err
+	}
+
+	if cw != nil {
+		meta.Compression = cw.Algorithm()
+	}
+	meta.fill(key, content.n, hex.EncodeToString(hasher.Sum(nil)), sniff.buf)
+	if err := s.writeMeta(path, key, meta); err != nil {
+		return content.n, err
+	}
+	entry := indexEntry{
+		Key:         meta.Key,
+		Location:    pathKey.FullPath(),
+		Size:        n,
+		Hash:        hex.EncodeToString(stored.Sum(nil)),
+		Compression: meta.Compression,
+	}
+	// Có quota: phần giữ chỗ được đổi thành dung lượng thật trong cùng 1 lần khóa index
+	if res != nil {
+		_, err = res.commit(entry)
+	} else {
+		_, err = s.index.put(id, key, entry)
+	}
+	if err == nil && local {
+		if err := s.deleteSiblings(path, nil); err != nil {
+			log.Printf("siblings: resolving [%s]: %s", pathBase(path), err)
+		}
+	}
+	if err == nil {
+		if err := s.clearTombstone(path); err != nil {
+			log.Printf("tombstones: clearing [%s]: %s", pathBase(path), err)
+		}
+	}
+	if err == nil && s.Versioning {
+		if _, err := s.applyRetention(path); err != nil {
+			log.Printf("versions: applying retention to [%s]: %s", pathBase(path), err)
+		}
+	}
+	return content.n, err
 }
 
-// Read: đọc dữ liệu từ file ra (trả về io.Reader để stream).
+// Read: đọc nội dung object (trả về io.Reader để stream). Object được nén khi ghi
+// sẽ được giải nén trong lúc đọc; size là kích thước nội dung gốc.
 func (s *Store) Read(id string, key string) (int64, io.Reader, error) {
-	return s.readStream(id, key)
+	size, r, err := s.readStream(id, key)
+	if err != nil {
+		return size, r, err
+	}
+	entry, _ := s.index.get(id, key)
+	if entry.Compression == CompressionNone {
+		return size, r, nil
+	}
+
+	meta, err := s.Stat(id, key)
+	if err == nil {
+		var zr io.ReadCloser
+		if zr, err = decompressReader(entry.Compression, r); err == nil {
+			return meta.Size, &decompressedReader{ReadCloser: zr, raw: r}, nil
+		}
+	}
+	r.Close()
+	return 0, nil, err
 }
 
-// readStream: mở file và trả về io.ReadCloser cùng với kích thước file.
-func (s *Store) readStream(id string, key string) (int64, io.ReadCloser, error) {
-	pathKey := s.PathTransformFunc(key)
-	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
+// ReadRaw: đọc nguyên trạng bytes trong backend (không giải nén) – dùng khi chuyển object
+// sang peer, cùng với metadata cho biết bytes đó đã được nén thế nào.
+func (s *Store) ReadRaw(id string, key string) (int64, io.ReadCloser, error) {
+	return s.readStream(id, key)
+}
 
-	// Mở file
-	file, err := os.Open(fullPathWithRoot)
+// ReadRange: đọc tối đa length byte nội dung (đã giải nén) của key bắt đầu từ offset.
+// length < 0 → đọc tới hết object. Object không nén + backend hỗ trợ RangeBackend → ranged
+// read thẳng ở backend; còn lại đọc tuần tự rồi bỏ qua offset byte đầu.
+func (s *Store) ReadRange(id string, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("read %s: negative offset %d", key, offset)
+	}
+	meta, err := s.Stat(id, key)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
+	}
+	if offset >= meta.Size {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if length < 0 || offset+length > meta.Size {
+		length = meta.Size - offset
+	}
+
+	entry, ok := s.index.get(id, key)
+	if rb, isRange := s.backend.(RangeBackend); isRange && ok && entry.Compression == CompressionNone {
+		path, ok := s.lookup(id, key)
+		if !ok {
+			return nil, fmt.Errorf("read %s: %w", key, os.ErrNotExist)
+		}
+		r, err := rb.GetRange(path, offset, length)
+		if errors.Is(err, os.ErrNotExist) {
+			// Giống readStream: object có thể vừa bị migration chuyển đi.
+			if path, ok = s.lookup(id, key); ok {
+				r, err = rb.GetRange(path, offset, length)
+			}
+		}
+		return r, err
 	}
 
-	// Lấy thông tin file (size, etc.)
-	fi, err := file.Stat()
+	_, r, err := s.Read(id, key)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
+	}
+	rc := r.(io.ReadCloser)
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, length), rc}, nil
+}
+
+// readStream: mở object và trả về io.ReadCloser cùng với kích thước.
+func (s *Store) readStream(id string, key string) (int64, io.ReadCloser, error) {
+	path, ok := s.lookup(id, key)
+	if !ok {
+		return 0, nil, fmt.Errorf("read %s: %w", key, os.ErrNotExist)
+	}
+
+	size, r, err := s.backend.Get(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Object có thể vừa bị migration chuyển đi → tìm lại một lần.
+		if path, ok = s.lookup(id, key); ok {
+			size, r, err = s.backend.Get(path)
+		}
 	}
+	return size, r, err
+}
+
+// decompressedReader: Close đóng cả bộ giải nén lẫn reader bên dưới.
+type decompressedReader struct {
+	io.ReadCloser
+	raw io.Closer
+}
+
+func (d *decompressedReader) Close() error {
+	d.ReadCloser.Close()
+	return d.raw.Close()
+}
+
+// countingWriter đếm số byte được ghi qua nó.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
 
-	// Trả về size và file (dùng làm Reader)
-	return fi.Size(), file, nil
+// pathBase: phần cuối của đường dẫn dạng "a/b/c".
+func pathBase(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
 }
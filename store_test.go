@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -36,6 +40,79 @@ func TestPathTransformFunc(t *testing.T) {
 	}
 }
 
+// TestNewCASPathTransformFunc kiểm tra layout tùy chỉnh (SHA-256, BLAKE2b, fan-out depth/width).
+func TestNewCASPathTransformFunc(t *testing.T) {
+	key := "momsbestpicture"
+
+	sha256Func, err := NewCASPathTransformFunc(CASOpts{Hash: HashSHA256, Width: 2, Depth: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathKey := sha256Func(key)
+	if len(pathKey.Filename) != 64 {
+		t.Errorf("want 64 hex chars have %d", len(pathKey.Filename))
+	}
+	expectedPathName := pathKey.Filename[0:2] + "/" + pathKey.Filename[2:4] + "/" + pathKey.Filename[4:6]
+	if pathKey.PathName != expectedPathName {
+		t.Errorf("have %s want %s", pathKey.PathName, expectedPathName)
+	}
+
+	blakeFunc, err := NewCASPathTransformFunc(CASOpts{Hash: HashBLAKE2b, Width: 4, Depth: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := blakeFunc(key); have.Filename == pathKey.Filename || len(have.Filename) != 64 {
+		t.Errorf("unexpected blake2b filename %s", have.Filename)
+	}
+
+	// Layout mặc định phải khớp với CASPathTransformFunc
+	defaultFunc, err := NewCASPathTransformFunc(CASOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultFunc(key) != CASPathTransformFunc(key) {
+		t.Errorf("default layout differs from CASPathTransformFunc")
+	}
+
+	// Cấu hình sai phải báo lỗi
+	if _, err := NewCASPathTransformFunc(CASOpts{Hash: "md4"}); err == nil {
+		t.Errorf("expected error for unsupported hash")
+	}
+	if _, err := NewCASPathTransformFunc(CASOpts{Hash: HashSHA1, Width: 5, Depth: 9}); err == nil {
+		t.Errorf("expected error for too deep layout")
+	}
+}
+
+// TestStoreLegacyLayout: trong lúc migrate, Store vẫn đọc được object ở layout cũ.
+func TestStoreLegacyLayout(t *testing.T) {
+	id := generateID()
+	legacy := newStore()
+	defer teardown(t, legacy)
+
+	if _, err := legacy.Write(id, "oldkey", bytes.NewReader([]byte("old bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	newFunc, _ := NewCASPathTransformFunc(CASOpts{Hash: HashSHA256, Width: 2, Depth: 2})
+	s := NewStore(StoreOpts{
+		Root:                    legacy.Root,
+		PathTransformFunc:       newFunc,
+		LegacyPathTransformFunc: CASPathTransformFunc,
+	})
+
+	if !s.Has(id, "oldkey") {
+		t.Fatalf("expected to find key in legacy layout")
+	}
+	_, r, err := s.Read(id, "oldkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "old bytes" {
+		t.Errorf("want %s have %s", "old bytes", b)
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                           TEST STORE (READ/WRITE)                          //
 ////////////////////////////////////////////////////////////////////////////////
@@ -95,6 +172,152 @@ func TestStore(t *testing.T) {
 	}
 }
 
+// TestStoreReadRange: ReadRange trên FSBackend đọc đúng đoạn, cắt theo kích thước object.
+func TestStoreReadRange(t *testing.T) {
+	s := newStore()
+	defer teardown(t, s)
+	id := generateID()
+
+	data := []byte("0123456789abcdef")
+	if _, err := s.Write(id, "range", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 4, "0123"},
+		{10, 3, "abc"},
+		{12, -1, "cdef"},
+		{14, 100, "ef"},
+		{16, 4, ""},
+	}
+	for _, tc := range tests {
+		r, err := s.ReadRange(id, "range", tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, _ := ioutil.ReadAll(r)
+		r.Close()
+		if string(part) != tc.want {
+			t.Errorf("range(%d,%d): want %q have %q", tc.offset, tc.length, tc.want, part)
+		}
+	}
+	if _, err := s.ReadRange(id, "range", -1, 4); err == nil {
+		t.Errorf("expected error for negative offset")
+	}
+	if _, err := s.ReadRange(id, "missing", 0, 4); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not-exist for missing key, have %v", err)
+	}
+}
+
+// TestStoreDeleteKeepsSiblings: Delete chỉ xóa đúng object, key khác chung nhánh thư mục vẫn còn.
+func TestStoreDeleteKeepsSiblings(t *testing.T) {
+	s := NewStore(StoreOpts{
+		Root: t.TempDir(),
+		PathTransformFunc: func(key string) PathKey {
+			// Mọi key chung thư mục cấp 1 "shared" – tình huống Delete cũ xóa nhầm
+			return PathKey{PathName: "shared/" + key, Filename: key}
+		},
+	})
+	id := generateID()
+
+	for _, key := range []string{"a", "b", "logs_1", "logs_2", "logs_3"} {
+		if _, err := s.Write(id, key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Delete(id, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id, "a") {
+		t.Errorf("expected a to be deleted")
+	}
+	if !s.Has(id, "b") {
+		t.Errorf("expected sibling b to survive")
+	}
+	if _, err := os.Stat(filepath.Join(s.Root, id, "shared", "a")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected empty parent dir of a to be pruned")
+	}
+	if err := s.Delete(id, "missing"); err != nil {
+		t.Errorf("deleting missing key: %s", err)
+	}
+
+	n, err := s.DeletePrefix(id, "logs_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || s.Has(id, "logs_2") || !s.Has(id, "b") {
+		t.Errorf("unexpected DeletePrefix result n=%d", n)
+	}
+
+	if err := s.DeleteNamespace(id); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id, "b") {
+		t.Errorf("expected namespace to be empty")
+	}
+	if err := s.DeleteNamespace("../etc"); err == nil {
+		t.Errorf("expected error for invalid namespace")
+	}
+}
+
+// failingReader trả về vài byte rồi lỗi – giả lập kết nối bị đứt giữa lúc stream.
+type failingReader struct{ sent bool }
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	if r.sent {
+		return 0, errors.New("connection reset")
+	}
+	r.sent = true
+	return copy(b, "partial"), nil
+}
+
+// TestStoreAtomicWrite: ghi lỗi giữa chừng thì không để lại file cụt,
+// file tạm mồ côi được dọn khi tạo lại Store.
+func TestStoreAtomicWrite(t *testing.T) {
+	s := newStore()
+	defer teardown(t, s)
+	id := generateID()
+
+	if _, err := s.Write(id, "broken", &failingReader{}); err == nil {
+		t.Fatalf("expected write error")
+	}
+	if s.Has(id, "broken") {
+		t.Errorf("expected failed write to leave nothing behind")
+	}
+
+	// Bản cũ vẫn còn nguyên khi lần ghi đè sau bị lỗi
+	if _, err := s.Write(id, "kept", bytes.NewReader([]byte("v1"))); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(id, "kept", &failingReader{})
+	_, r, err := s.Read(id, "kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	r.(io.Closer).Close()
+	if string(b) != "v1" {
+		t.Errorf("want v1 have %s", b)
+	}
+
+	// Giả lập crash: file tạm còn sót lại
+	pathKey := s.PathTransformFunc("kept")
+	orphan := filepath.Join(s.Root, id, pathKey.PathName, tempFilePrefix+pathKey.Filename+"-123")
+	if err := os.WriteFile(orphan, []byte("half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	NewStore(s.StoreOpts)
+	if _, err := os.Stat(orphan); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected orphaned temp file to be swept")
+	}
+	if !s.Has(id, "kept") {
+		t.Errorf("expected committed object to survive the sweep")
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                              HELPER FUNCTIONS                              //
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                        XÓA CÓ THỨ TỰ (TOMBSTONE)                           //
+////////////////////////////////////////////////////////////////////////////////
+
+// tombstonesDirName: tombstone của object "id/<location>" là "<tombstones>/id/<location>.meta" –
+// metadata (version vector + mốc HLC) của lần xóa, giữ lại để bản sao cũ hơn nhận sau đó không "sống lại".
+const tombstonesDirName = ".tombstones"
+
+// tombstonePath: path (không gồm đuôi .meta) của tombstone cho object ở path.
+func tombstonePath(path string) string {
+	return tombstonesDirName + "/" + path
+}
+
+// tombstone đọc tombstone của object ở path (false nếu object chưa từng bị xóa hoặc tombstone đã hết hạn).
+func (s *Store) tombstone(path string) (ObjectMeta, bool) {
+	sc, err := s.readSidecar(tombstonePath(path))
+	if err != nil {
+		return ObjectMeta{}, false
+	}
+	return sc.ObjectMeta, true
+}
+
+func (s *Store) writeTombstone(path string, key string, tomb ObjectMeta) error {
+	return s.writeSidecar(tombstonePath(path), sidecar{ObjectMeta: tomb, StoreKey: key})
+}
+
+// clearTombstone xóa tombstone của object ở path (nếu có) – object vừa được ghi lại.
+func (s *Store) clearTombstone(path string) error {
+	if _, ok := s.tombstone(path); !ok {
+		return nil
+	}
+	return s.backend.Delete(tombstonePath(path) + metaFileSuffix)
+}
+
+// Remove xóa (id, key) như 1 lần ghi: để lại tombstone mang version vector (bao trùm mọi phiên bản
+// đang biết) và mốc HLC của lần xóa. Trả về tombstone để gửi cho peers (xem DeleteReplica).
+func (s *Store) Remove(id string, key string) (ObjectMeta, error) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	tomb := ObjectMeta{
+		Key:       key,
+		CreatedAt: time.Now().UTC(),
+		VersionID: s.newVersionID(),
+		Vector:    s.knownVector(id, key).Increment(s.ReplicaID),
+		Timestamp: s.Clock.Now(),
+		Replica:   s.ReplicaID,
+	}
+	if meta, err := s.Stat(id, key); err == nil {
+		tomb.Key, tomb.Owner = meta.Key, meta.Owner
+	}
+	if err := s.Delete(id, key); err != nil {
+		return tomb, err
+	}
+	return tomb, s.writeTombstone(s.objectPath(id, s.PathTransformFunc(key)), key, tomb)
+}
+
+// DeleteReplica áp lần xóa nhận từ node khác (tomb = tombstone của node gốc), đối chiếu như WriteReplica:
+//   - lần xóa đã "thấy" phiên bản đang có → xóa; cũ hơn/trùng → bỏ qua;
+//   - xóa đồng thời với 1 lần ghi → luôn theo last-writer-wins (HLC), bất kể ConflictPolicy.
+//
+// Chưa có object thì tombstone vẫn được ghi nhận để chặn bản sao cũ hơn đến sau.
+func (s *Store) DeleteReplica(id string, key string, tomb ObjectMeta) (ReplicaOutcome, error) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	path := s.objectPath(id, s.PathTransformFunc(key))
+	if old, ok := s.tombstone(path); ok {
+		if ord := tomb.Vector.Compare(old.Vector); ord == OrderEqual || ord == OrderBefore {
+			return ReplicaStale, nil
+		}
+		tomb.Vector = tomb.Vector.Merge(old.Vector)
+		if newerThan(old, tomb) {
+			tomb.Timestamp, tomb.Replica = old.Timestamp, old.Replica
+		}
+	}
+
+	outcome := ReplicaApplied
+	if current, err := s.Stat(id, key); err == nil {
+		switch tomb.Vector.Compare(current.Vector) {
+		case OrderEqual, OrderBefore:
+			return ReplicaStale, nil
+		case OrderConcurrent:
+			log.Printf("conflict on [%s]: write %s %s vs delete %s", key, current.VersionID, current.Vector, tomb.Vector)
+			merged := tomb.Vector.Merge(current.Vector)
+			if !newerThan(tomb, current) {
+				current.Vector = merged
+				return ReplicaLost, s.writeMeta(path, key, current)
+			}
+			tomb.Vector, outcome = merged, ReplicaWon
+		}
+		if err := s.Delete(id, key); err != nil {
+			return outcome, err
+		}
+	}
+	return outcome, s.writeTombstone(path, key, tomb)
+}
+
+// PruneTombstones xóa tombstone có mốc HLC cũ hơn ttl. Sau đó bản sao cũ (nếu còn ở đâu đó)
+// lại có thể được nhận như object mới → ttl nên dài hơn thời gian 1 node có thể mất kết nối.
+func (s *Store) PruneTombstones(ttl time.Duration) (int, error) {
+	paths, err := s.backend.List(tombstonesDirName + "/")
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-ttl).UnixNano()
+	removed := 0
+	for _, p := range paths {
+		if !strings.HasSuffix(p, metaFileSuffix) {
+			continue
+		}
+		tomb, ok := s.tombstone(strings.TrimPrefix(strings.TrimSuffix(p, metaFileSuffix), tombstonesDirName+"/"))
+		if !ok || tomb.Timestamp.Wall > cutoff {
+			continue
+		}
+		if err := s.backend.Delete(p); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
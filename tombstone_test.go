@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestStoreTombstone: lần xóa nhận từ node khác xóa bản sao và chặn bản sao cũ hơn đến sau;
+// ghi đồng thời với xóa được quyết định theo HLC.
+func TestStoreTombstone(t *testing.T) {
+	id := generateID()
+	newReplica := func(name string) *Store {
+		return NewStore(StoreOpts{
+			PathTransformFunc: CASPathTransformFunc,
+			Backend:           NewMemoryBackend(),
+			ReplicaID:         name,
+		})
+	}
+	a, b, c := newReplica("a"), newReplica("b"), newReplica("c")
+
+	v1 := writeOn(t, a, id, "doc", []byte("v1"))
+	for _, s := range []*Store{b, c} {
+		if _, err := s.WriteReplica(id, "doc", v1, bytes.NewReader([]byte("v1"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tomb, err := a.Remove(id, "doc")
+	if err != nil || a.Has(id, "doc") {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if tomb.Vector.Compare(v1.Vector) != OrderAfter || tomb.Timestamp.Compare(v1.Timestamp) <= 0 {
+		t.Fatalf("tombstone must follow v1: %+v", tomb)
+	}
+	if outcome, err := c.DeleteReplica(id, "doc", tomb); err != nil || outcome != ReplicaApplied || c.Has(id, "doc") {
+		t.Fatalf("want applied delete have %s (%v)", outcome, err)
+	}
+
+	// Bản sao v1 đến trễ không làm key sống lại
+	if outcome, _ := c.WriteReplica(id, "doc", v1, bytes.NewReader([]byte("v1"))); outcome != ReplicaStale || c.Has(id, "doc") {
+		t.Errorf("stale replica resurrected deleted key (%s)", outcome)
+	}
+
+	// b ghi đè v1 sau lần xóa (HLC lớn hơn) nhưng chưa thấy lần xóa → đồng thời, ghi thắng
+	v2 := writeOn(t, b, id, "doc", []byte("v2"))
+	if outcome, err := c.WriteReplica(id, "doc", v2, bytes.NewReader([]byte("v2"))); err != nil || outcome != ReplicaWon {
+		t.Fatalf("want won have %s (%v)", outcome, err)
+	}
+	if outcome, _ := c.DeleteReplica(id, "doc", tomb); outcome != ReplicaStale || !c.Has(id, "doc") {
+		t.Errorf("delete already merged must be stale (%s)", outcome)
+	}
+	// ...và ở node đã áp lần xóa trước đó, bản ghi cũng thắng tombstone
+	if outcome, _ := a.WriteReplica(id, "doc", v2, bytes.NewReader([]byte("v2"))); outcome != ReplicaWon || !a.Has(id, "doc") {
+		t.Errorf("want won over tombstone have %s", outcome)
+	}
+
+	// Tombstone quá hạn bị dọn
+	if n, err := a.PruneTombstones(time.Hour); err != nil || n != 0 {
+		t.Errorf("fresh tombstones must be kept, pruned %d (%v)", n, err)
+	}
+	a.Remove(id, "doc")
+	if n, err := a.PruneTombstones(-time.Hour); err != nil || n != 1 {
+		t.Errorf("want 1 pruned tombstone have %d (%v)", n, err)
+	}
+}
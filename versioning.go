@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+//                      PHIÊN BẢN OBJECT (LỊCH SỬ + KHÔI PHỤC)                  //
+////////////////////////////////////////////////////////////////////////////////
+
+// versionsDirName: phiên bản cũ của object "id/<location>" nằm ở
+// "<versions>/id/<location>/<versionID>" (+ sidecar .meta) trong backend – thư mục ẩn
+// nên không bị index coi là object hiện hành.
+const versionsDirName = ".versions"
+
+// RetentionPolicy: quy tắc giữ phiên bản cũ (0 → không giới hạn theo tiêu chí đó).
+//   - KeepLast: giữ tối đa N phiên bản cũ gần nhất.
+//   - KeepFor : bỏ phiên bản cũ đã bị thay thế lâu hơn khoảng này.
+type RetentionPolicy struct {
+	KeepLast int
+	KeepFor  time.Duration
+}
+
+// ObjectVersion: 1 phiên bản trong lịch sử của key.
+type ObjectVersion struct {
+	VersionID  string
+	Size       int64     // kích thước nội dung gốc
+	Hash       string    // SHA-256 nội dung gốc
+	CreatedAt  time.Time // thời điểm phiên bản được ghi
+	ArchivedAt time.Time // thời điểm bị thay thế/xóa (rỗng với phiên bản hiện hành)
+	Current    bool
+}
+
+// newVersionID: định danh phiên bản = thời điểm ghi (unix nano, 16 ký tự hex) → sắp xếp theo
+// chuỗi cũng là theo thời gian. Tăng đơn điệu trong 1 Store kể cả khi đồng hồ trả về cùng giá trị.
+func (s *Store) newVersionID() string {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+
+	now := time.Now().UnixNano()
+	if now <= s.lastVersion {
+		now = s.lastVersion + 1
+	}
+	s.lastVersion = now
+	return fmt.Sprintf("%016x", now)
+}
+
+// versionIDOf: VersionID của metadata; object ghi trước khi có versioning dùng CreatedAt thay thế.
+func versionIDOf(meta ObjectMeta) string {
+	if len(meta.VersionID) > 0 {
+		return meta.VersionID
+	}
+	return fmt.Sprintf("%016x", meta.CreatedAt.UnixNano())
+}
+
+// versionDir: thư mục (prefix) chứa lịch sử của object ở path.
+func versionDir(path string) string {
+	return versionsDirName + "/" + path + "/"
+}
+
+// archive chép phiên bản hiện hành của (id, key) (dữ liệu + sidecar) vào lịch sử.
+// Gọi trước khi phiên bản hiện hành bị ghi đè hoặc xóa. Không có object → không làm gì.
+func (s *Store) archive(id string, key string) error {
+	path, ok := s.lookup(id, key)
+	if !ok {
+		return nil
+	}
+	sc, err := s.readSidecar(path)
+	if errors.Is(err, os.ErrNotExist) {
+		info, err := s.backend.Stat(path)
+		if err != nil {
+			return err
+		}
+		sc = sidecar{ObjectMeta: ObjectMeta{Key: key, Size: info.Size, CreatedAt: info.ModTime}, StoreKey: key}
+	} else if err != nil {
+		return err
+	}
+	dst := versionDir(path) + versionIDOf(sc.ObjectMeta)
+
+	_, r, err := s.backend.Get(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.backend.Put(dst, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	sc.ArchivedAt = time.Now().UTC()
+	return s.writeSidecar(dst, sc)
+}
+
+// history đọc các phiên bản cũ của object ở path, cũ nhất trước.
+func (s *Store) history(path string) ([]string, []sidecar, error) {
+	paths, err := s.backend.List(versionDir(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		versions []string
+		sidecars []sidecar
+	)
+	for _, p := range paths {
+		if strings.HasSuffix(p, metaFileSuffix) {
+			continue
+		}
+		sc, err := s.readSidecar(p)
+		if err != nil {
+			log.Printf("versions: skipping %s: %s", p, err)
+			continue
+		}
+		versions = append(versions, p)
+		sidecars = append(sidecars, sc)
+	}
+	return versions, sidecars, nil
+}
+
+// Versions liệt kê lịch sử của key (phiên bản hiện hành, nếu còn, và các phiên bản cũ), mới nhất trước.
+// Key đã bị xóa vẫn còn lịch sử (nếu bật Versioning) → khôi phục được bằng RestoreVersion.
+func (s *Store) Versions(id string, key string) ([]ObjectVersion, error) {
+	var (
+		versions []ObjectVersion
+		current  string
+	)
+	if meta, err := s.Stat(id, key); err == nil {
+		current = versionIDOf(meta)
+		versions = append(versions, ObjectVersion{
+			VersionID: current,
+			Size:      meta.Size,
+			Hash:      meta.Hash,
+			CreatedAt: meta.CreatedAt,
+			Current:   true,
+		})
+	}
+
+	_, sidecars, err := s.history(s.objectPath(id, s.PathTransformFunc(key)))
+	if err != nil {
+		return nil, err
+	}
+	for _, sc := range sidecars {
+		// Bản lưu trữ trùng phiên bản hiện hành (lần ghi đè sau đó bị lỗi) → bỏ qua
+		if id := versionIDOf(sc.ObjectMeta); id != current {
+			versions = append(versions, ObjectVersion{
+				VersionID:  id,
+				Size:       sc.Size,
+				Hash:       sc.Hash,
+				CreatedAt:  sc.CreatedAt,
+				ArchivedAt: sc.ArchivedAt,
+			})
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("versions %s: %w", key, os.ErrNotExist)
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].VersionID > versions[j].VersionID })
+	return versions, nil
+}
+
+// ReadVersion đọc nội dung (đã giải nén) của 1 phiên bản cụ thể.
+func (s *Store) ReadVersion(id string, key string, versionID string) (int64, io.ReadCloser, error) {
+	if meta, err := s.Stat(id, key); err == nil && versionIDOf(meta) == versionID {
+		size, r, err := s.Read(id, key)
+		if err != nil {
+			return 0, nil, err
+		}
+		return size, r.(io.ReadCloser), nil
+	}
+
+	sc, r, err := s.openVersion(id, key, versionID)
+	if err != nil {
+		return 0, nil, err
+	}
+	zr, err := decompressReader(sc.Compression, r)
+	if err != nil {
+		r.Close()
+		return 0, nil, err
+	}
+	return sc.Size, &decompressedReader{ReadCloser: zr, raw: r}, nil
+}
+
+// openVersion mở bytes nguyên trạng + sidecar của 1 phiên bản cũ.
+func (s *Store) openVersion(id string, key string, versionID string) (sidecar, io.ReadCloser, error) {
+	if len(versionID) == 0 || strings.ContainsAny(versionID, `/\`) {
+		return sidecar{}, nil, fmt.Errorf("invalid version id %q", versionID)
+	}
+	path := versionDir(s.objectPath(id, s.PathTransformFunc(key))) + versionID
+
+	sc, err := s.readSidecar(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return sc, nil, fmt.Errorf("version %s of %s: %w", versionID, key, os.ErrNotExist)
+		}
+		return sc, nil, err
+	}
+	_, r, err := s.backend.Get(path)
+	return sc, r, err
+}
+
+// RestoreVersion đưa 1 phiên bản cũ trở lại làm phiên bản hiện hành (với VersionID mới);
+// phiên bản hiện hành trước đó được lưu vào lịch sử như mọi lần ghi đè.
+func (s *Store) RestoreVersion(id string, key string, versionID string) error {
+	if meta, err := s.Stat(id, key); err == nil && versionIDOf(meta) == versionID {
+		return nil // đã là phiên bản hiện hành
+	}
+
+	sc, r, err := s.openVersion(id, key, versionID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Bytes được chép nguyên trạng (giữ Hash/Compression cũ); VersionID/CreatedAt/vector được gán mới
+	meta := sc.ObjectMeta
+	meta.VersionID, meta.CreatedAt = "", time.Time{}
+	meta.Vector, meta.Timestamp, meta.Replica = nil, Timestamp{}, ""
+	_, err = s.writeAtomic(id, key, meta, func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+	return err
+}
+
+// applyRetention xóa các phiên bản cũ của object ở path không còn thỏa Retention.
+// Trả về số phiên bản đã xóa.
+func (s *Store) applyRetention(path string) (int, error) {
+	policy := s.Retention
+	if policy.KeepLast <= 0 && policy.KeepFor <= 0 {
+		return 0, nil
+	}
+	versions, sidecars, err := s.history(path)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := range versions {
+		newer := len(versions) - 1 - i // số phiên bản cũ mới hơn phiên bản này
+		expired := policy.KeepFor > 0 && time.Since(sidecars[i].ArchivedAt) > policy.KeepFor
+		if !expired && (policy.KeepLast <= 0 || newer < policy.KeepLast) {
+			continue
+		}
+		for _, p := range []string{versions[i] + metaFileSuffix, versions[i]} {
+			if err := s.backend.Delete(p); err != nil {
+				return removed, err
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// PruneVersions áp Retention cho lịch sử của mọi object (kể cả key đã bị xóa).
+// Cần chạy định kỳ khi dùng KeepFor vì phiên bản cũ chỉ bị kiểm tra lại khi key được ghi.
+func (s *Store) PruneVersions() (int, error) {
+	paths, err := s.backend.List(versionsDirName + "/")
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool)
+	removed := 0
+	for _, p := range paths {
+		// "<versions>/<object path>/<versionID>[.meta]" → object path
+		objPath := strings.TrimPrefix(p[:strings.LastIndex(p, "/")], versionsDirName+"/")
+		if seen[objPath] {
+			continue
+		}
+		seen[objPath] = true
+		n, err := s.applyRetention(objPath)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestStoreVersioning: ghi đè/xóa giữ lại phiên bản cũ, đọc/khôi phục theo VersionID,
+// Retention giới hạn số phiên bản cũ và tuổi của chúng.
+func TestStoreVersioning(t *testing.T) {
+	for name, backend := range map[string]func(root string) StorageBackend{
+		"fs":     func(root string) StorageBackend { return NewFSBackend(root) },
+		"memory": func(string) StorageBackend { return NewMemoryBackend() },
+	} {
+		backend := backend
+		t.Run(name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "store")
+			s := NewStore(StoreOpts{
+				Root:              root,
+				PathTransformFunc: CASPathTransformFunc,
+				Backend:           backend(root),
+				Versioning:        true,
+				Retention:         RetentionPolicy{KeepLast: 2},
+			})
+			id := generateID()
+
+			for i := 1; i <= 4; i++ {
+				if _, err := s.Write(id, "doc.txt", bytes.NewReader([]byte(fmt.Sprintf("v%d", i)))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			versions, err := s.Versions(id, "doc.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Hiện hành (v4) + tối đa 2 bản cũ (v3, v2)
+			if len(versions) != 3 || !versions[0].Current || versions[1].ArchivedAt.IsZero() {
+				t.Fatalf("unexpected history %+v", versions)
+			}
+			readVersion := func(v ObjectVersion) string {
+				_, r, err := s.ReadVersion(id, "doc.txt", v.VersionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+				data, _ := ioutil.ReadAll(r)
+				return string(data)
+			}
+			for i, want := range []string{"v4", "v3", "v2"} {
+				if have := readVersion(versions[i]); have != want {
+					t.Errorf("version %d: want %s have %s", i, want, have)
+				}
+			}
+
+			// Khôi phục v2 → trở thành phiên bản hiện hành mới, v4 vào lịch sử
+			if err := s.RestoreVersion(id, "doc.txt", versions[2].VersionID); err != nil {
+				t.Fatal(err)
+			}
+			_, r, _ := s.Read(id, "doc.txt")
+			if data, _ := ioutil.ReadAll(r); string(data) != "v2" {
+				t.Errorf("want restored v2 have %s", data)
+			}
+
+			// Xóa vẫn giữ lịch sử → khôi phục được
+			if err := s.Delete(id, "doc.txt"); err != nil {
+				t.Fatal(err)
+			}
+			versions, err = s.Versions(id, "doc.txt")
+			if err != nil || len(versions) != 2 || versions[0].Current {
+				t.Fatalf("unexpected history after delete %+v (%v)", versions, err)
+			}
+			if err := s.RestoreVersion(id, "doc.txt", versions[0].VersionID); err != nil || !s.Has(id, "doc.txt") {
+				t.Fatalf("restoring deleted key failed: %v", err)
+			}
+
+			// KeepFor: mọi bản cũ đều đã quá hạn
+			s.Retention = RetentionPolicy{KeepFor: time.Nanosecond}
+			time.Sleep(time.Millisecond)
+			if n, err := s.PruneVersions(); err != nil || n != 2 {
+				t.Errorf("want 2 pruned versions have %d (%v)", n, err)
+			}
+			if versions, _ := s.Versions(id, "doc.txt"); len(versions) != 1 {
+				t.Errorf("expected only current version left, have %+v", versions)
+			}
+
+			// Lịch sử không bị coi là object khi dựng lại index
+			if err := s.RebuildIndex(); err != nil {
+				t.Fatal(err)
+			}
+			if u := s.TotalUsage(); u.Objects != 1 {
+				t.Errorf("unexpected usage after rebuild %+v", u)
+			}
+		})
+	}
+}